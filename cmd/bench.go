@@ -0,0 +1,85 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/traffic"
+)
+
+// benchExprs returns the named rules to load test from either -expr or the rule set within -file.
+func (r *rules) benchExprs(opts *options) (map[string]string, error) {
+	if opts.expr != "" {
+		return map[string]string{"expr": opts.expr}, nil
+	}
+	return r.loadRuleSet(opts.file, yamlOptions(opts))
+}
+
+// runBench evaluates the rules against n generated requests, produced no faster than rate
+// requests per second, and reports the evaluation throughput along with how often each rule
+// matched.
+func (r *rules) runBench(exprs map[string]string, n, rate int, seed int64) error {
+	ce, err := r.NewCorpusEvaluator(exprs)
+	if err != nil {
+		return err
+	}
+	cfg := traffic.DefaultConfig()
+	cfg.Seed = seed
+	cfg.Rate = rate
+	g, err := traffic.New(cfg)
+	if err != nil {
+		return err
+	}
+	matches := map[string]int{}
+	errs := map[string]int{}
+	var evalTime time.Duration
+	start := time.Now()
+	for vars := range g.Stream(context.Background(), n) {
+		evalStart := time.Now()
+		res := ce.Evaluate([]*cloudarmor.Variables{vars})[0]
+		evalTime += time.Since(evalStart)
+		for _, name := range res.Matches {
+			matches[name]++
+		}
+		for name := range res.Errors {
+			errs[name]++
+		}
+	}
+	elapsed := time.Since(start)
+
+	fmt.Printf("%d requests against %d rules in %v (seed %d)\n", n, len(exprs), elapsed.Round(time.Millisecond), seed)
+	if n > 0 {
+		fmt.Printf("  evaluation: %v per request, %.0f requests/s\n",
+			evalTime/time.Duration(n), float64(n)/evalTime.Seconds())
+	}
+	stats := ce.Stats()
+	fmt.Printf("  prefilter: %d of %d rules, %.1f%% of evaluations skipped\n",
+		stats.RulesWithPrefilter, len(exprs), 100*stats.SkipRate())
+	names := make([]string, 0, len(exprs))
+	for name := range exprs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %d matches (%.1f%%), %d errors\n",
+			name, matches[name], 100*float64(matches[name])/float64(max(n, 1)), errs[name])
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/traffic"
+)
+
+// canarySamples is the number of diverging requests printed by -canary.
+const canarySamples = 5
+
+// loadRuleSet returns the named rules within either a rule bundle, whose definitions are expanded,
+// or a file of ';' separated expressions, which are named by their position.
+func (r *rules) loadRuleSet(path string, yamlOpts []cloudarmor.YAMLOption) (map[string]string, error) {
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		b, err := cloudarmor.LoadRuleBundle(path, yamlOpts...)
+		if err != nil {
+			return nil, err
+		}
+		exprs, err := r.ExpandBundle(b)
+		if err != nil {
+			return nil, err
+		}
+		named := map[string]string{}
+		for i, rule := range b.Rules {
+			named[rule.Name] = exprs[i]
+		}
+		return named, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	exprs := map[string]string{}
+	for _, entry := range cloudarmor.ParseRuleFile(string(content)) {
+		exprs[ruleName(len(exprs)+1)] = entry.Expr
+	}
+	return exprs, nil
+}
+
+// runCanary evaluates n generated requests against the active and candidate rule sets and reports
+// how often, and for which rules, their outcomes diverge.
+func (r *rules) runCanary(activePath, candidatePath string, n int, seed int64, yamlOpts []cloudarmor.YAMLOption) error {
+	active, err := r.loadRuleSet(activePath, yamlOpts)
+	if err != nil {
+		return fmt.Errorf("active: %w", err)
+	}
+	candidate, err := r.loadRuleSet(candidatePath, yamlOpts)
+	if err != nil {
+		return fmt.Errorf("candidate: %w", err)
+	}
+	cfg := traffic.DefaultConfig()
+	cfg.Seed = seed
+	g, err := traffic.New(cfg)
+	if err != nil {
+		return err
+	}
+	p := r.newProgress("canary", "requests")
+	report, err := r.CanaryContext(p.context(), active, candidate, g.Generate(n), canarySamples)
+	p.finish()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d of %d requests diverge (%.2f%%, seed %d)\n",
+		report.Diverged, report.Requests, 100*report.DivergenceRate(), seed)
+	names := make([]string, 0, len(report.RuleDivergence))
+	for name := range report.RuleDivergence {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %d requests\n", name, report.RuleDivergence[name])
+	}
+	for _, d := range report.Samples {
+		req := d.Input.Request
+		fmt.Printf("  sample: %s %s?%s from %s\n    active:    %s\n    candidate: %s\n",
+			req.Method, req.Path, req.Query, d.Input.Origin.IP, describeResult(d.Active), describeResult(d.Candidate))
+	}
+	return nil
+}
+
+func describeResult(res cloudarmor.CorpusResult) string {
+	desc := "matches [" + strings.Join(res.Matches, ", ") + "]"
+	if len(res.Errors) != 0 {
+		var failed []string
+		for name := range res.Errors {
+			failed = append(failed, name)
+		}
+		sort.Strings(failed)
+		desc += " errors [" + strings.Join(failed, ", ") + "]"
+	}
+	return desc
+}
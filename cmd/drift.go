@@ -0,0 +1,84 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// runDrift compares the local rule set with an exported security policy and returns an error if
+// the deployed policy has drifted from the local rules.
+func (r *rules) runDrift(localPath, policyPath string, yamlOpts []cloudarmor.YAMLOption) error {
+	local, err := r.loadRuleSet(localPath, yamlOpts)
+	if err != nil {
+		return fmt.Errorf("local: %w", err)
+	}
+	policy, err := loadSecurityPolicy(policyPath)
+	if err != nil {
+		return err
+	}
+	report, err := r.Drift(local, policy)
+	if err != nil {
+		return err
+	}
+	for _, name := range report.Added {
+		fmt.Printf("+ %s: not deployed\n", name)
+	}
+	for _, name := range report.Removed {
+		fmt.Printf("- %s: deployed but not defined locally\n", name)
+	}
+	for _, c := range report.Changed {
+		fmt.Printf("~ %s: deployed %.12s, local %.12s\n", c.Name, c.DeployedHash, c.LocalHash)
+		for _, d := range c.Differences {
+			fmt.Printf("    deployed: %s\n    local:    %s\n", d.Deployed, d.Local)
+		}
+	}
+	for _, err := range report.Invalid {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Printf("! %s\n", line)
+		}
+	}
+	fmt.Printf("%d added, %d removed, %d changed, %d unchanged, %d invalid\n",
+		len(report.Added), len(report.Removed), len(report.Changed), report.Unchanged, len(report.Invalid))
+	if report.Drifted() {
+		return &codedError{code: exitTestFailure, err: fmt.Errorf("policy %s has drifted from %s", policy.Name, localPath)}
+	}
+	return nil
+}
+
+// loadSecurityPolicy reads a security policy in the versioned YAML format when the file has a
+// .yaml or .yml extension, and as a JSON export otherwise.
+func loadSecurityPolicy(path string) (*cloudarmor.SecurityPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy *cloudarmor.SecurityPolicy
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		policy, err = cloudarmor.SecurityPolicyFromYAML(data)
+	default:
+		policy, err = cloudarmor.SecurityPolicyFromJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policy, nil
+}
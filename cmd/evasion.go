@@ -0,0 +1,59 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/evasion"
+)
+
+// runEvasion re-encodes the matching inputs of the test suite and returns an error if any of
+// them evaded the suite's expression.
+func (r *rules) runEvasion(path string, yamlOpts []cloudarmor.YAMLOption) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ts, err := cloudarmor.TestSuiteFromYAML(data, yamlOpts...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	report, err := evasion.Run(r.Rules, ts.Expr, ts.Tests)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, e := range report.Evasions {
+		fmt.Printf("EVADED %s/%s: %s %q -> %q (%s)\n", ts.Name, e.TestCase, e.Attribute,
+			r.Redact(e.Attribute, e.Original), r.Redact(e.Attribute, e.Mutated), e.Technique)
+	}
+	normalizations := report.Normalizations()
+	techniques := make([]string, 0, len(normalizations))
+	for t := range normalizations {
+		techniques = append(techniques, t)
+	}
+	sort.Strings(techniques)
+	for _, t := range techniques {
+		fmt.Printf("to resist %s, consider %s\n", t, normalizations[t])
+	}
+	fmt.Printf("%d of %d re-encoded inputs evaded the rule\n", len(report.Evasions), report.Attempts)
+	if len(report.Evasions) != 0 {
+		return &codedError{code: exitTestFailure, err: fmt.Errorf("%s can be evaded by %d techniques", ts.Name, len(techniques))}
+	}
+	return nil
+}
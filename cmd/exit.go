@@ -0,0 +1,87 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// The exit codes of the CLI are stable, so that scripts can act on the outcome of a run with
+// -quiet rather than parsing its output.
+const (
+	exitOK = 0
+	// exitCompileError is returned when an expression fails to compile, and for any failure
+	// which is not a test result, such as invalid flags or unreadable files.
+	exitCompileError = 1
+	// exitTestFailure is returned when a test case or budget fails, and when a check of the
+	// rules finds a problem: a mutant survives, an input evades a rule, or a policy drifts or
+	// violates its guardrails.
+	exitTestFailure = 2
+	// exitEvalError is returned when a test case fails because its evaluation returned an
+	// unexpected error.
+	exitEvalError = 3
+)
+
+// codedError is an error which terminates the CLI with a specific exit code.
+type codedError struct {
+	code int
+	err  error
+}
+
+// Error implements the error interface.
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code for an error returned by one of the modes of the CLI.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return exitCompileError
+}
+
+// statusExitCode returns the exit code for a test status: exitEvalError when evaluation failed
+// unexpectedly, exitTestFailure for any other failure, and exitOK when it passed.
+func statusExitCode(s cloudarmor.TestStatus) int {
+	switch {
+	case s.Err != nil:
+		return exitEvalError
+	case s.Fail != "":
+		return exitTestFailure
+	}
+	return exitOK
+}
+
+// worstExitCode returns the exit code which takes precedence: compile errors, then evaluation
+// errors, then test failures.
+func worstExitCode(a, b int) int {
+	rank := map[int]int{exitOK: 0, exitTestFailure: 1, exitEvalError: 2, exitCompileError: 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
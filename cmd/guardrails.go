@@ -0,0 +1,53 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// runGuardrails checks a security policy against the guardrails, and the baseline policy when
+// one is given, and returns an error if any guardrail is violated.
+func runGuardrails(guardrailsPath, policyPath, baselinePath string) error {
+	data, err := os.ReadFile(guardrailsPath)
+	if err != nil {
+		return err
+	}
+	g, err := cloudarmor.GuardrailsFromYAML(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", guardrailsPath, err)
+	}
+	policy, err := loadSecurityPolicy(policyPath)
+	if err != nil {
+		return err
+	}
+	var baseline *cloudarmor.SecurityPolicy
+	if baselinePath != "" {
+		if baseline, err = loadSecurityPolicy(baselinePath); err != nil {
+			return err
+		}
+	}
+	violations := g.Check(policy, baseline)
+	for _, v := range violations {
+		fmt.Printf("%s: %s\n", policyPath, v)
+	}
+	if len(violations) != 0 {
+		return &codedError{code: exitTestFailure, err: fmt.Errorf("policy %s violates its guardrails", policy.Name)}
+	}
+	return nil
+}
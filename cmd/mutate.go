@@ -0,0 +1,50 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/mutation"
+)
+
+// runMutate runs the test suite against the mutants of its expression and returns an error if
+// any mutant survived.
+func (r *rules) runMutate(path string, yamlOpts []cloudarmor.YAMLOption) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ts, err := cloudarmor.TestSuiteFromYAML(data, yamlOpts...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	report, err := mutation.Run(r.Rules, ts.Expr, ts.Tests)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	survivors := report.Survivors()
+	for _, m := range survivors {
+		fmt.Printf("SURVIVED %s\n    %s\n", m.Description, m.Expr)
+	}
+	fmt.Printf("%d of %d mutants killed (score %.2f), %d invalid mutants skipped\n",
+		len(report.Mutants)-len(survivors), len(report.Mutants), report.Score(), report.Invalid)
+	if len(survivors) != 0 {
+		return &codedError{code: exitTestFailure, err: fmt.Errorf("%d mutants of %s survived", len(survivors), ts.Name)}
+	}
+	return nil
+}
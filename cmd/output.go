@@ -0,0 +1,106 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// outputWriter receives the encoded form of each compiled expression.
+type outputWriter interface {
+	// WriteRule writes the encoded rule, where name identifies the rule within output
+	// containing more than one rule, and returns the path written to, or "-" for stdout.
+	WriteRule(name string, data []byte) (string, error)
+	// Close flushes and releases the underlying destination.
+	Close() error
+}
+
+// newOutputWriter selects the writer for the -out and -out_dir flags, defaulting to stdout.
+func newOutputWriter(out, outDir, format string) (outputWriter, error) {
+	switch {
+	case outDir != "":
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return nil, err
+		}
+		return &dirWriter{dir: outDir, ext: "." + format}, nil
+	case out != "":
+		return &streamWriter{dest: out}, nil
+	default:
+		return &streamWriter{w: os.Stdout, dest: "-"}, nil
+	}
+}
+
+// streamWriter writes every rule to a single stream in order.
+//
+// A streamWriter without a stream creates the file named by dest when the first rule is written,
+// so that a run which fails before producing output leaves an existing file intact.
+type streamWriter struct {
+	w    io.Writer
+	c    io.Closer
+	dest string
+}
+
+// WriteRule implements the outputWriter interface.
+func (s *streamWriter) WriteRule(name string, data []byte) (string, error) {
+	if s.w == nil {
+		f, err := os.Create(s.dest)
+		if err != nil {
+			return s.dest, err
+		}
+		s.w, s.c = f, f
+	}
+	_, err := s.w.Write(data)
+	return s.dest, err
+}
+
+// Close implements the outputWriter interface.
+func (s *streamWriter) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// dirWriter writes each rule to its own file within a directory.
+type dirWriter struct {
+	dir string
+	ext string
+}
+
+// WriteRule implements the outputWriter interface.
+func (d *dirWriter) WriteRule(name string, data []byte) (string, error) {
+	path := filepath.Join(d.dir, name+d.ext)
+	return path, os.WriteFile(path, data, 0644)
+}
+
+// Close implements the outputWriter interface.
+func (d *dirWriter) Close() error {
+	return nil
+}
+
+// printChecksum reports the sha256 of the data written to dest on stderr, using the format of
+// sha256sum so that pipelines can verify the output they receive.
+func printChecksum(dest string, data []byte) {
+	fmt.Fprintf(os.Stderr, "%x  %s\n", sha256.Sum256(data), dest)
+}
+
+// ruleName returns the name of the n-th expression, counting from one, within -file output.
+func ruleName(n int) string {
+	return fmt.Sprintf("expr-%03d", n)
+}
@@ -0,0 +1,99 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// runPRDiff prints the semantic changes to the rules of a bundle between its base and head
+// revisions, one line per rule. When corpusPath is not empty, both revisions are then replayed
+// against the corpus and an error is returned if the requests matched by any rule changed by more
+// than the tolerance.
+func (r *rules) runPRDiff(basePath, headPath, corpusPath string, tol cloudarmor.RegressionTolerance, yamlOpts []cloudarmor.YAMLOption) error {
+	base, err := cloudarmor.LoadRuleBundle(basePath, yamlOpts...)
+	if err != nil {
+		return fmt.Errorf("base: %w", err)
+	}
+	head, err := cloudarmor.LoadRuleBundle(headPath, yamlOpts...)
+	if err != nil {
+		return fmt.Errorf("head: %w", err)
+	}
+	diff, err := r.DiffBundles(base, head)
+	if err != nil {
+		return err
+	}
+	for _, name := range diff.Added {
+		fmt.Printf("+ %s: added\n", name)
+	}
+	for _, name := range diff.Removed {
+		fmt.Printf("- %s: removed\n", name)
+	}
+	for _, c := range diff.Changed {
+		fmt.Printf("~ %s: base %.12s, head %.12s\n", c.Name, c.BaseHash, c.HeadHash)
+		for _, d := range c.Differences {
+			fmt.Printf("    base: %s\n    head: %s\n", d.Base, d.Head)
+		}
+	}
+	for _, m := range diff.Moved {
+		fmt.Printf("> %s: moved from position %d to %d\n", m.Name, m.From, m.To)
+	}
+	fmt.Printf("%d added, %d removed, %d changed, %d moved, %d unchanged\n",
+		len(diff.Added), len(diff.Removed), len(diff.Changed), len(diff.Moved), diff.Unchanged)
+	if corpusPath == "" {
+		return nil
+	}
+	return r.runRegression(base, head, corpusPath, tol, yamlOpts)
+}
+
+// runRegression replays the base and head revisions of a bundle against a corpus and prints the
+// rules whose matched requests changed, returning an error if any changed beyond the tolerance.
+func (r *rules) runRegression(base, head *cloudarmor.RuleBundle, corpusPath string, tol cloudarmor.RegressionTolerance, yamlOpts []cloudarmor.YAMLOption) error {
+	corpus, err := os.Open(corpusPath)
+	if err != nil {
+		return err
+	}
+	defer corpus.Close()
+	p := r.newProgress("replay", "requests")
+	report, err := r.ReplayRegressionContext(p.context(), base, head, corpus, tol, yamlOpts...)
+	p.finish()
+	if err != nil {
+		return err
+	}
+	for _, rule := range report.Rules {
+		if !rule.Changed() {
+			continue
+		}
+		status := "CHANGED"
+		if rule.Exceeded {
+			status = "REGRESSED"
+		}
+		fmt.Printf("%s %s: %d -> %d matches, %d newly matched, %d no longer matched\n",
+			status, rule.Name, rule.BaseMatches, rule.HeadMatches, rule.Broadened, rule.Narrowed)
+		if len(rule.BroadenedLines) != 0 {
+			fmt.Printf("    newly matched lines: %v\n", rule.BroadenedLines)
+		}
+	}
+	regressed := report.Regressed()
+	fmt.Printf("%d requests, %d of %d rules exceed the tolerance (%s broadened, %s narrowed)\n",
+		report.Requests, len(regressed), len(report.Rules), tol.MaxBroadened, tol.MaxNarrowed)
+	if len(regressed) != 0 {
+		return &codedError{code: exitTestFailure, err: fmt.Errorf("%d rules changed beyond the tolerance", len(regressed))}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// progressInterval is the minimum time between the status lines of a long-running operation.
+// Operations which complete within it print none.
+const progressInterval = 2 * time.Second
+
+// progress prints periodic status lines for a long-running operation to stderr, with its
+// throughput and, when the amount of work is known, the estimated time remaining.
+type progress struct {
+	label   string
+	unit    string
+	enabled bool
+	start   time.Time
+	last    time.Time
+	done    int
+	total   int
+	printed bool
+}
+
+// newProgress returns the progress of an operation described by label, which counts its work in
+// unit, such as "requests".
+func (r *rules) newProgress(label, unit string) *progress {
+	return &progress{label: label, unit: unit, enabled: r.progress, total: -1}
+}
+
+// context returns a context which reports the progress of the cloudarmor operation it is passed
+// to.
+func (p *progress) context() context.Context {
+	return cloudarmor.WithProgress(context.Background(), p.report)
+}
+
+// report records that done of total units of work have completed, where total is -1 if unknown,
+// and prints a status line if one has not been printed within progressInterval. Time is measured
+// from the first report, so that preparing the work does not skew the throughput.
+func (p *progress) report(done, total int) {
+	p.done, p.total = done, total
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start, p.last = now, now
+	}
+	if !p.enabled || now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	p.print(now)
+}
+
+// finish prints a final status line if any were printed, so the last one reflects completion.
+func (p *progress) finish() {
+	if p.printed {
+		p.print(time.Now())
+	}
+}
+
+func (p *progress) print(now time.Time) {
+	p.printed = true
+	elapsed := now.Sub(p.start)
+	rate := float64(p.done) / elapsed.Seconds()
+	line := fmt.Sprintf("%s: %d %s", p.label, p.done, p.unit)
+	if p.total >= 0 {
+		line = fmt.Sprintf("%s: %d of %d %s (%.0f%%)", p.label, p.done, p.total, p.unit, 100*float64(p.done)/float64(max(p.total, 1)))
+	}
+	line += fmt.Sprintf(", %.0f/s, %s elapsed", rate, elapsed.Round(time.Second))
+	if p.total > p.done && rate > 0 {
+		eta := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
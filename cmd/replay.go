@@ -0,0 +1,85 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// runReplay evaluates the rules of a bundle against a corpus of requests and prints the digest of
+// the outcomes, writing the outcomes themselves to outPath and a report of the impact of each rule
+// to reportPath if they are not empty.
+func (r *rules) runReplay(bundlePath, corpusPath, outPath, reportPath string, yamlOpts []cloudarmor.YAMLOption) error {
+	b, err := cloudarmor.LoadRuleBundle(bundlePath, yamlOpts...)
+	if err != nil {
+		return err
+	}
+	corpus, err := os.Open(corpusPath)
+	if err != nil {
+		return err
+	}
+	defer corpus.Close()
+	var (
+		f   *os.File
+		buf *bufio.Writer
+		w   io.Writer
+	)
+	if outPath != "" {
+		if f, err = os.Create(outPath); err != nil {
+			return err
+		}
+		defer f.Close()
+		buf = bufio.NewWriter(f)
+		w = buf
+	}
+	printFingerprint(r.Rules)
+	p := r.newProgress("replay", "requests")
+	report, err := r.ReplayContext(p.context(), b, corpus, w, yamlOpts...)
+	p.finish()
+	if err != nil {
+		return err
+	}
+	if buf != nil {
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("DIGEST %s (%d requests, %d rules)\n", report.Digest, report.Requests, report.Rules)
+	if report.CompileErrors != 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d rules failed to compile\n", report.CompileErrors, report.Rules)
+	}
+	for _, impact := range report.Impact {
+		if rep := impact.Reproducer; rep != nil {
+			fmt.Fprintf(os.Stderr, "%s: %d errors, line %d reduces to %s\n", impact.Name, impact.Errors, rep.Line, rep.When)
+		}
+	}
+	if reportPath != "" {
+		impact := cloudarmor.NewReport(b.Name)
+		impact.AddImpact(report)
+		if r.asns != nil {
+			impact.NameASNs(r.asns)
+		}
+		return writeReport(reportPath, impact)
+	}
+	return nil
+}
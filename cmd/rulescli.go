@@ -16,17 +16,25 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"unicode"
 
 	"github.com/google/cel-go/cel"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/conformance"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/differential"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/ipset"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/randutil"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/templates"
 )
 
 const textFmtHeader = `# proto-file: github.com/google/cel-spec/proto/checked.proto
@@ -35,35 +43,367 @@ const textFmtHeader = `# proto-file: github.com/google/cel-spec/proto/checked.pr
 `
 
 type options struct {
-	expr, file, test      string
-	outputFormat, version string
-	textproto             string
-	verbose               bool
+	expr, file, test       string
+	outputFormat, version  string
+	graph                  string
+	profile                string
+	textproto, conformance string
+	bundle, canary, drift  string
+	guardrails, policy     string
+	baseline               string
+	prDiff                 string
+	maxBroadened           string
+	maxNarrowed            string
+	replay, replayOut      string
+	report                 string
+	cacheDir               string
+	out, outDir            string
+	template, unparse      string
+	minimizeCIDRs          string
+	asnNames               string
+	presenceStyle          string
+	normalize              string
+	disableOperators       string
+	tags                   string
+	features               string
+	redact, redactAllow    string
+	params                 paramFlags
+	fingerprintLists       fingerprintListFlags
+	differential           int
+	bench, rate, requests  int
+	repeat                 int
+	maxExprLength          int
+	costLimit              uint64
+	maxStringSize          int
+	maxBodySize            int
+	seed                   int64
+	checkDeterminism       bool
+	unknowns               bool
+	absentAttributes       bool
+	validateRegionCodes    bool
+	validateASNs           bool
+	validateTLS            bool
+	explainFailures        bool
+	debug                  bool
+	strictYAML             bool
+	validateVars           bool
+	strictHeaders          bool
+	streamTests            bool
+	mutate                 bool
+	evasion                bool
+	explainStatic          bool
+	analyze                bool
+	changelog              bool
+	quiet                  bool
+	noProgress             bool
+	verbose                bool
 }
 
 func (o *options) registerFlags(fs *flag.FlagSet) {
 	fs.StringVar(&o.test, "test", "", "file containing test suites for a rule expression")
+	fs.StringVar(&o.tags, "tags", "", "Comma-separated tags selecting the test cases to run, e.g. 'smoke,regression'")
+	fs.BoolVar(&o.streamTests, "stream_tests", false, "Run -test as a stream of test cases, one per YAML document or JSON line, without loading the whole file")
+	fs.BoolVar(&o.mutate, "mutate", false, "Report the mutants of the -test suite's expr which its test cases fail to detect")
+	fs.BoolVar(&o.evasion, "evasion", false, "Report the re-encodings of the -test suite's matching inputs which evade its expr")
 	fs.StringVar(&o.expr, "expr", "", "CEL expression representing the Cloud Armor rule")
 	fs.StringVar(&o.file, "file", "", "File containing CEL expressions representing the Cloud Armor rule")
 	fs.StringVar(&o.outputFormat, "output_format", "", "output format (textproto, binarypb)")
+	fs.StringVar(&o.out, "out", "", "File to write the -output_format output to instead of stdout")
+	fs.StringVar(&o.outDir, "out_dir", "", "Directory in which to write one file per compiled expression")
 	fs.StringVar(&o.version, "version", "VCurrent", "valid versions (VCurrent, VNext)")
+	fs.StringVar(&o.profile, "profile", "http", "Security policy profile whose attributes are available (http, network, response)")
 	fs.StringVar(&o.textproto, "textproto", "", "File containing the rulesets as proto defined in VendorRulesetCollection")
+	fs.StringVar(&o.bundle, "bundle", "", "Rule bundle file whose rules are all compiled and tested")
+	fs.IntVar(&o.repeat, "repeat", 1, "Number of times -bundle runs its tests, reporting the tests whose outcome differs between runs as flaky")
+	fs.StringVar(&o.report, "report", "", "File to write a report of the -bundle, -replay, or -test run to, as HTML if it ends in .html and as JSON otherwise")
+	fs.StringVar(&o.prDiff, "pr_diff", "", "Base revision of -bundle whose rules are compared semantically with those of -bundle, such as for a pull request")
+	fs.StringVar(&o.maxBroadened, "max_broadened", "0", "Fraction of the -replay corpus, e.g. 0.1%, which a rule may newly match between the -pr_diff and -bundle revisions")
+	fs.StringVar(&o.maxNarrowed, "max_narrowed", "0", "Fraction of the -replay corpus, e.g. 0.1%, which a rule may no longer match between the -pr_diff and -bundle revisions")
+	fs.StringVar(&o.replay, "replay", "", "Corpus of requests to evaluate the rules of -bundle against, printing a digest of the outcomes")
+	fs.StringVar(&o.replayOut, "replay_out", "", "File to write the canonical outcomes of -replay to, for diffing replays")
+	fs.StringVar(&o.conformance, "conformance", "", "File or directory containing Cloud Armor behavior conformance fixtures")
+	fs.StringVar(&o.template, "template", "", "Rule template to render, or 'list' to list the available templates")
+	fs.StringVar(&o.minimizeCIDRs, "minimize_cidrs", "", "File of CIDR ranges, or '-' for stdin, to print as the fewest equivalent ranges")
+	fs.Var(&o.params, "param", "Template parameter as name=value; may be repeated")
+	fs.StringVar(&o.cacheDir, "cache_dir", "", "Directory in which to cache compiled expressions between invocations")
+	fs.IntVar(&o.differential, "differential", 0, "Compare -expr against the standard CEL environment over N generated inputs")
+	fs.IntVar(&o.bench, "bench", 0, "Load test -expr or -file against N generated requests")
+	fs.IntVar(&o.rate, "rate", 0, "Maximum requests per second generated by -bench, or 0 for no limit")
+	fs.StringVar(&o.canary, "canary", "", "Candidate rule set to compare against the active rule set in -file over generated requests")
+	fs.StringVar(&o.drift, "drift", "", "JSON export, or versioned YAML file, of a deployed security policy to compare against the rule set in -file")
+	fs.StringVar(&o.guardrails, "guardrails", "", "Guardrails to check the security policy in -policy against")
+	fs.StringVar(&o.policy, "policy", "", "JSON export, or versioned YAML file, of the security policy checked by -guardrails")
+	fs.StringVar(&o.baseline, "baseline", "", "Currently deployed security policy against which -guardrails checks require_preview")
+	fs.IntVar(&o.requests, "requests", 1000, "Number of generated requests evaluated by -canary")
+	fs.Int64Var(&o.seed, "seed", 1, "Seed for generated inputs, or 0 for a fresh seed which is printed with the results")
+	fs.BoolVar(&o.checkDeterminism, "check_determinism", false, "Evaluate each test case with optimized and unoptimized programs and fail on discrepancies")
+	fs.BoolVar(&o.unknowns, "unknowns", false, "Treat attributes omitted from test case inputs as unknown rather than zero values")
+	fs.BoolVar(&o.absentAttributes, "absent_attributes", false, "Treat scalar attributes omitted from test case inputs as absent, so has(request.method) is false")
+	fs.StringVar(&o.features, "features", "", "Comma-separated VNext features to enable with -version=VCurrent, e.g. 'request_body,params'")
+	fs.IntVar(&o.maxExprLength, "max_expr_length", 0, "Maximum length of an expression in characters, or 0 for the Cloud Armor default")
+	fs.Uint64Var(&o.costLimit, "cost_limit", 0, "Maximum cost of a single evaluation, or 0 for no limit")
+	fs.IntVar(&o.maxStringSize, "max_string_size", 0, "Maximum length of a string produced during evaluation, or 0 for no limit")
+	fs.IntVar(&o.maxBodySize, "max_body_size", 0, "Number of bytes of request.body inspected by rules, or 0 for the Cloud Armor default")
+	fs.StringVar(&o.disableOperators, "disable_operators", "", "Comma-separated operators to reject at check time, e.g. '?:,in'")
+	fs.BoolVar(&o.validateASNs, "validate_asns", false, "Reject comparisons of origin.asn with literals outside the 32-bit range of autonomous system numbers")
+	fs.BoolVar(&o.validateTLS, "validate_tls_fingerprints", false, "Reject comparisons of origin.tls_ja3_fingerprint and origin.tls_ja4_fingerprint with literals which are not fingerprints of that form")
+	fs.Var(&o.fingerprintLists, "fingerprint_list", "Fingerprint list matched by inFingerprintList as name=path to a CSV or JSON feed; may be repeated")
+	fs.BoolVar(&o.validateRegionCodes, "validate_region_codes", false, "Reject comparisons of origin.region_code with literals which are not ISO 3166-1 alpha-2 codes, e.g. 'UK'")
+	fs.StringVar(&o.redact, "redact", "", "Comma-separated attributes whose values are redacted from test failures and reports, e.g. \"request.query,request.headers['x-session']\"")
+	fs.StringVar(&o.redactAllow, "redact_allow", "", "Comma-separated attributes which are not redacted, including the default request.headers['authorization'] and cookies")
+	fs.BoolVar(&o.strictYAML, "strict_yaml", false, "Reject test suites containing unknown fields, duplicate keys, or mistyped values")
+	fs.BoolVar(&o.validateVars, "validate_vars", false, "Reject test cases whose variables fail validation, e.g. an unparseable origin.ip")
+	fs.StringVar(&o.normalize, "normalize", "none", "Normalization applied to the request.path and request.query of test cases and corpus requests (none, gfe, strict)")
+	fs.BoolVar(&o.strictHeaders, "strict_headers", false, "Reject test cases whose header values are numbers, booleans, or null rather than strings")
+	fs.StringVar(&o.unparse, "unparse", "", "CheckedExpr file written with -output_format to print as a Cloud Armor expression")
+	fs.StringVar(&o.presenceStyle, "presence_style", "field", "Form of the has() calls printed by -unparse (field, index)")
+	fs.StringVar(&o.graph, "graph", "", "Print the checked AST of each compiled expression as a graph (dot, mermaid)")
+	fs.StringVar(&o.asnNames, "asn_names", "", "File mapping autonomous system numbers to organization names, or 'builtin', used by -explain_static and -report")
+	fs.BoolVar(&o.explainFailures, "explain_failures", false, "Print the values of the subexpressions of the rule beneath each failed test case, and record them in -report")
+	fs.BoolVar(&o.debug, "debug", false, "Print the stack of each panic recovered during the evaluation of a failed test case")
+	fs.BoolVar(&o.explainStatic, "explain_static", false, "Print an English summary of each compiled expression")
+	fs.BoolVar(&o.changelog, "changelog", false, "Print the attributes, functions, and features which VNext adds to VCurrent")
+	fs.BoolVar(&o.analyze, "analyze", false, "Print the estimated memory footprint of each compiled expression, or of the rulesets in -textproto")
+	fs.BoolVar(&o.noProgress, "no_progress", false, "Disable the periodic status lines of corpus replays, vendor ruleset compilation, and test suites, such as in CI")
+	fs.BoolVar(&o.quiet, "quiet", false, "Suppress all output and report the outcome only through the exit code")
 	fs.BoolVar(&o.verbose, "verbose", false, "Enable verbose logging")
 }
 
+// limits returns the Cloud Armor limits overridden by the limit flags, and whether any were.
+func (o *options) limits() (cloudarmor.Limits, bool) {
+	limits := cloudarmor.DefaultLimits()
+	if o.maxExprLength != 0 {
+		limits.MaxExpressionLength = o.maxExprLength
+	}
+	if o.maxBodySize != 0 {
+		limits.MaxBodySize = o.maxBodySize
+	}
+	limits.CostLimit = o.costLimit
+	limits.MaxStringSize = o.maxStringSize
+	return limits, o.maxExprLength != 0 || o.costLimit != 0 || o.maxStringSize != 0 || o.maxBodySize != 0
+}
+
+// tolerance returns the regression tolerance of the -max_broadened and -max_narrowed flags.
+func (o *options) tolerance() (cloudarmor.RegressionTolerance, error) {
+	broadened, err := cloudarmor.ParseMatchRate(o.maxBroadened)
+	if err != nil {
+		return cloudarmor.RegressionTolerance{}, fmt.Errorf("-max_broadened: %w", err)
+	}
+	narrowed, err := cloudarmor.ParseMatchRate(o.maxNarrowed)
+	if err != nil {
+		return cloudarmor.RegressionTolerance{}, fmt.Errorf("-max_narrowed: %w", err)
+	}
+	return cloudarmor.RegressionTolerance{MaxBroadened: broadened, MaxNarrowed: narrowed}, nil
+}
+
+// hasMode reports whether the options select something for the CLI to do.
+func (o *options) hasMode() bool {
+	return o.expr != "" || o.file != "" || o.test != "" || o.textproto != "" || o.conformance != "" || o.template != "" || o.bundle != "" || o.unparse != "" || o.changelog || o.minimizeCIDRs != "" || o.guardrails != ""
+}
+
+// readStdin reads the expression from stdin when -expr=- is given, or when no other mode is
+// selected and stdin is not a terminal, so that rule text can be piped to the CLI without
+// quoting it for the shell.
+func (o *options) readStdin(stdin *os.File) error {
+	if o.expr != "-" {
+		if o.hasMode() {
+			return nil
+		}
+		if info, err := stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice != 0 {
+			return nil
+		}
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read expression from stdin: %w", err)
+	}
+	o.expr = strings.TrimSpace(string(data))
+	if o.expr == "" {
+		return fmt.Errorf("no expression on stdin")
+	}
+	return nil
+}
+
 func (o *options) validate() error {
-	if o.expr == "" && o.file == "" && o.test == "" && o.textproto == "" {
-		return fmt.Errorf("either -expr=<expression> or -file=<file> or -test=<test_suite_file> or -textproto=<textproto_file> is required")
+	if !o.hasMode() {
+		return fmt.Errorf("either -expr=<expression> or -file=<file> or -test=<test_suite_file> or -textproto=<textproto_file> or -conformance=<path> or -template=<name> or -bundle=<bundle_file> or -unparse=<checked_expr_file> or -changelog or -minimize_cidrs=<file> or -guardrails=<guardrails_file> is required")
 	}
-	if o.expr != "" && o.outputFormat != "" &&
-		o.outputFormat != "textproto" && o.outputFormat != "binarypb" {
+	if len(o.params) != 0 && (o.template == "" || o.template == "list") {
+		return fmt.Errorf("-param requires -template=<name>")
+	}
+	if _, err := cloudarmor.ParsePolicyProfile(o.profile); err != nil {
+		return err
+	}
+	if _, err := cloudarmor.ParsePresenceStyle(o.presenceStyle); err != nil {
+		return err
+	}
+	if _, err := cloudarmor.ParseNormalization(o.normalize); err != nil {
+		return err
+	}
+	if o.features != "" {
+		for _, name := range strings.Split(o.features, ",") {
+			if _, err := cloudarmor.ParseFeature(name); err != nil {
+				return err
+			}
+		}
+	}
+	if o.graph != "" {
+		if _, err := cloudarmor.ParseGraphFormat(o.graph); err != nil {
+			return err
+		}
+	}
+	if o.maxExprLength < 0 || o.maxStringSize < 0 || o.maxBodySize < 0 {
+		return fmt.Errorf("-max_expr_length, -max_string_size, and -max_body_size must not be negative")
+	}
+	if o.outputFormat != "" && o.outputFormat != "textproto" && o.outputFormat != "binarypb" {
 		return fmt.Errorf("unsupported -output_format=%s, must be textproto or binarypb", o.outputFormat)
 	}
+	if o.out != "" && o.outDir != "" {
+		return fmt.Errorf("-out and -out_dir are mutually exclusive")
+	}
+	if (o.out != "" || o.outDir != "") && o.outputFormat == "" {
+		return fmt.Errorf("-out and -out_dir require -output_format=<textproto|binarypb>")
+	}
+	if o.differential != 0 && o.expr == "" {
+		return fmt.Errorf("-differential requires -expr=<expression>")
+	}
+	if o.bench != 0 && o.expr == "" && o.file == "" {
+		return fmt.Errorf("-bench requires -expr=<expression> or -file=<file>")
+	}
+	if o.canary != "" && o.file == "" {
+		return fmt.Errorf("-canary requires -file=<active rule set>")
+	}
+	if o.repeat < 1 {
+		return fmt.Errorf("-repeat must be at least 1")
+	}
+	if o.repeat != 1 && o.bundle == "" {
+		return fmt.Errorf("-repeat requires -bundle=<bundle_file>")
+	}
+	if o.report != "" && o.bundle == "" && (o.test == "" || o.streamTests) {
+		return fmt.Errorf("-report requires -bundle=<bundle_file> or -test=<test_suite_file>")
+	}
+	if o.replay != "" && o.bundle == "" {
+		return fmt.Errorf("-replay requires -bundle=<bundle_file>")
+	}
+	if o.prDiff != "" && o.bundle == "" {
+		return fmt.Errorf("-pr_diff requires -bundle=<head bundle_file>")
+	}
+	if o.prDiff != "" && (o.replayOut != "" || o.report != "") {
+		return fmt.Errorf("-replay_out and -report are not supported with -pr_diff")
+	}
+	if (o.maxBroadened != "0" || o.maxNarrowed != "0") && (o.prDiff == "" || o.replay == "") {
+		return fmt.Errorf("-max_broadened and -max_narrowed require -pr_diff=<base bundle_file> and -replay=<corpus_file>")
+	}
+	if _, err := o.tolerance(); err != nil {
+		return err
+	}
+	if o.replayOut != "" && o.replay == "" {
+		return fmt.Errorf("-replay_out requires -replay=<corpus_file>")
+	}
+	if o.drift != "" && o.file == "" {
+		return fmt.Errorf("-drift requires -file=<local rule set>")
+	}
+	if o.guardrails != "" && o.policy == "" {
+		return fmt.Errorf("-guardrails requires -policy=<security policy>")
+	}
+	if (o.policy != "" || o.baseline != "") && o.guardrails == "" {
+		return fmt.Errorf("-policy and -baseline require -guardrails=<guardrails file>")
+	}
+	if o.streamTests && o.test == "" {
+		return fmt.Errorf("-stream_tests requires -test=<test_stream_file>")
+	}
+	if o.mutate && o.test == "" {
+		return fmt.Errorf("-mutate requires -test=<test_suite_file>")
+	}
+	if o.evasion && o.test == "" {
+		return fmt.Errorf("-evasion requires -test=<test_suite_file>")
+	}
+	if o.bench < 0 || o.rate < 0 {
+		return fmt.Errorf("-bench and -rate must not be negative")
+	}
+	return nil
+}
+
+// paramFlags collects repeated -param name=value flags.
+type paramFlags map[string]string
+
+// String implements the flag.Value interface.
+func (p paramFlags) String() string {
+	var params []string
+	for name, val := range p {
+		params = append(params, name+"="+val)
+	}
+	return strings.Join(params, " ")
+}
+
+// Set implements the flag.Value interface.
+func (p *paramFlags) Set(param string) error {
+	name, val, found := strings.Cut(param, "=")
+	if !found {
+		return fmt.Errorf("parameter %q must be in the form name=value", param)
+	}
+	if *p == nil {
+		*p = paramFlags{}
+	}
+	(*p)[name] = val
+	return nil
+}
+
+// fingerprintListFlags collects repeated -fingerprint_list name=path flags, loading each list.
+type fingerprintListFlags []*cloudarmor.FingerprintList
+
+// String implements the flag.Value interface.
+func (f fingerprintListFlags) String() string {
+	var names []string
+	for _, l := range f {
+		names = append(names, l.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// Set implements the flag.Value interface.
+func (f *fingerprintListFlags) Set(list string) error {
+	name, path, found := strings.Cut(list, "=")
+	if !found {
+		return fmt.Errorf("fingerprint list %q must be in the form name=path", list)
+	}
+	l, err := cloudarmor.LoadFingerprintList(name, path)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, l)
 	return nil
 }
 
 type rules struct {
 	*cloudarmor.Rules
+	cache   *cloudarmor.CompileCache
+	out     outputWriter
+	explain bool
+	analyze bool
+	// progress enables status lines for long-running operations.
+	progress bool
+	// graph is the format in which compiled expressions are printed as graphs, if set.
+	graph *cloudarmor.GraphFormat
+	// asns names the organizations of autonomous systems in summaries and reports, if set.
+	asns cloudarmor.ASNDirectory
+	// options are the options selected by the flags, on top of which a test suite's version and
+	// options are applied.
+	options []cloudarmor.RulesOption
+}
+
+// useSuite replaces the environment with one configured by the version and options of a test
+// suite, if it declares any, which take precedence over the flags.
+func (r *rules) useSuite(ts *cloudarmor.TestSuite) error {
+	if !ts.HasSetup() {
+		return nil
+	}
+	suiteRules, err := cloudarmor.NewRulesForSuite(ts, r.options...)
+	if err != nil {
+		return fmt.Errorf("suite %q: %w", ts.Name, err)
+	}
+	r.Rules = suiteRules
+	return nil
 }
 
 func verboseLog(enabled bool, message string, args ...any) {
@@ -72,18 +412,125 @@ func verboseLog(enabled bool, message string, args ...any) {
 	}
 }
 
-func newRules(ver string) *rules {
+// printTrace prints the values of the subexpressions of a failed test case on stderr, indented
+// beneath its failure.
+func printTrace(trace []cloudarmor.TraceStep) {
+	for _, step := range trace {
+		fmt.Fprintf(os.Stderr, "    %s\n", step)
+	}
+}
+
+// printStack prints the stack of a panic recovered during the evaluation of a failed test case on
+// stderr, if it was captured by the -debug flag.
+func printStack(err error) {
+	var e *cloudarmor.EvalError
+	if errors.As(err, &e) && len(e.Stack) != 0 {
+		os.Stderr.Write(e.Stack)
+	}
+}
+
+// printFingerprint reports the fingerprint of the environment in which test results were
+// produced on stderr, ahead of the results, so that reports record the semantics they used.
+func printFingerprint(r *cloudarmor.Rules) {
+	fmt.Fprintf(os.Stderr, "ENV %s\n", r.EnvFingerprint())
+}
+
+// rulesOptions returns the options which configure the rules environment as selected by the
+// flags.
+func rulesOptions(opts *options) []cloudarmor.RulesOption {
 	version := cloudarmor.VCurrent
-	if ver == "VNext" {
+	if opts.version == "VNext" {
 		version = cloudarmor.VNext
 	}
 
-	r, err := cloudarmor.NewRules(cloudarmor.Version(version))
+	profile, _ := cloudarmor.ParsePolicyProfile(opts.profile)
+	rulesOpts := []cloudarmor.RulesOption{cloudarmor.Version(version), cloudarmor.Profile(profile)}
+	if opts.checkDeterminism {
+		rulesOpts = append(rulesOpts, cloudarmor.DeterminismCheck())
+	}
+	if opts.unknowns {
+		rulesOpts = append(rulesOpts, cloudarmor.WithUnknowns())
+	}
+	if opts.absentAttributes {
+		rulesOpts = append(rulesOpts, cloudarmor.Presence(cloudarmor.PresenceAbsent))
+	}
+	if opts.disableOperators != "" {
+		rulesOpts = append(rulesOpts, cloudarmor.DisableOperators(strings.Split(opts.disableOperators, ",")...))
+	}
+	if opts.validateRegionCodes {
+		rulesOpts = append(rulesOpts, cloudarmor.ValidateRegionCodes())
+	}
+	if opts.validateASNs {
+		rulesOpts = append(rulesOpts, cloudarmor.ValidateASNs())
+	}
+	if opts.validateTLS {
+		rulesOpts = append(rulesOpts, cloudarmor.ValidateTLSFingerprints())
+	}
+	if opts.explainFailures {
+		rulesOpts = append(rulesOpts, cloudarmor.ExplainFailures())
+	}
+	if opts.debug {
+		rulesOpts = append(rulesOpts, cloudarmor.Debug())
+	}
+	if len(opts.fingerprintLists) > 0 {
+		rulesOpts = append(rulesOpts, cloudarmor.WithFingerprintLists(opts.fingerprintLists...))
+	}
+	if opts.features != "" {
+		for _, name := range strings.Split(opts.features, ",") {
+			f, _ := cloudarmor.ParseFeature(name)
+			rulesOpts = append(rulesOpts, cloudarmor.WithFeature(f))
+		}
+	}
+	if limits, ok := opts.limits(); ok {
+		rulesOpts = append(rulesOpts, cloudarmor.WithLimits(limits))
+	}
+	if opts.redact != "" {
+		rulesOpts = append(rulesOpts, cloudarmor.RedactAttributes(strings.Split(opts.redact, ",")...))
+	}
+	if opts.redactAllow != "" {
+		rulesOpts = append(rulesOpts, cloudarmor.AllowAttributes(strings.Split(opts.redactAllow, ",")...))
+	}
+	if opts.tags != "" {
+		rulesOpts = append(rulesOpts, cloudarmor.TestTags(strings.Split(opts.tags, ",")...))
+	}
+	return rulesOpts
+}
+
+func newRules(opts *options) *rules {
+	if profile, _ := cloudarmor.ParsePolicyProfile(opts.profile); profile == cloudarmor.ProfileResponse {
+		fmt.Fprintln(os.Stderr, "warning: the response profile is experimental; its rules cannot be deployed to Cloud Armor")
+	}
+	rulesOpts := rulesOptions(opts)
+	r, err := cloudarmor.NewRules(rulesOpts...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create rules environment: %v\n", err)
 		os.Exit(1)
 	}
-	return &rules{r}
+	var cache *cloudarmor.CompileCache
+	if opts.cacheDir != "" {
+		cache, err = cloudarmor.NewCompileCache(opts.cacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create compile cache: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	out, err := newOutputWriter(opts.out, opts.outDir, opts.outputFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create output writer: %v\n", err)
+		os.Exit(1)
+	}
+	rs := &rules{Rules: r, cache: cache, out: out, explain: opts.explainStatic, analyze: opts.analyze, progress: !opts.noProgress, options: rulesOpts}
+	if opts.graph != "" {
+		format, _ := cloudarmor.ParseGraphFormat(opts.graph)
+		rs.graph = &format
+	}
+	if opts.asnNames != "" {
+		if rs.asns, err = cloudarmor.LoadASNDirectory(opts.asnNames); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load ASN names: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	return rs
 }
 
 func (r *rules) processExprFile(filename string, outputFormat string, verbose bool) error {
@@ -101,17 +548,15 @@ func (r *rules) processExprFile(filename string, outputFormat string, verbose bo
 		return err
 	}
 
-	expressions := strings.Split(string(content), ";") // Expressions are separated by delimiter ';'
-
-	LineNumber := 1
-	for index, expr := range expressions {
-		LineNumber += strings.Count(expr, "\n")
-		expr = strings.TrimSpace(expr)
-		if expr == "" {
-			continue
-		}
-
-		verboseLog(verbose, "Processing expr at index: %d, line: %d, expr: %s", index, LineNumber, expr)
+	entries := cloudarmor.ParseRuleFile(string(content))
+	// Concatenated messages merge when parsed, so a single stream holds one binarypb rule.
+	if _, stream := r.out.(*streamWriter); stream && outputFormat == "binarypb" && len(entries) > 1 {
+		return fmt.Errorf("-output_format=binarypb of %d expressions requires -out_dir=<dir>", len(entries))
+	}
+	compiled := 0
+	for index, entry := range entries {
+		expr := entry.Expr
+		verboseLog(verbose, "Processing expr at index: %d, line: %d, expr: %s", index, entry.Line, expr)
 
 		ast, ok := r.newAST(expr)
 		if !ok {
@@ -119,42 +564,109 @@ func (r *rules) processExprFile(filename string, outputFormat string, verbose bo
 		}
 		verboseLog(verbose, "Successfully compiled expression: %v", expr)
 
-		r.printAST(ast, outputFormat)
+		compiled++
+		if err := r.printAST(ruleName(compiled), ast, outputFormat, entry.Comments); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (r *rules) newAST(expr string) (*cel.Ast, bool) {
-
-	// Convert bracket notation to dot notation
-	if strings.Contains(expr, "request.params") {
-		expr = strings.ReplaceAll(expr, "['", ".")
-		expr = strings.ReplaceAll(expr, "']", "")
-	}
-	ast, err := r.Compile(expr)
+	ast, err := r.compile(expr)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to compile expression: %v\n", err)
+		printHints(err)
 		return nil, false
 	}
 	return ast, true
 }
 
-func (r *rules) printAST(ast *cel.Ast, outputFormat string) {
+// printHints suggests the flags which declare the attributes and functions that a failed
+// compilation found undeclared.
+func printHints(err error) {
+	var compileErr *cloudarmor.CompileError
+	if !errors.As(err, &compileErr) {
+		return
+	}
+	for _, h := range compileErr.Hints {
+		var flags []string
+		if h.Declared.Profile != h.In.Profile {
+			flags = append(flags, "-profile="+h.Declared.Profile.String())
+		}
+		if h.Declared.Version != h.In.Version {
+			flags = append(flags, "-version="+cloudarmor.VersionName(h.Declared.Version))
+		}
+		hint := fmt.Sprintf("hint: %s; pass %s", h, strings.Join(flags, " "))
+		if h.Feature != "" {
+			hint += fmt.Sprintf(" or -features=%s", h.Feature)
+		}
+		fmt.Fprintln(os.Stderr, hint)
+	}
+}
+
+func (r *rules) compile(expr string) (*cel.Ast, error) {
+	if r.cache != nil {
+		return r.CompileCached(r.cache, expr)
+	}
+	return r.Compile(expr)
+}
+
+// printAST prints the requested summaries of the compiled expression and writes it in the output
+// format, if any. The comments annotating the rule are carried into the textproto output as
+// comment lines following its header.
+func (r *rules) printAST(name string, ast *cel.Ast, outputFormat string, comments []string) error {
+	if r.explain {
+		fmt.Println(cloudarmor.Summarize(ast, cloudarmor.SummaryASNs(r.asns)))
+	}
+	if r.analyze {
+		fmt.Printf("footprint: %s\n", cloudarmor.EstimateFootprint(ast))
+	}
+	if r.graph != nil {
+		g, err := r.Graph(ast, name, *r.graph)
+		if err != nil {
+			return err
+		}
+		fmt.Print(g)
+	}
 	pb, err := cel.AstToCheckedExpr(ast)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to convert ast to checked expr: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to convert ast to checked expr: %w", err)
+	}
+	var data []byte
+	switch outputFormat {
+	case "textproto":
+		var header strings.Builder
+		header.WriteString(strings.TrimSuffix(textFmtHeader, "\n"))
+		fmt.Fprintf(&header, "# env-fingerprint: %s\n\n", r.EnvFingerprint())
+		for _, c := range comments {
+			fmt.Fprintf(&header, "# %s\n", c)
+		}
+		if len(comments) != 0 {
+			header.WriteString("\n")
+		}
+		data = []byte(header.String() + prototext.Format(pb) + "\n")
+	case "binarypb":
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(pb)
+		if err != nil {
+			return fmt.Errorf("failed to marshal checked expr: %w", err)
+		}
+	default:
+		return nil
 	}
-	if outputFormat == "textproto" {
-		fmt.Println(textFmtHeader + prototext.Format(pb))
-	} else if outputFormat == "binarypb" {
-		fmt.Println(proto.MarshalOptions{Deterministic: true}.Marshal(pb))
+	dest, err := r.out.WriteRule(name, data)
+	if err != nil {
+		return err
 	}
+	printChecksum(dest, data)
+	return nil
 }
 
+// newProgram creates a program for running test cases, tracking the cost of each evaluation so
+// that max_cost budgets can be checked.
 func (r *rules) newProgram(ast *cel.Ast) cel.Program {
-	prg, err := r.Program(ast)
+	prg, err := r.Program(ast, cel.CostTracking(nil))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create program: %v\n", err)
 		os.Exit(1)
@@ -162,7 +674,7 @@ func (r *rules) newProgram(ast *cel.Ast) cel.Program {
 	return prg
 }
 
-func processVendorRuleset(filename string, verbose bool) error {
+func (r *rules) processVendorRuleset(filename string, verbose bool) error {
 	verboseLog(verbose, "Reading vendor ruleset file: %s", filename)
 	content, err := os.ReadFile(filename)
 
@@ -181,13 +693,270 @@ func processVendorRuleset(filename string, verbose bool) error {
 	}
 
 	fmt.Printf("Successfully validated vendor ruleset. \n")
+	if r.analyze {
+		p := r.newProgress("compile", "rules")
+		f, err := r.VendorRulesetFootprintContext(p.context(), &rulesetCollection)
+		p.finish()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("footprint: %s\n", f)
+	}
+	return nil
+}
+
+func runConformance(path string) error {
+	fixtures, err := conformance.Load(path)
+	if err != nil {
+		return err
+	}
+	results, err := conformance.Run(fixtures)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, res := range results {
+		if res.Fail != "" {
+			failed++
+			fmt.Fprintf(os.Stderr, "FAIL %s/%s: %s\n", res.Fixture, res.Name, res.Fail)
+		} else {
+			fmt.Fprintf(os.Stderr, "PASS %s/%s\n", res.Fixture, res.Name)
+		}
+	}
+	if failed != 0 {
+		return &codedError{code: exitTestFailure, err: fmt.Errorf("%d of %d conformance cases failed", failed, len(results))}
+	}
+	return nil
+}
+
+func yamlOptions(opts *options) []cloudarmor.YAMLOption {
+	var yamlOpts []cloudarmor.YAMLOption
+	if opts.strictYAML {
+		yamlOpts = append(yamlOpts, cloudarmor.StrictYAML())
+	}
+	if opts.validateVars {
+		yamlOpts = append(yamlOpts, cloudarmor.ValidateVariables())
+	}
+	if opts.strictHeaders {
+		yamlOpts = append(yamlOpts, cloudarmor.StrictHeaderValues())
+	}
+	if n, _ := cloudarmor.ParseNormalization(opts.normalize); n != cloudarmor.NormalizeNone {
+		yamlOpts = append(yamlOpts, cloudarmor.NormalizeRequests(n))
+	}
+	return yamlOpts
+}
+
+// checkMatchRate evaluates the program against the corpus of the test suite, whose path is
+// relative to the suite file, and returns the status of the suite's max_match_rate assertion.
+func (r *rules) checkMatchRate(prg cel.Program, ts *cloudarmor.TestSuite, suitePath string, yamlOpts []cloudarmor.YAMLOption) (cloudarmor.TestStatus, error) {
+	path := ts.Corpus
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(suitePath), path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return cloudarmor.TestStatus{}, err
+	}
+	defer f.Close()
+	p := r.newProgress("corpus", "requests")
+	report, err := r.EvaluateCorpusContext(p.context(), prg, f, yamlOpts...)
+	p.finish()
+	if err != nil {
+		return cloudarmor.TestStatus{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return ts.CheckMatchRate(report), nil
+}
+
+// runPolicySuite evaluates the test cases of the suite against its security policy, whose path
+// is relative to the suite file.
+func (r *rules) runPolicySuite(ts *cloudarmor.TestSuite, suitePath string) ([]cloudarmor.TestStatus, error) {
+	path := ts.Policy
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(suitePath), path)
+	}
+	policy, err := loadSecurityPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := r.NewPolicyEvaluator(policy)
+	if err != nil {
+		return nil, err
+	}
+	return r.RunPolicyValidation(e, ts.Tests), nil
+}
+
+func (r *rules) runBundle(path string, repeat int, reportPath string, yamlOpts []cloudarmor.YAMLOption) error {
+	b, err := cloudarmor.LoadRuleBundle(path, yamlOpts...)
+	if err != nil {
+		return err
+	}
+	// Each run is recorded in the report, so that test cases whose outcome differs between runs
+	// are reported as flaky.
+	report := cloudarmor.NewReport(b.Name)
+	code := exitOK
+	for i := 0; i < repeat; i++ {
+		results, err := r.RunBundle(b)
+		if err != nil {
+			return err
+		}
+		for _, res := range results {
+			if res.CompileError != nil {
+				code = worstExitCode(code, exitCompileError)
+			}
+			for _, s := range res.Statuses {
+				code = worstExitCode(code, statusExitCode(s))
+			}
+		}
+		report.Add(results)
+	}
+	printFingerprint(r.Rules)
+	for _, rule := range report.Rules {
+		if rule.CompileError != "" {
+			fmt.Fprintf(os.Stderr, "FAIL %s/%s: failed to compile: %s\n", b.Name, rule.Name, rule.CompileError)
+			continue
+		}
+		for _, c := range rule.Cases {
+			switch c.Outcome {
+			case cloudarmor.OutcomePass:
+				fmt.Fprintf(os.Stderr, "PASS %s/%s/%s\n", b.Name, rule.Name, c.Name)
+			case cloudarmor.OutcomeFail:
+				fmt.Fprintf(os.Stderr, "FAIL %s/%s/%s: %s\n", b.Name, rule.Name, c.Name, strings.Join(c.Failures, "; "))
+				printTrace(c.Trace)
+			case cloudarmor.OutcomeFlaky:
+				fmt.Fprintf(os.Stderr, "FLAKY %s/%s/%s: passed %d of %d runs: %s\n",
+					b.Name, rule.Name, c.Name, c.Passes, c.Runs, strings.Join(c.Failures, "; "))
+				printTrace(c.Trace)
+			}
+		}
+	}
+	sum := report.Summary
+	fmt.Fprintf(os.Stderr, "%d of %d rules passed, %d of %d tests passed\n",
+		sum.Rules-sum.FailedRules, sum.Rules, sum.Cases-sum.FailedCases-sum.FlakyCases, sum.Cases)
+	if sum.FlakyCases != 0 {
+		fmt.Fprintf(os.Stderr, "%d tests are flaky over %d runs\n", sum.FlakyCases, sum.Runs)
+	}
+	if reportPath != "" {
+		if err := writeReport(reportPath, report); err != nil {
+			return err
+		}
+	}
+	if !report.Passed() {
+		return &codedError{code: code, err: fmt.Errorf("%d of %d rules failed", sum.FailedRules, sum.Rules)}
+	}
+	return nil
+}
+
+// writeReport writes the aggregate report of a run to a file, as HTML if the file name ends in
+// .html and as JSON otherwise.
+func writeReport(path string, report *cloudarmor.Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	write := report.WriteJSON
+	if ext := filepath.Ext(path); ext == ".html" || ext == ".htm" {
+		write = report.WriteHTML
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runTemplate(name string, params map[string]string) error {
+	if name == "list" {
+		for _, t := range templates.List() {
+			fmt.Printf("%s: %s\n", t.Name, t.Description)
+			for _, p := range t.Params {
+				fmt.Printf("  -param %s=<%s>  %s\n", p.Name, p.Kind, p.Description)
+			}
+		}
+		return nil
+	}
+	expr, err := templates.Render(name, params)
+	if err != nil {
+		return err
+	}
+	fmt.Println(expr)
+	return nil
+}
+
+// runMinimizeCIDRs prints the fewest CIDR ranges covering exactly the ranges listed in a file,
+// one per line. Ranges may be separated by newlines, commas, or spaces, and '#' begins a comment.
+func runMinimizeCIDRs(path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	var cidrs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		cidrs = append(cidrs, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	minimized, err := ipset.Minimize(cidrs)
+	if err != nil {
+		return err
+	}
+	for _, cidr := range minimized {
+		fmt.Println(cidr)
+	}
+	fmt.Fprintf(os.Stderr, "%d ranges minimized to %d\n", len(cidrs), len(minimized))
+	return nil
+}
+
+func (r *rules) runDifferential(expr string, n int, seed int64) error {
+	report, err := differential.Compare(r.Rules, expr, differential.GenerateInputs(seed, n))
+	if err != nil {
+		return err
+	}
+	if report.CompileDiffers() {
+		fmt.Printf("compilation differs:\n  cloud armor: %v\n  standard:    %v\n",
+			report.CloudArmorCompile, report.StandardCompile)
+		return nil
+	}
+	if report.CloudArmorCompile.Err != nil {
+		fmt.Printf("compilation fails in both environments:\n  cloud armor: %v\n  standard:    %v\n",
+			report.CloudArmorCompile, report.StandardCompile)
+		return nil
+	}
+	fmt.Printf("%d of %d evaluations differ (seed %d)\n", len(report.Differences), report.Evaluations, seed)
+	for _, d := range report.Differences {
+		fmt.Printf("  input: %s\n    reduced:     %s\n    cloud armor: %v\n    standard:    %v\n",
+			cloudarmor.FormatVariables(d.Input), cloudarmor.FormatVariables(d.Reduced), d.CloudArmor, d.Standard)
+	}
 	return nil
 }
 
 func main() {
 	var opts options
 	opts.registerFlags(flag.CommandLine)
-	flag.Parse()
+	// Invalid flags exit with exitCompileError rather than the flag package's default of 2,
+	// which is reserved for test failures.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitCompileError)
+	}
+	if opts.quiet {
+		// Every mode writes through os.Stdout and os.Stderr, so discarding them silences the
+		// CLI while files written with -out and -out_dir are still produced.
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout, os.Stderr = devNull, devNull
+		}
+	}
 
 	// Handle default expression
 	args := flag.Args()
@@ -195,25 +964,150 @@ func main() {
 		opts.expr = args[0] // Assign default argument to `expr`
 	}
 
+	if err := opts.readStdin(os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 	if err := opts.validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "invalid options: %v\n", err)
 		os.Exit(1)
 	}
+	opts.seed = randutil.Resolve(opts.seed)
+
+	if opts.conformance != "" {
+		if err := runConformance(opts.conformance); err != nil {
+			fmt.Fprintf(os.Stderr, "conformance: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.template != "" {
+		if err := runTemplate(opts.template, opts.params); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if opts.minimizeCIDRs != "" {
+		if err := runMinimizeCIDRs(opts.minimizeCIDRs); err != nil {
+			fmt.Fprintf(os.Stderr, "minimize_cidrs: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if opts.changelog {
+		changes, err := cloudarmor.ChangesBetween(cloudarmor.VCurrent, cloudarmor.VNext)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "changelog: %v\n", err)
+			os.Exit(1)
+		}
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		os.Exit(0)
+	}
+
+	if opts.unparse != "" {
+		if err := runUnparse(opts.unparse, opts.presenceStyle); err != nil {
+			fmt.Fprintf(os.Stderr, "unparse: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	r := newRules(opts.version)
+	r := newRules(&opts)
 
 	if opts.textproto != "" {
-		if err := processVendorRuleset(opts.textproto, opts.verbose); err != nil {
+		if err := r.processVendorRuleset(opts.textproto, opts.verbose); err != nil {
 			fmt.Fprintf(os.Stderr, "failed to process vendor ruleset: %v\n", err)
 			os.Exit(1)
 		}
 		os.Exit(0)
 	}
 
+	if opts.prDiff != "" {
+		tol, _ := opts.tolerance()
+		if err := r.runPRDiff(opts.prDiff, opts.bundle, opts.replay, tol, yamlOptions(&opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "pr_diff: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.replay != "" {
+		if err := r.runReplay(opts.bundle, opts.replay, opts.replayOut, opts.report, yamlOptions(&opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.bundle != "" {
+		if err := r.runBundle(opts.bundle, opts.repeat, opts.report, yamlOptions(&opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "bundle: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.differential != 0 {
+		if err := r.runDifferential(opts.expr, opts.differential, opts.seed); err != nil {
+			fmt.Fprintf(os.Stderr, "differential: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.canary != "" {
+		if err := r.runCanary(opts.file, opts.canary, opts.requests, opts.seed, yamlOptions(&opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "canary: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.drift != "" {
+		if err := r.runDrift(opts.file, opts.drift, yamlOptions(&opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "drift: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.guardrails != "" {
+		if err := runGuardrails(opts.guardrails, opts.policy, opts.baseline); err != nil {
+			fmt.Fprintf(os.Stderr, "guardrails: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.bench != 0 {
+		exprs, err := r.benchExprs(&opts)
+		if err == nil {
+			err = r.runBench(exprs, opts.bench, opts.rate, opts.seed)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if opts.expr != "" {
 		ast, ok := r.newAST(opts.expr)
 		if ok {
-			r.printAST(ast, opts.outputFormat)
+			if err := r.printAST("expr", ast, opts.outputFormat, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write output: %v\n", err)
+				os.Exit(1)
+			}
+			if err := r.out.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write output: %v\n", err)
+				os.Exit(1)
+			}
 			os.Exit(0)
 		} else {
 			os.Exit(1)
@@ -221,6 +1115,9 @@ func main() {
 	}
 	if opts.file != "" {
 		err := r.processExprFile(opts.file, opts.outputFormat, opts.verbose)
+		if err == nil {
+			err = r.out.Close()
+		}
 		if err != nil {
 			fmt.Println("Error processing file:", err)
 			os.Exit(1)
@@ -228,28 +1125,95 @@ func main() {
 		os.Exit(0)
 	}
 
+	if opts.mutate {
+		if err := r.runMutate(opts.test, yamlOptions(&opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "mutate: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.evasion {
+		if err := r.runEvasion(opts.test, yamlOptions(&opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "evasion: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	if opts.streamTests {
+		if err := r.runStream(opts.test, opts.verbose, yamlOptions(&opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "stream: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
 	tsData, err := os.ReadFile(opts.test)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read test suite file: %v\n", err)
 		os.Exit(1)
 	}
-	ts, err := cloudarmor.TestSuiteFromYAML(tsData)
+	ts, err := cloudarmor.TestSuiteFromYAML(tsData, yamlOptions(&opts)...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to parse test suite: %v\n", err)
 		os.Exit(1)
 	}
-	ast, ok := r.newAST(ts.Expr)
-	if !ok {
+	if err := r.useSuite(ts); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
-
-	prg := r.newProgram(ast)
-	statuses := r.RunRuleValidation(prg, ts.Tests)
-	for _, s := range statuses {
+	code := exitOK
+	report := func(suite string, s cloudarmor.TestStatus) {
+		code = worstExitCode(code, statusExitCode(s))
 		if s.Fail != "" {
-			fmt.Fprintf(os.Stderr, "FAIL %s/%s: %s\n", ts.Name, s.Name, s.Fail)
+			fmt.Fprintf(os.Stderr, "FAIL %s/%s: %s\n", suite, s.Name, s.Fail)
+			printTrace(s.Trace)
+			printStack(s.Err)
 		} else {
-			fmt.Fprintf(os.Stderr, "PASS %s/%s\n", ts.Name, s.Name)
+			fmt.Fprintf(os.Stderr, "PASS %s/%s\n", suite, s.Name)
+		}
+	}
+	var statuses []cloudarmor.TestStatus
+	if ts.Policy != "" {
+		statuses, err = r.runPolicySuite(ts, opts.test)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "policy: %v\n", err)
+			os.Exit(1)
+		}
+		for _, s := range statuses {
+			report(ts.Name, s)
+		}
+	} else {
+		ast, ok := r.newAST(ts.Expr)
+		if !ok {
+			os.Exit(1)
+		}
+		prg := r.newProgram(ast)
+		printFingerprint(r.Rules)
+		// Results are printed as each test case completes rather than once the suite has run.
+		runner := r.NewRunner()
+		runner.OnCaseDone = report
+		p := r.newProgress(ts.Name, "test cases")
+		statuses, _ = runner.Run(p.context(), ts.Name, prg, ts.Tests)
+		p.finish()
+		if ts.Corpus != "" {
+			s, err := r.checkMatchRate(prg, ts, opts.test, yamlOptions(&opts))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "corpus: %v\n", err)
+				os.Exit(1)
+			}
+			report(ts.Name, s)
+			statuses = append(statuses, s)
+		}
+	}
+	if opts.report != "" {
+		suiteReport := cloudarmor.NewReport(ts.Name)
+		suiteReport.AddSuite(ts, statuses)
+		if err := writeReport(opts.report, suiteReport); err != nil {
+			fmt.Fprintf(os.Stderr, "report: %v\n", err)
+			os.Exit(1)
 		}
 	}
+	os.Exit(code)
 }
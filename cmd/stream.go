@@ -0,0 +1,76 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// runStream runs a streamed test suite one case at a time, reporting failures as they occur and
+// periodic progress, and returns an error if any test case failed.
+func (r *rules) runStream(path string, verbose bool, yamlOpts []cloudarmor.YAMLOption) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr, err := cloudarmor.NewTestCaseReader(f, yamlOpts...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := r.useSuite(tr.Suite); err != nil {
+		return err
+	}
+	ast, ok := r.newAST(tr.Suite.Expr)
+	if !ok {
+		return fmt.Errorf("%s: failed to compile expr", path)
+	}
+	prg := r.newProgram(ast)
+	printFingerprint(r.Rules)
+	var total, failed int
+	code := exitOK
+	p := r.newProgress(tr.Suite.Name, "test cases")
+	report := func(s cloudarmor.TestStatus) {
+		total++
+		code = worstExitCode(code, statusExitCode(s))
+		if s.Fail != "" {
+			failed++
+			fmt.Fprintf(os.Stderr, "FAIL %s/%s: %s\n", tr.Suite.Name, s.Name, s.Fail)
+			printTrace(s.Trace)
+			printStack(s.Err)
+		} else if verbose {
+			fmt.Fprintf(os.Stderr, "PASS %s/%s\n", tr.Suite.Name, s.Name)
+		}
+		p.report(total, -1)
+	}
+	err = r.RunStreamValidation(prg, tr, report)
+	if err == nil && tr.Suite.Corpus != "" {
+		var s cloudarmor.TestStatus
+		s, err = r.checkMatchRate(prg, tr.Suite, path, yamlOpts)
+		report(s)
+	}
+	p.finish()
+	fmt.Fprintf(os.Stderr, "%d of %d test cases passed\n", total-failed, total)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if failed != 0 {
+		return &codedError{code: code, err: fmt.Errorf("%d of %d test cases failed", failed, total)}
+	}
+	return nil
+}
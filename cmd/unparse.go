@@ -0,0 +1,91 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/google/cel-go/cel"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// runUnparse prints the Cloud Armor expression of a CheckedExpr written with -output_format.
+// Files with a .textproto or .txtpb extension are read as text, and all others as binary.
+// Presence tests of map keys are printed in the named PresenceStyle, and the rule comments
+// written into the header of textproto files are printed above the expression.
+func runUnparse(path, presenceStyle string) error {
+	style, err := cloudarmor.ParsePresenceStyle(presenceStyle)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var checked exprpb.CheckedExpr
+	var comments []string
+	switch filepath.Ext(path) {
+	case ".textproto", ".txtpb":
+		comments = ruleComments(data)
+		err = prototext.Unmarshal(data, &checked)
+	default:
+		err = proto.Unmarshal(data, &checked)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: failed to parse checked expr: %w", path, err)
+	}
+	if checked.GetExpr() == nil {
+		return fmt.Errorf("%s: checked expr has no expression", path)
+	}
+	expr, err := cloudarmor.Unparse(cel.CheckedExprToAst(&checked), cloudarmor.UnparsePresenceStyle(style))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, c := range comments {
+		fmt.Printf("// %s\n", c)
+	}
+	fmt.Println(expr)
+	return nil
+}
+
+// ruleComments returns the rule comments within the leading comment lines of a textproto file,
+// omitting the proto-file, proto-message, and env-fingerprint lines of its header.
+func ruleComments(data []byte) []string {
+	var comments []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		comment, ok := strings.CutPrefix(line, "#")
+		if !ok {
+			break
+		}
+		comment = strings.TrimSpace(comment)
+		switch {
+		case strings.HasPrefix(comment, "proto-file:"), strings.HasPrefix(comment, "proto-message:"), strings.HasPrefix(comment, "env-fingerprint:"):
+		default:
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
@@ -0,0 +1,310 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The actions of a security policy rule other than deny, whose action includes the status code of
+// the response, e.g. deny(403).
+const (
+	ActionAllow        = "allow"
+	ActionRedirect     = "redirect"
+	ActionThrottle     = "throttle"
+	ActionRateBasedBan = "rate_based_ban"
+)
+
+// The types of redirect performed by the redirect action.
+const (
+	// RedirectGoogleRecaptcha redirects the request to a reCAPTCHA Enterprise assessment.
+	RedirectGoogleRecaptcha = "GOOGLE_RECAPTCHA"
+	// RedirectExternal302 responds with a 302 redirect to the target URL.
+	RedirectExternal302 = "EXTERNAL_302"
+)
+
+// RedirectOptions are the parameters of the redirect action, or of the exceed action of a rate
+// limit.
+type RedirectOptions struct {
+	Type string `json:"type" yaml:"type"`
+	// Target is the URL of an EXTERNAL_302 redirect, and must be empty for GOOGLE_RECAPTCHA.
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
+}
+
+// RateLimitOptions are the parameters of the throttle and rate_based_ban actions.
+type RateLimitOptions struct {
+	RateLimitThreshold *RateLimitThreshold `json:"rateLimitThreshold,omitempty" yaml:"rateLimitThreshold,omitempty"`
+	// ConformAction is applied to requests below the threshold, and must be allow.
+	ConformAction string `json:"conformAction,omitempty" yaml:"conformAction,omitempty"`
+	// ExceedAction is applied to requests above the threshold: a deny action or redirect.
+	ExceedAction          string           `json:"exceedAction,omitempty" yaml:"exceedAction,omitempty"`
+	ExceedRedirectOptions *RedirectOptions `json:"exceedRedirectOptions,omitempty" yaml:"exceedRedirectOptions,omitempty"`
+	// EnforceOnKey selects how clients are counted, e.g. IP or HTTP_HEADER. EnforceOnKeyName
+	// names the header or cookie of the HTTP_HEADER and HTTP_COOKIE keys.
+	EnforceOnKey     string `json:"enforceOnKey,omitempty" yaml:"enforceOnKey,omitempty"`
+	EnforceOnKeyName string `json:"enforceOnKeyName,omitempty" yaml:"enforceOnKeyName,omitempty"`
+	// BanThreshold and BanDurationSec configure the ban of a rate_based_ban action.
+	BanThreshold   *RateLimitThreshold `json:"banThreshold,omitempty" yaml:"banThreshold,omitempty"`
+	BanDurationSec int64               `json:"banDurationSec,omitempty" yaml:"banDurationSec,omitempty"`
+}
+
+// RateLimitThreshold is a number of requests within an interval.
+type RateLimitThreshold struct {
+	Count       int64 `json:"count" yaml:"count"`
+	IntervalSec int64 `json:"intervalSec" yaml:"intervalSec"`
+}
+
+// HeaderAction is the set of request headers which a rule inserts into the requests it forwards
+// to the backend, i.e. those it allows or which conform to its rate limit.
+type HeaderAction struct {
+	RequestHeadersToAdds []*RequestHeader `json:"requestHeadersToAdds" yaml:"requestHeadersToAdds"`
+}
+
+// RequestHeader is a header inserted by a HeaderAction.
+type RequestHeader struct {
+	HeaderName  string `json:"headerName" yaml:"headerName"`
+	HeaderValue string `json:"headerValue" yaml:"headerValue"`
+}
+
+// denyStatuses are the status codes with which a deny action may respond.
+var denyStatuses = map[int]bool{403: true, 404: true, 429: true, 502: true}
+
+// DenyStatus returns the status code with which the deny action of the rule responds, and false
+// if the action is not a deny action.
+//
+// Custom error responses are configured on the load balancer rather than on the security policy,
+// and are selected by this status code, so the status is the reference by which a denied request
+// reaches its custom error response.
+func (r *SecurityPolicyRule) DenyStatus() (int, bool) {
+	return denyStatus(r.Action)
+}
+
+// denyStatus parses the status code of a deny action, e.g. deny(403).
+func denyStatus(action string) (int, bool) {
+	code, found := strings.CutPrefix(action, "deny(")
+	if !found {
+		return 0, false
+	}
+	code, found = strings.CutSuffix(code, ")")
+	if !found {
+		return 0, false
+	}
+	status, err := strconv.Atoi(code)
+	if err != nil {
+		return 0, false
+	}
+	return status, true
+}
+
+// validateDeny checks that the deny action responds with one of the allowed status codes.
+func validateDeny(field, action string) error {
+	status, ok := denyStatus(action)
+	if !ok || !denyStatuses[status] {
+		return fmt.Errorf("%s %q must deny with one of the status codes 403, 404, 429, or 502", field, action)
+	}
+	return nil
+}
+
+// rateLimitIntervals are the intervals, and ban durations, in seconds accepted by Cloud Armor.
+var rateLimitIntervals = map[int64]bool{
+	10: true, 30: true, 60: true, 120: true, 180: true, 240: true, 300: true, 600: true,
+	900: true, 1200: true, 1800: true, 2700: true, 3600: true,
+}
+
+// enforceOnKeys are the keys by which rate limits count clients. The empty key is ALL.
+var enforceOnKeys = map[string]bool{
+	"": true, "ALL": true, "IP": true, "HTTP_HEADER": true, "XFF_IP": true, "HTTP_COOKIE": true,
+	"HTTP_PATH": true, "SNI": true, "REGION_CODE": true, "TLS_JA3_FINGERPRINT": true,
+	"TLS_JA4_FINGERPRINT": true, "USER_IP": true,
+}
+
+// Validate checks that the action of each rule is one of those of Cloud Armor and that its
+// parameters form an allowed combination.
+//
+// The return value joins an error for each invalid rule, or is nil if there are none.
+func (p *SecurityPolicy) Validate() error {
+	var errs []error
+	for _, rule := range p.Rules {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that the action of the rule is one of those of Cloud Armor and that its
+// parameters form an allowed combination: redirectOptions only with redirect, rateLimitOptions
+// only with throttle or rate_based_ban, and headerAction only with the actions which forward the
+// request, i.e. allow, throttle, and rate_based_ban.
+func (r *SecurityPolicyRule) Validate() error {
+	var errs []error
+	switch {
+	case r.Action == ActionAllow:
+	case strings.HasPrefix(r.Action, "deny"):
+		if err := validateDeny("action", r.Action); err != nil {
+			errs = append(errs, err)
+		}
+	case r.Action == ActionRedirect:
+		if r.RedirectOptions == nil {
+			errs = append(errs, errors.New("redirect requires redirectOptions"))
+		} else if err := r.RedirectOptions.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	case r.Action == ActionThrottle || r.Action == ActionRateBasedBan:
+		if r.RateLimitOptions == nil {
+			errs = append(errs, fmt.Errorf("%s requires rateLimitOptions", r.Action))
+		} else {
+			errs = append(errs, r.RateLimitOptions.validate(r.Action)...)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown action %q", r.Action))
+	}
+	if r.RedirectOptions != nil && r.Action != ActionRedirect {
+		errs = append(errs, fmt.Errorf("redirectOptions are not allowed with %s", r.Action))
+	}
+	if r.RateLimitOptions != nil && r.Action != ActionThrottle && r.Action != ActionRateBasedBan {
+		errs = append(errs, fmt.Errorf("rateLimitOptions are not allowed with %s", r.Action))
+	}
+	if r.HeaderAction != nil {
+		if r.Action != ActionAllow && r.Action != ActionThrottle && r.Action != ActionRateBasedBan {
+			errs = append(errs, fmt.Errorf("headerAction is not allowed with %s", r.Action))
+		}
+		errs = append(errs, r.HeaderAction.validate()...)
+	}
+	for i, err := range errs {
+		errs[i] = fmt.Errorf("rule %s: %w", r.Name(), err)
+	}
+	return errors.Join(errs...)
+}
+
+func (o *RedirectOptions) validate() error {
+	switch o.Type {
+	case RedirectGoogleRecaptcha:
+		if o.Target != "" {
+			return fmt.Errorf("%s redirect does not accept a target", o.Type)
+		}
+	case RedirectExternal302:
+		if !strings.HasPrefix(o.Target, "https://") && !strings.HasPrefix(o.Target, "http://") {
+			return fmt.Errorf("%s redirect requires an http or https target, got %q", o.Type, o.Target)
+		}
+	default:
+		return fmt.Errorf("unknown redirect type %q", o.Type)
+	}
+	return nil
+}
+
+// validate checks that each header has a name which is an HTTP token, that is inserted once, and a
+// value without control characters other than horizontal tab.
+func (a *HeaderAction) validate() []error {
+	var errs []error
+	seen := map[string]bool{}
+	for _, h := range a.RequestHeadersToAdds {
+		if !isHeaderToken(h.HeaderName) {
+			errs = append(errs, fmt.Errorf("header name %q is not a valid HTTP token", h.HeaderName))
+			continue
+		}
+		name := strings.ToLower(h.HeaderName)
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("header %q is inserted more than once", h.HeaderName))
+		}
+		seen[name] = true
+		for _, c := range []byte(h.HeaderValue) {
+			if (c < 0x20 && c != '\t') || c == 0x7f {
+				errs = append(errs, fmt.Errorf("header %q value %q contains a control character", h.HeaderName, h.HeaderValue))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// isHeaderToken reports whether the header name is a token as defined by RFC 9110.
+func isHeaderToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (o *RateLimitOptions) validate(action string) []error {
+	var errs []error
+	if err := o.RateLimitThreshold.validate("rateLimitThreshold"); err != nil {
+		errs = append(errs, err)
+	}
+	if o.ConformAction != ActionAllow {
+		errs = append(errs, fmt.Errorf("conformAction must be allow, got %q", o.ConformAction))
+	}
+	switch {
+	case strings.HasPrefix(o.ExceedAction, "deny"):
+		if err := validateDeny("exceedAction", o.ExceedAction); err != nil {
+			errs = append(errs, err)
+		}
+		if o.ExceedRedirectOptions != nil {
+			errs = append(errs, fmt.Errorf("exceedRedirectOptions are not allowed with exceedAction %s", o.ExceedAction))
+		}
+	case o.ExceedAction == ActionRedirect:
+		if o.ExceedRedirectOptions == nil {
+			errs = append(errs, errors.New("exceedAction redirect requires exceedRedirectOptions"))
+		} else if err := o.ExceedRedirectOptions.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("exceedAction must be a deny action or redirect, got %q", o.ExceedAction))
+	}
+	if !enforceOnKeys[o.EnforceOnKey] {
+		errs = append(errs, fmt.Errorf("unknown enforceOnKey %q", o.EnforceOnKey))
+	}
+	if named := o.EnforceOnKey == "HTTP_HEADER" || o.EnforceOnKey == "HTTP_COOKIE"; named != (o.EnforceOnKeyName != "") {
+		errs = append(errs, errors.New("enforceOnKeyName is required with, and only with, HTTP_HEADER and HTTP_COOKIE keys"))
+	}
+	if action == ActionThrottle {
+		if o.BanThreshold != nil || o.BanDurationSec != 0 {
+			errs = append(errs, errors.New("banThreshold and banDurationSec are not allowed with throttle"))
+		}
+		return errs
+	}
+	if !rateLimitIntervals[o.BanDurationSec] {
+		errs = append(errs, fmt.Errorf("banDurationSec %d is not one of the allowed intervals", o.BanDurationSec))
+	}
+	if o.BanThreshold != nil {
+		if err := o.BanThreshold.validate("banThreshold"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (t *RateLimitThreshold) validate(field string) error {
+	switch {
+	case t == nil:
+		return fmt.Errorf("%s is required", field)
+	case t.Count <= 0:
+		return fmt.Errorf("%s count must be positive, got %d", field, t.Count)
+	case !rateLimitIntervals[t.IntervalSec]:
+		return fmt.Errorf("%s intervalSec %d is not one of the allowed intervals", field, t.IntervalSec)
+	}
+	return nil
+}
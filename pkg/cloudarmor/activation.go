@@ -0,0 +1,78 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"strings"
+
+	"github.com/google/cel-go/interpreter"
+)
+
+// Activation resolves Cloud Armor attribute names, e.g. "origin.asn", to their values during
+// evaluation. *Variables implements Activation, as do Attributes and the result of Overlay, and
+// any Activation may be passed to the Eval method of a program created by Rules.Program.
+type Activation interface {
+	interpreter.Activation
+}
+
+// Attributes is an Activation backed by a map from attribute names to values.
+//
+// Values are converted to CEL values in the same way as Variables fields, so an int attribute
+// may be provided as an int64 and a map attribute as a map[string]string.
+type Attributes map[string]any
+
+// ResolveName implements the interpreter.Activation interface.
+func (a Attributes) ResolveName(name string) (any, bool) {
+	val, found := a[name]
+	return val, found
+}
+
+// Parent implements the interpreter.Activation interface.
+func (a Attributes) Parent() interpreter.Activation {
+	return nil
+}
+
+// Overlay returns an Activation which resolves attributes from overrides when they are present
+// there and from base otherwise, so that embedders can inject computed attributes, such as an
+// ASN derived by their own enrichment, without reimplementing the resolution of Variables.
+//
+// When base is or overlays *Variables, the presence and unknown attribute semantics configured on
+// the Rules continue to apply to the attributes resolved from it.
+func Overlay(base, overrides Activation) Activation {
+	return &overlay{base: base, overrides: overrides}
+}
+
+type overlay struct {
+	base, overrides Activation
+}
+
+// ResolveName implements the interpreter.Activation interface.
+func (o *overlay) ResolveName(name string) (any, bool) {
+	// An overridden attribute is present regardless of the base, see Presence.
+	if attr, found := strings.CutPrefix(name, presencePrefix); found {
+		if _, found := o.overrides.ResolveName(attr); found {
+			return true, true
+		}
+	}
+	if val, found := o.overrides.ResolveName(name); found {
+		return val, true
+	}
+	return o.base.ResolveName(name)
+}
+
+// Parent implements the interpreter.Activation interface.
+func (o *overlay) Parent() interpreter.Activation {
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+)
+
+// ASNDirectory maps autonomous system numbers to the names of the organizations which operate
+// them, so that reports and summaries can display AS15169 (Google LLC) rather than a bare number.
+type ASNDirectory map[int64]string
+
+// wellKnownASNs contains the operators of autonomous systems which are commonly named in rules,
+// such as those of cloud providers and content delivery networks.
+var wellKnownASNs = ASNDirectory{
+	714:    "Apple Inc.",
+	3356:   "Level 3 Parent, LLC",
+	7922:   "Comcast Cable Communications, LLC",
+	8075:   "Microsoft Corporation",
+	13335:  "Cloudflare, Inc.",
+	14061:  "DigitalOcean, LLC",
+	14618:  "Amazon.com, Inc.",
+	15169:  "Google LLC",
+	16276:  "OVH SAS",
+	16509:  "Amazon.com, Inc.",
+	16625:  "Akamai Technologies, Inc.",
+	19551:  "Incapsula Inc",
+	20940:  "Akamai International B.V.",
+	24940:  "Hetzner Online GmbH",
+	31898:  "Oracle Corporation",
+	32934:  "Facebook, Inc.",
+	36459:  "GitHub, Inc.",
+	37963:  "Hangzhou Alibaba Advertising Co., Ltd.",
+	45102:  "Alibaba (US) Technology Co., Ltd.",
+	54113:  "Fastly, Inc.",
+	63949:  "Akamai Connected Cloud",
+	132203: "Tencent",
+	396982: "Google LLC",
+}
+
+// WellKnownASNs returns a directory of the autonomous systems most commonly named in rules, such
+// as those of cloud providers and content delivery networks. It is a small embedded table, not a
+// substitute for a full registry loaded with LoadASNDirectory.
+func WellKnownASNs() ASNDirectory {
+	d := make(ASNDirectory, len(wellKnownASNs))
+	for asn, name := range wellKnownASNs {
+		d[asn] = name
+	}
+	return d
+}
+
+// ReadASNDirectory reads a directory with one autonomous system per line, its number, optionally
+// prefixed with "AS", followed by a comma, tab, or spaces and the name of its organization, e.g.
+//
+//	AS15169,Google LLC
+//
+// Blank lines and lines beginning with '#' are ignored, and later entries replace earlier ones.
+func ReadASNDirectory(r io.Reader) (ASNDirectory, error) {
+	d := ASNDirectory{}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.IndexAny(text, ",\t ")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing organization name after %q", line, text)
+		}
+		num := strings.TrimPrefix(strings.TrimPrefix(text[:i], "AS"), "as")
+		asn, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %q is not an autonomous system number", line, text[:i])
+		}
+		if err := CheckASN(asn); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		d[asn] = strings.TrimSpace(text[i+1:])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// LoadASNDirectory reads a directory from a file in the format of ReadASNDirectory. The name
+// "builtin" returns WellKnownASNs.
+func LoadASNDirectory(path string) (ASNDirectory, error) {
+	if path == "builtin" {
+		return WellKnownASNs(), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := ReadASNDirectory(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+// Format returns the autonomous system number with the name of its organization, e.g.
+// "AS15169 (Google LLC)", or only the number, e.g. "AS64496", if the directory does not name it.
+func (d ASNDirectory) Format(asn int64) string {
+	if name, found := d[asn]; found && name != "" {
+		return fmt.Sprintf("AS%d (%s)", asn, name)
+	}
+	return fmt.Sprintf("AS%d", asn)
+}
+
+// CheckASN returns an error if the number is outside the 32-bit range of autonomous system
+// numbers, such as a number with a miscopied digit, which origin.asn can never be.
+func CheckASN(asn int64) error {
+	if asn < 0 || asn > math.MaxUint32 {
+		return fmt.Errorf("%d is outside the range 0 to %d", asn, uint32(math.MaxUint32))
+	}
+	return nil
+}
+
+// ASNCount is the number of requests from an autonomous system which a rule matched.
+type ASNCount struct {
+	ASN int64 `json:"asn"`
+	// Organization is the name of the organization operating the autonomous system, if named by
+	// the directory passed to Report.NameASNs.
+	Organization string `json:"organization,omitempty"`
+	Count        int    `json:"count"`
+}
+
+// String returns the autonomous system as formatted by ASNDirectory.Format, e.g.
+// "AS15169 (Google LLC)".
+func (c ASNCount) String() string {
+	return ASNDirectory{c.ASN: c.Organization}.Format(c.ASN)
+}
+
+// topASNs returns the most frequent autonomous systems, breaking ties by number.
+func topASNs(counts map[int64]int) []ASNCount {
+	var top []ASNCount
+	for asn, count := range counts {
+		top = append(top, ASNCount{ASN: asn, Count: count})
+	}
+	sort.Slice(top, func(a, b int) bool {
+		return top[a].Count > top[b].Count || top[a].Count == top[b].Count && top[a].ASN < top[b].ASN
+	})
+	if len(top) > topSignatures {
+		top = top[:topSignatures]
+	}
+	return top
+}
+
+// NameASNs sets the organization of the autonomous systems reported by the impact of each rule
+// from the directory.
+func (rep *Report) NameASNs(d ASNDirectory) {
+	for _, rule := range rep.Rules {
+		if rule.Impact == nil {
+			continue
+		}
+		for i, c := range rule.Impact.TopASNs {
+			rule.Impact.TopASNs[i].Organization = d[c.ASN]
+		}
+	}
+}
+
+// ValidateASNs rejects expressions which compare origin.asn with an integer literal outside the
+// 32-bit range of autonomous system numbers at check time, such as a number with a miscopied
+// digit, since Cloud Armor accepts the expression but the comparison never holds as intended.
+func ValidateASNs() RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.validateASNs = true
+		return r, nil
+	}
+}
+
+// asnOptions returns the environment options which enforce ValidateASNs.
+func (r *Rules) asnOptions() []cel.EnvOption {
+	if !r.validateASNs {
+		return nil
+	}
+	return []cel.EnvOption{cel.ASTValidators(asnValidator{})}
+}
+
+// asnComparisons contains the comparison operators checked by asnValidator.
+var asnComparisons = map[string]bool{
+	operators.Equals:        true,
+	operators.NotEquals:     true,
+	operators.Less:          true,
+	operators.LessEquals:    true,
+	operators.Greater:       true,
+	operators.GreaterEquals: true,
+}
+
+// asnValidator reports integer literals compared with origin.asn which are outside the 32-bit
+// range of autonomous system numbers.
+type asnValidator struct{}
+
+// Name implements the cel.ASTValidator interface.
+func (asnValidator) Name() string {
+	return "cloudarmor.validator.asn"
+}
+
+// Validate implements the cel.ASTValidator interface.
+func (asnValidator) Validate(_ *cel.Env, _ cel.ValidatorConfig, a *ast.AST, iss *cel.Issues) {
+	check := func(e ast.Expr) {
+		if e.Kind() != ast.LiteralKind {
+			return
+		}
+		asn, ok := e.AsLiteral().(types.Int)
+		if !ok {
+			return
+		}
+		if err := CheckASN(int64(asn)); err != nil {
+			iss.ReportErrorAtID(e.ID(), "origin.asn: %v", err)
+		}
+	}
+	for _, e := range ast.MatchDescendants(ast.NavigateAST(a), ast.KindMatcher(ast.CallKind)) {
+		call := e.AsCall()
+		args := call.Args()
+		if len(args) != 2 || !asnComparisons[call.FunctionName()] {
+			continue
+		}
+		if isAttribute(args[0], "origin", "asn") {
+			check(args[1])
+		} else if isAttribute(args[1], "origin", "asn") {
+			check(args[0])
+		}
+	}
+}
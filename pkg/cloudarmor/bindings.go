@@ -0,0 +1,78 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/ext"
+)
+
+// bindUnusedIterVar is the iteration variable of the comprehension generated by cel.bind.
+const bindUnusedIterVar = "#unused"
+
+// bindings returns the environment options which enable the cel.bind macro for the given version.
+//
+// cel.bind(name, init, expr) evaluates init once and makes the result available to expr as name,
+// so an expensive transformation such as request.query.urlDecode() may be shared by several
+// conditions within a rule. The cost estimate accounts for init once regardless of the number
+// of references to name.
+//
+// Only the macro is enabled, not the cel.@block function which backs common subexpression
+// elimination, and bound names may not shadow a Cloud Armor attribute.
+func bindings(version uint32) []cel.EnvOption {
+	if version < VNext {
+		return nil
+	}
+	return []cel.EnvOption{
+		ext.Bindings(ext.BindingsVersion(0)),
+		cel.ASTValidators(bindValidator{}),
+	}
+}
+
+// bindValidator reports cel.bind variables whose names shadow a Cloud Armor attribute, since
+// the bound value would silently replace the attribute within the bind expression.
+type bindValidator struct{}
+
+// Name implements the cel.ASTValidator interface.
+func (bindValidator) Name() string {
+	return "cloudarmor.validator.bind"
+}
+
+// Validate implements the cel.ASTValidator interface.
+func (bindValidator) Validate(e *cel.Env, _ cel.ValidatorConfig, a *ast.AST, iss *cel.Issues) {
+	for _, b := range ast.MatchDescendants(ast.NavigateAST(a), func(expr ast.NavigableExpr) bool { return isBind(expr) }) {
+		name := b.AsComprehension().AccuVar()
+		for _, v := range e.Variables() {
+			if v.Name() == name || strings.HasPrefix(v.Name(), name+".") {
+				iss.ReportErrorAtID(b.ID(), "cel.bind variable %q shadows the attribute %s", name, v.Name())
+				break
+			}
+		}
+	}
+}
+
+// isBind reports whether the expression is the comprehension generated by the cel.bind macro.
+func isBind(e ast.Expr) bool {
+	if e.Kind() != ast.ComprehensionKind {
+		return false
+	}
+	comp := e.AsComprehension()
+	rng := comp.IterRange()
+	return comp.IterVar() == bindUnusedIterVar &&
+		rng.Kind() == ast.ListKind && rng.AsList().Size() == 0
+}
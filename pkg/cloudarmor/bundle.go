@@ -0,0 +1,199 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleBundle is a named collection of rule expressions, each with its own test cases, so that a
+// team's complete ruleset can be compiled and tested in a single invocation.
+type RuleBundle struct {
+	Name string `yaml:"name"`
+	// Definitions are named sub-expressions which are expanded inline wherever the rules refer
+	// to them, so that complex policies remain readable while exporting flattened expressions.
+	Definitions []*Definition `yaml:"definitions"`
+	Rules       []*BundleRule `yaml:"rules"`
+}
+
+// BundleRule is a single named expression within a RuleBundle.
+type BundleRule struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+	// Tests are the test cases embedded within the bundle.
+	Tests []*TestCase `yaml:"tests"`
+	// TestsFile references a test suite file, relative to the bundle, whose test cases are run
+	// after the embedded ones. The expression of the referenced suite is ignored, and its
+	// budgets apply to its own test cases. Since the rules of a bundle share one environment,
+	// a suite which declares a version, features, limits, or a corpus is rejected.
+	TestsFile string `yaml:"tests_file"`
+}
+
+// RuleResult is the outcome of compiling and testing a single rule within a bundle.
+type RuleResult struct {
+	Name string
+	// Expr is the rule expression with all definitions expanded inline.
+	Expr string
+	// CompileError is set when the rule failed to compile, in which case no tests are run.
+	CompileError error
+	Statuses     []TestStatus
+}
+
+// Passed reports whether the rule compiled and all of its test cases passed.
+func (r *RuleResult) Passed() bool {
+	if r.CompileError != nil {
+		return false
+	}
+	for _, s := range r.Statuses {
+		if s.Fail != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// RuleBundleFromYAML converts a YAML representation of a rule bundle to a RuleBundle type.
+//
+// Test files referenced by the rules are resolved relative to dir.
+//
+// The return value is the RuleBundle type or an error if the YAML is invalid, a rule name is
+// missing or repeated, or a referenced test file cannot be read.
+func RuleBundleFromYAML(yamlBytes []byte, dir string, opts ...YAMLOption) (*RuleBundle, error) {
+	o := newYAMLOptions(opts)
+	if o.strict {
+		if err := checkSchema(yamlBytes, &RuleBundle{}, ruleBundleVariables...); err != nil {
+			return nil, err
+		}
+	}
+	b := &RuleBundle{}
+	if err := yaml.Unmarshal(yamlBytes, b); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, rule := range b.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("bundle %s: rule with expr %q has no name", b.Name, rule.Expr)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("bundle %s: duplicate rule name %q", b.Name, rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Expr == "" {
+			return nil, fmt.Errorf("bundle %s: rule %q has no expr", b.Name, rule.Name)
+		}
+		for i, t := range rule.Tests {
+			if err := o.validateTestCase(t); err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			rule.Tests[i] = SafeTestCase(t)
+		}
+		if rule.TestsFile == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, rule.TestsFile))
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		ts, err := TestSuiteFromYAML(data, opts...)
+		if err == nil {
+			err = checkBundledSuite(ts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %s: %w", rule.Name, rule.TestsFile, err)
+		}
+		rule.Tests = append(rule.Tests, ts.Tests...)
+	}
+	return b, nil
+}
+
+// checkBundledSuite rejects the suite-level setup of a test suite referenced by a bundle rule,
+// which would otherwise be silently dropped when the rule runs within the bundle environment.
+func checkBundledSuite(ts *TestSuite) error {
+	switch {
+	case ts.Version != "":
+		return fmt.Errorf("suite version %q is not supported within a bundle", ts.Version)
+	case ts.Options != nil && (len(ts.Options.Features) != 0 || ts.Options.Limits != nil):
+		return fmt.Errorf("suite options.features and options.limits are not supported within a bundle")
+	case ts.Corpus != "":
+		return fmt.Errorf("suite corpus and max_match_rate are not supported within a bundle")
+	}
+	return nil
+}
+
+// LoadRuleBundle reads the rule bundle at the given path, resolving referenced test files
+// relative to the directory containing the bundle.
+func LoadRuleBundle(path string, opts ...YAMLOption) (*RuleBundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return RuleBundleFromYAML(data, filepath.Dir(path), opts...)
+}
+
+// ExpandBundle returns the expression of each rule, in the order the rules are declared, with
+// the bundle definitions expanded inline.
+//
+// The return value is an error if a definition is invalid or refers to itself, or if an
+// expanded expression fails to compile or exceeds the maximum expression length of the Rules,
+// which is MaxExpressionLength unless configured by WithLimits or UntrustedMode.
+func (r *Rules) ExpandBundle(b *RuleBundle) ([]string, error) {
+	x, err := r.newDefinitionExpander(b.Definitions)
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", b.Name, err)
+	}
+	exprs := make([]string, len(b.Rules))
+	for i, rule := range b.Rules {
+		exprs[i], err = x.expand(rule.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+	return exprs, nil
+}
+
+// RunBundle compiles every rule within the bundle and runs its test cases.
+//
+// Rules which fail to compile, or whose expansion exceeds the maximum expression length, are
+// reported through the CompileError of their result. An error is returned only when the
+// definitions of the bundle are themselves invalid.
+//
+// The return value contains one RuleResult per rule, in the order the rules are declared.
+func (r *Rules) RunBundle(b *RuleBundle) ([]RuleResult, error) {
+	return r.NewRunner().RunBundle(context.Background(), b)
+}
+
+// bundleProgram expands and compiles a rule of a bundle, returning the program along with the
+// expanded expression, which is empty if the expansion failed.
+func (r *Rules) bundleProgram(x *definitionExpander, rule *BundleRule) (cel.Program, string, error) {
+	expr, err := x.expand(rule.Expr)
+	if err != nil {
+		return nil, "", err
+	}
+	ast, err := r.Compile(expr)
+	if err != nil {
+		return nil, expr, err
+	}
+	prg, err := r.Program(ast, cel.CostTracking(nil))
+	if err != nil {
+		return nil, expr, err
+	}
+	return prg, expr, nil
+}
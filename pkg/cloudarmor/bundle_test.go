@@ -0,0 +1,415 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+func TestRunBundle(t *testing.T) {
+	b, err := cloudarmor.LoadRuleBundle("../../test/rules-bundle.yaml", cloudarmor.StrictYAML())
+	if err != nil {
+		t.Fatalf("cloudarmor.LoadRuleBundle() returned error: %v", err)
+	}
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	results, err := r.RunBundle(b)
+	if err != nil {
+		t.Fatalf("r.RunBundle() returned error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("len(results) = %d, want 2", len(results))
+	}
+	wantTests := map[string]int{"block-admin": 2, "http-get": 2}
+	for _, res := range results {
+		if !res.Passed() {
+			t.Errorf("rule %s failed: compile error %v, statuses %+v", res.Name, res.CompileError, res.Statuses)
+		}
+		if len(res.Statuses) != wantTests[res.Name] {
+			t.Errorf("rule %s ran %d tests, want %d", res.Name, len(res.Statuses), wantTests[res.Name])
+		}
+	}
+}
+
+func TestRuleBundleFromYAMLErrors(t *testing.T) {
+	tests := []struct {
+		bundle  string
+		wantErr string
+	}{
+		{
+			bundle:  "name: b\nrules:\n  - expr: 'true'\n",
+			wantErr: "has no name",
+		},
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n    expr: 'true'\n  - name: a\n    expr: 'false'\n",
+			wantErr: `duplicate rule name "a"`,
+		},
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n",
+			wantErr: `rule "a" has no expr`,
+		},
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n    expr: 'true'\n    tests_file: missing.yaml\n",
+			wantErr: "missing.yaml",
+		},
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n    expr: 'true'\n    tests:\n      - name: t\n        expect: true\n        error_code: internal\n",
+			wantErr: `rule "a": test case "t" has both expect and error_code`,
+		},
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n    expr: 'true'\n    tests:\n      - name: t\n        expect: true\n        error: boom\n",
+			wantErr: `rule "a": test case "t" has both expect and error`,
+		},
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n    expr: 'true'\n    tests:\n      - name: t\n        when:\n          requst:\n            path: /\n",
+			wantErr: "line 8: field requst not found",
+		},
+	}
+	dir := t.TempDir()
+	for name, suite := range map[string]string{
+		"version.yaml":  "name: s\nversion: VNext\ntests:\n  - name: t\n",
+		"features.yaml": "name: s\noptions:\n  features: [request_body]\ntests:\n  - name: t\n",
+		"corpus.yaml":   "name: s\nmax_match_rate: 1%\ncorpus: good.yaml\ntests:\n  - name: t\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(suite), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests = append(tests, []struct {
+		bundle  string
+		wantErr string
+	}{
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n    expr: 'true'\n    tests_file: version.yaml\n",
+			wantErr: `rule "a": version.yaml: suite version "VNext" is not supported within a bundle`,
+		},
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n    expr: 'true'\n    tests_file: features.yaml\n",
+			wantErr: "options.features and options.limits are not supported within a bundle",
+		},
+		{
+			bundle:  "name: b\nrules:\n  - name: a\n    expr: 'true'\n    tests_file: corpus.yaml\n",
+			wantErr: "corpus and max_match_rate are not supported within a bundle",
+		},
+	}...)
+	for _, tst := range tests {
+		_, err := cloudarmor.RuleBundleFromYAML([]byte(tst.bundle), dir, cloudarmor.StrictYAML())
+		if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+			t.Errorf("cloudarmor.RuleBundleFromYAML() returned error %v, wanted error containing %q", err, tst.wantErr)
+		}
+	}
+}
+
+func TestRunBundleCompileError(t *testing.T) {
+	b, err := cloudarmor.RuleBundleFromYAML([]byte("name: b\nrules:\n  - name: bad\n    expr: request.nope\n"), "")
+	if err != nil {
+		t.Fatalf("cloudarmor.RuleBundleFromYAML() returned error: %v", err)
+	}
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	results, err := r.RunBundle(b)
+	if err != nil {
+		t.Fatalf("r.RunBundle() returned error: %v", err)
+	}
+	if results[0].CompileError == nil || results[0].Passed() {
+		t.Errorf("r.RunBundle() = %+v, wanted a compile error", results[0])
+	}
+}
+
+func TestExpandBundle(t *testing.T) {
+	r, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	b := &cloudarmor.RuleBundle{
+		Name: "defs",
+		Definitions: []*cloudarmor.Definition{
+			{Name: "is_admin_path", Expr: "is_api_path && request.path.contains('/admin')"},
+			{Name: "is_api_path", Expr: "request.path.startsWith('/api')"},
+			{Name: "is_internal_ip", Expr: "inIpRange(origin.ip, '10.0.0.0/8')"},
+			{Name: "has_debug", Expr: "request.params.exists(k, k == 'debug')"},
+		},
+		Rules: []*cloudarmor.BundleRule{
+			{Name: "admin", Expr: "is_admin_path && !is_internal_ip"},
+			{Name: "debug", Expr: "has_debug || (is_api_path && request.method == 'DELETE' && !is_internal_ip)"},
+		},
+	}
+	exprs, err := r.ExpandBundle(b)
+	if err != nil {
+		t.Fatalf("r.ExpandBundle() returned error: %v", err)
+	}
+	want := []string{
+		`request.path.startsWith("/api") && request.path.contains("/admin") && !(inIpRange(origin.ip, "10.0.0.0/8"))`,
+		`request.params.exists(k, k == "debug") || request.path.startsWith("/api") && request.method == "DELETE" && !(inIpRange(origin.ip, "10.0.0.0/8"))`,
+	}
+	for i, expr := range exprs {
+		if expr != want[i] {
+			t.Errorf("exprs[%d] = %q, wanted %q", i, expr, want[i])
+		}
+		if _, err := r.Compile(expr); err != nil {
+			t.Errorf("r.Compile(%q) returned error: %v", expr, err)
+		}
+	}
+}
+
+func TestExpandBundleErrors(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	long := "request.path.contains('" + strings.Repeat("a", cloudarmor.MaxExpressionLength) + "')"
+	tests := []struct {
+		name    string
+		defs    []*cloudarmor.Definition
+		expr    string
+		wantErr string
+	}{
+		{
+			name: "cycle",
+			defs: []*cloudarmor.Definition{
+				{Name: "a", Expr: "b || request.method == 'GET'"},
+				{Name: "b", Expr: "c"},
+				{Name: "c", Expr: "a"},
+			},
+			expr:    "a",
+			wantErr: "definition cycle: a -> b -> c -> a",
+		},
+		{
+			name:    "self reference",
+			defs:    []*cloudarmor.Definition{{Name: "a", Expr: "a"}},
+			expr:    "a",
+			wantErr: "definition cycle: a -> a",
+		},
+		{
+			name:    "invalid name",
+			defs:    []*cloudarmor.Definition{{Name: "is-api", Expr: "true"}},
+			expr:    "true",
+			wantErr: "not a valid identifier",
+		},
+		{
+			name:    "shadows attribute",
+			defs:    []*cloudarmor.Definition{{Name: "origin", Expr: "true"}},
+			expr:    "true",
+			wantErr: "shadows the attribute",
+		},
+		{
+			name:    "too long",
+			defs:    []*cloudarmor.Definition{{Name: "long", Expr: long}},
+			expr:    "long",
+			wantErr: "expanded expression length 2073 exceeds the maximum of 2048",
+		},
+		{
+			name:    "undeclared",
+			expr:    "is_api_path",
+			wantErr: "undeclared reference to 'is_api_path'",
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			b := &cloudarmor.RuleBundle{
+				Name:        tst.name,
+				Definitions: tst.defs,
+				Rules:       []*cloudarmor.BundleRule{{Name: "rule", Expr: tst.expr}},
+			}
+			_, err := r.ExpandBundle(b)
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("r.ExpandBundle() returned error %v, wanted error containing %q", err, tst.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpandBundleLimits(t *testing.T) {
+	limits := cloudarmor.DefaultLimits()
+	limits.MaxExpressionLength = 4096
+	r, err := cloudarmor.NewRules(cloudarmor.WithLimits(limits))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	b := &cloudarmor.RuleBundle{
+		Name: "limits",
+		Definitions: []*cloudarmor.Definition{
+			{Name: "long", Expr: "request.path.contains('" + strings.Repeat("a", cloudarmor.MaxExpressionLength) + "')"},
+		},
+		Rules: []*cloudarmor.BundleRule{{Name: "rule", Expr: "long"}},
+	}
+	if _, err := r.ExpandBundle(b); err != nil {
+		t.Errorf("r.ExpandBundle() with a raised limit returned error %v, wanted nil", err)
+	}
+	b.Definitions[0].Expr = "request.path.contains('" + strings.Repeat("a", limits.MaxExpressionLength) + "')"
+	if _, err := r.ExpandBundle(b); err == nil || !strings.Contains(err.Error(), "exceeds the maximum of 4096") {
+		t.Errorf("r.ExpandBundle() beyond the raised limit returned error %v, wanted the limit exceeded", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := cloudarmor.NewReport("b")
+	run := func(flakyFail string) []cloudarmor.RuleResult {
+		return []cloudarmor.RuleResult{
+			{Name: "ok", Expr: "true", Statuses: []cloudarmor.TestStatus{
+				{Name: "always", Pass: true},
+				{Name: "sometimes", Pass: flakyFail == "", Fail: flakyFail},
+			}},
+			{Name: "broken", CompileError: errors.New("undeclared reference")},
+		}
+	}
+	report.Add(run(""))
+	if report.Summary.FlakyCases != 0 || report.Summary.FailedRules != 1 {
+		t.Errorf("report.Summary = %+v after one run, wanted no flaky cases and one failed rule", report.Summary)
+	}
+	report.Add(run("latency exceeded"))
+	report.Add(run("latency exceeded"))
+	want := cloudarmor.ReportSummary{Runs: 3, Rules: 2, FailedRules: 2, Cases: 2, FlakyCases: 1}
+	if report.Summary != want {
+		t.Errorf("report.Summary = %+v, want %+v", report.Summary, want)
+	}
+	c := report.Rules[0].Cases[1]
+	if c.Outcome != cloudarmor.OutcomeFlaky || c.Passes != 1 || c.Runs != 3 || len(c.Failures) != 1 {
+		t.Errorf("flaky case reported as %+v, wanted 1 of 3 passes and one distinct failure", c)
+	}
+	var out strings.Builder
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatalf("report.WriteJSON() returned error: %v", err)
+	}
+	for _, s := range []string{`"flaky_cases": 1`, `"outcome": "flaky"`, `"compile_error": "undeclared reference"`} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report.WriteJSON() wrote %s, wanted it to contain %s", out.String(), s)
+		}
+	}
+}
+
+func TestReportHTML(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	b, err := cloudarmor.RuleBundleFromYAML([]byte(`
+name: "html"
+rules:
+  - name: "admin"
+    expr: "request.path.startsWith('/admin') && request.method != '<script>'"
+    tests:
+      - name: "admin-path"
+        expect: true
+        when:
+          request:
+            path: /admin
+`), "")
+	if err != nil {
+		t.Fatalf("cloudarmor.RuleBundleFromYAML() returned error: %v", err)
+	}
+	results, err := r.RunBundle(b)
+	if err != nil {
+		t.Fatalf("r.RunBundle() returned error: %v", err)
+	}
+	corpus := "{\"request\": {\"method\": \"GET\", \"path\": \"/admin/users\"}}\n{\"request\": {\"path\": \"/\"}}\n"
+	replay, err := r.Replay(b, strings.NewReader(corpus), nil)
+	if err != nil {
+		t.Fatalf("r.Replay() returned error: %v", err)
+	}
+	report := cloudarmor.NewReport(b.Name)
+	report.Add(results)
+	report.AddImpact(replay)
+	if cov := report.Rules[0].Coverage; cov.Match != 1 || cov.Complete() {
+		t.Errorf("report coverage = %+v, wanted one matching case and incomplete coverage", cov)
+	}
+	var out strings.Builder
+	if err := report.WriteHTML(&out); err != nil {
+		t.Fatalf("report.WriteHTML() returned error: %v", err)
+	}
+	html := out.String()
+	for _, s := range []string{"admin-path", "Matched 1 of 2 corpus requests (50.00%)", "width: 50.0%", "GET /admin/users", "&lt;script&gt;"} {
+		if !strings.Contains(html, s) {
+			t.Errorf("report.WriteHTML() wrote %s, wanted it to contain %q", html, s)
+		}
+	}
+	if strings.Contains(html, "<script>") {
+		t.Errorf("report.WriteHTML() wrote an unescaped expression: %s", html)
+	}
+}
+
+func TestDiffBundles(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	base := &cloudarmor.RuleBundle{
+		Name: "base",
+		Definitions: []*cloudarmor.Definition{
+			{Name: "is_internal_ip", Expr: "inIpRange(origin.ip, '10.0.0.0/8')"},
+		},
+		Rules: []*cloudarmor.BundleRule{
+			{Name: "block-admin", Expr: "request.path.startsWith('/admin') && !is_internal_ip"},
+			{Name: "block-post", Expr: "request.method == 'POST'"},
+			{Name: "block-xx", Expr: "origin.region_code == 'XX'"},
+			{Name: "legacy", Expr: "request.path == '/old'"},
+		},
+	}
+	head := &cloudarmor.RuleBundle{
+		Name: "head",
+		Definitions: []*cloudarmor.Definition{
+			{Name: "is_internal_ip", Expr: "inIpRange(origin.ip, '10.0.0.0/16')"},
+		},
+		Rules: []*cloudarmor.BundleRule{
+			// Formatting changes alone are not reported.
+			{Name: "block-xx", Expr: "origin.region_code == \"XX\""},
+			{Name: "block-admin", Expr: "(request.path.startsWith('/admin')) && !is_internal_ip"},
+			{Name: "block-post", Expr: "request.method == 'POST'"},
+			{Name: "wp-login", Expr: "request.path.contains('wp-login')"},
+		},
+	}
+	diff, err := r.DiffBundles(base, head)
+	if err != nil {
+		t.Fatalf("DiffBundles() returned error: %v", err)
+	}
+	if !diff.Differs() {
+		t.Error("Differs() returned false, wanted true")
+	}
+	if !reflect.DeepEqual(diff.Added, []string{"wp-login"}) || !reflect.DeepEqual(diff.Removed, []string{"legacy"}) {
+		t.Errorf("DiffBundles() added %v and removed %v, wanted [wp-login] and [legacy]", diff.Added, diff.Removed)
+	}
+	if diff.Unchanged != 2 {
+		t.Errorf("DiffBundles() reported %d unchanged rules, wanted 2", diff.Unchanged)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].Name != "block-admin" {
+		t.Fatalf("DiffBundles() changed %v, wanted block-admin", diff.Changed)
+	}
+	want := []cloudarmor.ExprChange{{Base: `"10.0.0.0/8"`, Head: `"10.0.0.0/16"`}}
+	if got := diff.Changed[0].Differences; !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffBundles() differences %v, wanted %v", got, want)
+	}
+	if want := []cloudarmor.RuleMove{{Name: "block-xx", From: 3, To: 1}}; !reflect.DeepEqual(diff.Moved, want) {
+		t.Errorf("DiffBundles() moved %v, wanted %v", diff.Moved, want)
+	}
+
+	if diff, err := r.DiffBundles(base, base); err != nil || diff.Differs() || diff.Unchanged != 4 {
+		t.Errorf("DiffBundles() of identical bundles returned %+v, %v, wanted no differences", diff, err)
+	}
+	head.Rules[3].Expr = "request.path.contains("
+	if _, err := r.DiffBundles(base, head); err == nil || !strings.Contains(err.Error(), "head") {
+		t.Errorf("DiffBundles() with an invalid head rule returned %v, wanted error", err)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import "fmt"
+
+// BundleDiff describes the semantic changes between a base and a head revision of a rule bundle,
+// such as those of a pull request, rather than the textual changes to the bundle file.
+type BundleDiff struct {
+	// Added contains the names of the rules which only exist in the head revision, in head order.
+	Added []string
+	// Removed contains the names of the rules which only exist in the base revision, in base
+	// order.
+	Removed []string
+	// Changed contains the rules whose expressions are not equivalent, in head order.
+	Changed []RuleChange
+	// Moved contains the rules whose position relative to the other rules of both revisions
+	// changed, in head order.
+	Moved []RuleMove
+	// Unchanged is the number of rules whose expressions are equivalent.
+	Unchanged int
+}
+
+// Differs reports whether any rule was added, removed, changed, or moved.
+func (d *BundleDiff) Differs() bool {
+	return len(d.Added) != 0 || len(d.Removed) != 0 || len(d.Changed) != 0 || len(d.Moved) != 0
+}
+
+// RuleChange is a rule whose head expression is not equivalent to its base expression.
+type RuleChange struct {
+	Name string
+	// BaseHash and HeadHash are the canonical hashes of the two expressions.
+	BaseHash, HeadHash string
+	// Differences pairs each differing sub-expression of the base rule with its head
+	// counterpart, outermost first.
+	Differences []ExprChange
+}
+
+// ExprChange is a pair of corresponding sub-expressions which differ between revisions.
+type ExprChange struct {
+	Base, Head string
+}
+
+// RuleMove is a rule which moved relative to the other rules of both revisions.
+type RuleMove struct {
+	Name string
+	// From and To are the positions of the rule within the base and head bundles, counting from
+	// one.
+	From, To int
+}
+
+// DiffBundles compares the rules of a base and a head revision of a bundle by name.
+//
+// Rules are compared after expanding the definitions of their bundle, and are reduced to the
+// canonical form used by Drift, so that formatting changes are not reported while a change to a
+// definition is reported for every rule which uses it. A rule is reported as moved when it is
+// not part of the longest sequence of rules common to both revisions whose order is preserved,
+// so that moving a single rule reports only that rule.
+//
+// The return value is an error if the definitions of either bundle are invalid, a rule name is
+// repeated, or an expression fails to compile.
+func (r *Rules) DiffBundles(base, head *RuleBundle) (*BundleDiff, error) {
+	baseExprs, err := r.diffExprs("base", base)
+	if err != nil {
+		return nil, err
+	}
+	headExprs, err := r.diffExprs("head", head)
+	if err != nil {
+		return nil, err
+	}
+	compile, err := r.canonicalCompiler()
+	if err != nil {
+		return nil, err
+	}
+	diff := &BundleDiff{}
+	for _, rule := range base.Rules {
+		if _, found := headExprs[rule.Name]; !found {
+			diff.Removed = append(diff.Removed, rule.Name)
+		}
+	}
+	for _, rule := range head.Rules {
+		baseExpr, found := baseExprs[rule.Name]
+		if !found {
+			diff.Added = append(diff.Added, rule.Name)
+			continue
+		}
+		ba, err := compile(baseExpr)
+		if err != nil {
+			return nil, fmt.Errorf("base rule %q: %w", rule.Name, err)
+		}
+		ha, err := compile(headExprs[rule.Name])
+		if err != nil {
+			return nil, fmt.Errorf("head rule %q: %w", rule.Name, err)
+		}
+		bhash, err := canonicalHash(ba)
+		if err != nil {
+			return nil, err
+		}
+		hhash, err := canonicalHash(ha)
+		if err != nil {
+			return nil, err
+		}
+		if bhash == hhash {
+			diff.Unchanged++
+			continue
+		}
+		change := RuleChange{Name: rule.Name, BaseHash: bhash, HeadHash: hhash}
+		for _, d := range exprDiff(ba.NativeRep().Expr(), ba.NativeRep().SourceInfo(),
+			ha.NativeRep().Expr(), ha.NativeRep().SourceInfo()) {
+			change.Differences = append(change.Differences, ExprChange{Base: d.Deployed, Head: d.Local})
+		}
+		diff.Changed = append(diff.Changed, change)
+	}
+	diff.Moved = ruleMoves(base, head, baseExprs, headExprs)
+	return diff, nil
+}
+
+// diffExprs returns the expanded expressions of the rules of a bundle by name.
+func (r *Rules) diffExprs(revision string, b *RuleBundle) (map[string]string, error) {
+	exprs, err := r.ExpandBundle(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", revision, err)
+	}
+	named := map[string]string{}
+	for i, rule := range b.Rules {
+		if _, found := named[rule.Name]; found {
+			return nil, fmt.Errorf("%s: bundle %s: duplicate rule name %q", revision, b.Name, rule.Name)
+		}
+		named[rule.Name] = exprs[i]
+	}
+	return named, nil
+}
+
+// ruleMoves returns the rules common to both revisions which are not part of the longest common
+// subsequence of their order.
+func ruleMoves(base, head *RuleBundle, baseExprs, headExprs map[string]string) []RuleMove {
+	var from, to []string
+	basePos, headPos := map[string]int{}, map[string]int{}
+	for i, rule := range base.Rules {
+		basePos[rule.Name] = i + 1
+		if _, found := headExprs[rule.Name]; found {
+			from = append(from, rule.Name)
+		}
+	}
+	for i, rule := range head.Rules {
+		headPos[rule.Name] = i + 1
+		if _, found := baseExprs[rule.Name]; found {
+			to = append(to, rule.Name)
+		}
+	}
+	// lcs[i][j] is the length of the longest common subsequence of from[i:] and to[j:].
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	kept := map[string]bool{}
+	for i, j := 0, 0; i < len(from) && j < len(to); {
+		switch {
+		case from[i] == to[j]:
+			kept[from[i]] = true
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	var moves []RuleMove
+	for _, name := range to {
+		if !kept[name] {
+			moves = append(moves, RuleMove{Name: name, From: basePos[name], To: headPos[name]})
+		}
+	}
+	return moves
+}
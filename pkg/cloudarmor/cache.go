@@ -0,0 +1,128 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common"
+	"google.golang.org/protobuf/proto"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// cacheFormatVersion must be incremented whenever a change to the library alters the compiled
+// output for an unchanged environment configuration, invalidating all existing cache entries.
+const cacheFormatVersion = 1
+
+// CompileCache persists compiled expressions on disk as serialized CheckedExpr values so that
+// large rulesets do not need to be recompiled on every invocation.
+//
+// Entries are keyed by a hash of the environment configuration and the expression text, so a
+// change to either one results in a cache miss rather than a stale result.
+type CompileCache struct {
+	dir string
+}
+
+// NewCompileCache creates a CompileCache which stores its entries within the given directory,
+// creating the directory if it does not exist.
+func NewCompileCache(dir string) (*CompileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &CompileCache{dir: dir}, nil
+}
+
+// CompileCached compiles the given expression, returning the cached result if one exists.
+//
+// Cache entries which cannot be read or decoded are treated as misses and overwritten. Failed
+// compilations are not cached.
+func (r *Rules) CompileCached(c *CompileCache, expr string) (*cel.Ast, error) {
+	key, err := r.cacheKey(expr)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(c.dir, key+".binarypb")
+	if data, err := os.ReadFile(path); err == nil {
+		pb := &exprpb.CheckedExpr{}
+		if proto.Unmarshal(data, pb) == nil {
+			ast, err := cel.CheckedExprToAstWithSource(pb, common.NewTextSource(expr))
+			if err == nil {
+				return ast, nil
+			}
+		}
+	}
+	ast, err := r.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := cel.AstToCheckedExpr(ast)
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(pb)
+	if err != nil {
+		return nil, err
+	}
+	// Write to a temporary file and rename it so that concurrent readers never observe a
+	// partially written entry.
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return ast, nil
+}
+
+// cacheKey returns the hex-encoded hash identifying the compiled form of the expression within
+// the Rules environment.
+func (r *Rules) cacheKey(expr string) (string, error) {
+	config, err := lookupConfig(r.profile, r.version)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%d\x00%d\x00%s\x00%s\x00%+v\x00%+v\x00%s\x00%s", cacheFormatVersion, r.profile, r.version, r.presence,
+		strings.Join(r.disabledOperatorList(), " "), strings.Join(r.featureList(), " "), r.untrusted, r.limits, config.source(), expr)
+	if r.validateRegionCodes {
+		fmt.Fprint(h, "\x00validate_region_codes")
+	}
+	if r.validateASNs {
+		fmt.Fprint(h, "\x00validate_asns")
+	}
+	if r.validateTLSFingerprints {
+		fmt.Fprint(h, "\x00validate_tls_fingerprints")
+	}
+	if len(r.fingerprintLists) > 0 {
+		fmt.Fprintf(h, "\x00fingerprint_lists %s", strings.Join(fingerprintListNames(r.fingerprintLists), " "))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+
+	"github.com/google/cel-go/common/types"
+)
+
+func TestCompileCached(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := cloudarmor.NewCompileCache(dir)
+	if err != nil {
+		t.Fatalf("cloudarmor.NewCompileCache() returned error: %v", err)
+	}
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	expr := "request.path.lower().startsWith('/admin')"
+	vars := &cloudarmor.Variables{Request: &cloudarmor.Request{Path: "/Admin/login"}}
+
+	// The first compilation populates the cache, the second is served from it, and the third
+	// recovers from a corrupted entry.
+	for i := 0; i < 3; i++ {
+		ast, err := rules.CompileCached(cache, expr)
+		if err != nil {
+			t.Fatalf("rules.CompileCached() returned error: %v", err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program() returned error: %v", err)
+		}
+		out, _, err := prg.Eval(vars)
+		if err != nil {
+			t.Fatalf("prg.Eval() returned error: %v", err)
+		}
+		if out != types.True {
+			t.Errorf("prg.Eval() = %v, want true", out)
+		}
+		entries, err := filepath.Glob(filepath.Join(dir, "*.binarypb"))
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("cache entries = %v, %v, want exactly one entry", entries, err)
+		}
+		if i == 1 {
+			if err := os.WriteFile(entries[0], []byte("corrupted"), 0644); err != nil {
+				t.Fatalf("os.WriteFile() returned error: %v", err)
+			}
+		}
+	}
+
+	// A different environment version must not share cache entries.
+	next, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := next.CompileCached(cache, expr); err != nil {
+		t.Fatalf("next.CompileCached() returned error: %v", err)
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, "*.binarypb"))
+	if len(entries) != 2 {
+		t.Errorf("len(cache entries) = %d, want 2", len(entries))
+	}
+
+	if _, err := rules.CompileCached(cache, "request.body == 'x'"); err == nil {
+		t.Error("rules.CompileCached() succeeded for an expression invalid in VCurrent, wanted error")
+	}
+}
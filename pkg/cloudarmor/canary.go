@@ -0,0 +1,128 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// CanaryReport summarizes how a candidate set of rules diverges from the active set of rules
+// over a sequence of requests.
+type CanaryReport struct {
+	// Requests is the number of requests evaluated against both sets of rules.
+	Requests int
+	// Diverged is the number of requests for which the sets of rules disagreed.
+	Diverged int
+	// RuleDivergence counts, for each rule name, the requests whose outcome for the rule differed.
+	// A rule which exists in only one of the sets diverges whenever it matches or fails.
+	RuleDivergence map[string]int
+	// Samples contains the first diverging requests, up to the requested number of samples.
+	Samples []Divergence
+}
+
+// Divergence is a single request for which the active and candidate rules disagreed.
+type Divergence struct {
+	Input     *Variables
+	Active    CorpusResult
+	Candidate CorpusResult
+}
+
+// DivergenceRate returns the fraction of requests for which the sets of rules disagreed.
+func (c *CanaryReport) DivergenceRate() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.Diverged) / float64(c.Requests)
+}
+
+// Canary evaluates every request against both the active and the candidate rules, keyed by rule
+// name, and reports where their outcomes differ so that a rule change can be rolled out once its
+// divergence is understood.
+//
+// Two outcomes for a request agree when the same rules matched and the same rules failed to
+// evaluate. The requests are expected to have been initialized with SafeVariables.
+func (r *Rules) Canary(active, candidate map[string]string, requests []*Variables, maxSamples int) (*CanaryReport, error) {
+	return r.CanaryContext(context.Background(), active, candidate, requests, maxSamples)
+}
+
+// CanaryContext is Canary which returns the context's error once the context is done. The
+// context is also passed to the evaluation of each rule.
+func (r *Rules) CanaryContext(ctx context.Context, active, candidate map[string]string, requests []*Variables, maxSamples int) (*CanaryReport, error) {
+	activeEval, err := r.NewCorpusEvaluator(active)
+	if err != nil {
+		return nil, fmt.Errorf("active: %w", err)
+	}
+	candidateEval, err := r.NewCorpusEvaluator(candidate)
+	if err != nil {
+		return nil, fmt.Errorf("candidate: %w", err)
+	}
+	report := &CanaryReport{RuleDivergence: map[string]int{}}
+	progress := progressFrom(ctx)
+	for _, req := range requests {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		a := activeEval.evaluate(ctx, req)
+		c := candidateEval.evaluate(ctx, req)
+		report.Requests++
+		progress(report.Requests, len(requests))
+		changed := a.diff(c)
+		if len(changed) == 0 {
+			continue
+		}
+		report.Diverged++
+		for _, name := range changed {
+			report.RuleDivergence[name]++
+		}
+		if len(report.Samples) < maxSamples {
+			report.Samples = append(report.Samples, Divergence{Input: req, Active: a, Candidate: c})
+		}
+	}
+	return report, nil
+}
+
+// diff returns the sorted names of the rules whose outcome differs between the results.
+func (res CorpusResult) diff(other CorpusResult) []string {
+	outcomes := func(cr CorpusResult) map[string]string {
+		o := map[string]string{}
+		for _, name := range cr.Matches {
+			o[name] = "match"
+		}
+		for name := range cr.Errors {
+			o[name] = "error"
+		}
+		return o
+	}
+	a, b := outcomes(res), outcomes(other)
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	var changed []string
+	for name, outcome := range a {
+		if b[name] != outcome {
+			changed = append(changed, name)
+		}
+	}
+	for name := range b {
+		if _, found := a[name]; !found {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ChangeKind describes how a declaration differs between two versions.
+type ChangeKind string
+
+const (
+	// ChangeAdded is a declaration which is only available in the newer version.
+	ChangeAdded ChangeKind = "added"
+	// ChangeRemoved is a declaration which is only available in the older version.
+	ChangeRemoved ChangeKind = "removed"
+	// ChangeModified is a declaration whose type, overloads, or setting differs between versions.
+	ChangeModified ChangeKind = "modified"
+)
+
+// Declaration categories reported within a Change.
+const (
+	ChangeAttribute = "attribute"
+	ChangeFunction  = "function"
+	ChangeFeature   = "feature"
+)
+
+// Change is a single difference between the environments of two versions.
+type Change struct {
+	Kind ChangeKind
+	// Category is the kind of declaration which changed: an attribute, function, or feature.
+	Category string
+	Name     string
+	// Detail describes the declaration or how it changed, e.g. the type of an attribute or the
+	// overloads added to a function.
+	Detail string
+}
+
+// String returns the change as a single changelog line, e.g. "+ attribute request.body (string)".
+func (c Change) String() string {
+	marker := map[ChangeKind]string{ChangeAdded: "+", ChangeRemoved: "-", ChangeModified: "~"}[c.Kind]
+	return fmt.Sprintf("%s %s %s (%s)", marker, c.Category, c.Name, c.Detail)
+}
+
+// ChangesBetween returns the changes to the attributes, functions, and language features of the
+// HTTP profile when moving from one version to another, such as from VCurrent to VNext, so that
+// tooling can show exactly what opting into a version enables.
+//
+// The changes are derived from the environments built from the embedded configurations and the
+// function implementations of each version, and are ordered by category and then by name.
+func ChangesBetween(from, to uint32) ([]Change, error) {
+	before, err := versionDecls(from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := versionDecls(to)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for _, category := range []string{ChangeAttribute, ChangeFunction, ChangeFeature} {
+		old, cur := before[category], after[category]
+		for _, name := range SortedNames(cur) {
+			prev, found := old[name]
+			switch {
+			case !found:
+				changes = append(changes, Change{Kind: ChangeAdded, Category: category, Name: name, Detail: strings.Join(cur[name], ", ")})
+			case strings.Join(prev, ",") != strings.Join(cur[name], ","):
+				changes = append(changes, Change{Kind: ChangeModified, Category: category, Name: name, Detail: modification(category, prev, cur[name])})
+			}
+		}
+		for _, name := range SortedNames(old) {
+			if _, found := cur[name]; !found {
+				changes = append(changes, Change{Kind: ChangeRemoved, Category: category, Name: name, Detail: strings.Join(old[name], ", ")})
+			}
+		}
+	}
+	rank := map[string]int{ChangeAttribute: 0, ChangeFunction: 1, ChangeFeature: 2}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Category != changes[j].Category {
+			return rank[changes[i].Category] < rank[changes[j].Category]
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
+
+// versionDecls returns the declarations of the HTTP profile environment for a version by
+// category and name: the type of each attribute, the sorted overload IDs of each function which
+// rules may call, and whether each feature is enabled.
+func versionDecls(version uint32) (map[string]map[string][]string, error) {
+	r, err := NewRules(Version(version))
+	if err != nil {
+		return nil, err
+	}
+	decls := map[string]map[string][]string{
+		ChangeAttribute: {},
+		ChangeFunction:  {},
+		ChangeFeature:   {},
+	}
+	for _, v := range r.env.Variables() {
+		decls[ChangeAttribute][v.Name()] = []string{v.Type().String()}
+	}
+	for name, fn := range r.env.Functions() {
+		// Internal functions, such as those supporting comprehensions, cannot be called by rules.
+		if strings.HasPrefix(name, "@") {
+			continue
+		}
+		var ids []string
+		for _, o := range fn.OverloadDecls() {
+			ids = append(ids, o.ID())
+		}
+		sort.Strings(ids)
+		decls[ChangeFunction][name] = ids
+	}
+	config, err := lookupConfig(ProfileHTTP, version)
+	if err != nil {
+		return nil, err
+	}
+	c, err := config.config()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range c.Features {
+		decls[ChangeFeature][f.Name] = []string{fmt.Sprintf("enabled: %t", f.Enabled)}
+	}
+	return decls, nil
+}
+
+// modification describes how a declaration changed between versions.
+func modification(category string, before, after []string) string {
+	if category != ChangeFunction {
+		return strings.Join(before, ", ") + " -> " + strings.Join(after, ", ")
+	}
+	var parts []string
+	if added := difference(after, before); len(added) != 0 {
+		parts = append(parts, "adds overloads "+strings.Join(added, ", "))
+	}
+	if removed := difference(before, after); len(removed) != 0 {
+		parts = append(parts, "removes overloads "+strings.Join(removed, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// difference returns the elements of a which are not in b.
+func difference(a, b []string) []string {
+	in := map[string]bool{}
+	for _, s := range b {
+		in[s] = true
+	}
+	var diff []string
+	for _, s := range a {
+		if !in[s] {
+			diff = append(diff, s)
+		}
+	}
+	return diff
+}
@@ -17,14 +17,15 @@
 package cloudarmor
 
 import (
-	_ "embed"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"net"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/google/cel-go/cel"
@@ -34,7 +35,7 @@ import (
 	"github.com/google/cel-go/common/overloads"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
-	"gopkg.in/yaml.v3"
+	"github.com/google/cel-go/interpreter"
 )
 
 const (
@@ -44,16 +45,41 @@ const (
 	VNext uint32 = 2
 )
 
-//go:embed config/cloud-armor-v1.yaml
-var cloudArmorV1 string
-
-//go:embed config/cloud-armor-v2.yaml
-var cloudArmorV2 string
-
 // Rules represents a Cloud Armor rules environment.
 type Rules struct {
-	version uint32
-	env     *cel.Env
+	version          uint32
+	profile          PolicyProfile
+	env              *cel.Env
+	checkDeterminism bool
+	unknowns         bool
+	presence         AttributePresence
+	// disabledOperators contains the symbols of the operators rejected at check time.
+	disabledOperators map[string]bool
+	// validateRegionCodes rejects region code literals which are not ISO 3166-1 alpha-2 codes.
+	validateRegionCodes bool
+	// validateASNs rejects ASN literals outside the 32-bit range of autonomous system numbers.
+	validateASNs bool
+	// validateTLSFingerprints rejects JA3 and JA4 fingerprint literals of the wrong form.
+	validateTLSFingerprints bool
+	// explainFailures records the values of the subexpressions of a rule in failed test cases.
+	explainFailures bool
+	// debug captures the stacks of panics recovered during evaluation.
+	debug bool
+	// untrusted contains the limits enforced for untrusted expressions, if enabled.
+	untrusted *UntrustedLimits
+	// limits contains the limits of the Cloud Armor tier being mirrored, if set.
+	limits *Limits
+	// testTags contains the tags of the test cases which are run, or nil to run all of them.
+	testTags map[string]bool
+	// features contains the VNext capabilities enabled within a VCurrent environment.
+	features map[Feature]bool
+	// fingerprintLists contains the fingerprint lists matched by inFingerprintList, by name.
+	fingerprintLists map[string]*FingerprintList
+	// localizer localizes diagnostics, if set.
+	localizer Localizer
+	// redact and redactAllow contain the attributes added to and excluded from the default
+	// sensitive attributes, whose values are redacted from diagnostics.
+	redact, redactAllow map[string]bool
 }
 
 // RulesOption is a functional operator for configuring the Cloud Armor rules environment.
@@ -89,7 +115,7 @@ func NewRules(options ...RulesOption) (*Rules, error) {
 		}
 	}
 	rules.env, err = cel.NewCustomEnv(
-		compileOptions(rules.version)...,
+		compileOptions(rules)...,
 	)
 	return rules, err
 }
@@ -101,22 +127,213 @@ func (r *Rules) Env() *cel.Env {
 
 // Compile compiles the given expression into a cel.Ast or returns a set of issues.
 func (r *Rules) Compile(expr string) (*cel.Ast, error) {
+	if err := r.checkExpressionLength(expr); err != nil {
+		return nil, err
+	}
 	ast, iss := r.env.Compile(expr)
 	if iss != nil {
-		return nil, iss.Err()
+		return nil, r.explainIssues(expr, iss)
 	}
 	if ast.OutputType() != cel.BoolType {
 		return nil, errors.New("expression must evaluate to a boolean value")
 	}
+	if err := r.checkEstimatedCost(ast); err != nil {
+		return nil, err
+	}
 	return ast, nil
 }
 
+// CompileAll compiles each of the named expressions, continuing past failures so that every
+// rule of a large rule set is validated in a single pass. It returns the compiled rules and the
+// compile errors by name; each name appears in exactly one of the maps. Use SortedNames and
+// JoinErrors to report the results in a deterministic order.
+func (r *Rules) CompileAll(exprs map[string]string) (map[string]*cel.Ast, map[string]error) {
+	asts, errs, _ := r.CompileAllContext(context.Background(), exprs)
+	return asts, errs
+}
+
+// CompileAllContext is CompileAll which stops compiling once the context is done, returning the
+// rules compiled so far along with the context's error. Rules are compiled in the lexical order
+// of their names.
+func (r *Rules) CompileAllContext(ctx context.Context, exprs map[string]string) (map[string]*cel.Ast, map[string]error, error) {
+	asts := make(map[string]*cel.Ast, len(exprs))
+	errs := map[string]error{}
+	progress := progressFrom(ctx)
+	for i, name := range SortedNames(exprs) {
+		if err := ctx.Err(); err != nil {
+			return asts, errs, err
+		}
+		a, err := r.Compile(exprs[name])
+		progress(i+1, len(exprs))
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		asts[name] = a
+	}
+	return asts, errs, nil
+}
+
+// SortedNames returns the keys of a map of named rules in lexical order.
+func SortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JoinErrors joins the errors of named rules, such as those returned by CompileAll, into a
+// single error in the lexical order of the rule names, or returns nil if there are none.
+func JoinErrors(errs map[string]error) error {
+	joined := make([]error, 0, len(errs))
+	for _, name := range SortedNames(errs) {
+		joined = append(joined, fmt.Errorf("rule %q: %w", name, errs[name]))
+	}
+	return errors.Join(joined...)
+}
+
 // Program creates a new program from the given cel.Ast and accepts an optional set of CEL program
 // options which can be used to alter how the expression evaluates to capture information like
 // intermediate evaluation results.
+//
+// Calls to base64Decode, urlDecode, lower, and upper whose receiver is a string literal are
+// folded into literals before the program is planned so they are not recomputed on every eval.
+//
+// A panic within a Cloud Armor function or the evaluation of the program, such as one raised by a
+// malformed input, is returned as an error of type ErrorInternal rather than unwinding the caller.
 func (r *Rules) Program(ast *cel.Ast, prgOpts ...cel.ProgramOption) (cel.Program, error) {
+	return r.program(r.env, ast, prgOpts...)
+}
+
+// program plans the AST within env, which is the environment of the Rules or an extension of it,
+// applying the evaluation semantics configured on the Rules.
+func (r *Rules) program(env *cel.Env, ast *cel.Ast, prgOpts ...cel.ProgramOption) (prg cel.Program, err error) {
+	// Planning evaluates calls whose arguments are literals, so a panicking binding is recovered
+	// here as well as during evaluation.
+	defer func() {
+		if p := recover(); p != nil {
+			prg, err = nil, panicErr("program", p, r.debug)
+		}
+	}()
+	folded, err := foldDecodeLiterals(env, ast)
+	if err != nil {
+		return nil, err
+	}
+	if r.unknowns {
+		prgOpts = append([]cel.ProgramOption{cel.EvalOptions(cel.OptPartialEval)}, prgOpts...)
+	}
+	prgOpts = append(append(r.untrustedProgramOptions(), r.limitsProgramOptions()...), prgOpts...)
+	prgOpts = append(prgOpts, r.recoverProgramOptions()...)
 	opts := append([]cel.ProgramOption{cel.EvalOptions(cel.OptOptimize)}, prgOpts...)
-	return r.env.Program(ast, opts...)
+	prg, err = env.Program(folded, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if r.limits != nil {
+		prg = &bodyLimitProgram{Program: prg, maxSize: r.limits.MaxBodySize}
+	}
+	if r.checkDeterminism {
+		var unoptimized cel.Program
+		unoptimized, err = env.Program(ast, prgOpts...)
+		if err != nil {
+			return nil, err
+		}
+		if r.limits != nil {
+			unoptimized = &bodyLimitProgram{Program: unoptimized, maxSize: r.limits.MaxBodySize}
+		}
+		prg = &determinismProgram{optimized: prg, unoptimized: unoptimized}
+	}
+	prg = r.variablesProgram(prg)
+	if r.untrusted != nil {
+		prg = &timeoutProgram{Program: prg, timeout: r.untrusted.EvalTimeout}
+	}
+	prg = &recoverProgram{Program: prg, stack: r.debug}
+	if r.explainFailures {
+		return r.explainProgram(env, prg, folded, prgOpts)
+	}
+	return prg, nil
+}
+
+// variablesProgram wraps the program so that the presence and unknown attribute semantics of the
+// Rules are applied to its input, if either differs from the default.
+func (r *Rules) variablesProgram(prg cel.Program) cel.Program {
+	if !r.unknowns && r.presence != PresenceAbsent {
+		return prg
+	}
+	vp := &variablesProgram{Program: prg, presence: map[string]bool{}}
+	for _, v := range r.env.Variables() {
+		if attr, found := strings.CutPrefix(v.Name(), presencePrefix); found {
+			vp.presence[attr] = true
+		} else if r.unknowns {
+			vp.unknowns = append(vp.unknowns, v.Name())
+		}
+	}
+	return vp
+}
+
+// variablesProgram applies the presence and unknown attribute semantics configured on the Rules
+// to the input Variables before evaluating the wrapped program.
+type variablesProgram struct {
+	cel.Program
+	// presence contains the scalar attributes which are hidden from the expression when unset.
+	presence map[string]bool
+	// unknowns contains the attributes which are treated as unknown when unset.
+	unknowns []string
+}
+
+// Eval implements the cel.Program interface.
+func (p *variablesProgram) Eval(input any) (ref.Val, *cel.EvalDetails, error) {
+	return p.Program.Eval(p.activation(input))
+}
+
+// ContextEval implements the cel.Program interface.
+func (p *variablesProgram) ContextEval(ctx context.Context, input any) (ref.Val, *cel.EvalDetails, error) {
+	return p.Program.ContextEval(ctx, p.activation(input))
+}
+
+func (p *variablesProgram) activation(input any) any {
+	// Apply the semantics to the Variables beneath any overlays, outermost overlay first.
+	var overlays []*overlay
+	base := input
+	for o, ok := base.(*overlay); ok; o, ok = base.(*overlay) {
+		overlays = append(overlays, o)
+		base = o.base
+	}
+	vars, ok := base.(*Variables)
+	if !ok {
+		return input
+	}
+	var act interpreter.Activation = vars
+	if len(p.presence) != 0 {
+		act = presenceActivation{Variables: vars, attrs: p.presence}
+	}
+	overridden := func(attr string) bool {
+		for _, o := range overlays {
+			if _, found := o.overrides.ResolveName(attr); found {
+				return true
+			}
+		}
+		return false
+	}
+	for i := len(overlays) - 1; i >= 0; i-- {
+		act = &overlay{base: act, overrides: overlays[i].overrides}
+	}
+	var patterns []*interpreter.AttributePattern
+	for _, attr := range p.unknowns {
+		if vars.isUnset(attr) && !overridden(attr) {
+			patterns = append(patterns, interpreter.NewAttributePattern(attr))
+		}
+	}
+	if len(patterns) == 0 {
+		return act
+	}
+	partial, err := interpreter.NewPartialActivation(act, patterns...)
+	if err != nil {
+		return act
+	}
+	return partial
 }
 
 // RunRuleValidation runs a test suite against the an expression.
@@ -125,96 +342,136 @@ func (r *Rules) Program(ast *cel.Ast, prgOpts ...cel.ProgramOption) (cel.Program
 // Each test case is expected to contain an expression to compile, the variables to bind to the
 // expression, and the expected output or error.
 //
-// The return value is a slice of test statuses, one for each test case in the suite.
+// The return value is a slice of test statuses, one for each test case in the suite which is
+// selected by the TestTags option.
 func (r *Rules) RunRuleValidation(prg cel.Program, testCases []*TestCase) []TestStatus {
-	var statuses []TestStatus
-	for _, tc := range testCases {
-		out, _, err := prg.Eval(tc.When)
-		if err != nil {
-			if tc.ExpectError != "" {
-				if !strings.Contains(err.Error(), tc.ExpectError) {
-					statuses = append(statuses, TestStatus{
-						Name: tc.Name,
-						Fail: fmt.Sprintf("got error %q, wanted error containing %q", err.Error(), tc.ExpectError),
-					})
-				} else {
-					statuses = append(statuses, TestStatus{Name: tc.Name, Pass: true})
-				}
-			} else {
-				statuses = append(statuses, TestStatus{Name: tc.Name, Fail: err.Error()})
-			}
-			continue
+	statuses, _ := r.RunRuleValidationContext(context.Background(), prg, testCases)
+	return statuses
+}
+
+// RunRuleValidationContext is RunRuleValidation which stops once the context is done, returning
+// the statuses of the test cases run so far along with the context's error.
+func (r *Rules) RunRuleValidationContext(ctx context.Context, prg cel.Program, testCases []*TestCase) ([]TestStatus, error) {
+	return r.NewRunner().Run(ctx, "", prg, testCases)
+}
+
+// runTestCase evaluates a single test case and compares the result against its expectation and
+// its performance budgets. The values of sensitive attributes are redacted from the failure, from
+// the actual result or error message, and from the trace of a failed test case.
+func (r *Rules) runTestCase(prg cel.Program, tc *TestCase) TestStatus {
+	s := r.evalTestCase(prg, tc)
+	s.Fail = r.RedactMessage(s.Fail, tc.When)
+	if msg, ok := s.Actual.(string); ok {
+		s.Actual = r.RedactMessage(msg, tc.When)
+	}
+	if ep, ok := prg.(*explainProgram); ok && !s.Pass {
+		s.Trace = ep.trace(tc.When, r.Sensitive)
+		for i, step := range s.Trace {
+			s.Trace[i].Value = truncateTraceValue(r.RedactMessage(step.Value, tc.When))
 		}
-		if out == types.Bool(tc.ExpectOutput) {
-			statuses = append(statuses, TestStatus{Name: tc.Name, Pass: true})
-			continue
+	}
+	return s
+}
+
+func (r *Rules) evalTestCase(prg cel.Program, tc *TestCase) TestStatus {
+	s := TestStatus{Name: tc.Name, Expected: tc.ExpectOutput}
+	switch {
+	case tc.ExpectErrorCode != "":
+		s.Expected = tc.ExpectErrorCode
+	case tc.ExpectError != "":
+		s.Expected = tc.ExpectError
+	}
+	start := time.Now()
+	out, det, err := prg.Eval(tc.When)
+	s.Duration = time.Since(start)
+	var detErr *DeterminismError
+	switch {
+	case errors.As(err, &detErr):
+		s.Actual = err.Error()
+		s.Fail = err.Error()
+	case err != nil && tc.ExpectError == "" && tc.ExpectErrorCode == "":
+		s.Actual = err.Error()
+		s.Fail = err.Error()
+		s.Err = err
+	case err != nil:
+		s.Actual = err.Error()
+		if code := ErrorCodeOf(err); tc.ExpectErrorCode != "" && code != tc.ExpectErrorCode {
+			s.Fail = r.message(MessageUnexpectedErrorCode, err.Error(), code, tc.ExpectErrorCode)
+		} else if !strings.Contains(err.Error(), tc.ExpectError) {
+			s.Fail = r.message(MessageUnexpectedError, err.Error(), tc.ExpectError)
 		}
-		statuses = append(statuses, TestStatus{
-			Name: tc.Name,
-			Fail: fmt.Sprintf("expected result %v, got %v", tc.ExpectOutput, out),
-		})
+	case tc.ExpectErrorCode != "":
+		s.Actual = out.Value()
+		s.Fail = r.message(MessageMissingError, out, tc.ExpectErrorCode)
+	case out != types.Bool(tc.ExpectOutput):
+		s.Actual = out.Value()
+		s.Fail = r.message(MessageUnexpectedResult, tc.ExpectOutput, out)
+	default:
+		s.Actual = out.Value()
+		s.Fail = r.checkBudgets(tc, det, s.Duration)
 	}
-	return statuses
+	s.Pass = s.Fail == ""
+	return s
 }
 
-func compileOptions(version uint32) []cel.EnvOption {
+// checkBudgets returns a description of the first performance budget of the test case which
+// the evaluation exceeded, or the empty string if it is within budget.
+func (r *Rules) checkBudgets(tc *TestCase, det *cel.EvalDetails, latency time.Duration) string {
+	if tc.MaxCost != 0 {
+		var cost *uint64
+		if det != nil {
+			cost = det.ActualCost()
+		}
+		if cost == nil {
+			return r.message(MessageCostTrackingRequired)
+		}
+		if *cost > tc.MaxCost {
+			return r.message(MessageCostExceeded, *cost, tc.MaxCost)
+		}
+	}
+	if tc.MaxLatencyMs != 0 {
+		budget := time.Duration(tc.MaxLatencyMs * float64(time.Millisecond))
+		if latency > budget {
+			return r.message(MessageLatencyExceeded, latency, tc.MaxLatencyMs)
+		}
+	}
+	return ""
+}
+
+func compileOptions(r *Rules) []cel.EnvOption {
+	// Load the environment configuration
+	config, err := lookupConfig(r.profile, r.version)
+	var c *env.Config
+	if err == nil {
+		c, err = config.config()
+	}
+	if err != nil {
+		return []cel.EnvOption{func(*cel.Env) (*cel.Env, error) { return nil, err }}
+	}
+	var presenceAttrs map[string]bool
+	if r.presence == PresenceAbsent {
+		presenceAttrs = scalarAttributes(c)
+	}
 	options := []cel.EnvOption{
 		// Replace the standard macros with a single custom has macro.
 		cel.ClearMacros(),
-		cel.Macros(cel.GlobalMacro("has", 1, hasWithIndexMacroFactory)),
-
-		// Load the environment configuration
-		func(e *cel.Env) (*cel.Env, error) {
-			cloudArmorVersion := "cloud-armor-v1"
-			cloudArmorConfig := cloudArmorV1
-			switch version {
-			case 1:
-				break
-			case 2:
-				cloudArmorConfig = cloudArmorV2
-			default:
-				return nil, fmt.Errorf("unsupported cloud armor version: v%d", version)
-			}
-			c := env.NewConfig(cloudArmorVersion)
-			if err := yaml.Unmarshal([]byte(cloudArmorConfig), c); err != nil {
-				return nil, err
-			}
-			return cel.FromConfig(c)(e)
-		},
+		cel.Macros(cel.GlobalMacro("has", 1, hasMacroFactory(presenceAttrs))),
+		cel.FromConfig(c),
 	}
-	options = append(options, cloudArmorFunctions(version)...)
+	options = append(options, presenceDecls(presenceAttrs)...)
+	options = append(options, config.functions()...)
+	options = append(options, r.featureOptions()...)
+	options = append(options, r.fingerprintListOptions()...)
+	options = append(options, r.operatorOptions()...)
+	options = append(options, r.regionCodeOptions()...)
+	options = append(options, r.asnOptions()...)
+	options = append(options, r.tlsFingerprintOptions()...)
+	options = append(options, r.untrustedCompileOptions()...)
 	return options
 }
 
-func cloudArmorFunctions(_ uint32) []cel.EnvOption {
-	// Normally equality is type parameterized; however, we only support a subset of types.
-	funcs := []cel.EnvOption{
-		cel.Function(operators.Equals,
-			cel.Overload(overloads.Equals+"_bool", []*cel.Type{cel.BoolType, cel.BoolType}, cel.BoolType),
-			cel.Overload(overloads.Equals+"_double", []*cel.Type{cel.DoubleType, cel.DoubleType}, cel.BoolType),
-			cel.Overload(overloads.Equals+"_int64", []*cel.Type{cel.IntType, cel.IntType}, cel.BoolType),
-			cel.Overload(overloads.Equals+"_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType),
-		),
-		cel.Function(operators.NotEquals,
-			cel.Overload(overloads.NotEquals+"_bool", []*cel.Type{cel.BoolType, cel.BoolType}, cel.BoolType),
-			cel.Overload(overloads.NotEquals+"_double", []*cel.Type{cel.DoubleType, cel.DoubleType}, cel.BoolType),
-			cel.Overload(overloads.NotEquals+"_int64", []*cel.Type{cel.IntType, cel.IntType}, cel.BoolType),
-			cel.Overload(overloads.NotEquals+"_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType),
-		),
-		cel.Function("inIpRange", cel.Overload("inIpRange_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
-			cel.BinaryBinding(func(ip, ipRange ref.Val) ref.Val {
-				ipStr := string(ip.(types.String))
-				ipRangeStr := string(ipRange.(types.String))
-				netIP := net.ParseIP(ipStr)
-				if netIP == nil {
-					return types.NewErr("invalid IP address: %s", ipStr)
-				}
-				_, netIPRange, err := net.ParseCIDR(ipRangeStr)
-				if err != nil {
-					return types.NewErr("invalid IP range: %s", ipRangeStr)
-				}
-				return types.Bool(netIPRange.Contains(netIP))
-			}))),
+func cloudArmorFunctions(version uint32) []cel.EnvOption {
+	funcs := append(coreFunctions(),
 		cel.Function("lower", cel.MemberOverload("string_lower", []*cel.Type{cel.StringType}, cel.StringType,
 			cel.UnaryBinding(func(str ref.Val) ref.Val {
 				s := string(str.(types.String))
@@ -248,10 +505,37 @@ func cloudArmorFunctions(_ uint32) []cel.EnvOption {
 				s := string(urlStr.(types.String))
 				return utf8ToUnicodeString(s)
 			}))),
+	)
+	if version >= VNext {
+		funcs = append(funcs, numericFunctions()...)
+		funcs = append(funcs, idnFunctions()...)
 	}
 	return funcs
 }
 
+// coreFunctions returns the equality and IP range functions shared by every policy profile.
+func coreFunctions() []cel.EnvOption {
+	// Normally equality is type parameterized; however, we only support a subset of types.
+	return []cel.EnvOption{
+		cel.Function(operators.Equals,
+			cel.Overload(overloads.Equals+"_bool", []*cel.Type{cel.BoolType, cel.BoolType}, cel.BoolType),
+			cel.Overload(overloads.Equals+"_double", []*cel.Type{cel.DoubleType, cel.DoubleType}, cel.BoolType),
+			cel.Overload(overloads.Equals+"_int64", []*cel.Type{cel.IntType, cel.IntType}, cel.BoolType),
+			cel.Overload(overloads.Equals+"_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType),
+		),
+		cel.Function(operators.NotEquals,
+			cel.Overload(overloads.NotEquals+"_bool", []*cel.Type{cel.BoolType, cel.BoolType}, cel.BoolType),
+			cel.Overload(overloads.NotEquals+"_double", []*cel.Type{cel.DoubleType, cel.DoubleType}, cel.BoolType),
+			cel.Overload(overloads.NotEquals+"_int64", []*cel.Type{cel.IntType, cel.IntType}, cel.BoolType),
+			cel.Overload(overloads.NotEquals+"_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType),
+		),
+		cel.Function("inIpRange", cel.Overload("inIpRange_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+			cel.BinaryBinding(func(ip, ipRange ref.Val) ref.Val {
+				return inIPRange(string(ip.(types.String)), string(ipRange.(types.String)))
+			}))),
+	}
+}
+
 func hasWithIndexMacroFactory(mef cel.MacroExprFactory, target ast.Expr, args []ast.Expr) (ast.Expr, *cel.Error) {
 	arg := args[0]
 	// The has() macro with field selection, as supported by CEL: has(msg.field)
@@ -280,7 +564,7 @@ func hasWithIndexMacroFactory(mef cel.MacroExprFactory, target ast.Expr, args []
 
 func lowerASCII(str string) ref.Val {
 	runes := []rune(str)
-	for i, r := range str {
+	for i, r := range runes {
 		if r <= unicode.MaxASCII {
 			r = unicode.ToLower(r)
 			runes[i] = r
@@ -307,11 +591,11 @@ func base64DecodeString(str string) ref.Val {
 	}
 	_, tryAltEncoding := err.(base64.CorruptInputError)
 	if !tryAltEncoding {
-		return types.NewErrFromString(err.Error())
+		return evalErr(ErrorInvalidBase64, "%s", err)
 	}
 	b, err = base64.RawStdEncoding.DecodeString(str)
 	if err != nil {
-		return types.NewErrFromString(err.Error())
+		return evalErr(ErrorInvalidBase64, "%s", err)
 	}
 	return types.String(b)
 }
@@ -323,7 +607,7 @@ func urlDecodeString(str string) ref.Val {
 	// Possibly use the more error tolerant version of net/url#Url.Query()
 	res, err := url.QueryUnescape(str)
 	if err != nil {
-		return types.NewErrFromString(err.Error())
+		return evalErr(ErrorInvalidURLEncoding, "%s", err)
 	}
 	return types.String(res)
 }
@@ -335,12 +619,12 @@ func urlDecodeUniString(str string) ref.Val {
 		c := str[idx]
 		if c == '%' {
 			if idx+1 >= len(str) {
-				return types.NewErrFromString("invalid URL escape sequence")
+				return evalErr(ErrorInvalidURLEncoding, "invalid URL escape sequence")
 			}
 			c1 := str[idx+1]
 			if c1 == 'u' || c1 == 'U' {
 				if idx+5 >= len(str) {
-					return types.NewErrFromString("invalid URL escape sequence")
+					return evalErr(ErrorInvalidURLEncoding, "invalid URL escape sequence")
 				}
 				r, err := strconv.Unquote("\"\\u" + str[idx+2:idx+6] + "\"")
 				if err == nil {
@@ -350,7 +634,7 @@ func urlDecodeUniString(str string) ref.Val {
 				}
 			}
 			if idx+2 >= len(str) {
-				return types.NewErrFromString("invalid URL escape sequence")
+				return evalErr(ErrorInvalidURLEncoding, "invalid URL escape sequence")
 			}
 			res, err := url.QueryUnescape(str[idx : idx+3])
 			if err != nil {
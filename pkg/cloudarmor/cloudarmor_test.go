@@ -15,13 +15,24 @@
 package cloudarmor_test
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/quick"
+	"time"
 
 	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
 
+	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
 )
 
 var tests = []struct {
@@ -297,6 +308,25 @@ var tests = []struct {
 		},
 		want: types.True,
 	},
+	{
+		name: "recaptcha score threshold",
+		expr: "token.recaptcha_action.score >= 0.5 && token.recaptcha_session.score < 0.9",
+		vars: &cloudarmor.Variables{
+			Token: &cloudarmor.Token{
+				RecaptchaAction:  &cloudarmor.RecaptchaAction{Score: 0.5},
+				RecaptchaSession: &cloudarmor.RecaptchaSession{Score: 0.3},
+			},
+		},
+		want: types.True,
+	},
+	{
+		name: "asn range",
+		expr: "origin.asn > 64511 && origin.asn <= 65534",
+		vars: &cloudarmor.Variables{
+			Origin: &cloudarmor.Origin{ASN: 64512},
+		},
+		want: types.True,
+	},
 	{
 		name: "string body success",
 		expr: "request.body.contains('bad_data')",
@@ -474,3 +504,2074 @@ func TestRunTestSuite(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeLiteralFolding(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	foldTests := []struct {
+		name    string
+		expr    string
+		vars    *cloudarmor.Variables
+		want    ref.Val
+		wantErr string
+	}{
+		{
+			name: "base64Decode literal",
+			expr: "'YWJj'.base64Decode() == request.query",
+			vars: &cloudarmor.Variables{Request: &cloudarmor.Request{Query: "abc"}},
+			want: types.True,
+		},
+		{
+			name: "nested literal transformations",
+			expr: "'%41%42C'.urlDecode().lower() == request.path",
+			vars: &cloudarmor.Variables{Request: &cloudarmor.Request{Path: "abc"}},
+			want: types.True,
+		},
+		{
+			name:    "invalid literal decode preserved as eval error",
+			expr:    "'%zz'.urlDecode() == request.query",
+			vars:    &cloudarmor.Variables{Request: &cloudarmor.Request{Query: "abc"}},
+			wantErr: "invalid URL escape",
+		},
+	}
+	for _, tst := range foldTests {
+		tc := tst
+		t.Run(tc.name, func(t *testing.T) {
+			ast, err := rules.Compile(tc.expr)
+			if err != nil {
+				t.Fatalf("rules.Compile() returned error: %v", err)
+			}
+			prg, err := rules.Program(ast)
+			if err != nil {
+				t.Fatalf("rules.Program() returned error: %v", err)
+			}
+			res, _, err := prg.Eval(tc.vars)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("prg.Eval() got error %v, wanted error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("prg.Eval() returned error: %v", err)
+			}
+			if res != tc.want {
+				t.Errorf("prg.Eval() = %v, want %v", res, tc.want)
+			}
+		})
+	}
+}
+
+// propertySeed fixes the random source of the property tests so failures are reproducible.
+const propertySeed = 20250101
+
+func propertyConfig(gen func(r *rand.Rand) string) *quick.Config {
+	return &quick.Config{
+		MaxCount: 500,
+		Rand:     rand.New(rand.NewSource(propertySeed)),
+		Values: func(args []reflect.Value, r *rand.Rand) {
+			args[0] = reflect.ValueOf(gen(r))
+		},
+	}
+}
+
+func randomString(r *rand.Rand, alphabet []rune) string {
+	runes := make([]rune, r.Intn(32))
+	for i := range runes {
+		runes[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(runes)
+}
+
+var (
+	asciiAlphabet   = []rune(" !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~")
+	unicodeAlphabet = append([]rune("aZ09%+ ÀÉéßĀāЀЁΣσ中文😀"), asciiAlphabet...)
+)
+
+// evalQueryProperty evaluates the expression with the generated string bound to request.query.
+func evalQueryProperty(t *testing.T, expr string) func(string) bool {
+	t.Helper()
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := rules.Compile(expr)
+	if err != nil {
+		t.Fatalf("rules.Compile(%q) returned error: %v", expr, err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	return func(s string) bool {
+		out, _, err := prg.Eval(&cloudarmor.Variables{Request: &cloudarmor.Request{Query: s}})
+		if err != nil {
+			t.Logf("prg.Eval(%q) returned error: %v", s, err)
+			return false
+		}
+		return out == types.True
+	}
+}
+
+func TestStringFunctionProperties(t *testing.T) {
+	propertyTests := []struct {
+		name     string
+		expr     string
+		alphabet []rune
+	}{
+		{
+			name:     "lower of upper equals lower",
+			expr:     "request.query.upper().lower() == request.query.lower()",
+			alphabet: asciiAlphabet,
+		},
+		{
+			name:     "upper of lower equals upper",
+			expr:     "request.query.lower().upper() == request.query.upper()",
+			alphabet: asciiAlphabet,
+		},
+		{
+			name:     "lower is idempotent",
+			expr:     "request.query.lower().lower() == request.query.lower()",
+			alphabet: unicodeAlphabet,
+		},
+		{
+			name:     "case conversion preserves size",
+			expr:     "size(request.query.lower()) == size(request.query) && size(request.query.upper()) == size(request.query)",
+			alphabet: unicodeAlphabet,
+		},
+		{
+			name:     "utf8ToUnicode is the identity on ascii without escapes",
+			expr:     "request.query.utf8ToUnicode() == request.query",
+			alphabet: []rune("abcXYZ0129 -_./"),
+		},
+	}
+	for _, tst := range propertyTests {
+		tc := tst
+		t.Run(tc.name, func(t *testing.T) {
+			prop := evalQueryProperty(t, tc.expr)
+			cfg := propertyConfig(func(r *rand.Rand) string { return randomString(r, tc.alphabet) })
+			if err := quick.Check(prop, cfg); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDecodeFunctionProperties(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	decodeTests := []struct {
+		name   string
+		expr   string
+		encode func(string) (in, want string)
+	}{
+		{
+			name: "urlDecode inverts query escaping",
+			expr: "request.query.urlDecode() == request.path",
+			encode: func(s string) (string, string) {
+				return url.QueryEscape(s), s
+			},
+		},
+		{
+			name: "urlDecodeUni inverts query escaping",
+			expr: "request.query.urlDecodeUni() == request.path",
+			encode: func(s string) (string, string) {
+				return url.QueryEscape(s), s
+			},
+		},
+		{
+			name: "base64Decode accepts padded input",
+			expr: "request.query.base64Decode() == request.path",
+			encode: func(s string) (string, string) {
+				return base64.StdEncoding.EncodeToString([]byte(s)), s
+			},
+		},
+		{
+			name: "base64Decode accepts unpadded input",
+			expr: "request.query.base64Decode() == request.path",
+			encode: func(s string) (string, string) {
+				return base64.RawStdEncoding.EncodeToString([]byte(s)), s
+			},
+		},
+	}
+	for _, tst := range decodeTests {
+		tc := tst
+		t.Run(tc.name, func(t *testing.T) {
+			ast, err := rules.Compile(tc.expr)
+			if err != nil {
+				t.Fatalf("rules.Compile() returned error: %v", err)
+			}
+			prg, err := rules.Program(ast)
+			if err != nil {
+				t.Fatalf("rules.Program() returned error: %v", err)
+			}
+			prop := func(s string) bool {
+				in, want := tc.encode(s)
+				vars := &cloudarmor.Variables{Request: &cloudarmor.Request{Query: in, Path: want}}
+				out, _, err := prg.Eval(vars)
+				if err != nil {
+					t.Logf("prg.Eval(%q) returned error: %v", in, err)
+					return false
+				}
+				return out == types.True
+			}
+			cfg := propertyConfig(func(r *rand.Rand) string { return randomString(r, unicodeAlphabet) })
+			if err := quick.Check(prop, cfg); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// flakyActivation resolves request.method to a different value on every access.
+type flakyActivation struct {
+	*cloudarmor.Variables
+	calls int
+}
+
+func (a *flakyActivation) ResolveName(name string) (any, bool) {
+	if name == "request.method" {
+		a.calls++
+		if a.calls%2 == 0 {
+			return "POST", true
+		}
+		return "GET", true
+	}
+	return a.Variables.ResolveName(name)
+}
+
+func TestDeterminismCheck(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.DeterminismCheck())
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := rules.Compile("'R0VU'.base64Decode() == request.method")
+	if err != nil {
+		t.Fatalf("rules.Compile() returned error: %v", err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	statuses := rules.RunRuleValidation(prg, []*cloudarmor.TestCase{
+		cloudarmor.SafeTestCase(&cloudarmor.TestCase{
+			Name:         "deterministic",
+			When:         &cloudarmor.Variables{Request: &cloudarmor.Request{Method: "GET"}},
+			ExpectOutput: true,
+		}),
+	})
+	if statuses[0].Fail != "" {
+		t.Errorf("statuses[0].Fail = %q, want pass", statuses[0].Fail)
+	}
+
+	_, _, err = prg.Eval(&flakyActivation{Variables: cloudarmor.SafeVariables(&cloudarmor.Variables{})})
+	var detErr *cloudarmor.DeterminismError
+	if !errors.As(err, &detErr) {
+		t.Fatalf("prg.Eval() returned error %v, wanted a DeterminismError", err)
+	}
+	if detErr.Optimized != "true" || detErr.Unoptimized != "false" {
+		t.Errorf("prg.Eval() returned %+v, wanted optimized true and unoptimized false", detErr)
+	}
+}
+
+func TestDeterminismCheckUnknowns(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.DeterminismCheck(), cloudarmor.WithUnknowns())
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := rules.Compile("'R0VU'.base64Decode() == request.method && token.recaptcha_action.score > 0.5")
+	if err != nil {
+		t.Fatalf("rules.Compile() returned error: %v", err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	out, _, err := prg.Eval(cloudarmor.SafeVariables(&cloudarmor.Variables{Request: &cloudarmor.Request{Method: "GET"}}))
+	if err != nil || !types.IsUnknown(out) {
+		t.Errorf("prg.Eval() = %v, %v, wanted an unknown agreed on by both evaluations", out, err)
+	}
+}
+
+func TestWithUnknowns(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.WithUnknowns())
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tests := []struct {
+		expr    string
+		vars    *cloudarmor.Variables
+		unknown bool
+		want    ref.Val
+	}{
+		{
+			expr:    "token.recaptcha_action.score > 0.5",
+			vars:    cloudarmor.SafeVariables(&cloudarmor.Variables{Request: &cloudarmor.Request{Method: "GET"}}),
+			unknown: true,
+		},
+		{
+			expr:    "token.recaptcha_action.score > 0.5",
+			vars:    &cloudarmor.Variables{Token: &cloudarmor.Token{}},
+			unknown: true,
+		},
+		{
+			expr: "request.method == 'GET' || token.recaptcha_action.valid",
+			vars: cloudarmor.SafeVariables(&cloudarmor.Variables{Request: &cloudarmor.Request{Method: "GET"}}),
+			want: types.True,
+		},
+		{
+			expr: "token.recaptcha_action.score > 0.5",
+			vars: cloudarmor.SafeVariables(&cloudarmor.Variables{
+				Token: &cloudarmor.Token{RecaptchaAction: &cloudarmor.RecaptchaAction{Score: 0.9}},
+			}),
+			want: types.True,
+		},
+	}
+	for _, tst := range tests {
+		ast, err := rules.Compile(tst.expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", tst.expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", tst.expr, err)
+		}
+		out, _, err := prg.Eval(tst.vars)
+		if err != nil {
+			t.Fatalf("prg.Eval(%q) returned error: %v", tst.expr, err)
+		}
+		if tst.unknown {
+			if !types.IsUnknown(out) {
+				t.Errorf("prg.Eval(%q) = %v, wanted unknown", tst.expr, out)
+			}
+			continue
+		}
+		if out != tst.want {
+			t.Errorf("prg.Eval(%q) = %v, wanted %v", tst.expr, out, tst.want)
+		}
+	}
+}
+
+func TestPresenceAbsent(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext), cloudarmor.Presence(cloudarmor.PresenceAbsent))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	empty, err := cloudarmor.VariablesFromYAML([]byte("request:\n  method: ''\n"))
+	if err != nil {
+		t.Fatalf("cloudarmor.VariablesFromYAML() returned error: %v", err)
+	}
+	unset, err := cloudarmor.VariablesFromYAML([]byte("request:\n  path: /\n"))
+	if err != nil {
+		t.Fatalf("cloudarmor.VariablesFromYAML() returned error: %v", err)
+	}
+	tests := []struct {
+		expr    string
+		vars    *cloudarmor.Variables
+		want    ref.Val
+		wantErr string
+	}{
+		{expr: "has(request.method)", vars: empty, want: types.True},
+		{expr: "has(request.method)", vars: unset, want: types.False},
+		{expr: "request.method == ''", vars: empty, want: types.True},
+		{expr: "request.method == ''", vars: unset, wantErr: "no such attribute"},
+		{expr: "!has(request.method) || request.method == 'GET'", vars: unset, want: types.True},
+		{expr: "has(request.headers.host)", vars: unset, want: types.False},
+		{
+			expr: "has(token.recaptcha_action.score)",
+			vars: cloudarmor.SafeVariables(&cloudarmor.Variables{
+				Token: &cloudarmor.Token{RecaptchaAction: &cloudarmor.RecaptchaAction{Score: 0.5}},
+			}),
+			want: types.True,
+		},
+	}
+	for _, tst := range tests {
+		ast, err := rules.Compile(tst.expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", tst.expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", tst.expr, err)
+		}
+		out, _, err := prg.Eval(tst.vars)
+		if tst.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("prg.Eval(%q) returned %v, %v, wanted error containing %q", tst.expr, out, err, tst.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("prg.Eval(%q) returned error: %v", tst.expr, err)
+		}
+		if out != tst.want {
+			t.Errorf("prg.Eval(%q) = %v, wanted %v", tst.expr, out, tst.want)
+		}
+	}
+
+	zero, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := zero.Compile("has(request.method)"); err == nil {
+		t.Error("zero.Compile(\"has(request.method)\") succeeded, wanted error under zero-value presence")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{
+			expr: "(inIpRange(origin.ip, '192.0.2.0/24') || inIpRange(origin.ip, '198.51.100.0/24')) && request.path.startsWith('/admin')",
+			want: `Matches when (client IP is in 2 ranges (192.0.2.0/24, 198.51.100.0/24)) AND path starts with "/admin"`,
+		},
+		{
+			expr: "origin.region_code == 'AQ' || origin.region_code == 'BV' || origin.asn == 64496",
+			want: `Matches when client region is one of "AQ", "BV" OR client ASN is 64496`,
+		},
+		{
+			expr: "!(request.method == 'GET') && request.headers['user-agent'].lower().contains('curl')",
+			want: `Matches when NOT method is "GET" AND lowercased header "user-agent" contains "curl"`,
+		},
+		{
+			expr: "token.recaptcha_action.score < 0.5 || size(request.query) > 1024",
+			want: `Matches when reCAPTCHA action score is less than 0.5 OR length of query is greater than 1024`,
+		},
+		{
+			expr: "request.params.exists(k, k == 'debug')",
+			want: "Matches when a condition holds over the entries of params",
+		},
+		{
+			expr: "cel.bind(q, request.query.urlDecode(), q.contains('union') && q.contains('select'))",
+			want: `Matches when q contains "union" AND q contains "select", where q is URL-decoded query`,
+		},
+		{
+			expr: "inRange(token.recaptcha_action.score, 0.0, 0.3)",
+			want: "Matches when reCAPTCHA action score is between 0 and 0.3",
+		},
+		{
+			expr: "request.headers['x-debug'] + 'a' == 'ba'",
+			want: "Matches when `request.headers[\"x-debug\"] + \"a\"` is \"ba\"",
+		},
+	}
+	for _, tst := range tests {
+		ast, err := rules.Compile(tst.expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", tst.expr, err)
+		}
+		if got := cloudarmor.Summarize(ast); got != tst.want {
+			t.Errorf("cloudarmor.Summarize(%q) = %q, wanted %q", tst.expr, got, tst.want)
+		}
+	}
+}
+
+func TestParamsIndexAccess(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{
+			Headers: map[string]string{"host": "example.com"},
+			Params: map[string]any{
+				"dest": "/somepath",
+				"keys": map[string]any{"key1": "a"},
+			},
+		},
+	})
+	exprs := []string{
+		"request.params['dest'] == '/somepath'",
+		"request.params.dest == '/somepath'",
+		"request.params['keys']['key1'] == 'a'",
+		"request.params.keys.key1 == 'a'",
+		"has(request.params['dest']) && !has(request.params['missing'])",
+		"request.headers['host'] == 'example.com' && request.params['keys']['key1'] == 'a'",
+		"request.params.exists(k, k == 'dest' && request.params[k].startsWith('/'))",
+	}
+	for _, expr := range exprs {
+		ast, err := rules.Compile(expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", expr, err)
+		}
+		out, _, err := prg.Eval(vars)
+		if err != nil || out != types.True {
+			t.Errorf("prg.Eval(%q) = %v, %v, wanted true", expr, out, err)
+		}
+	}
+}
+
+func TestBind(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Query: "id=1%20UNION%20SELECT%20password"},
+	})
+	bound := "cel.bind(q, request.query.urlDecode().lower(), q.contains('union') && q.contains('select'))"
+	inline := "request.query.urlDecode().lower().contains('union') && request.query.urlDecode().lower().contains('select')"
+	costs := map[string]uint64{}
+	for _, expr := range []string{bound, inline} {
+		ast, err := rules.Compile(expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", expr, err)
+		}
+		prg, err := rules.Program(ast, cel.CostTracking(nil))
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", expr, err)
+		}
+		out, det, err := prg.Eval(vars)
+		if err != nil || out != types.True {
+			t.Fatalf("prg.Eval(%q) = %v, %v, wanted true", expr, out, err)
+		}
+		costs[expr] = *det.ActualCost()
+	}
+	if costs[bound] >= costs[inline] {
+		t.Errorf("cel.bind cost %d, wanted less than the inline cost %d", costs[bound], costs[inline])
+	}
+
+	if _, err := rules.Compile("cel.bind(origin, request.path, origin.startsWith('/'))"); err == nil ||
+		!strings.Contains(err.Error(), "shadows the attribute") {
+		t.Errorf("rules.Compile() with a shadowing cel.bind got error %v, wanted shadowing error", err)
+	}
+	current, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := current.Compile(bound); err == nil {
+		t.Error("current.Compile() with cel.bind succeeded, wanted error in VCurrent")
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	features := map[string]cloudarmor.OperatorFeature{}
+	for _, op := range rules.Features().Operators {
+		features[op.Symbol] = op
+	}
+	for _, sym := range []string{"?:", "in", "/", "%", "-x"} {
+		if features[sym].Enabled {
+			t.Errorf("Features() reported operator %q as enabled", sym)
+		}
+	}
+	want := []string{"double, double", "int, int"}
+	if got := features["<"]; !got.Enabled || !reflect.DeepEqual(got.Signatures, want) {
+		t.Errorf("Features() reported operator '<' as %+v, wanted signatures %v", got, want)
+	}
+	if got := features["!"]; !got.Enabled {
+		t.Errorf("Features() reported operator '!' as disabled")
+	}
+}
+
+func TestDisableOperators(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.DisableOperators("!", "<"))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	for _, expr := range []string{"!(request.method == 'GET')", "origin.asn < 100"} {
+		if _, err := rules.Compile(expr); err == nil || !strings.Contains(err.Error(), "is disabled") {
+			t.Errorf("rules.Compile(%q) got error %v, wanted disabled operator error", expr, err)
+		}
+	}
+	if _, err := rules.Compile("origin.asn > 100 && request.method != 'GET'"); err != nil {
+		t.Errorf("rules.Compile() returned error: %v", err)
+	}
+	for _, op := range rules.Features().Operators {
+		if (op.Symbol == "!" || op.Symbol == "<") && op.Enabled {
+			t.Errorf("Features() reported disabled operator %q as enabled", op.Symbol)
+		}
+	}
+	if _, err := cloudarmor.NewRules(cloudarmor.DisableOperators("**")); err == nil {
+		t.Error("cloudarmor.NewRules() with an unknown operator succeeded, wanted error")
+	}
+}
+
+func TestValidateRegionCodes(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.ValidateRegionCodes())
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "origin.region_code == 'UK'", want: `did you mean "GB" (United Kingdom)?`},
+		{expr: "'gb' != origin.region_code", want: `"gb" must be uppercase`},
+		{expr: "origin.region_code == 'ZZ'", want: `"ZZ" is not an ISO 3166-1 alpha-2 region code`},
+		{expr: "(origin.region_code == 'US' || origin.region_code == 'GB') && request.path == 'UK'"},
+		{expr: "request.headers['x-region'] == 'UK'"},
+	}
+	for _, tc := range tests {
+		_, err := rules.Compile(tc.expr)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("rules.Compile(%q) returned error: %v", tc.expr, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("rules.Compile(%q) got error %v, wanted %q", tc.expr, err, tc.want)
+		}
+	}
+	plain, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := plain.Compile("origin.region_code == 'UK'"); err != nil {
+		t.Errorf("rules.Compile() without ValidateRegionCodes returned error: %v", err)
+	}
+	regions := cloudarmor.Regions()
+	if len(regions) != 249 || regions[0].Code != "AD" {
+		t.Errorf("cloudarmor.Regions() returned %d regions starting with %v, wanted 249 starting with AD", len(regions), regions[0])
+	}
+	if r, found := cloudarmor.LookupRegion("GB"); !found || r.Name != "United Kingdom" {
+		t.Errorf("cloudarmor.LookupRegion(GB) = %v, %t", r, found)
+	}
+}
+
+func TestASNDirectory(t *testing.T) {
+	d, err := cloudarmor.ReadASNDirectory(strings.NewReader("# comment\nAS15169,Google LLC\n\n64496\tExample Networks\n"))
+	if err != nil {
+		t.Fatalf("cloudarmor.ReadASNDirectory() returned error: %v", err)
+	}
+	if got := d.Format(15169); got != "AS15169 (Google LLC)" {
+		t.Errorf("d.Format(15169) = %q", got)
+	}
+	if got := d.Format(64511); got != "AS64511" {
+		t.Errorf("d.Format(64511) = %q", got)
+	}
+	for _, in := range []string{"15169", "ASX,Example", "4294967296,Example"} {
+		if _, err := cloudarmor.ReadASNDirectory(strings.NewReader(in)); err == nil {
+			t.Errorf("cloudarmor.ReadASNDirectory(%q) succeeded, wanted error", in)
+		}
+	}
+
+	rules, err := cloudarmor.NewRules(cloudarmor.ValidateASNs())
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := rules.Compile("origin.asn == 15169 || origin.asn == 64496 || origin.asn > 4200000000")
+	if err != nil {
+		t.Fatalf("rules.Compile() returned error: %v", err)
+	}
+	want := "Matches when client ASN is one of AS15169 (Google LLC), AS64496 (Example Networks) OR client ASN is greater than AS4200000000"
+	if got := cloudarmor.Summarize(ast, cloudarmor.SummaryASNs(d)); got != want {
+		t.Errorf("cloudarmor.Summarize() = %q, want %q", got, want)
+	}
+	for _, expr := range []string{"origin.asn == 151690000000", "-1 != origin.asn", "origin.asn <= 4294967296"} {
+		if _, err := rules.Compile(expr); err == nil || !strings.Contains(err.Error(), "outside the range 0 to 4294967295") {
+			t.Errorf("rules.Compile(%q) got error %v, wanted an ASN range error", expr, err)
+		}
+	}
+}
+
+func TestValidateTLSFingerprints(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.ValidateTLSFingerprints())
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "origin.tls_ja3_fingerprint == 'e7d705a3286e19ea42f587b344ee6865'"},
+		{expr: "origin.tls_ja4_fingerprint != 't13d1516h2_8daaf6152771_b186095e22b6'"},
+		{expr: "origin.tls_ja4_fingerprint.startsWith('t13d')"},
+		{expr: "origin.tls_ja3_fingerprint == 'e7d705a3286e19ea42f587b344ee686'", want: "it has 31 hex digits rather than 32"},
+		{expr: "'E7D705A3286E19EA42F587B344EE6865' == origin.tls_ja3_fingerprint", want: "must be lowercase"},
+		{expr: "origin.tls_ja3_fingerprint == '771,4865-4866,0-23,29-23,0'", want: `did you mean "40a8d16d28297af652a24c22c97c89a8"?`},
+		{expr: "origin.tls_ja4_fingerprint == 't13d1516h2_8daaf6152771'", want: "it has 23 characters rather than 36"},
+		{expr: "origin.tls_ja4_fingerprint == 'T13D1516H2_8DAAF6152771_B186095E22B6'", want: "must be lowercase"},
+		{expr: "origin.tls_ja4_fingerprint == 't13x1516h2_8daaf6152771_b186095e22b6'", want: "is not a JA4 fingerprint"},
+	}
+	for _, tc := range tests {
+		_, err := rules.Compile(tc.expr)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("rules.Compile(%q) returned error: %v", tc.expr, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("rules.Compile(%q) got error %v, wanted %q", tc.expr, err, tc.want)
+		}
+	}
+}
+
+func TestFingerprintLists(t *testing.T) {
+	sslbl, err := cloudarmor.ReadFingerprintListCSV("sslbl", strings.NewReader(`################
+# abuse.ch SSLBL JA3 Fingerprint Blacklist (CSV)
+################
+# ja3_md5,Firstseen,Lastseen,Listingreason
+1aa7bf8b97e540ca5edd75f7b8384bfa,2017-07-14 18:08:15,2019-07-27 20:42:54,TrickBot
+1AA7BF8B97E540CA5EDD75F7B8384BFA,2017-07-14 18:08:15,2019-07-27 20:42:54,TrickBot
+`))
+	if err != nil {
+		t.Fatalf("cloudarmor.ReadFingerprintListCSV() returned error: %v", err)
+	}
+	if want := []string{"1aa7bf8b97e540ca5edd75f7b8384bfa"}; !reflect.DeepEqual(sslbl.Fingerprints, want) {
+		t.Errorf("sslbl.Fingerprints = %q, wanted %q", sslbl.Fingerprints, want)
+	}
+	malware, err := cloudarmor.ReadFingerprintListJSON("malware-ja4", strings.NewReader(
+		`["t13d1516h2_8daaf6152771_b186095e22b6", {"ja4": "t13d1517h2_8daaf6152771_b186095e22b6", "family": "x"}]`))
+	if err != nil {
+		t.Fatalf("cloudarmor.ReadFingerprintListJSON() returned error: %v", err)
+	}
+	if len(malware.Fingerprints) != 2 {
+		t.Errorf("malware.Fingerprints = %q, wanted 2 fingerprints", malware.Fingerprints)
+	}
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext), cloudarmor.WithFingerprintLists(sslbl, malware))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Headers: map[string]string{"x-list": "missing"}},
+		Origin: &cloudarmor.Origin{
+			TLSJA3Fingerprint: "1aa7bf8b97e540ca5edd75f7b8384bfa",
+			TLSJA4Fingerprint: "t13d1516h2_8daaf6152771_b186095e22b6",
+		},
+	})
+	tests := []struct {
+		expr    string
+		want    ref.Val
+		wantErr string
+	}{
+		{expr: "inFingerprintList(origin.tls_ja4_fingerprint, 'malware-ja4')", want: types.True},
+		{expr: "inFingerprintList(origin.tls_ja3_fingerprint, 'sslbl')", want: types.True},
+		{expr: "inFingerprintList(origin.tls_ja3_fingerprint, 'malware-ja4')", want: types.False},
+		{expr: "inFingerprintList('T13D1516H2_8DAAF6152771_B186095E22B6', 'malware-ja4')", want: types.False},
+		{expr: "inFingerprintList(origin.tls_ja4_fingerprint, request.headers['x-list'])", wantErr: `unknown fingerprint list "missing"`},
+	}
+	for _, tst := range tests {
+		ast, err := rules.Compile(tst.expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", tst.expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", tst.expr, err)
+		}
+		out, _, err := prg.Eval(vars)
+		if tst.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) || cloudarmor.ErrorCodeOf(err) != cloudarmor.ErrorUnknownFingerprintList {
+				t.Errorf("prg.Eval(%q) got error %v, wanted unknown_fingerprint_list error containing %q", tst.expr, err, tst.wantErr)
+			}
+			continue
+		}
+		if err != nil || out != tst.want {
+			t.Errorf("prg.Eval(%q) = %v, %v, wanted %v", tst.expr, out, err, tst.want)
+		}
+	}
+	if _, err := rules.Compile("inFingerprintList(origin.tls_ja4_fingerprint, 'malware')"); err == nil || !strings.Contains(err.Error(), "must be one of malware-ja4, sslbl") {
+		t.Errorf("rules.Compile() with an unknown list got error %v, wanted unknown fingerprint list error", err)
+	}
+	if _, err := cloudarmor.NewFingerprintList("bad", "t13d1516h2_8daaf6152771"); err == nil {
+		t.Error("cloudarmor.NewFingerprintList() with a truncated fingerprint succeeded, wanted error")
+	}
+	if _, err := cloudarmor.NewRules(cloudarmor.WithFingerprintLists(sslbl, sslbl)); err == nil {
+		t.Error("cloudarmor.NewRules() with a duplicate list succeeded, wanted error")
+	}
+	current, err := cloudarmor.NewRules(cloudarmor.WithFingerprintLists(sslbl))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := current.Compile("inFingerprintList(origin.tls_ja3_fingerprint, 'sslbl')"); err == nil {
+		t.Error("inFingerprintList() compiled in VCurrent, wanted error")
+	}
+	feature, err := cloudarmor.NewRules(cloudarmor.WithFeature(cloudarmor.FeatureFingerprintLists), cloudarmor.WithFingerprintLists(sslbl))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := feature.Compile("inFingerprintList(origin.tls_ja3_fingerprint, 'sslbl')"); err != nil {
+		t.Errorf("inFingerprintList() with the fingerprint_lists feature failed to compile: %v", err)
+	}
+}
+
+func TestRelationalOperatorErrors(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{
+			expr: "request.path < 'b'",
+			want: "'<' is only supported between two int or two double operands",
+		},
+		{
+			expr: "token.recaptcha_action.valid >= true",
+			want: "'>=' is only supported between two int or two double operands",
+		},
+		{
+			expr: "origin.asn > 0.5",
+			want: "int and double operands may not be mixed",
+		},
+		{
+			expr: "token.recaptcha_action.score <= 1",
+			want: "token.recaptcha_action.score <= 0.5",
+		},
+	}
+	for _, tst := range tests {
+		_, err := rules.Compile(tst.expr)
+		if err == nil || !strings.Contains(err.Error(), "found no matching overload") || !strings.Contains(err.Error(), tst.want) {
+			t.Errorf("rules.Compile(%q) got error %v, wanted error containing %q", tst.expr, err, tst.want)
+		}
+	}
+}
+
+func TestInRange(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	// In floating-point arithmetic 0.1 * 7 is 0.7000000000000001, which exceeds 0.7.
+	tenth := 0.1
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Origin: &cloudarmor.Origin{ASN: 64512},
+		Token: &cloudarmor.Token{
+			RecaptchaAction: &cloudarmor.RecaptchaAction{Score: tenth * 7},
+		},
+	})
+	tests := []struct {
+		expr    string
+		want    ref.Val
+		wantErr string
+	}{
+		{expr: "token.recaptcha_action.score <= 0.7", want: types.False},
+		{expr: "inRange(token.recaptcha_action.score, 0.3, 0.7)", want: types.True},
+		{expr: "inRange(token.recaptcha_action.score, 0.7, 1.0)", want: types.True},
+		{expr: "inRange(token.recaptcha_action.score, 0.8, 1.0)", want: types.False},
+		{expr: "inRange(token.recaptcha_action.score, 0.0, 0.6)", want: types.False},
+		{expr: "inRange(origin.asn, 64512, 65534)", want: types.True},
+		{expr: "inRange(origin.asn, 1, 64511)", want: types.False},
+		{expr: "inRange(origin.asn, 65534, 64512)", wantErr: "lower bound 65534 exceeds upper bound 64512"},
+		{expr: "inRange(token.recaptcha_action.score, 1.0, 0.5)", wantErr: "exceeds upper bound"},
+	}
+	for _, tst := range tests {
+		ast, err := rules.Compile(tst.expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", tst.expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", tst.expr, err)
+		}
+		out, _, err := prg.Eval(vars)
+		if tst.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("prg.Eval(%q) got error %v, wanted error containing %q", tst.expr, err, tst.wantErr)
+			}
+			continue
+		}
+		if err != nil || out != tst.want {
+			t.Errorf("prg.Eval(%q) = %v, %v, wanted %v", tst.expr, out, err, tst.want)
+		}
+	}
+	if _, err := rules.Compile("inRange(origin.asn, 1.0, 2.0)"); err == nil {
+		t.Error("rules.Compile() with mixed inRange operands succeeded, wanted error")
+	}
+	current, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := current.Compile("inRange(origin.asn, 1, 2)"); err == nil {
+		t.Error("current.Compile() with inRange succeeded, wanted error in VCurrent")
+	}
+}
+
+func TestUntrustedMode(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext), cloudarmor.UntrustedMode())
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	compileTests := []struct {
+		expr string
+		want string
+	}{
+		{
+			expr: strings.Repeat("request.path.contains('abcdefgh') || ", 60) + "true",
+			want: "exceeds the maximum of 2048",
+		},
+		{
+			expr: "request.params.exists(k, request.params.exists(j, j == k))",
+			want: "comprehension exceeds nesting limit",
+		},
+		{
+			expr: strings.Repeat("(", 40) + "true" + strings.Repeat(")", 40),
+			want: "recursion limit exceeded",
+		},
+	}
+	for _, tst := range compileTests {
+		if _, err := rules.Compile(tst.expr); err == nil || !strings.Contains(err.Error(), tst.want) {
+			t.Errorf("rules.Compile(%.40q) got error %v, wanted error containing %q", tst.expr, err, tst.want)
+		}
+	}
+	if _, err := rules.Compile("request.path.lower().contains('admin') && inIpRange(origin.ip, '10.0.0.0/8')"); err != nil {
+		t.Errorf("rules.Compile() returned error: %v", err)
+	}
+
+	limits := cloudarmor.DefaultUntrustedLimits()
+	limits.BannedFunctions = []string{"matches"}
+	limits.MaxEstimatedCost = 100
+	limits.MaxStringSize = 32
+	limited, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext), cloudarmor.UntrustedModeWithLimits(limits))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := limited.Compile("request.path.matches('^/admin')"); err == nil || !strings.Contains(err.Error(), "function 'matches' is not permitted") {
+		t.Errorf("limited.Compile() with a banned function got error %v, wanted not permitted error", err)
+	}
+	if _, err := limited.Compile("request.body.lower().contains('select')"); err == nil || !strings.Contains(err.Error(), "estimated cost") {
+		t.Errorf("limited.Compile() with an expensive expression got error %v, wanted estimated cost error", err)
+	}
+	ast, err := limited.Compile("request.path.utf8ToUnicode() == ''")
+	if err != nil {
+		t.Fatalf("limited.Compile() returned error: %v", err)
+	}
+	prg, err := limited.Program(ast)
+	if err != nil {
+		t.Fatalf("limited.Program() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{Request: &cloudarmor.Request{Path: "/ädmin/ünïcödé"}})
+	if _, _, err := prg.Eval(vars); err == nil || !strings.Contains(err.Error(), "exceeding the maximum of 32") {
+		t.Errorf("prg.Eval() got error %v, wanted string size error", err)
+	}
+
+	limits = cloudarmor.DefaultUntrustedLimits()
+	limits.CostLimit = 5
+	costly, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext), cloudarmor.UntrustedModeWithLimits(limits))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err = costly.Compile("request.params.exists(k, k.startsWith('debug'))")
+	if err != nil {
+		t.Fatalf("costly.Compile() returned error: %v", err)
+	}
+	prg, err = costly.Program(ast)
+	if err != nil {
+		t.Fatalf("costly.Program() returned error: %v", err)
+	}
+	params := map[string]any{}
+	for i := 0; i < 100; i++ {
+		params[fmt.Sprintf("key%d", i)] = "value"
+	}
+	vars = cloudarmor.SafeVariables(&cloudarmor.Variables{Request: &cloudarmor.Request{Params: params}})
+	if _, _, err := prg.Eval(vars); err == nil || !strings.Contains(err.Error(), "cost limit exceeded") {
+		t.Errorf("prg.Eval() got error %v, wanted cost limit error", err)
+	}
+
+	limits = cloudarmor.DefaultUntrustedLimits()
+	limits.EvalTimeout = time.Nanosecond
+	limits.InterruptCheckFrequency = 1
+	slow, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext), cloudarmor.UntrustedModeWithLimits(limits))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err = slow.Compile("request.params.exists(k, k.startsWith('debug'))")
+	if err != nil {
+		t.Fatalf("slow.Compile() returned error: %v", err)
+	}
+	prg, err = slow.Program(ast)
+	if err != nil {
+		t.Fatalf("slow.Program() returned error: %v", err)
+	}
+	if _, _, err := prg.Eval(vars); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("prg.Eval() got error %v, wanted interrupted error", err)
+	}
+
+	if _, err := cloudarmor.NewRules(cloudarmor.UntrustedModeWithLimits(cloudarmor.UntrustedLimits{})); err == nil {
+		t.Error("cloudarmor.NewRules() with zero untrusted limits succeeded, wanted error")
+	}
+}
+
+func TestNetworkProfile(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Profile(cloudarmor.ProfileNetwork))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	vars, err := cloudarmor.VariablesFromYAML([]byte(`
+origin:
+  ip: 203.0.113.7
+connection:
+  src_port: 40000
+  dst_ip: 192.0.2.10
+  dst_port: 53
+  protocol: UDP
+  bytes: 1400
+`))
+	if err != nil {
+		t.Fatalf("cloudarmor.VariablesFromYAML() failed: %v", err)
+	}
+	for expr, want := range map[string]bool{
+		"connection.protocol == 'UDP' && connection.dst_port == 53 && connection.bytes > 512": true,
+		"inIpRange(connection.dst_ip, '192.0.2.0/24') && connection.src_port >= 1024":         true,
+		"inIpRange(origin.ip, '10.0.0.0/8')":                                                  false,
+	} {
+		ast, err := rules.Compile(expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) failed: %v", expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) failed: %v", expr, err)
+		}
+		if out, _, err := prg.Eval(vars); err != nil || out != types.Bool(want) {
+			t.Errorf("prg.Eval(%q) = %v, %v, wanted %v", expr, out, err, want)
+		}
+	}
+	for _, expr := range []string{
+		"request.method == 'GET'",
+		"connection.protocol.lower() == 'udp'",
+		"token.recaptcha_action.valid",
+	} {
+		if _, err := rules.Compile(expr); err == nil {
+			t.Errorf("rules.Compile(%q) succeeded for a network policy, wanted error", expr)
+		}
+	}
+	if _, err := cloudarmor.NewRules(cloudarmor.Profile(cloudarmor.ProfileNetwork), cloudarmor.Version(cloudarmor.VNext)); err == nil {
+		t.Error("cloudarmor.NewRules() with a VNext network profile succeeded, wanted error")
+	}
+	if p, err := cloudarmor.ParsePolicyProfile("network"); err != nil || p != cloudarmor.ProfileNetwork {
+		t.Errorf("cloudarmor.ParsePolicyProfile(network) = %v, %v", p, err)
+	}
+
+	vars.Connection.DstPort = 70000
+	vars.Connection.Protocol = "udp"
+	vs := vars.Validate()
+	if len(vs) != 2 || vs[0].Attribute != "connection.dst_port" || vs[1].Attribute != "connection.protocol" {
+		t.Errorf("vars.Validate() = %v, wanted dst_port and protocol violations", vs)
+	}
+}
+
+func TestRegisterConfig(t *testing.T) {
+	builtin := []cloudarmor.ConfigKey{
+		{Profile: cloudarmor.ProfileHTTP, Version: cloudarmor.VCurrent},
+		{Profile: cloudarmor.ProfileHTTP, Version: cloudarmor.VNext},
+		{Profile: cloudarmor.ProfileNetwork, Version: cloudarmor.VCurrent},
+		{Profile: cloudarmor.ProfileResponse, Version: cloudarmor.VNext},
+	}
+	if got := cloudarmor.Configs(); !reflect.DeepEqual(got[:len(builtin)], builtin) {
+		t.Errorf("cloudarmor.Configs() = %v, wanted to start with %v", got, builtin)
+	}
+
+	regional := cloudarmor.PolicyProfile(100)
+	config := `
+name: cloud-armor-regional-v1
+stdlib:
+  disable_macros: true
+  include_functions:
+    - name: _&&_
+variables:
+  - name: request.method
+    type_name: string
+  - name: origin.region_code
+    type_name: string
+`
+	if err := cloudarmor.RegisterConfig(regional, cloudarmor.VCurrent, config); err != nil {
+		t.Fatalf("cloudarmor.RegisterConfig() failed: %v", err)
+	}
+	if err := cloudarmor.RegisterConfig(regional, cloudarmor.VCurrent, config); err == nil {
+		t.Error("cloudarmor.RegisterConfig() of a registered config succeeded, wanted error")
+	}
+	if err := cloudarmor.RegisterConfig(regional, cloudarmor.VNext, "variables: [}"); err == nil {
+		t.Error("cloudarmor.RegisterConfig() of invalid YAML succeeded, wanted error")
+	}
+	if got := cloudarmor.Configs(); got[len(got)-1] != (cloudarmor.ConfigKey{Profile: regional, Version: cloudarmor.VCurrent}) {
+		t.Errorf("cloudarmor.Configs() = %v, wanted the registered config last", got)
+	}
+
+	rules, err := cloudarmor.NewRules(cloudarmor.Profile(regional))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	if _, err := rules.Compile("request.method == 'GET' && origin.region_code.lower() == 'us'"); err != nil {
+		t.Errorf("rules.Compile() failed: %v", err)
+	}
+	if _, err := rules.Compile("request.path == '/'"); err == nil {
+		t.Error("rules.Compile() of an undeclared attribute succeeded, wanted error")
+	}
+	if _, err := cloudarmor.NewRules(cloudarmor.Profile(regional), cloudarmor.Version(cloudarmor.VNext)); err == nil {
+		t.Error("cloudarmor.NewRules() of an unregistered version succeeded, wanted error")
+	}
+}
+
+func TestResponseProfile(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Profile(cloudarmor.ProfileResponse), cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	vars, err := cloudarmor.VariablesFromYAML([]byte(`
+request:
+  path: /login
+response:
+  status_code: 401
+  headers:
+    Content-Type: text/html
+`))
+	if err != nil {
+		t.Fatalf("cloudarmor.VariablesFromYAML() failed: %v", err)
+	}
+	expr := "request.path == '/login' && response.status_code == 401 && response.headers['content-type'].startsWith('text/')"
+	ast, err := rules.Compile(expr)
+	if err != nil {
+		t.Fatalf("rules.Compile(%q) failed: %v", expr, err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() failed: %v", err)
+	}
+	if out, _, err := prg.Eval(vars); err != nil || out != types.True {
+		t.Errorf("prg.Eval() = %v, %v, wanted true", out, err)
+	}
+	// The response profile extends the VNext request attributes.
+	if _, err := rules.Compile("request.body.contains('x') && response.status_code >= 500"); err != nil {
+		t.Errorf("rules.Compile() of a VNext request attribute failed: %v", err)
+	}
+
+	http, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	if _, err := http.Compile(expr); err == nil {
+		t.Error("rules.Compile() of a response attribute in the http profile succeeded, wanted error")
+	}
+	if _, err := cloudarmor.NewRules(cloudarmor.Profile(cloudarmor.ProfileResponse)); err == nil {
+		t.Error("cloudarmor.NewRules() with a VCurrent response profile succeeded, wanted error")
+	}
+}
+
+func TestGraph(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := r.Compile("request.path.lower() == 'a\"b' && origin.asn > 10")
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{
+			format: "dot",
+			want: []string{
+				`digraph "rule" {`,
+				`[label="&&\nbool\ncost 3..5"];`,
+				`[label=".lower()\nstring\ncost 2"];`,
+				`[label="request.path\nstring\ncost 1"];`,
+				`[label="\"a\\\"b\"\nstring\ncost 0"];`,
+				`[label="origin.asn\nint\ncost 1"];`,
+			},
+		},
+		{
+			format: "mermaid",
+			want: []string{
+				"title: rule\n---\nflowchart TD\n",
+				`["#gt;<br/>bool<br/>cost 2"]`,
+				`["#quot;a\#quot;b#quot;<br/>string<br/>cost 0"]`,
+			},
+		},
+	}
+	for _, tst := range tests {
+		format, err := cloudarmor.ParseGraphFormat(tst.format)
+		if err != nil {
+			t.Fatalf("cloudarmor.ParseGraphFormat(%q) returned error: %v", tst.format, err)
+		}
+		g, err := r.Graph(ast, "rule", format)
+		if err != nil {
+			t.Fatalf("r.Graph() returned error: %v", err)
+		}
+		for _, want := range tst.want {
+			if !strings.Contains(g, want) {
+				t.Errorf("r.Graph(%s) = %s, wanted it to contain %s", tst.format, g, want)
+			}
+		}
+		// Every node but the root has a single incoming edge.
+		if edges := strings.Count(g, "->"); edges != 7 {
+			t.Errorf("r.Graph(%s) has %d edges, want 7", tst.format, edges)
+		}
+	}
+	if _, err := cloudarmor.ParseGraphFormat("svg"); err == nil {
+		t.Error("cloudarmor.ParseGraphFormat(svg) succeeded, wanted error")
+	}
+}
+
+func TestUnparse(t *testing.T) {
+	tests := []struct {
+		expr  string
+		opts  []cloudarmor.RulesOption
+		style string
+		want  string
+	}{
+		{
+			expr: "has(request.headers['x-token']) && request.path.lower().urlDecode().contains('/admin')",
+			want: `has(request.headers["x-token"]) && request.path.lower().urlDecode().contains("/admin")`,
+		},
+		{
+			expr: "has(request.headers['token']) || origin.region_code == 'AU'",
+			want: `has(request.headers.token) || origin.region_code == "AU"`,
+		},
+		{
+			expr: "has(request.method) && request.method != 'GET'",
+			opts: []cloudarmor.RulesOption{cloudarmor.Presence(cloudarmor.PresenceAbsent)},
+			want: `has(request.method) && request.method != "GET"`,
+		},
+		{
+			expr:  "has(request.headers.token) && has(request.headers['x-token'])",
+			style: "index",
+			want:  `has(request.headers["token"]) && has(request.headers["x-token"])`,
+		},
+		{
+			expr:  "has(request.method)",
+			opts:  []cloudarmor.RulesOption{cloudarmor.Presence(cloudarmor.PresenceAbsent)},
+			style: "index",
+			want:  `has(request.method)`,
+		},
+	}
+	for _, tst := range tests {
+		r, err := cloudarmor.NewRules(tst.opts...)
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+		}
+		ast, err := r.Compile(tst.expr)
+		if err != nil {
+			t.Fatalf("r.Compile(%q) returned error: %v", tst.expr, err)
+		}
+		// Round trip through the CheckedExpr form written by -output_format=binarypb.
+		checked, err := cel.AstToCheckedExpr(ast)
+		if err != nil {
+			t.Fatalf("cel.AstToCheckedExpr() returned error: %v", err)
+		}
+		var unparseOpts []cloudarmor.UnparseOption
+		if tst.style != "" {
+			style, err := cloudarmor.ParsePresenceStyle(tst.style)
+			if err != nil {
+				t.Fatalf("cloudarmor.ParsePresenceStyle(%q) returned error: %v", tst.style, err)
+			}
+			unparseOpts = append(unparseOpts, cloudarmor.UnparsePresenceStyle(style))
+		}
+		got, err := cloudarmor.Unparse(cel.CheckedExprToAst(checked), unparseOpts...)
+		if err != nil {
+			t.Fatalf("cloudarmor.Unparse(%q) returned error: %v", tst.expr, err)
+		}
+		if got != tst.want {
+			t.Errorf("cloudarmor.Unparse(%q) = %s, want %s", tst.expr, got, tst.want)
+		}
+		if _, err := r.Compile(got); err != nil {
+			t.Errorf("r.Compile(%q) returned error: %v", got, err)
+		}
+	}
+	if _, err := cloudarmor.ParsePresenceStyle("bracket"); err == nil {
+		t.Error("cloudarmor.ParsePresenceStyle(bracket) succeeded, wanted error")
+	}
+}
+
+func TestParseRuleFile(t *testing.T) {
+	content := `// Block admin scanners.
+// added during incident 2024-11-02
+request.path.startsWith('/admin;x'); // trailing note
+
+// detached comment
+
+// Legacy header check; see runbook
+has(request.headers['x-token']) &&
+  request.headers['x-token'] == "a\";" // inline
+;
+origin.region_code == 'AU';
+`
+	want := []cloudarmor.RuleFileEntry{
+		{
+			Expr:     `request.path.startsWith('/admin;x')`,
+			Comments: []string{"Block admin scanners.", "added during incident 2024-11-02"},
+			Line:     3,
+		},
+		{
+			Expr:     "has(request.headers['x-token']) &&\n  request.headers['x-token'] == \"a\\\";\" // inline",
+			Comments: []string{"Legacy header check; see runbook"},
+			Line:     8,
+		},
+		{
+			Expr: "origin.region_code == 'AU'",
+			Line: 11,
+		},
+	}
+	got := cloudarmor.ParseRuleFile(content)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cloudarmor.ParseRuleFile() = %#v, want %#v", got, want)
+	}
+}
+
+func TestCompileAll(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	asts, errs := r.CompileAll(map[string]string{
+		"admin":   "request.path.startsWith('/admin')",
+		"typo":    "request.pth == '/'",
+		"country": "origin.region_code == 'AU'",
+		"int":     "origin.asn",
+	})
+	if got, want := cloudarmor.SortedNames(asts), []string{"admin", "country"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("r.CompileAll() compiled %v, want %v", got, want)
+	}
+	if got, want := cloudarmor.SortedNames(errs), []string{"int", "typo"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("r.CompileAll() failed %v, want %v", got, want)
+	}
+	joined := cloudarmor.JoinErrors(errs).Error()
+	if !strings.HasPrefix(joined, `rule "int": `) || !strings.Contains(joined, "\nrule \"typo\": ") {
+		t.Errorf("cloudarmor.JoinErrors() = %q, want the int and typo rules in order", joined)
+	}
+	if err := cloudarmor.JoinErrors(nil); err != nil {
+		t.Errorf("cloudarmor.JoinErrors(nil) = %v, want nil", err)
+	}
+}
+
+func TestFootprint(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := r.Compile("request.path.matches('^/a+$') && request.method == 'GET'")
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	f := cloudarmor.EstimateFootprint(ast)
+	want := cloudarmor.Footprint{Rules: 1, Nodes: 7, LiteralBytes: 8, Regexes: 1}
+	if f.AutomatonInsts == 0 {
+		t.Errorf("cloudarmor.EstimateFootprint() counted no automaton instructions for a matches() pattern")
+	}
+	want.AutomatonInsts = f.AutomatonInsts
+	if *f != want {
+		t.Errorf("cloudarmor.EstimateFootprint() = %+v, want %+v", *f, want)
+	}
+	if f.Bytes() <= f.Nodes {
+		t.Errorf("f.Bytes() = %d, want more than one byte per node", f.Bytes())
+	}
+
+	collection := &cloudarmor.VendorRulesetCollection{
+		RuleSets: []*cloudarmor.VendorRuleSet{{
+			Name: "lfi",
+			Rules: []*cloudarmor.VendorRuleSet_VendorRule{
+				{Id: "1", CelExpression: "request.path.matches('^/a+$') && request.method == 'GET'"},
+				{Id: "2", CelExpression: "request.path.contains('/etc/passwd')"},
+			},
+		}},
+	}
+	total, err := r.VendorRulesetFootprint(collection)
+	if err != nil {
+		t.Fatalf("r.VendorRulesetFootprint() returned error: %v", err)
+	}
+	if total.Rules != 2 || total.Regexes != 1 || total.Nodes != f.Nodes+3 {
+		t.Errorf("r.VendorRulesetFootprint() = %+v, wanted 2 rules with 1 regex and %d nodes", *total, f.Nodes+3)
+	}
+	collection.RuleSets[0].Rules[1].CelExpression = "request.pth == '/'"
+	if _, err := r.VendorRulesetFootprint(collection); err == nil || !strings.Contains(err.Error(), `rule "lfi/2"`) {
+		t.Errorf("r.VendorRulesetFootprint() returned error %v, wanted a compile error for lfi/2", err)
+	}
+}
+
+func TestChangesBetween(t *testing.T) {
+	changes, err := cloudarmor.ChangesBetween(cloudarmor.VCurrent, cloudarmor.VNext)
+	if err != nil {
+		t.Fatalf("cloudarmor.ChangesBetween() returned error: %v", err)
+	}
+	var lines []string
+	for _, c := range changes {
+		if c.Kind != cloudarmor.ChangeAdded {
+			t.Errorf("cloudarmor.ChangesBetween(VCurrent, VNext) reported %v, wanted only additions", c)
+		}
+		lines = append(lines, c.String())
+	}
+	got := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"+ attribute request.body (string)",
+		"+ attribute request.params (map(string, dyn))",
+		"+ function inRange (inRange_double_double_double, inRange_int64_int64_int64)",
+		"+ feature cel.feature.backtick_escape_syntax (enabled: true)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("cloudarmor.ChangesBetween(VCurrent, VNext) = %s, wanted it to contain %s", got, want)
+		}
+	}
+	if strings.Index(got, "+ attribute") > strings.Index(got, "+ function") {
+		t.Errorf("cloudarmor.ChangesBetween(VCurrent, VNext) = %s, wanted attributes before functions", got)
+	}
+
+	reverse, err := cloudarmor.ChangesBetween(cloudarmor.VNext, cloudarmor.VCurrent)
+	if err != nil {
+		t.Fatalf("cloudarmor.ChangesBetween() returned error: %v", err)
+	}
+	if len(reverse) != len(changes) || reverse[0].Kind != cloudarmor.ChangeRemoved {
+		t.Errorf("cloudarmor.ChangesBetween(VNext, VCurrent) = %v, wanted the additions reported as removals", reverse)
+	}
+	if same, err := cloudarmor.ChangesBetween(cloudarmor.VCurrent, cloudarmor.VCurrent); err != nil || len(same) != 0 {
+		t.Errorf("cloudarmor.ChangesBetween(VCurrent, VCurrent) = %v, %v, wanted no changes", same, err)
+	}
+	if _, err := cloudarmor.ChangesBetween(cloudarmor.VCurrent, 99); err == nil {
+		t.Error("cloudarmor.ChangesBetween(VCurrent, 99) succeeded, wanted error")
+	}
+}
+
+func TestWithFeature(t *testing.T) {
+	r, err := cloudarmor.NewRules(cloudarmor.WithFeature(cloudarmor.FeatureRequestBody), cloudarmor.WithFeature(cloudarmor.FeatureNumericRanges))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := r.Compile("request.body.contains('union select') && inRange(origin.asn, 1, 100)")
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Body: "id=1 union select"},
+		Origin:  &cloudarmor.Origin{ASN: 15},
+	})
+	if out, _, err := prg.Eval(vars); err != nil || out != types.True {
+		t.Errorf("prg.Eval() = %v, %v, want true", out, err)
+	}
+	for _, expr := range []string{"has(request.params.id)", "request.backend_service == ''", "cel.bind(x, 1, x == 1)"} {
+		if _, err := r.Compile(expr); err == nil {
+			t.Errorf("r.Compile(%q) succeeded, wanted an error for a feature which is not enabled", expr)
+		}
+	}
+
+	if _, err := cloudarmor.NewRules(cloudarmor.WithFeature("request_headers")); err == nil {
+		t.Error("cloudarmor.NewRules(WithFeature(request_headers)) succeeded, wanted error")
+	}
+	if _, err := cloudarmor.NewRules(cloudarmor.Profile(cloudarmor.ProfileNetwork), cloudarmor.WithFeature(cloudarmor.FeatureParams)); err == nil {
+		t.Error("cloudarmor.NewRules(ProfileNetwork, WithFeature(params)) succeeded, wanted error")
+	}
+	next, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext), cloudarmor.WithFeature(cloudarmor.FeatureParams))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules(VNext, WithFeature(params)) returned error: %v", err)
+	}
+	if _, err := next.Compile("has(request.params.id) && request.body == ''"); err != nil {
+		t.Errorf("next.Compile() returned error: %v", err)
+	}
+	if f, err := cloudarmor.ParseFeature("params"); err != nil || f != cloudarmor.FeatureParams {
+		t.Errorf("cloudarmor.ParseFeature(params) = %v, %v, want FeatureParams", f, err)
+	}
+	if _, err := cloudarmor.ParseFeature("body"); err == nil || !strings.Contains(err.Error(), "request_body") {
+		t.Errorf("cloudarmor.ParseFeature(body) returned error %v, wanted the list of features", err)
+	}
+}
+
+func TestEnvFingerprint(t *testing.T) {
+	fingerprint := func(opts ...cloudarmor.RulesOption) string {
+		t.Helper()
+		r, err := cloudarmor.NewRules(opts...)
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+		}
+		return r.EnvFingerprint()
+	}
+	base := fingerprint()
+	if len(base) != 64 {
+		t.Errorf("r.EnvFingerprint() = %q, want a hex-encoded sha256", base)
+	}
+	if got := fingerprint(cloudarmor.TestTags("smoke")); got != base {
+		t.Errorf("r.EnvFingerprint() with TestTags = %s, want %s", got, base)
+	}
+	seen := map[string]string{base: "default"}
+	for name, opt := range map[string]cloudarmor.RulesOption{
+		"VNext":           cloudarmor.Version(cloudarmor.VNext),
+		"ProfileNetwork":  cloudarmor.Profile(cloudarmor.ProfileNetwork),
+		"WithUnknowns":    cloudarmor.WithUnknowns(),
+		"PresenceAbsent":  cloudarmor.Presence(cloudarmor.PresenceAbsent),
+		"DisableOperator": cloudarmor.DisableOperators("?:"),
+		"WithFeature":     cloudarmor.WithFeature(cloudarmor.FeatureRequestBody),
+	} {
+		got := fingerprint(opt)
+		if prev, found := seen[got]; found {
+			t.Errorf("r.EnvFingerprint() with %s = %s, the same as with %s", name, got, prev)
+		}
+		seen[got] = name
+	}
+}
+
+func TestWithLimits(t *testing.T) {
+	limits := cloudarmor.DefaultLimits()
+	limits.MaxExpressionLength = 64
+	limits.MaxStringSize = 16
+	limits.MaxBodySize = 8
+	r, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext), cloudarmor.WithLimits(limits))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := r.Compile("request.path == '" + strings.Repeat("a", 64) + "'"); err == nil || !strings.Contains(err.Error(), "exceeds the maximum of 64") {
+		t.Errorf("r.Compile() of a long expression got error %v, wanted length error", err)
+	}
+	tests := []struct {
+		expr    string
+		body    string
+		want    bool
+		wantErr string
+	}{
+		{expr: "request.body.contains('attack')", body: "xxattack", want: true},
+		{expr: "request.body.contains('attack')", body: "xxxxattack", want: false},
+		{expr: "request.body == 'abcdefgh'", body: "abcdefghijkl", want: true},
+		{expr: "(request.body + request.body + request.body).size() > 0", body: "abcdefgh", wantErr: "exceeding the maximum of 16"},
+	}
+	for _, tst := range tests {
+		ast, err := r.Compile(tst.expr)
+		if err != nil {
+			t.Fatalf("r.Compile(%q) returned error: %v", tst.expr, err)
+		}
+		prg, err := r.Program(ast)
+		if err != nil {
+			t.Fatalf("r.Program() returned error: %v", err)
+		}
+		out, _, err := prg.Eval(cloudarmor.SafeVariables(&cloudarmor.Variables{Request: &cloudarmor.Request{Body: tst.body}}))
+		if tst.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("prg.Eval(%q) got error %v, wanted error containing %q", tst.expr, err, tst.wantErr)
+			}
+			continue
+		}
+		if err != nil || out != types.Bool(tst.want) {
+			t.Errorf("prg.Eval(%q) with body %q = %v, %v, want %t", tst.expr, tst.body, out, err, tst.want)
+		}
+	}
+	if _, err := cloudarmor.NewRules(cloudarmor.WithLimits(cloudarmor.Limits{})); err == nil || !strings.Contains(err.Error(), "invalid limits") {
+		t.Errorf("cloudarmor.NewRules() with zero limits got error %v, wanted invalid limits error", err)
+	}
+}
+
+func TestWithLimitsAndVariableSemantics(t *testing.T) {
+	limits := cloudarmor.DefaultLimits()
+	limits.MaxBodySize = 8
+	unset, err := cloudarmor.VariablesFromYAML([]byte("request:\n  body: abcdefghijkl\n"))
+	if err != nil {
+		t.Fatalf("cloudarmor.VariablesFromYAML() returned error: %v", err)
+	}
+	tests := []struct {
+		name    string
+		opts    []cloudarmor.RulesOption
+		expr    string
+		vars    *cloudarmor.Variables
+		unknown bool
+		want    ref.Val
+		wantErr string
+	}{
+		{
+			name:    "unknowns",
+			opts:    []cloudarmor.RulesOption{cloudarmor.WithUnknowns(), cloudarmor.WithLimits(cloudarmor.DefaultLimits())},
+			expr:    "token.recaptcha_action.valid",
+			vars:    cloudarmor.SafeVariables(&cloudarmor.Variables{}),
+			unknown: true,
+		},
+		{
+			name: "unknowns truncate body",
+			opts: []cloudarmor.RulesOption{cloudarmor.Version(cloudarmor.VNext), cloudarmor.WithUnknowns(), cloudarmor.WithLimits(limits)},
+			expr: "request.body == 'abcdefgh'",
+			vars: cloudarmor.SafeVariables(&cloudarmor.Variables{Request: &cloudarmor.Request{Body: "abcdefghijkl"}}),
+			want: types.True,
+		},
+		{
+			name: "presence",
+			opts: []cloudarmor.RulesOption{cloudarmor.Version(cloudarmor.VNext), cloudarmor.Presence(cloudarmor.PresenceAbsent), cloudarmor.WithLimits(limits)},
+			expr: "!has(request.method) && request.body == 'abcdefgh'",
+			vars: unset,
+			want: types.True,
+		},
+		{
+			name:    "presence hides unset",
+			opts:    []cloudarmor.RulesOption{cloudarmor.Version(cloudarmor.VNext), cloudarmor.Presence(cloudarmor.PresenceAbsent), cloudarmor.WithLimits(limits)},
+			expr:    "request.method == ''",
+			vars:    unset,
+			wantErr: "no such attribute",
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			r, err := cloudarmor.NewRules(tst.opts...)
+			if err != nil {
+				t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+			}
+			ast, err := r.Compile(tst.expr)
+			if err != nil {
+				t.Fatalf("r.Compile(%q) returned error: %v", tst.expr, err)
+			}
+			prg, err := r.Program(ast)
+			if err != nil {
+				t.Fatalf("r.Program() returned error: %v", err)
+			}
+			out, _, err := prg.Eval(tst.vars)
+			switch {
+			case tst.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+					t.Errorf("prg.Eval(%q) returned %v, %v, wanted error containing %q", tst.expr, out, err, tst.wantErr)
+				}
+			case err != nil:
+				t.Errorf("prg.Eval(%q) returned error: %v", tst.expr, err)
+			case tst.unknown:
+				if !types.IsUnknown(out) {
+					t.Errorf("prg.Eval(%q) = %v, wanted unknown", tst.expr, out)
+				}
+			case out != tst.want:
+				t.Errorf("prg.Eval(%q) = %v, wanted %v", tst.expr, out, tst.want)
+			}
+		})
+	}
+}
+
+func TestSymbolHints(t *testing.T) {
+	tests := []struct {
+		opts []cloudarmor.RulesOption
+		expr string
+		want []string
+	}{
+		{
+			expr: "request.body.contains('x') && request.method == 'GET'",
+			want: []string{"request.body requires VNext or the request_body feature"},
+		},
+		{
+			expr: "inRange(origin.asn, 1, 100) || request.params.id == 'a'",
+			want: []string{"inRange requires VNext or the numeric_ranges feature", "request.params requires VNext or the params feature"},
+		},
+		{
+			opts: []cloudarmor.RulesOption{cloudarmor.Version(cloudarmor.VNext)},
+			expr: "response.status_code >= 500",
+			want: []string{"response.status_code requires the response profile"},
+		},
+		{
+			opts: []cloudarmor.RulesOption{cloudarmor.Profile(cloudarmor.ProfileNetwork)},
+			expr: "request.path == '/'",
+			want: []string{"request.path requires the http profile"},
+		},
+	}
+	for _, tst := range tests {
+		r, err := cloudarmor.NewRules(tst.opts...)
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+		}
+		_, err = r.Compile(tst.expr)
+		var compileErr *cloudarmor.CompileError
+		if !errors.As(err, &compileErr) {
+			t.Errorf("r.Compile(%q) returned error %v, wanted a CompileError", tst.expr, err)
+			continue
+		}
+		var got []string
+		for _, h := range compileErr.Hints {
+			got = append(got, h.String())
+		}
+		if strings.Join(got, "; ") != strings.Join(tst.want, "; ") {
+			t.Errorf("r.Compile(%q) returned hints %q, want %q", tst.expr, got, tst.want)
+		}
+		if !strings.Contains(err.Error(), tst.want[0]) {
+			t.Errorf("r.Compile(%q) returned error %v, wanted it to contain %q", tst.expr, err, tst.want[0])
+		}
+	}
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	var compileErr *cloudarmor.CompileError
+	if _, err := r.Compile("request.path.nosuchfunction()"); err == nil || errors.As(err, &compileErr) {
+		t.Errorf("r.Compile() of an unknown function returned error %v, wanted an error without hints", err)
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "origin.regioncode == 'US'", want: "did you mean 'origin.region_code'?"},
+		{expr: "request.mthod.lower() == 'get'", want: "did you mean 'request.method'?"},
+		{expr: "orgin.ip == '10.0.0.1'", want: "did you mean 'origin.ip'?"},
+		{expr: "request.path.lowr() == '/'", want: "did you mean 'lower'?"},
+		{expr: "inIPRange(origin.ip, '10.0.0.0/8')", want: "did you mean 'inIpRange'?"},
+	}
+	for _, tst := range tests {
+		if _, err := r.Compile(tst.expr); err == nil || !strings.Contains(err.Error(), tst.want) {
+			t.Errorf("r.Compile(%q) got error %v, wanted error containing %q", tst.expr, err, tst.want)
+		}
+	}
+	if _, err := r.Compile("request.path.nosuchfunction()"); err == nil || strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("r.Compile() of an unrelated function got error %v, wanted no suggestion", err)
+	}
+}
+
+func TestWithLocalizer(t *testing.T) {
+	localizer := cloudarmor.CatalogLocalizer(map[cloudarmor.MessageID]string{
+		cloudarmor.MessageSymbolRequiresVersionOrFeature: "%[1]s erfordert %[2]s oder das Feature %[3]s",
+		cloudarmor.MessageUnexpectedResult:               "Ergebnis %[2]v, erwartet %[1]v",
+	})
+	r, err := cloudarmor.NewRules(cloudarmor.WithLocalizer(localizer))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := r.Compile("request.body == ''"); err == nil || !strings.Contains(err.Error(), "request.body erfordert VNext oder das Feature request_body") {
+		t.Errorf("r.Compile() got error %v, wanted localized hint", err)
+	}
+	if _, err := r.Compile("origin.regioncode == 'US'"); err == nil || !strings.Contains(err.Error(), "did you mean 'origin.region_code'?") {
+		t.Errorf("r.Compile() got error %v, wanted untranslated message in English", err)
+	}
+	ast, err := r.Compile("request.method == 'GET'")
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	tc := &cloudarmor.TestCase{Name: "get", When: cloudarmor.SafeVariables(&cloudarmor.Variables{}), ExpectOutput: true}
+	statuses := r.RunRuleValidation(prg, []*cloudarmor.TestCase{tc})
+	if len(statuses) != 1 || statuses[0].Fail != "Ergebnis false, erwartet true" {
+		t.Errorf("r.RunRuleValidation() = %+v, wanted localized failure", statuses)
+	}
+	for id, format := range cloudarmor.MessageCatalog() {
+		if format == "" {
+			t.Errorf("cloudarmor.MessageCatalog()[%q] is empty", id)
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	r, err := cloudarmor.NewRules(cloudarmor.RedactAttributes("origin.ip", "request.headers['X-Session']"))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	for attr, want := range map[string]bool{
+		"request.headers['authorization']": true,
+		"request.headers['Cookie']":        true,
+		"request.headers['x-session']":     true,
+		"origin.ip":                        true,
+		"request.path":                     false,
+		"request.headers['user-agent']":    false,
+	} {
+		if got := r.Sensitive(attr); got != want {
+			t.Errorf("r.Sensitive(%q) = %t, want %t", attr, got, want)
+		}
+	}
+	ast, err := r.Compile("inIpRange(origin.ip, '10.0.0.0/8') && request.headers['authorization'] != ''")
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	tc := &cloudarmor.TestCase{Name: "bad-ip", When: cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Headers: map[string]string{"authorization": "Bearer abc"}},
+		Origin:  &cloudarmor.Origin{IP: "internal-host"},
+	})}
+	statuses := r.RunRuleValidation(prg, []*cloudarmor.TestCase{tc})
+	if len(statuses) != 1 || statuses[0].Fail != "invalid IP address: [REDACTED]" {
+		t.Errorf("r.RunRuleValidation() = %+v, wanted the IP address redacted", statuses)
+	}
+	if got := r.RedactMessage("got Bearer abc from internal-host", tc.When); got != "got [REDACTED] from [REDACTED]" {
+		t.Errorf("r.RedactMessage() = %q, wanted both values redacted", got)
+	}
+
+	allowed, err := cloudarmor.NewRules(cloudarmor.AllowAttributes("request.headers['authorization']"))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if got := allowed.Redact("request.headers['authorization']", "Bearer abc"); got != "Bearer abc" {
+		t.Errorf("allowed.Redact() = %q, wanted the allowed header unredacted", got)
+	}
+	if _, err := cloudarmor.NewRules(cloudarmor.RedactAttributes("origin.ipaddr")); err == nil {
+		t.Error("cloudarmor.NewRules() with an unknown attribute to redact succeeded, wanted error")
+	}
+}
+
+func TestExplainFailures(t *testing.T) {
+	expr := "request.path.startsWith('/admin') && (request.headers['authorization'] == '' || origin.region_code == 'CN')"
+	r, err := cloudarmor.NewRules(cloudarmor.ExplainFailures())
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := r.Compile(expr)
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	when := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Path: "/admin/users", Headers: map[string]string{"authorization": "Bearer abc"}},
+		Origin:  &cloudarmor.Origin{RegionCode: "US"},
+	})
+	statuses := r.RunRuleValidation(prg, []*cloudarmor.TestCase{
+		{Name: "fails", When: when, ExpectOutput: true},
+		{Name: "passes", When: when, ExpectOutput: false},
+		{Name: "short-circuit", When: cloudarmor.SafeVariables(&cloudarmor.Variables{}), ExpectOutput: true},
+	})
+	want := []cloudarmor.TraceStep{
+		{Expr: `request.path.startsWith("/admin")`, Value: "true"},
+		{Expr: "request.path", Value: `"/admin/users"`},
+		{Expr: `request.headers["authorization"] == "" || origin.region_code == "CN"`, Value: "false"},
+		{Expr: `request.headers["authorization"] == ""`, Value: "false"},
+		{Expr: `request.headers["authorization"]`, Value: `"[REDACTED]"`},
+		{Expr: `origin.region_code == "CN"`, Value: "false"},
+		{Expr: "origin.region_code", Value: `"US"`},
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("r.RunRuleValidation() returned %d statuses, wanted 3", len(statuses))
+	}
+	if !reflect.DeepEqual(statuses[0].Trace, want) {
+		t.Errorf("statuses[0].Trace = %v, wanted %v", statuses[0].Trace, want)
+	}
+	if statuses[1].Trace != nil {
+		t.Errorf("statuses[1].Trace = %v, wanted no trace for a passing test case", statuses[1].Trace)
+	}
+	want = []cloudarmor.TraceStep{
+		{Expr: `request.path.startsWith("/admin")`, Value: "false"},
+		{Expr: "request.path", Value: `""`},
+	}
+	if !reflect.DeepEqual(statuses[2].Trace, want) {
+		t.Errorf("statuses[2].Trace = %v, wanted %v", statuses[2].Trace, want)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	compile := func(r *cloudarmor.Rules, expr string, prgOpts ...cel.ProgramOption) cel.Program {
+		t.Helper()
+		a, err := r.Compile(expr)
+		if err != nil {
+			t.Fatalf("r.Compile(%q) returned error: %v", expr, err)
+		}
+		prg, err := r.Program(a, prgOpts...)
+		if err != nil {
+			t.Fatalf("r.Program(%q) returned error: %v", expr, err)
+		}
+		return prg
+	}
+	// Hostile inputs which the caller failed to build with SafeVariables.
+	hostile := []any{
+		(*cloudarmor.Variables)(nil),
+		&cloudarmor.Variables{},
+		&cloudarmor.Variables{Request: &cloudarmor.Request{}, Token: &cloudarmor.Token{}},
+		map[string]any{"request": 42, "origin": map[string]any{"ip": 42}},
+		nil,
+		42,
+	}
+	for _, opt := range []cloudarmor.RulesOption{cloudarmor.Version(cloudarmor.VNext), cloudarmor.Presence(cloudarmor.PresenceAbsent), cloudarmor.WithUnknowns()} {
+		r, err := cloudarmor.NewRules(opt)
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+		}
+		prg := compile(r, "request.path.lower() == '/a' || inIpRange(origin.ip, '10.0.0.0/8') || token.recaptcha_action.score > 0.5")
+		for _, in := range hostile {
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						t.Errorf("prg.Eval(%#v) panicked: %v", in, p)
+					}
+				}()
+				prg.Eval(in)
+			}()
+		}
+	}
+	r, err := cloudarmor.NewRules(cloudarmor.Presence(cloudarmor.PresenceAbsent))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	_, _, err = compile(r, "request.path == '/'").Eval((*cloudarmor.Variables)(nil))
+	if code := cloudarmor.ErrorCodeOf(err); code != cloudarmor.ErrorInternal {
+		t.Errorf("prg.Eval(nil) returned error %v with code %q, wanted %q", err, code, cloudarmor.ErrorInternal)
+	}
+
+	// A binding which panics, as simulated by a decorator, fails only its own call.
+	panicking := cel.CustomDecorator(func(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+		if call, ok := i.(interpreter.InterpretableCall); ok && call.Function() == "lower" {
+			return &panicCall{call}, nil
+		}
+		return i, nil
+	})
+	for _, debug := range []bool{false, true} {
+		opts := []cloudarmor.RulesOption{}
+		if debug {
+			opts = append(opts, cloudarmor.Debug())
+		}
+		r, err := cloudarmor.NewRules(opts...)
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+		}
+		vars := cloudarmor.SafeVariables(&cloudarmor.Variables{Request: &cloudarmor.Request{Path: "/Admin"}})
+		out, _, err := compile(r, "request.path.lower() == '/admin' || request.path == '/Admin'", panicking).Eval(vars)
+		if err != nil || out != types.True {
+			t.Errorf("prg.Eval() of a panicking call absorbed by || returned %v, %v, wanted true", out, err)
+		}
+		_, _, err = compile(r, "request.path.lower() == '/admin'", panicking).Eval(vars)
+		var e *cloudarmor.EvalError
+		if !errors.As(err, &e) || e.Code != cloudarmor.ErrorInternal || !strings.Contains(e.Message, "lower: internal error: hostile input") {
+			t.Fatalf("prg.Eval() of a panicking call returned error %v, wanted an %q error from lower", err, cloudarmor.ErrorInternal)
+		}
+		if hasStack := len(e.Stack) != 0; hasStack != debug {
+			t.Errorf("prg.Eval() of a panicking call with Debug %v captured a stack: %v", debug, hasStack)
+		}
+	}
+}
+
+type panicCall struct {
+	interpreter.InterpretableCall
+}
+
+func (panicCall) Eval(interpreter.Activation) ref.Val {
+	panic("hostile input")
+}
+
+func TestPunycode(t *testing.T) {
+	rules, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Headers: map[string]string{"Host": "Bücher.example:8443"}},
+	})
+	tests := []struct {
+		expr    string
+		want    ref.Val
+		wantErr string
+	}{
+		{expr: "'bücher.example'.toPunycode() == 'xn--bcher-kva.example'", want: types.True},
+		{expr: "'BÜCHER.Example'.toPunycode() == 'xn--bcher-kva.example'", want: types.True},
+		{expr: "'例え。テスト'.toPunycode() == 'xn--r8jz45g.xn--zckzah'", want: types.True},
+		{expr: "'www.example.com'.toPunycode() == 'www.example.com'", want: types.True},
+		// Decomposed characters are normalized to NFC before encoding.
+		{expr: "'bu\\u0308cher.example'.toPunycode() == 'xn--bcher-kva.example'", want: types.True},
+		{expr: "'BU\\u0308CHER.example'.toPunycode() == 'bücher.example'.toPunycode()", want: types.True},
+		{expr: "'bücher.example'.toPunycode() == 'bücher.example'", want: types.False},
+		{expr: "'xn--bcher-kva.example'.fromPunycode() == 'bücher.example'", want: types.True},
+		{expr: "'XN--R8JZ45G.xn--zckzah'.fromPunycode() == '例え.テスト'", want: types.True},
+		{expr: "'xn--ls8h.la'.fromPunycode() == '💩.la'", want: types.True},
+		{expr: "'www.example.com'.fromPunycode() == 'www.example.com'", want: types.True},
+		{expr: "'xn--bcher-kva!.example'.fromPunycode() == ''", wantErr: "invalid digit"},
+		{expr: "'xn--99999999999.example'.fromPunycode() == ''", wantErr: "overflow"},
+		// Unicode Host headers are converted to the form clients send.
+		{expr: "request.headers['host'] == 'xn--bcher-kva.example:8443'", want: types.True},
+		{expr: "request.headers['host'].startsWith('bücher.example'.toPunycode())", want: types.True},
+	}
+	for _, tst := range tests {
+		ast, err := rules.Compile(tst.expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", tst.expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", tst.expr, err)
+		}
+		out, _, err := prg.Eval(vars)
+		if tst.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) || cloudarmor.ErrorCodeOf(err) != cloudarmor.ErrorInvalidPunycode {
+				t.Errorf("prg.Eval(%q) got error %v, wanted invalid_punycode error containing %q", tst.expr, err, tst.wantErr)
+			}
+			continue
+		}
+		if err != nil || out != tst.want {
+			t.Errorf("prg.Eval(%q) = %v, %v, wanted %v", tst.expr, out, err, tst.want)
+		}
+	}
+	current, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := current.Compile("'bücher.example'.toPunycode() == 'x'"); err == nil {
+		t.Error("toPunycode() compiled in VCurrent, wanted error")
+	}
+	idn, err := cloudarmor.NewRules(cloudarmor.WithFeature(cloudarmor.FeatureIDN))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := idn.Compile("'bücher.example'.toPunycode() == 'x'"); err != nil {
+		t.Errorf("toPunycode() with the idn feature failed to compile: %v", err)
+	}
+}
+
+func TestInIpRangeForms(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := rules.Compile("inIpRange(origin.ip, request.headers['x-range'])")
+	if err != nil {
+		t.Fatalf("rules.Compile() returned error: %v", err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	// Every form of an address must be within exactly the same ranges.
+	forms := map[string][]string{
+		"ipv4":    {"1.2.3.4", "::ffff:1.2.3.4", "::FFFF:102:304", "0:0:0:0:0:ffff:0102:0304"},
+		"ipv6":    {"2001:db8::1", "2001:DB8::1", "2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8:0:0::1"},
+		"ipv4-b":  {"10.9.8.7", "::ffff:10.9.8.7"},
+		"ipv6-lo": {"::1", "0:0:0:0:0:0:0:1"},
+	}
+	ranges := map[string][]string{
+		"ipv4":    {"1.2.0.0/16", "::ffff:1.2.0.0/112", "0.0.0.0/0", "1.2.3.4/32", "1.2.3.0/24"},
+		"ipv6":    {"2001:db8::/32", "2001:0DB8::/48", "::/0", "2001:db8::1/128"},
+		"ipv4-b":  {"10.0.0.0/8", "::ffff:10.0.0.0/104", "0.0.0.0/0"},
+		"ipv6-lo": {"::1/128", "::/0"},
+	}
+	for addrFamily, addrs := range forms {
+		for rangeFamily, rs := range ranges {
+			for _, ipRange := range rs {
+				// The IPv4 and IPv6 default routes contain only addresses of their own family.
+				want := addrFamily == rangeFamily ||
+					ipRange == "0.0.0.0/0" && (addrFamily == "ipv4" || addrFamily == "ipv4-b") ||
+					ipRange == "::/0" && (addrFamily == "ipv6" || addrFamily == "ipv6-lo")
+				for _, addr := range addrs {
+					vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+						Request: &cloudarmor.Request{Headers: map[string]string{"x-range": ipRange}},
+						Origin:  &cloudarmor.Origin{IP: addr},
+					})
+					out, _, err := prg.Eval(vars)
+					if err != nil || out != types.Bool(want) {
+						t.Errorf("inIpRange(%q, %q) = %v, %v, wanted %t", addr, ipRange, out, err, want)
+					}
+				}
+			}
+		}
+	}
+	errs := []struct {
+		ip, ipRange string
+		code        cloudarmor.ErrorCode
+		wantErr     string
+	}{
+		{ip: "fe80::1%eth0", ipRange: "fe80::/10", code: cloudarmor.ErrorInvalidIP, wantErr: "zone identifiers are not supported"},
+		{ip: "fe80::1", ipRange: "fe80::%eth0/10", code: cloudarmor.ErrorInvalidIPRange, wantErr: "zone identifiers are not supported"},
+		{ip: "1.2.3", ipRange: "1.2.0.0/16", code: cloudarmor.ErrorInvalidIP, wantErr: "invalid IP address: 1.2.3"},
+		{ip: "01.2.3.4", ipRange: "1.2.0.0/16", code: cloudarmor.ErrorInvalidIP, wantErr: "invalid IP address"},
+		{ip: "2001:db8:::1", ipRange: "2001:db8::/32", code: cloudarmor.ErrorInvalidIP, wantErr: "invalid IP address"},
+		{ip: "1.2.3.4", ipRange: "1.2.3.4", code: cloudarmor.ErrorInvalidIPRange, wantErr: "invalid IP range: 1.2.3.4"},
+		{ip: "1.2.3.4", ipRange: "1.2.0.0/33", code: cloudarmor.ErrorInvalidIPRange, wantErr: "invalid IP range"},
+		{ip: "2001:db8::1", ipRange: "2001:db8::/129", code: cloudarmor.ErrorInvalidIPRange, wantErr: "invalid IP range"},
+	}
+	for _, tc := range errs {
+		vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+			Request: &cloudarmor.Request{Headers: map[string]string{"x-range": tc.ipRange}},
+			Origin:  &cloudarmor.Origin{IP: tc.ip},
+		})
+		_, _, err := prg.Eval(vars)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) || cloudarmor.ErrorCodeOf(err) != tc.code {
+			t.Errorf("inIpRange(%q, %q) returned error %v, wanted %s error containing %q", tc.ip, tc.ipRange, err, tc.code, tc.wantErr)
+		}
+	}
+}
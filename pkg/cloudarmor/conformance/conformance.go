@@ -0,0 +1,190 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance runs published Cloud Armor behavior fixtures against the local
+// environment so that semantic drift from the production service is detected automatically.
+package conformance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// Fixture is a named set of conformance cases describing the observed behavior of Cloud Armor.
+type Fixture struct {
+	Name string `yaml:"name"`
+	// Version is the default environment version for the cases, either VCurrent or VNext.
+	Version string  `yaml:"version"`
+	Cases   []*Case `yaml:"cases"`
+}
+
+// Case is a single expression, input, and expected outcome triple.
+type Case struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+	// Version overrides the fixture version for this case.
+	Version string                `yaml:"version"`
+	When    *cloudarmor.Variables `yaml:"when"`
+	Expect  bool                  `yaml:"expect"`
+	// Error is a substring of the expected evaluation error.
+	Error string `yaml:"error"`
+	// CompileError is a substring of the expected compilation error.
+	CompileError string `yaml:"compile_error"`
+}
+
+// Result is the outcome of running a single conformance case.
+type Result struct {
+	Fixture string
+	cloudarmor.TestStatus
+}
+
+// FixtureFromYAML converts a YAML representation of a conformance fixture to a Fixture type.
+func FixtureFromYAML(yamlBytes []byte) (*Fixture, error) {
+	f := &Fixture{}
+	if err := yaml.Unmarshal(yamlBytes, f); err != nil {
+		return nil, err
+	}
+	for _, c := range f.Cases {
+		set := 0
+		for _, s := range []string{c.Error, c.CompileError} {
+			if s != "" {
+				set++
+			}
+		}
+		if c.Expect {
+			set++
+		}
+		if set > 1 {
+			return nil, fmt.Errorf("conformance case %q has more than one of expect, error, and compile_error", c.Name)
+		}
+		if c.When == nil {
+			c.When = &cloudarmor.Variables{}
+		}
+		c.When = cloudarmor.SafeVariables(c.When)
+	}
+	return f, nil
+}
+
+// Load reads the fixtures at the given path, which may either be a single fixture file or a
+// directory whose .yaml, .yml, .textproto, and .txtpb files are read in lexical order. Files with
+// a .textproto or .txtpb extension are read with FixtureFromTextproto, all others with
+// FixtureFromYAML.
+func Load(path string) ([]*Fixture, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if !e.IsDir() && (isTextproto(e.Name()) || strings.HasSuffix(e.Name(), ".yaml") || strings.HasSuffix(e.Name(), ".yml")) {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+		sort.Strings(files)
+	}
+	var fixtures []*Fixture
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		parse := FixtureFromYAML
+		if isTextproto(file) {
+			parse = FixtureFromTextproto
+		}
+		f, err := parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+func isTextproto(name string) bool {
+	return strings.HasSuffix(name, ".textproto") || strings.HasSuffix(name, ".txtpb")
+}
+
+// Run executes every case within the fixtures and returns one result per case.
+func Run(fixtures []*Fixture) ([]Result, error) {
+	envs := map[uint32]*cloudarmor.Rules{}
+	var results []Result
+	for _, f := range fixtures {
+		for _, c := range f.Cases {
+			ver := c.Version
+			if ver == "" {
+				ver = f.Version
+			}
+			version, err := cloudarmor.ParseVersion(ver)
+			if err != nil {
+				return nil, fmt.Errorf("conformance case %s/%s: %w", f.Name, c.Name, err)
+			}
+			r, found := envs[version]
+			if !found {
+				r, err = cloudarmor.NewRules(cloudarmor.Version(version))
+				if err != nil {
+					return nil, err
+				}
+				envs[version] = r
+			}
+			results = append(results, Result{Fixture: f.Name, TestStatus: runCase(r, c)})
+		}
+	}
+	return results, nil
+}
+
+func runCase(r *cloudarmor.Rules, c *Case) cloudarmor.TestStatus {
+	ast, err := r.Compile(c.Expr)
+	if err != nil {
+		if c.CompileError == "" {
+			return cloudarmor.TestStatus{Name: c.Name, Fail: fmt.Sprintf("unexpected compile error: %v", err)}
+		}
+		if !strings.Contains(err.Error(), c.CompileError) {
+			return cloudarmor.TestStatus{
+				Name: c.Name,
+				Fail: fmt.Sprintf("got compile error %q, wanted error containing %q", err.Error(), c.CompileError),
+			}
+		}
+		return cloudarmor.TestStatus{Name: c.Name, Pass: true}
+	}
+	if c.CompileError != "" {
+		return cloudarmor.TestStatus{
+			Name: c.Name,
+			Fail: fmt.Sprintf("expression compiled, wanted compile error containing %q", c.CompileError),
+		}
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		return cloudarmor.TestStatus{Name: c.Name, Fail: fmt.Sprintf("failed to create program: %v", err)}
+	}
+	return r.RunRuleValidation(prg, []*cloudarmor.TestCase{{
+		Name:         c.Name,
+		When:         c.When,
+		ExpectOutput: c.Expect,
+		ExpectError:  c.Error,
+	}})[0]
+}
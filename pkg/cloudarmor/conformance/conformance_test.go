@@ -0,0 +1,189 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/conformance"
+)
+
+func TestRun(t *testing.T) {
+	fixtures, err := conformance.Load("../../../test/conformance-tests.yaml")
+	if err != nil {
+		t.Fatalf("conformance.Load() returned error: %v", err)
+	}
+	results, err := conformance.Run(fixtures)
+	if err != nil {
+		t.Fatalf("conformance.Run() returned error: %v", err)
+	}
+	if len(results) != len(fixtures[0].Cases) {
+		t.Errorf("len(results) = %d, want %d", len(results), len(fixtures[0].Cases))
+	}
+	for _, r := range results {
+		if !r.Pass {
+			t.Errorf("FAIL %s/%s: %s", r.Fixture, r.Name, r.Fail)
+		}
+	}
+}
+
+func TestRunReportsDrift(t *testing.T) {
+	f, err := conformance.FixtureFromYAML([]byte(`
+name: drift
+cases:
+  - name: wrong-outcome
+    expr: "request.method == 'GET'"
+    expect: true
+  - name: missing-compile-error
+    expr: "request.method == 'GET'"
+    compile_error: "undeclared reference"
+`))
+	if err != nil {
+		t.Fatalf("conformance.FixtureFromYAML() returned error: %v", err)
+	}
+	results, err := conformance.Run([]*conformance.Fixture{f})
+	if err != nil {
+		t.Fatalf("conformance.Run() returned error: %v", err)
+	}
+	for _, r := range results {
+		if r.Pass {
+			t.Errorf("case %s passed, wanted failure", r.Name)
+		}
+	}
+}
+
+func TestFixtureFromYAMLErrors(t *testing.T) {
+	_, err := conformance.FixtureFromYAML([]byte(`
+name: invalid
+cases:
+  - name: conflicting
+    expr: "true"
+    expect: true
+    compile_error: "x"
+`))
+	if err == nil {
+		t.Error("conformance.FixtureFromYAML() succeeded, wanted error for conflicting expectations")
+	}
+	f, err := conformance.FixtureFromYAML([]byte(`
+name: bad-version
+version: V3
+cases:
+  - name: c
+    expr: "true"
+`))
+	if err != nil {
+		t.Fatalf("conformance.FixtureFromYAML() returned error: %v", err)
+	}
+	if _, err := conformance.Run([]*conformance.Fixture{f}); err == nil {
+		t.Error("conformance.Run() succeeded, wanted error for unsupported version")
+	}
+}
+
+const textprotoFixture = `
+name: "textproto"
+section {
+  name: "request"
+  test {
+    name: "method"
+    expr: "request.method == 'GET'"
+    bindings {
+      key: "request"
+      value { value { map_value { entries {
+        key { string_value: "method" }
+        value { string_value: "GET" }
+      } } } }
+    }
+  }
+  test {
+    name: "no-match"
+    expr: "origin.region_code == 'US'"
+    value { bool_value: false }
+  }
+  test {
+    name: "invalid-escape"
+    expr: "request.query.urlDecode() == 'a'"
+    eval_error { errors { message: "invalid URL escape" } }
+    bindings {
+      key: "request"
+      value { value { map_value { entries {
+        key { string_value: "query" }
+        value { string_value: "%zz" }
+      } } } }
+    }
+  }
+}
+`
+
+func TestFixtureFromTextproto(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fixture.textproto"), []byte(textprotoFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fixtures, err := conformance.Load(dir)
+	if err != nil {
+		t.Fatalf("conformance.Load() returned error: %v", err)
+	}
+	if len(fixtures) != 1 || len(fixtures[0].Cases) != 3 {
+		t.Fatalf("conformance.Load() = %d fixtures, want 1 with 3 cases", len(fixtures))
+	}
+	if got := fixtures[0].Cases[0].Name; got != "request/method" {
+		t.Errorf("Cases[0].Name = %q, want %q", got, "request/method")
+	}
+	results, err := conformance.Run(fixtures)
+	if err != nil {
+		t.Fatalf("conformance.Run() returned error: %v", err)
+	}
+	for _, r := range results {
+		if !r.Pass {
+			t.Errorf("FAIL %s/%s: %s", r.Fixture, r.Name, r.Fail)
+		}
+	}
+}
+
+func TestFixtureFromTextprotoErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		wantErr string
+	}{
+		{
+			name:    "non-bool value",
+			fixture: `section { name: "s" test { name: "t" expr: "1" value { int64_value: 1 } } }`,
+			wantErr: "must be a bool_value",
+		},
+		{
+			name:    "type env",
+			fixture: `section { name: "s" test { name: "t" expr: "x" type_env { name: "x" } } }`,
+			wantErr: "type_env is not supported",
+		},
+		{
+			name: "unknown binding",
+			fixture: `section { name: "s" test { name: "t" expr: "true" bindings {
+				key: "x" value { value { int64_value: 1 } } } } }`,
+			wantErr: "bindings",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := conformance.FixtureFromTextproto([]byte(tc.fixture))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("conformance.FixtureFromTextproto() = %v, wanted error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"fmt"
+
+	"cel.dev/expr"
+	"cel.dev/expr/conformance/test"
+	"google.golang.org/protobuf/encoding/prototext"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// FixtureFromTextproto converts a textproto SimpleTestFile, the format of the CEL conformance
+// suite, to a Fixture type.
+//
+// Each test becomes a case named '<section>/<test>' which is evaluated in the VCurrent
+// environment. The bindings name the top-level Cloud Armor variables, such as request or origin,
+// and are validated as strictly as a YAML 'when' clause. A test without a result matcher expects
+// true, a bool value matcher sets the expected outcome, and an eval_error matcher expects an
+// error containing the message of its first error. Tests which rely on type environments,
+// containers, disabled macros or checks, or any other result matcher are rejected.
+func FixtureFromTextproto(data []byte) (*Fixture, error) {
+	file := &test.SimpleTestFile{}
+	if err := prototext.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+	f := &Fixture{Name: file.GetName()}
+	for _, section := range file.GetSection() {
+		for _, t := range section.GetTest() {
+			name := section.GetName() + "/" + t.GetName()
+			c, err := caseFromSimpleTest(name, t)
+			if err != nil {
+				return nil, fmt.Errorf("conformance case %q: %w", name, err)
+			}
+			f.Cases = append(f.Cases, c)
+		}
+	}
+	return f, nil
+}
+
+func caseFromSimpleTest(name string, t *test.SimpleTest) (*Case, error) {
+	switch {
+	case t.GetDisableMacros(), t.GetDisableCheck(), t.GetCheckOnly():
+		return nil, fmt.Errorf("disable_macros, disable_check, and check_only are not supported")
+	case len(t.GetTypeEnv()) != 0:
+		return nil, fmt.Errorf("type_env is not supported, the Cloud Armor declarations are used")
+	case t.GetContainer() != "" || t.GetLocale() != "":
+		return nil, fmt.Errorf("container and locale are not supported")
+	}
+	c := &Case{Name: name, Expr: t.GetExpr(), Expect: true}
+	switch m := t.GetResultMatcher().(type) {
+	case nil:
+	case *test.SimpleTest_Value:
+		b, ok := m.Value.GetKind().(*expr.Value_BoolValue)
+		if !ok {
+			return nil, fmt.Errorf("value matcher must be a bool_value, Cloud Armor expressions are boolean")
+		}
+		c.Expect = b.BoolValue
+	case *test.SimpleTest_EvalError:
+		errs := m.EvalError.GetErrors()
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("eval_error matcher has no errors")
+		}
+		c.Expect = false
+		c.Error = errs[0].GetMessage()
+	default:
+		return nil, fmt.Errorf("unsupported result matcher %T", m)
+	}
+	bindings := map[string]any{}
+	for k, v := range t.GetBindings() {
+		val, ok := v.GetKind().(*expr.ExprValue_Value)
+		if !ok {
+			return nil, fmt.Errorf("binding %q must be a value", k)
+		}
+		native, err := nativeValue(val.Value)
+		if err != nil {
+			return nil, fmt.Errorf("binding %q: %w", k, err)
+		}
+		bindings[k] = native
+	}
+	when, err := yaml.Marshal(bindings)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := cloudarmor.VariablesFromYAML(when, cloudarmor.StrictYAML())
+	if err != nil {
+		return nil, fmt.Errorf("bindings: %w", err)
+	}
+	c.When = cloudarmor.SafeVariables(vars)
+	return c, nil
+}
+
+// nativeValue converts a CEL value to the Go value of the equivalent YAML 'when' clause.
+func nativeValue(v *expr.Value) (any, error) {
+	switch k := v.GetKind().(type) {
+	case *expr.Value_NullValue:
+		return nil, nil
+	case *expr.Value_BoolValue:
+		return k.BoolValue, nil
+	case *expr.Value_Int64Value:
+		return k.Int64Value, nil
+	case *expr.Value_Uint64Value:
+		return k.Uint64Value, nil
+	case *expr.Value_DoubleValue:
+		return k.DoubleValue, nil
+	case *expr.Value_StringValue:
+		return k.StringValue, nil
+	case *expr.Value_ListValue:
+		out := make([]any, 0, len(k.ListValue.GetValues()))
+		for _, e := range k.ListValue.GetValues() {
+			n, err := nativeValue(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, n)
+		}
+		return out, nil
+	case *expr.Value_MapValue:
+		out := make(map[string]any, len(k.MapValue.GetEntries()))
+		for _, e := range k.MapValue.GetEntries() {
+			key, ok := e.GetKey().GetKind().(*expr.Value_StringValue)
+			if !ok {
+				return nil, fmt.Errorf("map keys must be strings, got %T", e.GetKey().GetKind())
+			}
+			n, err := nativeValue(e.GetValue())
+			if err != nil {
+				return nil, err
+			}
+			out[key.StringValue] = n
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %T", k)
+	}
+}
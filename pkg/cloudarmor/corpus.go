@@ -0,0 +1,370 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// transformationFunctions maps the string transformation functions to their implementations.
+var transformationFunctions = map[string]func(string) ref.Val{
+	"base64Decode":  base64DecodeString,
+	"urlDecode":     urlDecodeString,
+	"urlDecodeUni":  urlDecodeUniString,
+	"utf8ToUnicode": utf8ToUnicodeString,
+	"toPunycode":    toPunycodeString,
+	"fromPunycode":  fromPunycodeString,
+	"lower":         lowerASCII,
+	"upper":         upperASCII,
+}
+
+// CorpusEvaluator evaluates a corpus of requests against a set of named rules.
+//
+// Transformation chains applied to attributes, such as request.path.lower() or
+// request.query.urlDecode().lower(), are computed once per request and shared by every rule
+// which refers to them rather than being recomputed by each rule.
+//
+// Literals which a rule requires in order to match, such as the argument to a top-level
+// contains() conjunct or the literal portion of a matches() pattern, are checked before the rule
+// is evaluated so that most requests skip the full evaluation of rules they cannot match.
+//
+// Each rule is planned with the same evaluation semantics as Program. Shared transformations are
+// computed from the Variables directly, so they are disabled when the Rules have limits, are in
+// untrusted mode, or apply presence or unknown attribute semantics, which change how attributes
+// and the results of functions are observed. Prefilters are disabled by the latter two, under
+// which a rule referring to an unset attribute may evaluate to an error or an unknown.
+type CorpusEvaluator struct {
+	names      []string
+	programs   []cel.Program
+	prefilters [][]literalPrefilter
+	transforms []*transformation
+	// stack captures the stack of a panic recovered within a transformation, see Debug.
+	stack bool
+
+	evaluations atomic.Int64
+	skipped     atomic.Int64
+}
+
+// CorpusResult represents the outcome of evaluating a single request against every rule.
+type CorpusResult struct {
+	// Matches contains the names of the rules which evaluated to true, in sorted order.
+	Matches []string
+	// Errors contains the evaluation error for each rule which failed to evaluate.
+	Errors map[string]error
+}
+
+// transformation is a chain of transformation functions applied to an attribute.
+type transformation struct {
+	// name is the synthetic variable name which replaces the chain within rule expressions.
+	name  string
+	attr  string
+	funcs []string
+}
+
+// NewCorpusEvaluator compiles the named rule expressions into a CorpusEvaluator.
+//
+// The return value is the CorpusEvaluator or an error if any of the rules fails to compile.
+func (r *Rules) NewCorpusEvaluator(exprs map[string]string) (*CorpusEvaluator, error) {
+	names := SortedNames(exprs)
+
+	attrs := map[string]bool{}
+	for _, v := range r.env.Variables() {
+		attrs[v.Name()] = true
+	}
+	transforms := map[string]*transformation{}
+	var ordered []*transformation
+	asts := make([]*cel.Ast, len(names))
+	for i, name := range names {
+		a, err := r.Compile(exprs[name])
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", name, err)
+		}
+		a, err = foldDecodeLiterals(r.env, a)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", name, err)
+		}
+		asts[i] = a
+		if !r.sharesTransformations() {
+			continue
+		}
+		for _, e := range transformationExprs(a.NativeRep(), attrs) {
+			t, _ := asTransformation(e, attrs)
+			key := t.key()
+			if _, found := transforms[key]; found {
+				continue
+			}
+			t.name = fmt.Sprintf("@transform%d", len(ordered))
+			transforms[key] = t
+			ordered = append(ordered, t)
+		}
+	}
+
+	decls := make([]cel.EnvOption, 0, len(ordered))
+	for _, t := range ordered {
+		decls = append(decls, cel.Variable(t.name, cel.StringType))
+	}
+	env, err := r.env.Extend(decls...)
+	if err != nil {
+		return nil, err
+	}
+	opt := cel.NewStaticOptimizer(&transformationOptimizer{attrs: attrs, transforms: transforms})
+	programs := make([]cel.Program, len(names))
+	prefilters := make([][]literalPrefilter, len(names))
+	for i, a := range asts {
+		if len(ordered) != 0 {
+			var iss *cel.Issues
+			a, iss = opt.Optimize(env, a)
+			if iss.Err() != nil {
+				return nil, fmt.Errorf("rule %q: %w", names[i], iss.Err())
+			}
+		}
+		if !r.observesUnset() {
+			prefilters[i] = extractPrefilters(a.NativeRep().Expr())
+		}
+		programs[i], err = r.program(env, a)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", names[i], err)
+		}
+	}
+	return &CorpusEvaluator{
+		names:      names,
+		programs:   programs,
+		prefilters: prefilters,
+		transforms: ordered,
+		stack:      r.debug,
+	}, nil
+}
+
+// sharesTransformations reports whether transformation chains may be computed once per request
+// from the Variables rather than within the evaluation of each rule.
+func (r *Rules) sharesTransformations() bool {
+	return r.limits == nil && r.untrusted == nil && !r.observesUnset()
+}
+
+// observesUnset reports whether unset attributes are observed differently from their zero values.
+func (r *Rules) observesUnset() bool {
+	return r.unknowns || r.presence == PresenceAbsent
+}
+
+// Stats returns the cumulative prefilter statistics for all of the requests evaluated so far.
+func (c *CorpusEvaluator) Stats() PrefilterStats {
+	stats := PrefilterStats{
+		Evaluations: c.evaluations.Load(),
+		Skipped:     c.skipped.Load(),
+	}
+	for _, filters := range c.prefilters {
+		if len(filters) != 0 {
+			stats.RulesWithPrefilter++
+		}
+	}
+	return stats
+}
+
+// Evaluate evaluates each request in the corpus against every rule.
+//
+// The requests in the corpus are expected to have been initialized with SafeVariables.
+//
+// The return value contains one CorpusResult per request, in the same order as the corpus.
+func (c *CorpusEvaluator) Evaluate(corpus []*Variables) []CorpusResult {
+	results, _ := c.EvaluateContext(context.Background(), corpus)
+	return results
+}
+
+// EvaluateContext is Evaluate which stops once the context is done, returning the results of the
+// requests evaluated so far along with the context's error. The context is also passed to the
+// evaluation of each rule.
+func (c *CorpusEvaluator) EvaluateContext(ctx context.Context, corpus []*Variables) ([]CorpusResult, error) {
+	results := make([]CorpusResult, 0, len(corpus))
+	progress := progressFrom(ctx)
+	for i, vars := range corpus {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		results = append(results, c.evaluate(ctx, vars))
+		progress(i+1, len(corpus))
+	}
+	return results, nil
+}
+
+func (c *CorpusEvaluator) evaluate(ctx context.Context, vars *Variables) CorpusResult {
+	cache := make(map[string]any, len(c.transforms))
+	for _, t := range c.transforms {
+		cache[t.name] = t.apply(vars, c.stack)
+	}
+	// The transformations overlay the Variables so that the programs apply their input semantics.
+	act := Overlay(vars, Attributes(cache))
+
+	var res CorpusResult
+	var skipped int64
+	for i, prg := range c.programs {
+		if !c.admits(i, vars, cache) {
+			skipped++
+			continue
+		}
+		out, _, err := prg.ContextEval(ctx, act)
+		if err != nil {
+			if res.Errors == nil {
+				res.Errors = map[string]error{}
+			}
+			res.Errors[c.names[i]] = err
+			continue
+		}
+		if out == types.True {
+			res.Matches = append(res.Matches, c.names[i])
+		}
+	}
+	c.evaluations.Add(int64(len(c.programs)))
+	c.skipped.Add(skipped)
+	return res
+}
+
+// admits reports whether every required literal of the rule is present in the request.
+func (c *CorpusEvaluator) admits(rule int, vars *Variables, cache map[string]any) bool {
+	for _, p := range c.prefilters[rule] {
+		val, found := cache[p.variable]
+		if !found {
+			val, found = vars.ResolveName(p.variable)
+		}
+		if !found {
+			continue
+		}
+		var str string
+		switch v := val.(type) {
+		case types.String:
+			str = string(v)
+		case string:
+			str = v
+		default:
+			// Errors must surface from the full evaluation.
+			continue
+		}
+		if !p.admits(str) {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns the canonical form of the transformation chain, e.g. request.path.lower().
+func (t *transformation) key() string {
+	var sb strings.Builder
+	sb.WriteString(t.attr)
+	for _, fn := range t.funcs {
+		sb.WriteString(".")
+		sb.WriteString(fn)
+		sb.WriteString("()")
+	}
+	return sb.String()
+}
+
+// apply computes the result of the transformation chain for the given variables.
+//
+// A panic within a transformation function results in an error of type ErrorInternal, as it does
+// when the function is called by a program, so that it fails only the rules which refer to the
+// chain.
+func (t *transformation) apply(vars *Variables, stack bool) (out ref.Val) {
+	where := t.attr
+	defer func() {
+		if p := recover(); p != nil {
+			out = types.WrapErr(panicErr(where, p, stack))
+		}
+	}()
+	val, found := vars.ResolveName(t.attr)
+	if !found {
+		return types.NewErr("no such attribute: %s", t.attr)
+	}
+	switch v := val.(type) {
+	case types.String:
+		out = v
+	case string:
+		out = types.String(v)
+	default:
+		return types.NewErr("no such overload: %s", t.key())
+	}
+	for _, fn := range t.funcs {
+		where = fn
+		out = transformationFunctions[fn](string(out.(types.String)))
+		if types.IsError(out) {
+			return out
+		}
+	}
+	return out
+}
+
+// transformationExprs returns the outermost transformation chains within the expression.
+func transformationExprs(a *ast.AST, attrs map[string]bool) []ast.NavigableExpr {
+	return ast.MatchDescendants(ast.NavigateAST(a), func(e ast.NavigableExpr) bool {
+		if t, ok := asTransformation(e, attrs); !ok || len(t.funcs) == 0 {
+			return false
+		}
+		parent, found := e.Parent()
+		if !found {
+			return true
+		}
+		_, nested := asTransformation(parent, attrs)
+		return !nested
+	})
+}
+
+// asTransformation determines whether the expression is a chain of transformation functions
+// applied to a declared attribute.
+func asTransformation(e ast.Expr, attrs map[string]bool) (*transformation, bool) {
+	switch e.Kind() {
+	case ast.IdentKind:
+		if !attrs[e.AsIdent()] {
+			return nil, false
+		}
+		return &transformation{attr: e.AsIdent()}, true
+	case ast.CallKind:
+		call := e.AsCall()
+		if _, found := transformationFunctions[call.FunctionName()]; !found {
+			return nil, false
+		}
+		if !call.IsMemberFunction() || len(call.Args()) != 0 {
+			return nil, false
+		}
+		t, ok := asTransformation(call.Target(), attrs)
+		if !ok {
+			return nil, false
+		}
+		t.funcs = append(t.funcs, call.FunctionName())
+		return t, true
+	}
+	return nil, false
+}
+
+// transformationOptimizer replaces transformation chains with references to the synthetic
+// variables which hold their precomputed results.
+type transformationOptimizer struct {
+	attrs      map[string]bool
+	transforms map[string]*transformation
+}
+
+// Optimize implements the cel.ASTOptimizer interface.
+func (opt *transformationOptimizer) Optimize(ctx *cel.OptimizerContext, a *ast.AST) *ast.AST {
+	for _, e := range transformationExprs(a, opt.attrs) {
+		t, _ := asTransformation(e, opt.attrs)
+		ctx.UpdateExpr(e, ctx.NewIdent(opt.transforms[t.key()].name))
+	}
+	return a
+}
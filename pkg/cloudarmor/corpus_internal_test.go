@@ -0,0 +1,65 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/common/types/ref"
+)
+
+func TestCorpusEvaluatorTransformationPanic(t *testing.T) {
+	// The transformation functions are not reachable through the public API with a panicking
+	// input, so one is substituted for the duration of the test.
+	lower := transformationFunctions["lower"]
+	transformationFunctions["lower"] = func(string) ref.Val { panic("hostile input") }
+	defer func() { transformationFunctions["lower"] = lower }()
+
+	for _, debug := range []bool{false, true} {
+		opts := []RulesOption{}
+		if debug {
+			opts = append(opts, Debug())
+		}
+		r, err := NewRules(opts...)
+		if err != nil {
+			t.Fatalf("NewRules() returned error: %v", err)
+		}
+		ce, err := r.NewCorpusEvaluator(map[string]string{
+			"lower": "request.path.lower() == '/admin'",
+			"exact": "request.path == '/Admin'",
+		})
+		if err != nil {
+			t.Fatalf("r.NewCorpusEvaluator() returned error: %v", err)
+		}
+		if len(ce.transforms) == 0 {
+			t.Fatal("r.NewCorpusEvaluator() shares no transformations, wanted request.path.lower()")
+		}
+		vars := SafeVariables(&Variables{Request: &Request{Path: "/Admin"}})
+		res := ce.Evaluate([]*Variables{vars})[0]
+		if !reflect.DeepEqual(res.Matches, []string{"exact"}) {
+			t.Errorf("ce.Evaluate().Matches = %v, want [exact]", res.Matches)
+		}
+		var e *EvalError
+		if !errors.As(res.Errors["lower"], &e) || e.Code != ErrorInternal || !strings.Contains(e.Message, "lower: internal error: hostile input") {
+			t.Fatalf("ce.Evaluate().Errors[lower] = %v, wanted an %q error from lower", res.Errors["lower"], ErrorInternal)
+		}
+		if hasStack := len(e.Stack) != 0; hasStack != debug {
+			t.Errorf("ce.Evaluate() of a panicking transformation with Debug %v captured a stack: %v", debug, hasStack)
+		}
+	}
+}
@@ -0,0 +1,587 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/traffic"
+
+	"github.com/google/cel-go/cel"
+)
+
+var corpusRules = map[string]string{
+	"admin-path":     "request.path.lower().startsWith('/admin')",
+	"wp-login":       "request.path.lower().contains('wp-login')",
+	"sqli-query":     "request.query.urlDecode().lower().contains('union select')",
+	"xss-query":      "request.query.urlDecode().lower().contains('<script')",
+	"method-and-ip":  "request.method == 'POST' && inIpRange(origin.ip, '10.0.0.0/8')",
+	"bad-encoding":   "request.query.urlDecode() == 'x'",
+	"traversal-path": "request.path.urlDecode().contains('../')",
+}
+
+func TestCorpusEvaluator(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ce, err := rules.NewCorpusEvaluator(corpusRules)
+	if err != nil {
+		t.Fatalf("rules.NewCorpusEvaluator() returned error: %v", err)
+	}
+	corpus := []*cloudarmor.Variables{
+		cloudarmor.SafeVariables(&cloudarmor.Variables{
+			Request: &cloudarmor.Request{Path: "/ADMIN/wp-login.php", Query: "q=UNION%20Select"},
+		}),
+		cloudarmor.SafeVariables(&cloudarmor.Variables{
+			Request: &cloudarmor.Request{Method: "POST", Path: "/a/..%2F..%2Fetc", Query: "%zz"},
+			Origin:  &cloudarmor.Origin{IP: "10.1.2.3"},
+		}),
+	}
+	results := ce.Evaluate(corpus)
+	if len(results) != len(corpus) {
+		t.Fatalf("len(ce.Evaluate()) = %d, want %d", len(results), len(corpus))
+	}
+	wantMatches := [][]string{
+		{"admin-path", "sqli-query", "wp-login"},
+		{"method-and-ip", "traversal-path"},
+	}
+	for i, res := range results {
+		if !reflect.DeepEqual(res.Matches, wantMatches[i]) {
+			t.Errorf("results[%d].Matches = %v, want %v", i, res.Matches, wantMatches[i])
+		}
+	}
+	if len(results[0].Errors) != 0 {
+		t.Errorf("results[0].Errors = %v, want none", results[0].Errors)
+	}
+	for _, name := range []string{"bad-encoding", "sqli-query", "xss-query"} {
+		if _, found := results[1].Errors[name]; !found {
+			t.Errorf("results[1].Errors[%q] not found, want invalid URL escape error", name)
+		}
+	}
+}
+
+func TestCorpusEvaluatorPrefilter(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ce, err := rules.NewCorpusEvaluator(map[string]string{
+		"contains":       "request.query.lower().contains('union') && request.method == 'GET'",
+		"regex":          "request.path.matches('^/api/v[0-9]+/admin')",
+		"case-fold":      "request.path.matches('(?i)admin')",
+		"no-literal":     "request.method == 'DELETE'",
+		"error-conjunct": "request.path.startsWith('/static') && request.query.urlDecode() == 'x'",
+	})
+	if err != nil {
+		t.Fatalf("rules.NewCorpusEvaluator() returned error: %v", err)
+	}
+	if got := ce.Stats().RulesWithPrefilter; got != 3 {
+		t.Errorf("ce.Stats().RulesWithPrefilter = %d, want 3", got)
+	}
+	results := ce.Evaluate([]*cloudarmor.Variables{
+		cloudarmor.SafeVariables(&cloudarmor.Variables{
+			Request: &cloudarmor.Request{Method: "GET", Path: "/home", Query: "%zz"},
+		}),
+		cloudarmor.SafeVariables(&cloudarmor.Variables{
+			Request: &cloudarmor.Request{Method: "GET", Path: "/api/v2/admin", Query: "q=UNION"},
+		}),
+	})
+	if len(results[0].Matches) != 0 || len(results[0].Errors) != 0 {
+		t.Errorf("results[0] = %+v, want no matches or errors", results[0])
+	}
+	wantMatches := []string{"case-fold", "contains", "regex"}
+	if !reflect.DeepEqual(results[1].Matches, wantMatches) {
+		t.Errorf("results[1].Matches = %v, want %v", results[1].Matches, wantMatches)
+	}
+	stats := ce.Stats()
+	if stats.Evaluations != 10 {
+		t.Errorf("stats.Evaluations = %d, want 10", stats.Evaluations)
+	}
+	// contains, regex, and error-conjunct are skipped for the first request, error-conjunct for
+	// the second.
+	if stats.Skipped != 4 {
+		t.Errorf("stats.Skipped = %d, want 4", stats.Skipped)
+	}
+}
+
+func TestCorpusEvaluatorProgramSemantics(t *testing.T) {
+	rules, err := cloudarmor.NewRules(
+		cloudarmor.Version(cloudarmor.VNext),
+		cloudarmor.WithLimits(cloudarmor.Limits{MaxExpressionLength: 2048, MaxBodySize: 8}),
+		cloudarmor.Presence(cloudarmor.PresenceAbsent),
+	)
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	exprs := map[string]string{
+		"body":   "request.body.lower().contains('attack')",
+		"method": "request.method.lower() == 'post'",
+	}
+	ce, err := rules.NewCorpusEvaluator(exprs)
+	if err != nil {
+		t.Fatalf("rules.NewCorpusEvaluator() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Body: "payload=ATTACK"},
+	})
+	res := ce.Evaluate([]*cloudarmor.Variables{vars})[0]
+	for name, expr := range exprs {
+		ast, err := rules.Compile(expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", expr, err)
+		}
+		_, _, wantErr := prg.Eval(vars)
+		if _, gotErr := res.Errors[name]; gotErr != (wantErr != nil) {
+			t.Errorf("ce.Evaluate() error for %s = %v, want %v", name, res.Errors[name], wantErr)
+		}
+	}
+	// The body is truncated beyond the attack and the unset method is absent.
+	if len(res.Matches) != 0 {
+		t.Errorf("ce.Evaluate().Matches = %v, want none", res.Matches)
+	}
+	if _, found := res.Errors["method"]; !found {
+		t.Errorf("ce.Evaluate().Errors = %v, want an error for the absent request.method", res.Errors)
+	}
+}
+
+func BenchmarkCorpusEvaluator(b *testing.B) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		b.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	exprs := map[string]string{}
+	for i := 0; i < 50; i++ {
+		exprs[fmt.Sprintf("rule-%d", i)] = fmt.Sprintf(
+			"request.query.urlDecode().lower().contains('sig%d') || request.path.lower().startsWith('/p%d')", i, i)
+	}
+	gen, err := traffic.New(traffic.DefaultConfig())
+	if err != nil {
+		b.Fatalf("traffic.New() returned error: %v", err)
+	}
+	corpus := gen.Generate(100)
+	b.Run("per-rule", func(b *testing.B) {
+		var programs []cel.Program
+		for _, expr := range exprs {
+			ast, err := rules.Compile(expr)
+			if err != nil {
+				b.Fatalf("rules.Compile() returned error: %v", err)
+			}
+			prg, err := rules.Program(ast)
+			if err != nil {
+				b.Fatalf("rules.Program() returned error: %v", err)
+			}
+			programs = append(programs, prg)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, vars := range corpus {
+				for _, prg := range programs {
+					prg.Eval(vars)
+				}
+			}
+		}
+	})
+	b.Run("shared-transformations", func(b *testing.B) {
+		ce, err := rules.NewCorpusEvaluator(exprs)
+		if err != nil {
+			b.Fatalf("rules.NewCorpusEvaluator() returned error: %v", err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ce.Evaluate(corpus)
+		}
+	})
+}
+
+func TestCanary(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	candidate := map[string]string{}
+	for name, expr := range corpusRules {
+		candidate[name] = expr
+	}
+	candidate["method-and-ip"] = "request.method == 'POST'"
+	delete(candidate, "wp-login")
+
+	g, err := traffic.New(traffic.DefaultConfig())
+	if err != nil {
+		t.Fatalf("traffic.New() failed: %v", err)
+	}
+	reqs := g.Generate(500)
+	report, err := rules.Canary(corpusRules, candidate, reqs, 3)
+	if err != nil {
+		t.Fatalf("Canary() failed: %v", err)
+	}
+	if report.Requests != len(reqs) {
+		t.Errorf("Canary() evaluated %d requests, wanted %d", report.Requests, len(reqs))
+	}
+	if report.Diverged == 0 || report.Diverged == len(reqs) {
+		t.Errorf("Canary() reported %d diverging requests, wanted some but not all", report.Diverged)
+	}
+	for name := range report.RuleDivergence {
+		if name != "method-and-ip" && name != "wp-login" {
+			t.Errorf("Canary() reported divergence for unchanged rule %s", name)
+		}
+	}
+	if report.RuleDivergence["method-and-ip"] == 0 || report.RuleDivergence["wp-login"] == 0 {
+		t.Errorf("Canary() rule divergence %v, wanted both changed rules", report.RuleDivergence)
+	}
+	if len(report.Samples) != 3 {
+		t.Errorf("Canary() returned %d samples, wanted 3", len(report.Samples))
+	}
+	for _, s := range report.Samples {
+		if reflect.DeepEqual(s.Active.Matches, s.Candidate.Matches) && len(s.Active.Errors) == len(s.Candidate.Errors) {
+			t.Errorf("Canary() sample %v does not diverge", s)
+		}
+	}
+
+	same, err := rules.Canary(corpusRules, corpusRules, reqs, 3)
+	if err != nil {
+		t.Fatalf("Canary() failed: %v", err)
+	}
+	if same.Diverged != 0 || same.DivergenceRate() != 0 {
+		t.Errorf("Canary() of identical rules reported %d diverging requests", same.Diverged)
+	}
+}
+
+func TestMatchRateBudget(t *testing.T) {
+	suite := `
+name: admin
+expr: request.path.lower().startsWith('/admin')
+max_match_rate: %s
+corpus: clean.ndjson
+`
+	rates := []struct {
+		rate    string
+		want    cloudarmor.MatchRate
+		wantErr string
+	}{
+		{rate: "0.1%", want: 0.001},
+		{rate: "0.25", want: 0.25},
+		{rate: "150%", wantErr: "not between 0 and 100%"},
+		{rate: "lots", wantErr: "invalid match rate"},
+	}
+	for _, tst := range rates {
+		ts, err := cloudarmor.TestSuiteFromYAML([]byte(fmt.Sprintf(suite, tst.rate)), cloudarmor.StrictYAML())
+		if tst.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("cloudarmor.TestSuiteFromYAML(%s) returned error %v, wanted error containing %q", tst.rate, err, tst.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("cloudarmor.TestSuiteFromYAML(%s) returned error: %v", tst.rate, err)
+		}
+		if *ts.MaxMatchRate != tst.want {
+			t.Errorf("max_match_rate %s = %v, want %v", tst.rate, *ts.MaxMatchRate, tst.want)
+		}
+	}
+	if _, err := cloudarmor.TestSuiteFromYAML([]byte("name: s\ncorpus: clean.ndjson\n")); err == nil {
+		t.Error("cloudarmor.TestSuiteFromYAML() accepted a corpus without max_match_rate, wanted error")
+	}
+
+	ts, err := cloudarmor.TestSuiteFromYAML([]byte(fmt.Sprintf(suite, "1%")))
+	if err != nil {
+		t.Fatalf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := r.Compile(ts.Expr)
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	var sb strings.Builder
+	for i := range 50 {
+		path := fmt.Sprintf("/home/%d", i)
+		if i == 9 {
+			path = "/Admin/users"
+		}
+		fmt.Fprintf(&sb, "{\"request\": {\"path\": %q}}\n", path)
+	}
+	report, err := r.EvaluateCorpus(prg, strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("r.EvaluateCorpus() returned error: %v", err)
+	}
+	if report.Requests != 50 || report.Matches != 1 || !reflect.DeepEqual(report.MatchedLines, []int{10}) {
+		t.Errorf("r.EvaluateCorpus() = %+v, wanted 1 of 50 requests matched on line 10", report)
+	}
+	if s := ts.CheckMatchRate(report); s.Pass || !strings.Contains(s.Fail, "(2%), exceeding max_match_rate 1%") {
+		t.Errorf("ts.CheckMatchRate() = %+v, wanted a 2%% match rate to exceed 1%%", s)
+	}
+	*ts.MaxMatchRate = 0.05
+	if s := ts.CheckMatchRate(report); !s.Pass {
+		t.Errorf("ts.CheckMatchRate() = %+v, wanted a 2%% match rate to pass 5%%", s)
+	}
+
+	yamlCorpus := "request:\n  path: /admin\n---\nrequest:\n  path: /\n  pth: typo\n"
+	if _, err := r.EvaluateCorpus(prg, strings.NewReader(yamlCorpus), cloudarmor.StrictYAML()); err == nil ||
+		!strings.Contains(err.Error(), "line 6: field pth not found") {
+		t.Errorf("r.EvaluateCorpus() returned error %v, wanted unknown field pth on line 6", err)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	asts, errs, err := r.CompileAllContext(ctx, corpusRules)
+	if !errors.Is(err, context.Canceled) || len(asts) != 0 || len(errs) != 0 {
+		t.Errorf("r.CompileAllContext() = %d, %d, %v, wanted nothing compiled and context.Canceled", len(asts), len(errs), err)
+	}
+	ce, err := r.NewCorpusEvaluator(corpusRules)
+	if err != nil {
+		t.Fatalf("r.NewCorpusEvaluator() returned error: %v", err)
+	}
+	corpus := []*cloudarmor.Variables{cloudarmor.SafeVariables(&cloudarmor.Variables{})}
+	if results, err := ce.EvaluateContext(ctx, corpus); !errors.Is(err, context.Canceled) || len(results) != 0 {
+		t.Errorf("ce.EvaluateContext() = %v, %v, wanted no results and context.Canceled", results, err)
+	}
+	if _, err := r.CanaryContext(ctx, corpusRules, corpusRules, corpus, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("r.CanaryContext() returned error %v, wanted context.Canceled", err)
+	}
+	ast, err := r.Compile("request.path == '/'")
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	corpusData := strings.NewReader("{\"request\": {\"path\": \"/\"}}\n")
+	if _, err := r.EvaluateCorpusContext(ctx, prg, corpusData); !errors.Is(err, context.Canceled) {
+		t.Errorf("r.EvaluateCorpusContext() returned error %v, wanted context.Canceled", err)
+	}
+}
+
+func TestWithProgress(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	var got []string
+	ctx := cloudarmor.WithProgress(context.Background(), func(done, total int) {
+		got = append(got, fmt.Sprintf("%d/%d", done, total))
+	})
+	exprs := map[string]string{"a": "request.path == '/'", "b": "request.method == 'GET'"}
+	if _, _, err := r.CompileAllContext(ctx, exprs); err != nil {
+		t.Fatalf("r.CompileAllContext() returned error: %v", err)
+	}
+	if want := "1/2,2/2"; strings.Join(got, ",") != want {
+		t.Errorf("r.CompileAllContext() reported progress %v, want %s", got, want)
+	}
+	got = nil
+	ast, err := r.Compile("request.path == '/'")
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	corpusData := strings.NewReader("{\"request\": {\"path\": \"/\"}}\n{\"request\": {\"path\": \"/a\"}}\n")
+	if _, err := r.EvaluateCorpusContext(ctx, prg, corpusData); err != nil {
+		t.Fatalf("r.EvaluateCorpusContext() returned error: %v", err)
+	}
+	if want := "1/-1,2/-1"; strings.Join(got, ",") != want {
+		t.Errorf("r.EvaluateCorpusContext() reported progress %v, want %s", got, want)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	bundle := func(expr string) *cloudarmor.RuleBundle {
+		b, err := cloudarmor.RuleBundleFromYAML([]byte(`
+name: "replay"
+definitions:
+  - name: "is_admin"
+    expr: "request.path.startsWith('/admin')"
+rules:
+  - name: "admin"
+    expr: "`+expr+`"
+  - name: "broken"
+    expr: "request.nope == 1"
+`), "")
+		if err != nil {
+			t.Fatalf("cloudarmor.RuleBundleFromYAML() returned error: %v", err)
+		}
+		return b
+	}
+	corpus := "{\"request\": {\"path\": \"/admin\"}, \"origin\": {\"asn\": 15169}}\n{\"request\": {\"path\": \"/\"}}\n"
+	var out strings.Builder
+	report, err := r.Replay(bundle("is_admin"), strings.NewReader(corpus), &out)
+	if err != nil {
+		t.Fatalf("r.Replay() returned error: %v", err)
+	}
+	want := "-\tadmin\tcompiled\n-\tbroken\tcompile-error\n" +
+		"1\tadmin\tmatch\n1\tbroken\tcompile-error\n2\tadmin\tno-match\n2\tbroken\tcompile-error\n"
+	if out.String() != want {
+		t.Errorf("r.Replay() wrote outcomes:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if report.Requests != 2 || report.Rules != 2 || report.CompileErrors != 1 {
+		t.Errorf("r.Replay() returned %+v, wanted 2 requests, 2 rules, and 1 compile error", report)
+	}
+	admin := report.Impact[0]
+	if admin.Matches != 1 || admin.MatchRate() != 0.5 || len(admin.TopSignatures) != 1 || admin.TopSignatures[0].Signature != " /admin" {
+		t.Errorf("r.Replay() reported impact %+v, wanted one match of the signature ' /admin'", admin)
+	}
+	if len(admin.TopASNs) != 1 || admin.TopASNs[0].Count != 1 || admin.TopASNs[0].String() != "AS15169" {
+		t.Errorf("r.Replay() reported top ASNs %v, wanted one match from AS15169", admin.TopASNs)
+	}
+	same, err := r.Replay(bundle("request.path.startsWith('/admin')"), strings.NewReader(corpus), nil)
+	if err != nil {
+		t.Fatalf("r.Replay() returned error: %v", err)
+	}
+	if same.Digest != report.Digest {
+		t.Errorf("r.Replay() of an equivalent rule returned digest %s, wanted %s", same.Digest, report.Digest)
+	}
+	changed, err := r.Replay(bundle("request.path == '/'"), strings.NewReader(corpus), nil)
+	if err != nil {
+		t.Fatalf("r.Replay() returned error: %v", err)
+	}
+	if changed.Digest == report.Digest {
+		t.Errorf("r.Replay() of a rule with different outcomes returned the same digest %s", changed.Digest)
+	}
+}
+
+func TestReplayRegression(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	base := &cloudarmor.RuleBundle{Name: "base", Rules: []*cloudarmor.BundleRule{
+		{Name: "admin", Expr: "request.path == '/admin'"},
+		{Name: "legacy", Expr: "request.path == '/old'"},
+	}}
+	head := &cloudarmor.RuleBundle{Name: "head", Rules: []*cloudarmor.BundleRule{
+		{Name: "admin", Expr: "request.path.startsWith('/admin')"},
+		{Name: "broken", Expr: "request.nope == 1"},
+	}}
+	corpus := strings.Join([]string{
+		`{"request": {"path": "/admin"}}`,
+		`{"request": {"path": "/admin/users"}}`,
+		`{"request": {"path": "/old"}}`,
+		`{"request": {"path": "/"}}`,
+	}, "\n")
+	tol := cloudarmor.RegressionTolerance{MaxBroadened: 0.25, MaxNarrowed: 0}
+	report, err := r.ReplayRegression(base, head, strings.NewReader(corpus), tol)
+	if err != nil {
+		t.Fatalf("r.ReplayRegression() returned error: %v", err)
+	}
+	if report.Requests != 4 || len(report.Rules) != 3 {
+		t.Fatalf("r.ReplayRegression() returned %d requests and %d rules, wanted 4 and 3", report.Requests, len(report.Rules))
+	}
+	admin, broken, legacy := report.Rules[0], report.Rules[1], report.Rules[2]
+	if admin.Name != "admin" || admin.BaseMatches != 1 || admin.HeadMatches != 2 || admin.Broadened != 1 ||
+		!reflect.DeepEqual(admin.BroadenedLines, []int{2}) || admin.Exceeded {
+		t.Errorf("r.ReplayRegression() reported %+v for admin, wanted one broadened request within tolerance", admin)
+	}
+	if broken.Name != "broken" || broken.Changed() {
+		t.Errorf("r.ReplayRegression() reported %+v for broken, wanted no change", broken)
+	}
+	if legacy.Name != "legacy" || legacy.Narrowed != 1 || !legacy.Exceeded {
+		t.Errorf("r.ReplayRegression() reported %+v for legacy, wanted one narrowed request exceeding tolerance", legacy)
+	}
+	if regressed := report.Regressed(); len(regressed) != 1 || regressed[0] != legacy {
+		t.Errorf("Regressed() returned %v, wanted [legacy]", regressed)
+	}
+	if _, err := cloudarmor.ParseMatchRate("0.1%"); err != nil {
+		t.Errorf("ParseMatchRate(0.1%%) returned error: %v", err)
+	}
+	if _, err := cloudarmor.ParseMatchRate("2"); err == nil {
+		t.Error("ParseMatchRate(2) succeeded, wanted error")
+	}
+}
+
+func TestShrinkOutcome(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	a, err := r.Compile("request.headers['user-agent'].contains('sqlmap') && request.path.startsWith('/adm')")
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(a)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	vars := &cloudarmor.Variables{
+		Request: &cloudarmor.Request{
+			Method: "POST",
+			Headers: cloudarmor.HTTPHeaders(map[string]string{
+				"user-agent": "Mozilla/5.0 sqlmap/1.7",
+				"cookie":     "session=abc",
+				"host":       "example.com",
+			}),
+			Path:  "/admin/login.php",
+			Query: "id=1",
+		},
+		Origin: &cloudarmor.Origin{IP: "1.2.3.4", RegionCode: "US", ASN: 15169},
+	}
+	want := "{request: {headers: {user-agent: sqlmap}, path: /adm}}"
+	if got := cloudarmor.FormatVariables(cloudarmor.ShrinkOutcome(prg, vars)); got != want {
+		t.Errorf("cloudarmor.ShrinkOutcome() reduced the request to %s, wanted %s", got, want)
+	}
+	if got := cloudarmor.FormatVariables(&cloudarmor.Variables{}); got != "{}" {
+		t.Errorf("cloudarmor.FormatVariables() of empty variables returned %s, wanted {}", got)
+	}
+
+	b, err := cloudarmor.RuleBundleFromYAML([]byte(`
+name: "shrink"
+rules:
+  - name: "decode"
+    expr: "request.query.urlDecode() == 'x' && request.method == 'GET'"
+`), "")
+	if err != nil {
+		t.Fatalf("cloudarmor.RuleBundleFromYAML() returned error: %v", err)
+	}
+	corpus := `{"request": {"method": "GET", "path": "/", "query": "a=b"}}
+{"request": {"method": "GET", "path": "/search", "query": "q=%zz&page=2", "headers": {"host": "example.com"}}}
+`
+	report, err := r.Replay(b, strings.NewReader(corpus), nil)
+	if err != nil {
+		t.Fatalf("r.Replay() returned error: %v", err)
+	}
+	wantRep := &cloudarmor.Reproducer{Line: 2, Outcome: cloudarmor.ReplayEvalError, When: "{request: {method: GET, query: '%'}}"}
+	if rep := report.Impact[0].Reproducer; !reflect.DeepEqual(rep, wantRep) {
+		t.Errorf("r.Replay() reported reproducer %+v, wanted %+v", rep, wantRep)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/parser"
+)
+
+// MaxExpressionLength is the maximum number of characters Cloud Armor accepts within a single
+// rule expression.
+const MaxExpressionLength = 2048
+
+// Definition is a named sub-expression which the rules of a bundle, and other definitions, may
+// refer to by name, e.g. is_internal_ip.
+type Definition struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+}
+
+var definitionNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// definitionExpander inlines the definitions of a bundle into the expressions which refer to
+// them.
+type definitionExpander struct {
+	rules *Rules
+	// env declares the macro call tracking required to unparse expanded expressions.
+	env  *cel.Env
+	defs map[string]*Definition
+	// expanded holds the checked, fully expanded AST of each definition once it has been visited.
+	expanded map[string]*cel.Ast
+	visiting map[string]bool
+}
+
+func (r *Rules) newDefinitionExpander(defs []*Definition) (*definitionExpander, error) {
+	env, err := r.env.Extend(cel.EnableMacroCallTracking())
+	if err != nil {
+		return nil, err
+	}
+	x := &definitionExpander{
+		rules:    r,
+		env:      env,
+		defs:     map[string]*Definition{},
+		expanded: map[string]*cel.Ast{},
+		visiting: map[string]bool{},
+	}
+	for _, d := range defs {
+		if !definitionNamePattern.MatchString(d.Name) {
+			return nil, fmt.Errorf("definition name %q is not a valid identifier", d.Name)
+		}
+		if _, found := x.defs[d.Name]; found {
+			return nil, fmt.Errorf("duplicate definition name %q", d.Name)
+		}
+		for _, v := range r.env.Variables() {
+			if v.Name() == d.Name || strings.HasPrefix(v.Name(), d.Name+".") {
+				return nil, fmt.Errorf("definition %q shadows the attribute %s", d.Name, v.Name())
+			}
+		}
+		x.defs[d.Name] = d
+	}
+	for _, d := range defs {
+		if _, err := x.definition(d.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// expand returns the expression with every definition it refers to expanded inline.
+func (x *definitionExpander) expand(expr string) (string, error) {
+	a, err := x.compile(expr)
+	if err != nil {
+		return "", err
+	}
+	// Disable line wrapping since the flattened expression is exported as a single line.
+	out, err := parser.Unparse(a.NativeRep().Expr(), a.NativeRep().SourceInfo(), parser.WrapOnColumn(math.MaxInt))
+	if err != nil {
+		return "", err
+	}
+	// The expanded expression is exported to Cloud Armor, which enforces its default limit when
+	// the Rules do not configure one.
+	r := x.rules
+	if r.limits == nil && r.untrusted == nil {
+		r = &Rules{limits: &Limits{MaxExpressionLength: MaxExpressionLength}}
+	}
+	if err := r.checkExpressionLength(out); err != nil {
+		return "", fmt.Errorf("expanded %w", err)
+	}
+	return out, nil
+}
+
+// definition returns the expanded AST of the named definition, where path contains the
+// definitions which are currently being expanded and is used to report cycles.
+func (x *definitionExpander) definition(name string, path []string) (*cel.Ast, error) {
+	if a, found := x.expanded[name]; found {
+		return a, nil
+	}
+	path = append(path, name)
+	if x.visiting[name] {
+		return nil, fmt.Errorf("definition cycle: %s", strings.Join(path, " -> "))
+	}
+	x.visiting[name] = true
+	defer delete(x.visiting, name)
+	a, err := x.compileWithin(x.defs[name].Expr, path)
+	if err != nil {
+		return nil, fmt.Errorf("definition %q: %w", name, err)
+	}
+	x.expanded[name] = a
+	return a, nil
+}
+
+func (x *definitionExpander) compile(expr string) (*cel.Ast, error) {
+	return x.compileWithin(expr, nil)
+}
+
+func (x *definitionExpander) compileWithin(expr string, path []string) (*cel.Ast, error) {
+	parsed, iss := x.env.Parse(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	refs := x.references(parsed)
+	if len(refs) == 0 {
+		checked, iss := x.env.Check(parsed)
+		if iss.Err() != nil {
+			return nil, iss.Err()
+		}
+		return checked, nil
+	}
+	decls := make([]cel.EnvOption, 0, len(refs))
+	inlined := map[string]*cel.Ast{}
+	for _, ref := range refs {
+		a, err := x.definition(ref, path)
+		if err != nil {
+			return nil, err
+		}
+		inlined[ref] = a
+		decls = append(decls, cel.Variable(ref, a.OutputType()))
+	}
+	env, err := x.env.Extend(decls...)
+	if err != nil {
+		return nil, err
+	}
+	checked, iss := env.Check(parsed)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	opt := cel.NewStaticOptimizer(&definitionInliner{defs: inlined})
+	out, iss := opt.Optimize(x.env, checked)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	return out, nil
+}
+
+// references returns the names of the definitions referred to by the parsed expression.
+func (x *definitionExpander) references(a *cel.Ast) []string {
+	seen := map[string]bool{}
+	var refs []string
+	ast.PostOrderVisit(a.NativeRep().Expr(), ast.NewExprVisitor(func(e ast.Expr) {
+		if e.Kind() != ast.IdentKind {
+			return
+		}
+		name := e.AsIdent()
+		if _, found := x.defs[name]; found && !seen[name] {
+			seen[name] = true
+			refs = append(refs, name)
+		}
+	}))
+	return refs
+}
+
+// definitionInliner replaces references to definitions with a copy of their expanded AST.
+type definitionInliner struct {
+	defs map[string]*cel.Ast
+}
+
+// Optimize implements the cel.ASTOptimizer interface.
+func (opt *definitionInliner) Optimize(ctx *cel.OptimizerContext, a *ast.AST) *ast.AST {
+	matches := ast.MatchDescendants(ast.NavigateAST(a), func(e ast.NavigableExpr) bool {
+		if e.Kind() != ast.IdentKind {
+			return false
+		}
+		_, found := opt.defs[e.AsIdent()]
+		return found
+	})
+	for _, m := range matches {
+		ctx.UpdateExpr(m, ctx.CopyASTAndMetadata(opt.defs[m.AsIdent()].NativeRep()))
+	}
+	return a
+}
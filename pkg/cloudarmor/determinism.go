@@ -0,0 +1,130 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// DeterminismCheck configures the Rules so that every program evaluates its input twice, once
+// with an optimized plan and once with an unoptimized plan, and reports any discrepancy between
+// the two evaluations as a DeterminismError.
+//
+// The check doubles the cost of evaluation and is intended for test suites rather than
+// production use. It catches optimizer bugs as well as non-deterministic function bindings.
+func DeterminismCheck() RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.checkDeterminism = true
+		return r, nil
+	}
+}
+
+// DeterminismError indicates that the optimized and unoptimized evaluations of the same input
+// produced different results.
+type DeterminismError struct {
+	Optimized   string
+	Unoptimized string
+}
+
+// Error implements the error interface.
+func (e *DeterminismError) Error() string {
+	return fmt.Sprintf("determinism violation: optimized evaluation produced %s, unoptimized evaluation produced %s",
+		e.Optimized, e.Unoptimized)
+}
+
+// determinismProgram evaluates both an optimized and an unoptimized program and compares the
+// results.
+type determinismProgram struct {
+	optimized   cel.Program
+	unoptimized cel.Program
+}
+
+// Eval implements the cel.Program interface.
+func (p *determinismProgram) Eval(input any) (ref.Val, *cel.EvalDetails, error) {
+	out, det, err := p.optimized.Eval(input)
+	unoptOut, _, unoptErr := p.unoptimized.Eval(input)
+	return compareEvals(out, det, err, unoptOut, unoptErr)
+}
+
+// ContextEval implements the cel.Program interface.
+func (p *determinismProgram) ContextEval(ctx context.Context, input any) (ref.Val, *cel.EvalDetails, error) {
+	out, det, err := p.optimized.ContextEval(ctx, input)
+	unoptOut, _, unoptErr := p.unoptimized.ContextEval(ctx, input)
+	return compareEvals(out, det, err, unoptOut, unoptErr)
+}
+
+func compareEvals(out ref.Val, det *cel.EvalDetails, err error, unoptOut ref.Val, unoptErr error) (ref.Val, *cel.EvalDetails, error) {
+	describe := func(val ref.Val, err error) string {
+		if err != nil {
+			return fmt.Sprintf("error %q", err.Error())
+		}
+		return fmt.Sprintf("%v", val)
+	}
+	switch {
+	case err != nil && unoptErr != nil:
+		if err.Error() == unoptErr.Error() {
+			return out, det, err
+		}
+	case err == nil && unoptErr == nil:
+		if out.Equal(unoptOut) == types.True || sameUnknowns(out, unoptOut) {
+			return out, det, nil
+		}
+	}
+	return nil, det, &DeterminismError{
+		Optimized:   describe(out, err),
+		Unoptimized: describe(unoptOut, unoptErr),
+	}
+}
+
+// sameUnknowns reports whether both values are unknowns of the same attributes. Unknowns never
+// compare equal, as their values have yet to be determined, and their expression IDs differ
+// between the plans since literal folding renumbers the expressions of the optimized one.
+func sameUnknowns(val, other ref.Val) bool {
+	u, ok := val.(*types.Unknown)
+	if !ok {
+		return false
+	}
+	o, ok := other.(*types.Unknown)
+	if !ok {
+		return false
+	}
+	attrs, otherAttrs := unknownAttributes(u), unknownAttributes(o)
+	if len(attrs) != len(otherAttrs) {
+		return false
+	}
+	for attr := range attrs {
+		if !otherAttrs[attr] {
+			return false
+		}
+	}
+	return true
+}
+
+// unknownAttributes returns the attribute trails of the unknown.
+func unknownAttributes(u *types.Unknown) map[string]bool {
+	attrs := map[string]bool{}
+	for _, id := range u.IDs() {
+		trails, _ := u.GetAttributeTrails(id)
+		for _, trail := range trails {
+			attrs[trail.String()] = true
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,170 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package differential compares the behavior of the restricted Cloud Armor environment with the
+// standard CEL environment so that maintainers can see exactly what the restrictions change.
+package differential
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/randutil"
+)
+
+// Outcome describes the result of compiling or evaluating an expression in one environment.
+type Outcome struct {
+	Value ref.Val
+	Err   error
+}
+
+// String implements the fmt.Stringer interface.
+func (o Outcome) String() string {
+	if o.Err != nil {
+		return fmt.Sprintf("error: %v", o.Err)
+	}
+	if o.Value == nil {
+		return "ok"
+	}
+	return fmt.Sprintf("%v", o.Value)
+}
+
+// agrees reports whether two outcomes are behaviorally equivalent. Error messages are not
+// compared since the environments may describe the same failure differently.
+func (o Outcome) agrees(other Outcome) bool {
+	if o.Err != nil || other.Err != nil {
+		return o.Err != nil && other.Err != nil
+	}
+	return o.Value.Type().TypeName() == other.Value.Type().TypeName() && o.Value.Equal(other.Value) == types.True
+}
+
+// Difference is a single input for which the environments disagree.
+type Difference struct {
+	// Input is the variables the expression was evaluated against.
+	Input *cloudarmor.Variables
+	// Reduced is the input reduced by cloudarmor.ShrinkVariables to the attributes for which the
+	// environments still disagree.
+	Reduced    *cloudarmor.Variables
+	CloudArmor Outcome
+	Standard   Outcome
+}
+
+// Report summarizes the behavioral differences between the environments for an expression.
+type Report struct {
+	Expr string
+	// CloudArmorCompile and StandardCompile are the compilation outcomes in each environment.
+	// When either compilation fails, no inputs are evaluated.
+	CloudArmorCompile Outcome
+	StandardCompile   Outcome
+	Evaluations       int
+	Differences       []Difference
+}
+
+// CompileDiffers reports whether the expression compiled in only one of the environments.
+func (r *Report) CompileDiffers() bool {
+	return (r.CloudArmorCompile.Err == nil) != (r.StandardCompile.Err == nil)
+}
+
+// StandardEnv creates a standard CEL environment declaring the same variables as the Cloud Armor
+// rules environment.
+func StandardEnv(r *cloudarmor.Rules) (*cel.Env, error) {
+	opts := []cel.EnvOption{}
+	for _, v := range r.Env().Variables() {
+		opts = append(opts, cel.Variable(v.Name(), v.Type()))
+	}
+	return cel.NewEnv(opts...)
+}
+
+// Compare evaluates the expression in both environments against each of the inputs and reports
+// where their behavior differs. Each differing input is also reduced to a minimal reproducer.
+func Compare(r *cloudarmor.Rules, expr string, inputs []*cloudarmor.Variables) (*Report, error) {
+	std, err := StandardEnv(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Expr: expr}
+	caAST, err := r.Compile(expr)
+	report.CloudArmorCompile.Err = err
+	stdAST, iss := std.Compile(expr)
+	report.StandardCompile.Err = iss.Err()
+	if report.CloudArmorCompile.Err != nil || report.StandardCompile.Err != nil {
+		return report, nil
+	}
+	caPrg, err := r.Program(caAST)
+	if err != nil {
+		return nil, err
+	}
+	stdPrg, err := std.Program(stdAST)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range inputs {
+		ca, st := evalBoth(caPrg, stdPrg, in)
+		report.Evaluations++
+		if !ca.agrees(st) {
+			reduced := cloudarmor.ShrinkVariables(in, func(v *cloudarmor.Variables) bool {
+				ca, st := evalBoth(caPrg, stdPrg, v)
+				return !ca.agrees(st)
+			})
+			report.Differences = append(report.Differences, Difference{Input: in, Reduced: reduced, CloudArmor: ca, Standard: st})
+		}
+	}
+	return report, nil
+}
+
+// evalBoth evaluates the input with the Cloud Armor and the standard program.
+func evalBoth(caPrg, stdPrg cel.Program, in *cloudarmor.Variables) (ca, st Outcome) {
+	ca.Value, _, ca.Err = caPrg.Eval(in)
+	st.Value, _, st.Err = stdPrg.Eval(in)
+	return ca, st
+}
+
+// GenerateInputs produces n pseudo-random variables from the given seed. The values are drawn
+// from small pools so that comparisons within expressions match a reasonable fraction of the
+// time.
+func GenerateInputs(seed int64, n int) []*cloudarmor.Variables {
+	rnd := randutil.New(seed)
+	pick := func(vals ...string) string { return vals[rnd.Intn(len(vals))] }
+	inputs := make([]*cloudarmor.Variables, n)
+	for i := range inputs {
+		inputs[i] = cloudarmor.SafeVariables(&cloudarmor.Variables{
+			Request: &cloudarmor.Request{
+				Method: pick("GET", "POST", "get", "PUT", ""),
+				Headers: cloudarmor.HTTPHeaders(map[string]string{
+					pick("user-agent", "host", "x-forwarded-for"): pick("curl/8.0", "Mozilla/5.0", ""),
+				}),
+				Path:   pick("/", "/admin", "/Admin/Login", "/a/../b", "/%41dmin"),
+				Query:  pick("", "a=b", "q=%zz", "q=Hello+World", "x=%u0041"),
+				Scheme: pick("http", "https"),
+				Body:   pick("", "bad_data", "YWJj"),
+			},
+			Origin: &cloudarmor.Origin{
+				IP:         pick("1.2.3.4", "10.0.0.1", "2001:db8::1", "not-an-ip"),
+				RegionCode: pick("US", "GB", "DE", ""),
+				ASN:        int64(rnd.Intn(70000)),
+			},
+			Token: &cloudarmor.Token{
+				RecaptchaAction: &cloudarmor.RecaptchaAction{
+					Score: rnd.Float64(),
+					Valid: rnd.Intn(2) == 0,
+				},
+			},
+		})
+	}
+	return inputs
+}
@@ -0,0 +1,82 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package differential_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/differential"
+)
+
+func TestCompare(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	inputs := differential.GenerateInputs(1, 50)
+	compareTests := []struct {
+		name           string
+		expr           string
+		compileDiffers bool
+	}{
+		{
+			name: "identical semantics",
+			expr: "request.method == 'GET' && request.path.startsWith('/admin')",
+		},
+		{
+			name:           "custom function missing from standard env",
+			expr:           "request.path.lower() == '/admin'",
+			compileDiffers: true,
+		},
+		{
+			name:           "membership test rejected by cloud armor",
+			expr:           "request.method in ['GET', 'POST']",
+			compileDiffers: true,
+		},
+		{
+			name: "missing header errors in both",
+			expr: "request.headers['host'] == 'example.com'",
+		},
+	}
+	for _, tst := range compareTests {
+		tc := tst
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := differential.Compare(rules, tc.expr, inputs)
+			if err != nil {
+				t.Fatalf("differential.Compare() returned error: %v", err)
+			}
+			if report.CompileDiffers() != tc.compileDiffers {
+				t.Errorf("report.CompileDiffers() = %v, want %v (cloud armor: %v, standard: %v)",
+					report.CompileDiffers(), tc.compileDiffers, report.CloudArmorCompile, report.StandardCompile)
+			}
+			if len(report.Differences) != 0 {
+				t.Errorf("report.Differences = %v, want none", report.Differences)
+			}
+			if !tc.compileDiffers && report.Evaluations != len(inputs) {
+				t.Errorf("report.Evaluations = %d, want %d", report.Evaluations, len(inputs))
+			}
+		})
+	}
+}
+
+func TestGenerateInputsDeterministic(t *testing.T) {
+	a := differential.GenerateInputs(42, 10)
+	b := differential.GenerateInputs(42, 10)
+	if !reflect.DeepEqual(a, b) {
+		t.Error("differential.GenerateInputs() produced different inputs for the same seed")
+	}
+}
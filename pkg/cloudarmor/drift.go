@@ -0,0 +1,284 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/parser"
+)
+
+// SecurityPolicy is the subset of a deployed Cloud Armor security policy, as exported by
+// `gcloud compute security-policies export --file-format=json`, which is compared by Drift,
+// evaluated by a PolicyEvaluator, and whose rule actions are checked by Validate.
+type SecurityPolicy struct {
+	Name  string                `json:"name" yaml:"name"`
+	Rules []*SecurityPolicyRule `json:"rules" yaml:"rules"`
+}
+
+// SecurityPolicyRule is a single rule of a deployed security policy.
+type SecurityPolicyRule struct {
+	Priority    int64  `json:"priority" yaml:"priority"`
+	Description string `json:"description" yaml:"description"`
+	Action      string `json:"action" yaml:"action"`
+	// RedirectOptions and RateLimitOptions are the parameters of the redirect, throttle, and
+	// rate_based_ban actions, see Validate.
+	RedirectOptions  *RedirectOptions  `json:"redirectOptions,omitempty" yaml:"redirectOptions,omitempty"`
+	RateLimitOptions *RateLimitOptions `json:"rateLimitOptions,omitempty" yaml:"rateLimitOptions,omitempty"`
+	// HeaderAction inserts request headers into the requests which the rule forwards.
+	HeaderAction *HeaderAction `json:"headerAction,omitempty" yaml:"headerAction,omitempty"`
+	// Preview rules are evaluated but their actions are not enforced.
+	Preview bool       `json:"preview,omitempty" yaml:"preview,omitempty"`
+	Match   *RuleMatch `json:"match" yaml:"match"`
+}
+
+// RuleMatch is the condition of a security policy rule: either a CEL expression or a
+// preconfigured match such as a list of source IP ranges.
+type RuleMatch struct {
+	Expr *MatchExpr `json:"expr,omitempty" yaml:"expr,omitempty"`
+	// VersionedExpr names the preconfigured match, e.g. SRC_IPS_V1, which is configured by Config.
+	VersionedExpr string       `json:"versionedExpr,omitempty" yaml:"versionedExpr,omitempty"`
+	Config        *MatchConfig `json:"config,omitempty" yaml:"config,omitempty"`
+}
+
+// MatchExpr is the CEL expression of a RuleMatch.
+type MatchExpr struct {
+	Expression string `json:"expression" yaml:"expression"`
+}
+
+// MatchConfig configures a preconfigured match.
+type MatchConfig struct {
+	// SrcIPRanges are the source IP ranges of SRC_IPS_V1, where '*' matches all addresses.
+	SrcIPRanges []string `json:"srcIpRanges,omitempty" yaml:"srcIpRanges,omitempty"`
+}
+
+// Name returns the description of the rule, which identifies the corresponding local rule, or
+// its priority when the rule has no description.
+func (r *SecurityPolicyRule) Name() string {
+	if r.Description != "" {
+		return r.Description
+	}
+	return fmt.Sprintf("priority-%d", r.Priority)
+}
+
+// Expression returns the CEL expression matched by the rule, or the empty string when the rule
+// uses a preconfigured match such as a list of source IP ranges.
+func (r *SecurityPolicyRule) Expression() string {
+	if r.Match == nil || r.Match.Expr == nil {
+		return ""
+	}
+	return r.Match.Expr.Expression
+}
+
+// SecurityPolicyFromJSON converts a JSON export of a deployed security policy to a
+// SecurityPolicy type.
+//
+// The return value is the SecurityPolicy type or an error if the JSON is invalid.
+func SecurityPolicyFromJSON(data []byte) (*SecurityPolicy, error) {
+	p := &SecurityPolicy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DriftReport describes how the deployed rules of a security policy differ from the local rules.
+type DriftReport struct {
+	// Added contains the names of local rules which are not deployed.
+	Added []string
+	// Removed contains the names of deployed rules which do not exist locally.
+	Removed []string
+	// Changed contains the rules whose local and deployed expressions differ semantically.
+	Changed []RuleDrift
+	// Unchanged is the number of rules whose expressions are equivalent.
+	Unchanged int
+	// Invalid contains an error for each deployed rule whose action fails
+	// SecurityPolicyRule.Validate, such as an action which is newer than this model.
+	Invalid []error
+}
+
+// Drifted reports whether any rule was added, removed, changed, or is invalid.
+func (d *DriftReport) Drifted() bool {
+	return len(d.Added) != 0 || len(d.Removed) != 0 || len(d.Changed) != 0 || len(d.Invalid) != 0
+}
+
+// RuleDrift is a rule whose local expression differs from its deployed expression.
+type RuleDrift struct {
+	Name string
+	// LocalHash and DeployedHash are the canonical hashes of the two expressions.
+	LocalHash, DeployedHash string
+	// Differences pairs each differing sub-expression of the deployed rule with its local
+	// counterpart, outermost first.
+	Differences []ExprDifference
+}
+
+// ExprDifference is a pair of corresponding sub-expressions which differ.
+type ExprDifference struct {
+	Deployed, Local string
+}
+
+// Drift compares the local rules, keyed by rule name, with the rules of a deployed security
+// policy, keyed by their description, so that the local rules remain the source of truth.
+//
+// Both sides are compiled and reduced to a canonical form which ignores formatting such as
+// whitespace, redundant parentheses, and quoting, so that only semantic changes are reported.
+// Deployed rules without a CEL expression are ignored. The actions of all deployed rules are
+// validated, and the rules whose actions are invalid are reported as drift rather than
+// preventing the comparison of the expressions.
+//
+// The return value is an error if a local or deployed expression fails to compile.
+func (r *Rules) Drift(local map[string]string, deployed *SecurityPolicy) (*DriftReport, error) {
+	compile, err := r.canonicalCompiler()
+	if err != nil {
+		return nil, err
+	}
+	report := &DriftReport{}
+	remote := map[string]string{}
+	for _, rule := range deployed.Rules {
+		if err := rule.Validate(); err != nil {
+			report.Invalid = append(report.Invalid, err)
+		}
+		if rule.Expression() == "" {
+			continue
+		}
+		if _, found := remote[rule.Name()]; found {
+			return nil, fmt.Errorf("policy %s: duplicate rule name %q", deployed.Name, rule.Name())
+		}
+		remote[rule.Name()] = rule.Expression()
+	}
+
+	for name := range remote {
+		if _, found := local[name]; !found {
+			report.Removed = append(report.Removed, name)
+		}
+	}
+	for name, localExpr := range local {
+		remoteExpr, found := remote[name]
+		if !found {
+			report.Added = append(report.Added, name)
+			continue
+		}
+		la, err := compile(localExpr)
+		if err != nil {
+			return nil, fmt.Errorf("local rule %q: %w", name, err)
+		}
+		ra, err := compile(remoteExpr)
+		if err != nil {
+			return nil, fmt.Errorf("deployed rule %q: %w", name, err)
+		}
+		lhash, err := canonicalHash(la)
+		if err != nil {
+			return nil, err
+		}
+		rhash, err := canonicalHash(ra)
+		if err != nil {
+			return nil, err
+		}
+		if lhash == rhash {
+			report.Unchanged++
+			continue
+		}
+		report.Changed = append(report.Changed, RuleDrift{
+			Name:         name,
+			LocalHash:    lhash,
+			DeployedHash: rhash,
+			Differences: exprDiff(ra.NativeRep().Expr(), ra.NativeRep().SourceInfo(),
+				la.NativeRep().Expr(), la.NativeRep().SourceInfo()),
+		})
+	}
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Slice(report.Changed, func(i, j int) bool { return report.Changed[i].Name < report.Changed[j].Name })
+	return report, nil
+}
+
+// canonicalCompiler returns a function compiling expressions for comparison by canonicalHash,
+// tracking macro calls so that the canonical form of an expression retains its macros.
+func (r *Rules) canonicalCompiler() (func(expr string) (*cel.Ast, error), error) {
+	env, err := r.env.Extend(cel.EnableMacroCallTracking())
+	if err != nil {
+		return nil, err
+	}
+	return func(expr string) (*cel.Ast, error) {
+		a, iss := env.Compile(expr)
+		if iss.Err() != nil {
+			return nil, r.explainIssues(expr, iss)
+		}
+		return a, nil
+	}, nil
+}
+
+// canonicalHash returns the hex-encoded hash of the canonical form of a checked expression.
+func canonicalHash(a *cel.Ast) (string, error) {
+	out, err := unparse(a.NativeRep().Expr(), a.NativeRep().SourceInfo())
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(out))
+	return hex.EncodeToString(h[:]), nil
+}
+
+// unparse returns the canonical single line form of an expression.
+func unparse(e ast.Expr, info *ast.SourceInfo) (string, error) {
+	return parser.Unparse(e, info, parser.WrapOnColumn(math.MaxInt))
+}
+
+// exprDiff returns the outermost sub-expressions which differ between two expressions,
+// descending into calls, selections, and lists whose shape is the same on both sides.
+func exprDiff(a ast.Expr, aInfo *ast.SourceInfo, b ast.Expr, bInfo *ast.SourceInfo) []ExprDifference {
+	as, aErr := unparse(a, aInfo)
+	bs, bErr := unparse(b, bInfo)
+	if aErr == nil && bErr == nil && as == bs {
+		return nil
+	}
+	var aKids, bKids []ast.Expr
+	if a.Kind() == b.Kind() {
+		switch a.Kind() {
+		case ast.CallKind:
+			ac, bc := a.AsCall(), b.AsCall()
+			if ac.FunctionName() == bc.FunctionName() && ac.IsMemberFunction() == bc.IsMemberFunction() &&
+				len(ac.Args()) == len(bc.Args()) {
+				if ac.IsMemberFunction() {
+					aKids, bKids = append(aKids, ac.Target()), append(bKids, bc.Target())
+				}
+				aKids, bKids = append(aKids, ac.Args()...), append(bKids, bc.Args()...)
+			}
+		case ast.SelectKind:
+			asel, bsel := a.AsSelect(), b.AsSelect()
+			if asel.FieldName() == bsel.FieldName() && asel.IsTestOnly() == bsel.IsTestOnly() {
+				aKids, bKids = []ast.Expr{asel.Operand()}, []ast.Expr{bsel.Operand()}
+			}
+		case ast.ListKind:
+			if len(a.AsList().Elements()) == len(b.AsList().Elements()) {
+				aKids, bKids = a.AsList().Elements(), b.AsList().Elements()
+			}
+		}
+	}
+	var diffs []ExprDifference
+	for i := range aKids {
+		diffs = append(diffs, exprDiff(aKids[i], aInfo, bKids[i], bInfo)...)
+	}
+	if len(diffs) == 0 {
+		diffs = []ExprDifference{{Deployed: as, Local: bs}}
+	}
+	return diffs
+}
@@ -0,0 +1,577 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+const deployedPolicy = `{
+  "name": "edge-policy",
+  "rules": [
+    {
+      "priority": 1000,
+      "description": "admin-path",
+      "action": "deny(403)",
+      "match": {"expr": {"expression": "request.path.lower().startsWith(\"/admin\")"}}
+    },
+    {
+      "priority": 1100,
+      "description": "internal-post",
+      "action": "deny(403)",
+      "match": {"expr": {"expression": "request.method == 'POST' && inIpRange(origin.ip, '10.0.0.0/8')"}}
+    },
+    {
+      "priority": 1200,
+      "description": "legacy-block",
+      "action": "deny(404)",
+      "match": {"expr": {"expression": "origin.region_code == 'XX'"}}
+    },
+    {
+      "priority": 2147483647,
+      "description": "default rule",
+      "action": "allow",
+      "match": {"versionedExpr": "SRC_IPS_V1", "config": {"srcIpRanges": ["*"]}}
+    }
+  ]
+}`
+
+func TestDrift(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	policy, err := cloudarmor.SecurityPolicyFromJSON([]byte(deployedPolicy))
+	if err != nil {
+		t.Fatalf("SecurityPolicyFromJSON() failed: %v", err)
+	}
+	local := map[string]string{
+		// Formatting differences alone are not drift.
+		"admin-path":    "(request.path.lower()).startsWith('/admin')",
+		"internal-post": "request.method == 'PUT' && inIpRange(origin.ip, '10.0.0.0/8')",
+		"wp-login":      "request.path.lower().contains('wp-login')",
+	}
+	report, err := rules.Drift(local, policy)
+	if err != nil {
+		t.Fatalf("Drift() failed: %v", err)
+	}
+	if !report.Drifted() {
+		t.Error("Drifted() returned false, wanted true")
+	}
+	if !reflect.DeepEqual(report.Added, []string{"wp-login"}) {
+		t.Errorf("Drift() added %v, wanted [wp-login]", report.Added)
+	}
+	if !reflect.DeepEqual(report.Removed, []string{"legacy-block"}) {
+		t.Errorf("Drift() removed %v, wanted [legacy-block]", report.Removed)
+	}
+	if report.Unchanged != 1 {
+		t.Errorf("Drift() reported %d unchanged rules, wanted 1", report.Unchanged)
+	}
+	if len(report.Changed) != 1 || report.Changed[0].Name != "internal-post" {
+		t.Fatalf("Drift() changed %v, wanted internal-post", report.Changed)
+	}
+	want := []cloudarmor.ExprDifference{{Deployed: `"POST"`, Local: `"PUT"`}}
+	if got := report.Changed[0].Differences; !reflect.DeepEqual(got, want) {
+		t.Errorf("Drift() differences %v, wanted %v", got, want)
+	}
+	if report.Changed[0].LocalHash == report.Changed[0].DeployedHash {
+		t.Error("Drift() reported equal hashes for a changed rule")
+	}
+
+	local["internal-post"] = "request.method == 'POST' && inIpRange(origin.ip, '10.0.0.0/8')"
+	local["legacy-block"] = "origin.region_code == \"XX\""
+	delete(local, "wp-login")
+	report, err = rules.Drift(local, policy)
+	if err != nil {
+		t.Fatalf("Drift() failed: %v", err)
+	}
+	if report.Drifted() || report.Unchanged != 3 {
+		t.Errorf("Drift() of equivalent rules returned %+v, wanted no drift", report)
+	}
+
+	// A deployed action unknown to the model is drift, yet the expressions are still compared.
+	policy.Rules[2].Action = "preview_action"
+	local["internal-post"] = "request.method == 'PUT' && inIpRange(origin.ip, '10.0.0.0/8')"
+	report, err = rules.Drift(local, policy)
+	if err != nil {
+		t.Fatalf("Drift() failed: %v", err)
+	}
+	if len(report.Invalid) != 1 || !strings.Contains(report.Invalid[0].Error(), `rule legacy-block: unknown action "preview_action"`) {
+		t.Errorf("Drift() invalid %v, wanted the unknown action of legacy-block", report.Invalid)
+	}
+	if !report.Drifted() || len(report.Changed) != 1 || report.Unchanged != 2 {
+		t.Errorf("Drift() with an invalid rule returned %+v, wanted internal-post changed and drift", report)
+	}
+}
+
+func TestDriftErrors(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	policy, err := cloudarmor.SecurityPolicyFromJSON([]byte(deployedPolicy))
+	if err != nil {
+		t.Fatalf("SecurityPolicyFromJSON() failed: %v", err)
+	}
+	_, err = rules.Drift(map[string]string{"admin-path": "request.path.startsWith("}, policy)
+	if err == nil || !strings.Contains(err.Error(), `local rule "admin-path"`) {
+		t.Errorf("Drift() with an invalid local rule returned %v, wanted a compile error", err)
+	}
+	policy.Rules = append(policy.Rules, policy.Rules[0])
+	if _, err := rules.Drift(nil, policy); err == nil || !strings.Contains(err.Error(), "duplicate rule name") {
+		t.Errorf("Drift() with duplicate deployed rules returned %v, wanted error", err)
+	}
+	if _, err := cloudarmor.SecurityPolicyFromJSON([]byte("{")); err == nil {
+		t.Error("SecurityPolicyFromJSON() with invalid JSON succeeded, wanted error")
+	}
+}
+
+func TestSecurityPolicyValidate(t *testing.T) {
+	policy, err := cloudarmor.SecurityPolicyFromJSON([]byte(deployedPolicy))
+	if err != nil {
+		t.Fatalf("SecurityPolicyFromJSON() failed: %v", err)
+	}
+	if err := policy.Validate(); err != nil {
+		t.Errorf("Validate() of the deployed policy returned %v, wanted nil", err)
+	}
+	if status, ok := policy.Rules[2].DenyStatus(); !ok || status != 404 {
+		t.Errorf("DenyStatus() of deny(404) = %d, %v, wanted 404", status, ok)
+	}
+	if status, ok := policy.Rules[3].DenyStatus(); ok {
+		t.Errorf("DenyStatus() of allow = %d, %v, wanted false", status, ok)
+	}
+	tests := []struct {
+		name string
+		rule string
+		err  string
+	}{
+		{
+			name: "recaptcha redirect",
+			rule: `{"action": "redirect", "redirectOptions": {"type": "GOOGLE_RECAPTCHA"}}`,
+		},
+		{
+			name: "external redirect",
+			rule: `{"action": "redirect", "redirectOptions": {"type": "EXTERNAL_302", "target": "https://example.com/blocked"}}`,
+		},
+		{
+			name: "throttle",
+			rule: `{"action": "throttle", "rateLimitOptions": {"conformAction": "allow", "exceedAction": "deny(429)",
+				"rateLimitThreshold": {"count": 100, "intervalSec": 60}, "enforceOnKey": "IP"}}`,
+		},
+		{
+			name: "rate based ban",
+			rule: `{"action": "rate_based_ban", "rateLimitOptions": {"conformAction": "allow", "exceedAction": "redirect",
+				"exceedRedirectOptions": {"type": "GOOGLE_RECAPTCHA"}, "rateLimitThreshold": {"count": 100, "intervalSec": 60},
+				"banThreshold": {"count": 1000, "intervalSec": 600}, "banDurationSec": 3600,
+				"enforceOnKey": "HTTP_HEADER", "enforceOnKeyName": "x-api-key"}}`,
+		},
+		{
+			name: "unknown action",
+			rule: `{"action": "block"}`,
+			err:  `unknown action "block"`,
+		},
+		{
+			name: "redirect without options",
+			rule: `{"action": "redirect"}`,
+			err:  "redirect requires redirectOptions",
+		},
+		{
+			name: "recaptcha redirect with target",
+			rule: `{"action": "redirect", "redirectOptions": {"type": "GOOGLE_RECAPTCHA", "target": "https://example.com"}}`,
+			err:  "does not accept a target",
+		},
+		{
+			name: "external redirect without target",
+			rule: `{"action": "redirect", "redirectOptions": {"type": "EXTERNAL_302"}}`,
+			err:  "requires an http or https target",
+		},
+		{
+			name: "redirect options on allow",
+			rule: `{"action": "allow", "redirectOptions": {"type": "GOOGLE_RECAPTCHA"}}`,
+			err:  "redirectOptions are not allowed with allow",
+		},
+		{
+			name: "throttle without options",
+			rule: `{"action": "throttle"}`,
+			err:  "throttle requires rateLimitOptions",
+		},
+		{
+			name: "throttle with ban",
+			rule: `{"action": "throttle", "rateLimitOptions": {"conformAction": "allow", "exceedAction": "deny(429)",
+				"rateLimitThreshold": {"count": 100, "intervalSec": 60}, "banDurationSec": 600}}`,
+			err: "not allowed with throttle",
+		},
+		{
+			name: "ban without duration",
+			rule: `{"action": "rate_based_ban", "rateLimitOptions": {"conformAction": "allow", "exceedAction": "deny(403)",
+				"rateLimitThreshold": {"count": 100, "intervalSec": 60}}}`,
+			err: "banDurationSec 0 is not one of the allowed intervals",
+		},
+		{
+			name: "invalid interval",
+			rule: `{"action": "throttle", "rateLimitOptions": {"conformAction": "allow", "exceedAction": "deny(429)",
+				"rateLimitThreshold": {"count": 100, "intervalSec": 45}}}`,
+			err: "rateLimitThreshold intervalSec 45",
+		},
+		{
+			name: "header key without name",
+			rule: `{"action": "throttle", "rateLimitOptions": {"conformAction": "allow", "exceedAction": "deny(429)",
+				"rateLimitThreshold": {"count": 100, "intervalSec": 60}, "enforceOnKey": "HTTP_HEADER"}}`,
+			err: "enforceOnKeyName is required",
+		},
+		{
+			name: "header action",
+			rule: `{"action": "allow", "headerAction": {"requestHeadersToAdds": [
+				{"headerName": "X-Verified-Bot", "headerValue": "googlebot"}, {"headerName": "x-empty", "headerValue": ""}]}}`,
+		},
+		{
+			name: "header action on deny",
+			rule: `{"action": "deny(403)", "headerAction": {"requestHeadersToAdds": [{"headerName": "x-a", "headerValue": "b"}]}}`,
+			err:  "headerAction is not allowed with deny(403)",
+		},
+		{
+			name: "invalid header name",
+			rule: `{"action": "allow", "headerAction": {"requestHeadersToAdds": [{"headerName": "x bot", "headerValue": "b"}]}}`,
+			err:  `header name "x bot" is not a valid HTTP token`,
+		},
+		{
+			name: "invalid header value",
+			rule: `{"action": "allow", "headerAction": {"requestHeadersToAdds": [{"headerName": "x-a", "headerValue": "b\r\nx-b: c"}]}}`,
+			err:  "contains a control character",
+		},
+		{
+			name: "duplicate header",
+			rule: `{"action": "allow", "headerAction": {"requestHeadersToAdds": [
+				{"headerName": "x-a", "headerValue": "b"}, {"headerName": "X-A", "headerValue": "c"}]}}`,
+			err: `header "X-A" is inserted more than once`,
+		},
+		{
+			name: "deny statuses",
+			rule: `{"action": "deny(404)"}, {"action": "deny(429)"}, {"action": "deny(502)"}`,
+		},
+		{
+			name: "invalid deny status",
+			rule: `{"action": "deny(418)"}`,
+			err:  `action "deny(418)" must deny with one of the status codes 403, 404, 429, or 502`,
+		},
+		{
+			name: "malformed deny",
+			rule: `{"action": "deny"}`,
+			err:  `action "deny" must deny with one of the status codes`,
+		},
+		{
+			name: "invalid exceed status",
+			rule: `{"action": "throttle", "rateLimitOptions": {"conformAction": "allow", "exceedAction": "deny(500)",
+				"rateLimitThreshold": {"count": 100, "intervalSec": 60}}}`,
+			err: `exceedAction "deny(500)" must deny`,
+		},
+		{
+			name: "rate limit options on deny",
+			rule: `{"action": "deny(403)", "rateLimitOptions": {}}`,
+			err:  "rateLimitOptions are not allowed with deny(403)",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := cloudarmor.SecurityPolicyFromJSON([]byte(`{"name": "p", "rules": [` + tc.rule + `]}`))
+			if err != nil {
+				t.Fatalf("SecurityPolicyFromJSON() failed: %v", err)
+			}
+			err = policy.Validate()
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("Validate() returned %v, wanted nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Validate() returned %v, wanted error containing %q", err, tc.err)
+			}
+		})
+	}
+}
+
+const evaluatedPolicy = `{
+  "name": "edge-policy",
+  "rules": [
+    {
+      "priority": 100,
+      "description": "decode",
+      "action": "deny(403)",
+      "match": {"expr": {"expression": "request.query.base64Decode() == 'attack'"}}
+    },
+    {
+      "priority": 200,
+      "description": "preview-admin",
+      "action": "deny(404)",
+      "preview": true,
+      "match": {"expr": {"expression": "request.path.startsWith('/admin')"}}
+    },
+    {
+      "priority": 300,
+      "description": "admin",
+      "action": "deny(403)",
+      "match": {"expr": {"expression": "request.path.startsWith('/admin')"}}
+    },
+    {
+      "priority": 150,
+      "description": "internal",
+      "action": "allow",
+      "headerAction": {"requestHeadersToAdds": [{"headerName": "X-Internal", "headerValue": "1"}]},
+      "match": {"versionedExpr": "SRC_IPS_V1", "config": {"srcIpRanges": ["10.0.0.0/8"]}}
+    },
+    {
+      "priority": 2147483647,
+      "description": "default rule",
+      "action": "deny(502)",
+      "match": {"versionedExpr": "SRC_IPS_V1", "config": {"srcIpRanges": ["*"]}}
+    }
+  ]
+}`
+
+func TestPolicyEvaluator(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	policy, err := cloudarmor.SecurityPolicyFromJSON([]byte(evaluatedPolicy))
+	if err != nil {
+		t.Fatalf("SecurityPolicyFromJSON() failed: %v", err)
+	}
+	request := func(path, query, ip string) *cloudarmor.Variables {
+		return cloudarmor.SafeVariables(&cloudarmor.Variables{
+			Request: &cloudarmor.Request{Path: path, Query: query},
+			Origin:  &cloudarmor.Origin{IP: ip},
+		})
+	}
+	tests := []struct {
+		name       string
+		vars       *cloudarmor.Variables
+		wantRule   string
+		wantAction string
+		wantStatus int
+		wantErrors []string
+		previewed  int
+	}{
+		{
+			name:       "error as non-match",
+			vars:       request("/admin", "!!!", "203.0.113.7"),
+			wantRule:   "admin",
+			wantAction: "deny(403)",
+			wantStatus: 403,
+			wantErrors: []string{"decode"},
+			previewed:  1,
+		},
+		{
+			name:       "source ranges",
+			vars:       request("/admin", "", "10.1.2.3"),
+			wantRule:   "internal",
+			wantAction: "allow",
+		},
+		{
+			name:       "default rule",
+			vars:       request("/", "", "203.0.113.7"),
+			wantRule:   "default rule",
+			wantAction: "deny(502)",
+			wantStatus: 502,
+		},
+	}
+	e, err := rules.NewPolicyEvaluator(policy)
+	if err != nil {
+		t.Fatalf("NewPolicyEvaluator() failed: %v", err)
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := e.Evaluate(tc.vars)
+			if err != nil {
+				t.Fatalf("Evaluate() failed: %v", err)
+			}
+			if res.Rule == nil || res.Rule.Name() != tc.wantRule || res.Action != tc.wantAction {
+				t.Errorf("Evaluate() = %+v, wanted rule %s with action %s", res, tc.wantRule, tc.wantAction)
+			}
+			if res.Status != tc.wantStatus {
+				t.Errorf("Evaluate() status = %d, wanted %d", res.Status, tc.wantStatus)
+			}
+			var errored []string
+			for _, re := range res.Errors {
+				errored = append(errored, re.Rule.Name())
+			}
+			if !reflect.DeepEqual(errored, tc.wantErrors) {
+				t.Errorf("Evaluate() errored rules %v, wanted %v", errored, tc.wantErrors)
+			}
+			if len(res.Previewed) != tc.previewed {
+				t.Errorf("Evaluate() previewed %d rules, wanted %d", len(res.Previewed), tc.previewed)
+			}
+		})
+	}
+
+	abort, err := rules.NewPolicyEvaluator(policy, cloudarmor.WithErrorPolicy(cloudarmor.ErrorAbort))
+	if err != nil {
+		t.Fatalf("NewPolicyEvaluator() failed: %v", err)
+	}
+	res, err := abort.Evaluate(request("/admin", "!!!", "203.0.113.7"))
+	if err == nil || !strings.Contains(err.Error(), "rule decode") || res.Rule != nil || len(res.Errors) != 1 {
+		t.Errorf("Evaluate() under ErrorAbort = %+v, %v, wanted an error from rule decode", res, err)
+	}
+
+	policy.Rules[3].Match.VersionedExpr = "SRC_IPS_V2"
+	if _, err := rules.NewPolicyEvaluator(policy); err == nil || !strings.Contains(err.Error(), "rule internal: unsupported match") {
+		t.Errorf("NewPolicyEvaluator() with an unsupported match returned %v, wanted error", err)
+	}
+}
+
+func TestSecurityPolicyYAML(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	policy, err := cloudarmor.SecurityPolicyFromJSON([]byte(evaluatedPolicy))
+	if err != nil {
+		t.Fatalf("SecurityPolicyFromJSON() failed: %v", err)
+	}
+	policy.Rules[2].Match.Expr.Expression = "request.path.startsWith(   '/admin' )"
+	out, err := rules.SecurityPolicyToYAML(policy)
+	if err != nil {
+		t.Fatalf("SecurityPolicyToYAML() failed: %v", err)
+	}
+	if !strings.HasPrefix(string(out), "formatVersion: 1\n") || !strings.Contains(string(out), `request.path.startsWith("/admin")`) {
+		t.Errorf("SecurityPolicyToYAML() = %s, wanted formatVersion 1 and canonical expressions", out)
+	}
+	if got := policy.Rules[2].Expression(); got != "request.path.startsWith(   '/admin' )" {
+		t.Errorf("SecurityPolicyToYAML() modified the policy, expression = %q", got)
+	}
+	loaded, err := cloudarmor.SecurityPolicyFromYAML(out)
+	if err != nil {
+		t.Fatalf("SecurityPolicyFromYAML() failed: %v", err)
+	}
+	if loaded.Name != policy.Name || len(loaded.Rules) != len(policy.Rules) {
+		t.Fatalf("SecurityPolicyFromYAML() = %+v, wanted the rules of %s", loaded, policy.Name)
+	}
+	if !reflect.DeepEqual(loaded.Rules[3], policy.Rules[3]) || !loaded.Rules[1].Preview {
+		t.Errorf("SecurityPolicyFromYAML() rules = %+v, wanted the fields of the exported rules", loaded.Rules)
+	}
+	again, err := rules.SecurityPolicyToYAML(loaded)
+	if err != nil || string(again) != string(out) {
+		t.Errorf("SecurityPolicyToYAML() of the loaded policy = %s, %v, wanted %s", again, err, out)
+	}
+	if _, err := rules.NewPolicyEvaluator(loaded); err != nil {
+		t.Errorf("NewPolicyEvaluator() of the loaded policy failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		doc     string
+		wantErr string
+	}{
+		{doc: "name: p\nrules: []\n", wantErr: "formatVersion is required"},
+		{doc: "formatVersion: 2\nname: p\n", wantErr: "formatVersion 2 is newer than version 1"},
+		{doc: "formatVersion: 1\nname: p\nrules:\n  - priority: 1\n    acton: allow\n", wantErr: "field acton not found"},
+		{doc: "formatVersion: 1\nname: p\nrules:\n  - priority: one\n", wantErr: "cannot unmarshal"},
+		{doc: "formatVersion: 1\nname: p\nrules:\n  - priority: 1\n    action: allow\n", wantErr: "rule priority-1 has no match"},
+	} {
+		if _, err := cloudarmor.SecurityPolicyFromYAML([]byte(tc.doc)); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("SecurityPolicyFromYAML(%q) returned error %v, wanted %q", tc.doc, err, tc.wantErr)
+		}
+	}
+}
+
+func TestGuardrails(t *testing.T) {
+	g, err := cloudarmor.GuardrailsFromYAML([]byte(`
+forbid_allow_ranges: [0.0.0.0/0, "::/0"]
+require_preview: true
+min_rate_limit_count: 100
+`))
+	if err != nil {
+		t.Fatalf("GuardrailsFromYAML() failed: %v", err)
+	}
+	policy, err := cloudarmor.SecurityPolicyFromYAML([]byte(`
+formatVersion: 1
+name: edge-policy
+rules:
+  - priority: 100
+    description: open
+    action: allow
+    match: {versionedExpr: SRC_IPS_V1, config: {srcIpRanges: [10.0.0.0/8, 0.0.0.0/1, 0.0.0.0/0]}}
+  - priority: 200
+    description: previewed-open
+    action: allow
+    preview: true
+    match: {versionedExpr: SRC_IPS_V1, config: {srcIpRanges: ["*"]}}
+  - priority: 300
+    description: admin
+    action: deny(403)
+    match: {expr: {expression: "request.path.startsWith('/admin')"}}
+  - priority: 400
+    description: login
+    action: deny(404)
+    match: {expr: {expression: "request.path == '/login'"}}
+  - priority: 500
+    description: throttle
+    action: throttle
+    rateLimitOptions:
+      rateLimitThreshold: {count: 10, intervalSec: 60}
+      conformAction: allow
+      exceedAction: deny(429)
+    match: {expr: {expression: "true"}}
+  - priority: 2147483647
+    description: default rule
+    action: deny(502)
+    match: {versionedExpr: SRC_IPS_V1, config: {srcIpRanges: ["*"]}}
+`))
+	if err != nil {
+		t.Fatalf("SecurityPolicyFromYAML() failed: %v", err)
+	}
+	baseline := &cloudarmor.SecurityPolicy{Rules: []*cloudarmor.SecurityPolicyRule{
+		{Description: "open", Action: "allow"},
+		{Description: "admin", Action: "deny(403)", Preview: true},
+		{Description: "login", Action: "deny(403)", Preview: true},
+		{Description: "throttle", Action: "throttle"},
+	}}
+	var got []string
+	for _, v := range g.Check(policy, baseline) {
+		got = append(got, v.String())
+	}
+	want := []string{
+		"open: forbid_allow_ranges: allows 0.0.0.0/0, which covers a forbidden range",
+		"login: require_preview: deny(404) is enforced, but deny(403) was previewed",
+		"throttle: min_rate_limit_count: rateLimitThreshold count 10 is below 100",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check() = %q, wanted %q", got, want)
+	}
+
+	got = nil
+	for _, v := range g.Check(policy, nil) {
+		if v.Guardrail == "require_preview" {
+			got = append(got, v.Rule.Name())
+		}
+	}
+	if want := []string{"open", "admin", "login", "throttle"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Check() without a baseline violated require_preview on %v, wanted %v", got, want)
+	}
+
+	for _, doc := range []string{
+		"require_preveiw: true\n",
+		"forbid_allow_ranges: [0.0.0.0]\n",
+		"min_rate_limit_count: -1\n",
+	} {
+		if _, err := cloudarmor.GuardrailsFromYAML([]byte(doc)); err == nil {
+			t.Errorf("GuardrailsFromYAML(%q) succeeded, wanted error", doc)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+)
+
+// ErrorCode classifies the errors produced by evaluating an expression, so that test cases may
+// expect a class of error with the error_code key rather than a substring of its message, which
+// may change between versions.
+type ErrorCode string
+
+const (
+	// ErrorInvalidIP is produced by inIpRange for an address which cannot be parsed.
+	ErrorInvalidIP ErrorCode = "invalid_ip"
+	// ErrorInvalidIPRange is produced by inIpRange for a CIDR range which cannot be parsed.
+	ErrorInvalidIPRange ErrorCode = "invalid_ip_range"
+	// ErrorInvalidRange is produced by inRange for a lower bound exceeding the upper bound.
+	ErrorInvalidRange ErrorCode = "invalid_range"
+	// ErrorInvalidBase64 is produced by base64Decode for input which is not base64 encoded.
+	ErrorInvalidBase64 ErrorCode = "invalid_base64"
+	// ErrorInvalidURLEncoding is produced by urlDecode and urlDecodeUni for an invalid escape.
+	ErrorInvalidURLEncoding ErrorCode = "invalid_url_encoding"
+	// ErrorInvalidPunycode is produced by toPunycode and fromPunycode for a label which cannot
+	// be converted.
+	ErrorInvalidPunycode ErrorCode = "invalid_punycode"
+	// ErrorUnknownFingerprintList is produced by inFingerprintList for a list name which is not
+	// given to WithFingerprintLists.
+	ErrorUnknownFingerprintList ErrorCode = "unknown_fingerprint_list"
+	// ErrorNonFiniteScore is produced by reading a score attribute which is NaN or infinite.
+	ErrorNonFiniteScore ErrorCode = "non_finite_score"
+	// ErrorStringTooLong is produced by a function whose result exceeds the maximum string size.
+	ErrorStringTooLong ErrorCode = "string_too_long"
+	// ErrorCostLimitExceeded is produced by an evaluation which exceeds its cost limit.
+	ErrorCostLimitExceeded ErrorCode = "cost_limit_exceeded"
+	// ErrorNoSuchOverload is produced by a function applied to values of unsupported types.
+	ErrorNoSuchOverload ErrorCode = "no_such_overload"
+	// ErrorNoSuchKey is produced by indexing a map with a key which it does not contain.
+	ErrorNoSuchKey ErrorCode = "no_such_key"
+	// ErrorNoSuchAttribute is produced by referencing an attribute which is not bound.
+	ErrorNoSuchAttribute ErrorCode = "no_such_attribute"
+	// ErrorTypeConversion is produced by a conversion such as int() of an unparseable value.
+	ErrorTypeConversion ErrorCode = "type_conversion"
+	// ErrorDivisionByZero is produced by dividing, or taking the modulus, by zero.
+	ErrorDivisionByZero ErrorCode = "division_by_zero"
+	// ErrorOverflow is produced by arithmetic which overflows its type.
+	ErrorOverflow ErrorCode = "overflow"
+	// ErrorInternal is produced by a panic within a function or the evaluation of an expression,
+	// which is recovered rather than unwinding the process.
+	ErrorInternal ErrorCode = "internal"
+)
+
+// errorCodePrefixes classifies the errors which the CEL runtime reports without a type, by the
+// prefix of their message.
+var errorCodePrefixes = []struct {
+	prefix string
+	code   ErrorCode
+}{
+	{"no such overload", ErrorNoSuchOverload},
+	{"no such key", ErrorNoSuchKey},
+	{"no such attribute", ErrorNoSuchAttribute},
+	{"type conversion error", ErrorTypeConversion},
+	{"division by zero", ErrorDivisionByZero},
+	{"modulus by zero", ErrorDivisionByZero},
+	{"integer overflow", ErrorOverflow},
+	{"unsigned integer overflow", ErrorOverflow},
+	{"internal error", ErrorInternal},
+}
+
+// errorCodes contains every ErrorCode, for validating the error_code of test cases.
+var errorCodes = map[ErrorCode]bool{
+	ErrorInvalidIP:              true,
+	ErrorInvalidIPRange:         true,
+	ErrorInvalidRange:           true,
+	ErrorInvalidBase64:          true,
+	ErrorInvalidURLEncoding:     true,
+	ErrorInvalidPunycode:        true,
+	ErrorUnknownFingerprintList: true,
+	ErrorNonFiniteScore:         true,
+	ErrorStringTooLong:          true,
+	ErrorCostLimitExceeded:      true,
+	ErrorNoSuchOverload:         true,
+	ErrorNoSuchKey:              true,
+	ErrorNoSuchAttribute:        true,
+	ErrorTypeConversion:         true,
+	ErrorDivisionByZero:         true,
+	ErrorOverflow:               true,
+	ErrorInternal:               true,
+}
+
+// AllErrorCodes returns every ErrorCode, in lexical order.
+func AllErrorCodes() []ErrorCode {
+	all := make([]ErrorCode, 0, len(errorCodes))
+	for code := range errorCodes {
+		all = append(all, code)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return all
+}
+
+// ParseErrorCode returns the ErrorCode with the given name, e.g. invalid_ip.
+func ParseErrorCode(name string) (ErrorCode, error) {
+	if code := ErrorCode(name); errorCodes[code] {
+		return code, nil
+	}
+	return "", fmt.Errorf("unknown error code %q, must be one of %v", name, AllErrorCodes())
+}
+
+// EvalError is an evaluation error produced by a Cloud Armor function, classified by its code.
+type EvalError struct {
+	Code    ErrorCode
+	Message string
+	// Stack is the stack of the goroutine which panicked, for an ErrorInternal recovered by
+	// Rules created with Debug.
+	Stack []byte
+}
+
+// Error implements the error interface.
+func (e *EvalError) Error() string {
+	return e.Message
+}
+
+// evalErr returns an error value classified by the code, whose message is formatted as by
+// types.NewErr.
+func evalErr(code ErrorCode, format string, args ...any) ref.Val {
+	return types.WrapErr(&EvalError{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// ErrorCodeOf returns the code classifying an error returned by the evaluation of an expression,
+// or the empty string if the error is not classified.
+func ErrorCodeOf(err error) ErrorCode {
+	var e *EvalError
+	var scoreErr *NonFiniteScoreError
+	var cancelled interpreter.EvalCancelledError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &e):
+		return e.Code
+	case errors.As(err, &scoreErr):
+		return ErrorNonFiniteScore
+	case errors.As(err, &cancelled) && cancelled.Cause == interpreter.CostLimitExceeded:
+		return ErrorCostLimitExceeded
+	}
+	msg := err.Error()
+	for _, p := range errorCodePrefixes {
+		if strings.HasPrefix(msg, p.prefix) {
+			return p.code
+		}
+	}
+	return ""
+}
@@ -0,0 +1,270 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package evasion tests whether a rule can be evaded by re-encoding the inputs which it matches,
+// using the tricks attackers apply to slip a request past a filter while the origin server still
+// interprets it as the original request.
+package evasion
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/cel-go/common/types"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// Technique is an encoding trick applied to a single attribute value.
+type Technique struct {
+	Name string
+	// Normalization suggests the function chain which undoes the technique within a rule.
+	Normalization string
+	// pathOnly restricts the technique to request.path.
+	pathOnly bool
+	// variants returns the encodings of the value.
+	variants func(string) []string
+}
+
+// Techniques returns the built-in evasion techniques.
+func Techniques() []Technique {
+	return []Technique{
+		{
+			Name:          "url-encoding",
+			Normalization: "urlDecode()",
+			variants:      func(s string) []string { return []string{percentEncode(s)} },
+		},
+		{
+			Name:          "double-url-encoding",
+			Normalization: "urlDecode().urlDecode()",
+			variants: func(s string) []string {
+				return []string{strings.ReplaceAll(percentEncode(s), "%", "%25")}
+			},
+		},
+		{
+			Name:          "mixed-case",
+			Normalization: "lower()",
+			variants:      func(s string) []string { return []string{mixedCase(s)} },
+		},
+		{
+			Name:          "unicode-escape",
+			Normalization: "urlDecodeUni()",
+			variants:      func(s string) []string { return []string{unicodeEscape(s)} },
+		},
+		{
+			Name:          "null-byte",
+			Normalization: "contains() or startsWith() rather than == or endsWith()",
+			variants:      func(s string) []string { return []string{s + "%00", s + "\x00"} },
+		},
+		{
+			Name:          "path-traversal",
+			Normalization: "contains() or matches() tolerant of dot segments and repeated slashes",
+			pathOnly:      true,
+			variants: func(s string) []string {
+				rest, found := strings.CutPrefix(s, "/")
+				if !found {
+					return nil
+				}
+				return []string{"/./" + rest, "//" + rest, "/x/../" + rest}
+			},
+		},
+	}
+}
+
+// Evasion is a re-encoded input which the rule no longer matches.
+type Evasion struct {
+	// TestCase is the name of the matching test case whose input was re-encoded.
+	TestCase string
+	// Attribute is the re-encoded attribute, e.g. request.path or request.headers['user-agent'].
+	Attribute string
+	Technique string
+	Original  string
+	Mutated   string
+}
+
+// Report summarizes the re-encoded inputs which evaded a rule.
+type Report struct {
+	Expr string
+	// Attempts is the number of re-encoded inputs evaluated.
+	Attempts int
+	Evasions []Evasion
+}
+
+// Normalizations returns the suggested normalization of each technique which evaded the rule,
+// keyed by technique name.
+func (r *Report) Normalizations() map[string]string {
+	suggestions := map[string]string{}
+	for _, t := range Techniques() {
+		for _, e := range r.Evasions {
+			if e.Technique == t.Name {
+				suggestions[t.Name] = t.Normalization
+			}
+		}
+	}
+	return suggestions
+}
+
+// Run re-encodes the request.path, request.query, request.body, and request.headers values of
+// every test case which expects the rule to match, using each of the techniques, and reports
+// the re-encoded inputs for which the rule no longer evaluates to true.
+//
+// The return value is an error if the expression fails to compile or if no test case matches.
+func Run(r *cloudarmor.Rules, expr string, tests []*cloudarmor.TestCase) (*Report, error) {
+	a, err := r.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	prg, err := r.Program(a)
+	if err != nil {
+		return nil, err
+	}
+	matches := func(act cloudarmor.Activation) bool {
+		out, _, err := prg.Eval(act)
+		return err == nil && out == types.True
+	}
+	report := &Report{Expr: expr}
+	seeds := 0
+	for _, tc := range tests {
+		if !tc.ExpectOutput || tc.When == nil || !matches(tc.When) {
+			continue
+		}
+		seeds++
+		for _, target := range targets(tc.When) {
+			for _, t := range Techniques() {
+				if t.pathOnly && target.attr != "request.path" {
+					continue
+				}
+				for _, mutated := range t.variants(target.value) {
+					if mutated == target.value {
+						continue
+					}
+					report.Attempts++
+					if matches(cloudarmor.Overlay(tc.When, target.override(mutated))) {
+						continue
+					}
+					report.Evasions = append(report.Evasions, Evasion{
+						TestCase:  tc.Name,
+						Attribute: target.name,
+						Technique: t.Name,
+						Original:  target.value,
+						Mutated:   mutated,
+					})
+				}
+			}
+		}
+	}
+	if seeds == 0 {
+		return nil, errors.New("no test case expects the rule to match")
+	}
+	return report, nil
+}
+
+// target is an attribute value which is re-encoded.
+type target struct {
+	// attr is the overridden attribute and name describes the value within it.
+	attr, name string
+	value      string
+	override   func(string) cloudarmor.Attributes
+}
+
+// targets returns the non-empty string values of the request which may be re-encoded.
+func targets(v *cloudarmor.Variables) []target {
+	if v.Request == nil {
+		return nil
+	}
+	var ts []target
+	scalar := func(attr, value string) {
+		if value == "" {
+			return
+		}
+		ts = append(ts, target{attr: attr, name: attr, value: value,
+			override: func(s string) cloudarmor.Attributes { return cloudarmor.Attributes{attr: s} }})
+	}
+	scalar("request.path", v.Request.Path)
+	scalar("request.query", v.Request.Query)
+	scalar("request.body", v.Request.Body)
+	keys := make([]string, 0, len(v.Request.Headers))
+	for k := range v.Request.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if v.Request.Headers[key] == "" {
+			continue
+		}
+		ts = append(ts, target{
+			attr:  "request.headers",
+			name:  fmt.Sprintf("request.headers['%s']", key),
+			value: v.Request.Headers[key],
+			override: func(s string) cloudarmor.Attributes {
+				headers := make(map[string]string, len(v.Request.Headers))
+				for k, val := range v.Request.Headers {
+					headers[k] = val
+				}
+				headers[key] = s
+				return cloudarmor.Attributes{"request.headers": headers}
+			},
+		})
+	}
+	return ts
+}
+
+// percentEncode percent-encodes the letters and digits of the value, leaving the separators
+// which give it structure, such as '/', '=', and '&', intact.
+func percentEncode(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			fmt.Fprintf(&sb, "%%%02X", r)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// unicodeEscape encodes the letters and digits of the value as %uXXXX escapes.
+func unicodeEscape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			fmt.Fprintf(&sb, "%%u%04X", r)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// mixedCase alternates the case of the letters of the value, starting with upper case.
+func mixedCase(s string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			sb.WriteRune(r)
+			continue
+		}
+		if upper {
+			sb.WriteRune(unicode.ToUpper(r))
+		} else {
+			sb.WriteRune(unicode.ToLower(r))
+		}
+		upper = !upper
+	}
+	return sb.String()
+}
@@ -0,0 +1,120 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evasion_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/evasion"
+)
+
+func TestRun(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tests := []*cloudarmor.TestCase{
+		cloudarmor.SafeTestCase(&cloudarmor.TestCase{
+			Name:         "admin",
+			ExpectOutput: true,
+			When:         &cloudarmor.Variables{Request: &cloudarmor.Request{Path: "/admin"}},
+		}),
+		cloudarmor.SafeTestCase(&cloudarmor.TestCase{
+			Name: "public",
+			When: &cloudarmor.Variables{Request: &cloudarmor.Request{Path: "/public"}},
+		}),
+	}
+	runTests := []struct {
+		name string
+		expr string
+		want []string
+	}{
+		{
+			name: "raw path",
+			expr: "request.path.startsWith('/admin')",
+			want: []string{"double-url-encoding", "mixed-case", "path-traversal", "unicode-escape", "url-encoding"},
+		},
+		{
+			name: "normalized path",
+			expr: "request.path.urlDecodeUni().lower().contains('/admin')",
+			want: []string{"double-url-encoding"},
+		},
+		{
+			name: "exact match",
+			expr: "request.path.urlDecodeUni().urlDecodeUni().lower() == '/admin'",
+			want: []string{"null-byte", "path-traversal"},
+		},
+	}
+	for _, tst := range runTests {
+		tc := tst
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := evasion.Run(rules, tc.expr, tests)
+			if err != nil {
+				t.Fatalf("evasion.Run() returned error: %v", err)
+			}
+			var got []string
+			for technique := range report.Normalizations() {
+				got = append(got, technique)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("evasion.Run() evaded with %v, want %v; evasions: %+v", got, tc.want, report.Evasions)
+			}
+			for _, e := range report.Evasions {
+				if e.TestCase != "admin" || e.Attribute != "request.path" {
+					t.Errorf("evasion %+v, wanted an evasion of request.path by admin", e)
+				}
+			}
+		})
+	}
+
+	if _, err := evasion.Run(rules, "request.path == '/login'", tests); err == nil {
+		t.Error("evasion.Run() succeeded without a matching test case, wanted error")
+	}
+}
+
+func TestRunHeaders(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tests := []*cloudarmor.TestCase{
+		cloudarmor.SafeTestCase(&cloudarmor.TestCase{
+			Name:         "scanner",
+			ExpectOutput: true,
+			When: &cloudarmor.Variables{Request: &cloudarmor.Request{
+				Headers: map[string]string{"user-agent": "sqlmap", "host": "example.com"},
+			}},
+		}),
+	}
+	report, err := evasion.Run(rules, "request.headers['user-agent'].contains('sqlmap')", tests)
+	if err != nil {
+		t.Fatalf("evasion.Run() returned error: %v", err)
+	}
+	for _, e := range report.Evasions {
+		if e.Attribute != "request.headers['user-agent']" {
+			t.Errorf("evasion of %s, wanted only request.headers['user-agent'] to be evaded", e.Attribute)
+		}
+		if e.Technique == "mixed-case" && e.Mutated != "SqLmAp" {
+			t.Errorf("mixed-case evasion mutated %q to %q, want %q", e.Original, e.Mutated, "SqLmAp")
+		}
+	}
+	if _, found := report.Normalizations()["mixed-case"]; !found {
+		t.Errorf("report.Normalizations() = %v, wanted mixed-case", report.Normalizations())
+	}
+}
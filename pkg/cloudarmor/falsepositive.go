@@ -0,0 +1,192 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"gopkg.in/yaml.v3"
+)
+
+// maxMatchedLines is the number of matching corpus lines recorded in a CorpusReport.
+const maxMatchedLines = 10
+
+// MatchRate is a fraction of requests, written in YAML either as a fraction such as 0.001 or as
+// a percentage such as 0.1%.
+type MatchRate float64
+
+// ParseMatchRate returns the rate written either as a fraction such as 0.001 or as a percentage
+// such as 0.1%.
+func ParseMatchRate(value string) (MatchRate, error) {
+	s, percent := strings.CutSuffix(strings.TrimSpace(value), "%")
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid match rate %q, want a fraction such as 0.001 or a percentage such as 0.1%%", value)
+	}
+	if percent {
+		f /= 100
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("match rate %s is not between 0 and 100%%", value)
+	}
+	return MatchRate(f), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (m *MatchRate) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: invalid match rate %q, want a fraction such as 0.001 or a percentage such as 0.1%%", node.Line, node.Value)
+	}
+	rate, err := ParseMatchRate(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*m = rate
+	return nil
+}
+
+// String formats the rate as a percentage.
+func (m MatchRate) String() string {
+	return strconv.FormatFloat(float64(m)*100, 'g', -1, 64) + "%"
+}
+
+// CorpusReport summarizes the evaluation of a rule against a corpus of requests.
+type CorpusReport struct {
+	Requests int
+	Matches  int
+	// Errors is the number of requests for which evaluation failed. Cloud Armor does not apply
+	// a rule which fails to evaluate, so errors are not counted as matches.
+	Errors int
+	// MatchedLines contains the lines of the first matching requests within the corpus.
+	MatchedLines []int
+	// Reproducer is the first matching request, reduced to the attributes which the match
+	// depends on.
+	Reproducer *Reproducer
+}
+
+// MatchRate returns the fraction of the corpus which the rule matched.
+func (c *CorpusReport) MatchRate() MatchRate {
+	if c.Requests == 0 {
+		return 0
+	}
+	return MatchRate(float64(c.Matches) / float64(c.Requests))
+}
+
+// EvaluateCorpus evaluates the program against each request of a corpus of known-good
+// traffic, reading one request at a time so that memory use does not grow with the corpus.
+//
+// The corpus holds one variables document per entry, either as YAML documents separated by
+// `---` or as newline-delimited JSON.
+//
+// The return value is an error if a request within the corpus cannot be decoded.
+func (r *Rules) EvaluateCorpus(prg cel.Program, corpus io.Reader, opts ...YAMLOption) (*CorpusReport, error) {
+	return r.EvaluateCorpusContext(context.Background(), prg, corpus, opts...)
+}
+
+// EvaluateCorpusContext is EvaluateCorpus which returns the context's error once the context is
+// done. The context is also passed to the evaluation of each request.
+func (r *Rules) EvaluateCorpusContext(ctx context.Context, prg cel.Program, corpus io.Reader, opts ...YAMLOption) (*CorpusReport, error) {
+	next := corpusRequests(corpus, opts)
+	report := &CorpusReport{}
+	progress := progressFrom(ctx)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vars, line, err := next()
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Requests++
+		vars = SafeVariables(vars)
+		out, _, err := prg.ContextEval(ctx, vars)
+		switch {
+		case err != nil:
+			report.Errors++
+		case out == types.True:
+			report.Matches++
+			if len(report.MatchedLines) < maxMatchedLines {
+				report.MatchedLines = append(report.MatchedLines, line)
+			}
+			if report.Reproducer == nil {
+				report.Reproducer = newReproducer(prg, vars, line, ReplayMatch)
+			}
+		}
+		progress(report.Requests, -1)
+	}
+}
+
+// corpusRequests returns a function which reads the next request of a corpus along with its line,
+// or returns io.EOF at the end of the corpus.
+func corpusRequests(corpus io.Reader, opts []YAMLOption) func() (*Variables, int, error) {
+	o := newYAMLOptions(opts)
+	br := bufio.NewReader(corpus)
+	var next func() (*yaml.Node, int, error)
+	if isJSONStream(br) {
+		next = jsonLines(br)
+	} else {
+		next = yamlDocuments(br)
+	}
+	return func() (*Variables, int, error) {
+		node, line, err := next()
+		if err != nil {
+			return nil, line, err
+		}
+		if o.strict {
+			if err := checkNode(node, &plainVariables{}); err != nil {
+				return nil, line, fmt.Errorf("request at line %d: %w", line, err)
+			}
+		}
+		vars := &Variables{}
+		if err := node.Decode(vars); err != nil {
+			return nil, line, fmt.Errorf("request at line %d: %w", line, err)
+		}
+		if err := o.checkHeaders(vars); err != nil {
+			return nil, line, fmt.Errorf("request at line %d: %w", line, err)
+		}
+		vars.Normalize(o.normalization)
+		return vars, line, nil
+	}
+}
+
+// CheckMatchRate returns the status of the suite's max_match_rate assertion for a report of its
+// corpus.
+func (ts *TestSuite) CheckMatchRate(report *CorpusReport) TestStatus {
+	status := TestStatus{Name: "max_match_rate", Actual: report.MatchRate()}
+	if ts.MaxMatchRate != nil {
+		status.Expected = *ts.MaxMatchRate
+	}
+	if ts.MaxMatchRate == nil || report.MatchRate() <= *ts.MaxMatchRate {
+		status.Pass = true
+		return status
+	}
+	status.Fail = fmt.Sprintf("matched %d of %d requests in %s (%v), exceeding max_match_rate %v; first matches on lines %v",
+		report.Matches, report.Requests, ts.Corpus, report.MatchRate(), *ts.MaxMatchRate, report.MatchedLines)
+	if rep := report.Reproducer; rep != nil {
+		status.Fail += fmt.Sprintf("; line %d reduces to %s", rep.Line, rep.When)
+	}
+	return status
+}
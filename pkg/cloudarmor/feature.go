@@ -0,0 +1,154 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Feature is a single VNext capability which can be enabled within a VCurrent environment.
+type Feature string
+
+const (
+	// FeatureRequestBody declares the request.body attribute.
+	FeatureRequestBody Feature = "request_body"
+	// FeatureParams declares the request.params attribute.
+	FeatureParams Feature = "params"
+	// FeatureLoadBalancerContext declares the request.backend_service and
+	// request.matched_url_map attributes.
+	FeatureLoadBalancerContext Feature = "load_balancer_context"
+	// FeatureAdaptiveProtection declares the preview adaptive_protection attributes.
+	FeatureAdaptiveProtection Feature = "adaptive_protection"
+	// FeatureNumericRanges declares the inRange() function.
+	FeatureNumericRanges Feature = "numeric_ranges"
+	// FeatureBindings enables cel.bind() variable bindings.
+	FeatureBindings Feature = "bindings"
+	// FeatureIDN declares the toPunycode() and fromPunycode() functions.
+	FeatureIDN Feature = "idn"
+	// FeatureFingerprintLists declares the inFingerprintList() function.
+	FeatureFingerprintLists Feature = "fingerprint_lists"
+)
+
+// featureDecl lists the VNext declarations enabled by a feature.
+type featureDecl struct {
+	attributes []string
+	functions  func() []cel.EnvOption
+}
+
+var features = map[Feature]featureDecl{
+	FeatureRequestBody:         {attributes: []string{"request.body"}},
+	FeatureParams:              {attributes: []string{"request.params"}},
+	FeatureLoadBalancerContext: {attributes: []string{"request.backend_service", "request.matched_url_map"}},
+	FeatureAdaptiveProtection:  {attributes: []string{"adaptive_protection.attack_likelihood", "adaptive_protection.attack_signatures"}},
+	FeatureNumericRanges:       {functions: numericFunctions},
+	FeatureBindings:            {functions: func() []cel.EnvOption { return bindings(VNext) }},
+	FeatureIDN:                 {functions: idnFunctions},
+	// inFingerprintList is bound to the lists of the Rules, so is declared by
+	// fingerprintListOptions.
+	FeatureFingerprintLists: {},
+}
+
+// AllFeatures returns the features which can be enabled with WithFeature, in lexical order.
+func AllFeatures() []Feature {
+	all := make([]Feature, 0, len(features))
+	for f := range features {
+		all = append(all, f)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return all
+}
+
+// ParseFeature returns the Feature with the given name, e.g. request_body.
+func ParseFeature(name string) (Feature, error) {
+	if _, found := features[Feature(name)]; !found {
+		var names []string
+		for _, f := range AllFeatures() {
+			names = append(names, string(f))
+		}
+		return "", fmt.Errorf("unknown feature %q, must be one of %s", name, strings.Join(names, ", "))
+	}
+	return Feature(name), nil
+}
+
+// WithFeature enables a single VNext capability within the VCurrent HTTP environment, so that
+// projects allowlisted for some preview attributes can use exactly those, while references to any
+// other VNext attribute or function remain compile errors.
+//
+// Every feature is already enabled in VNext environments, where WithFeature has no effect.
+func WithFeature(f Feature) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		if _, found := features[f]; !found {
+			return nil, fmt.Errorf("unknown feature: %q", f)
+		}
+		if r.features == nil {
+			r.features = map[Feature]bool{}
+		}
+		r.features[f] = true
+		return r, nil
+	}
+}
+
+// featureList returns the enabled features in lexical order.
+func (r *Rules) featureList() []string {
+	var list []string
+	for f := range r.features {
+		list = append(list, string(f))
+	}
+	sort.Strings(list)
+	return list
+}
+
+// featureOptions returns the declarations of the enabled features which the version of the
+// environment does not already include.
+func (r *Rules) featureOptions() []cel.EnvOption {
+	if len(r.features) == 0 || r.version >= VNext {
+		return nil
+	}
+	if r.profile != ProfileHTTP {
+		return []cel.EnvOption{func(*cel.Env) (*cel.Env, error) {
+			return nil, fmt.Errorf("features %s require the %v profile", strings.Join(r.featureList(), ", "), ProfileHTTP)
+		}}
+	}
+	next, err := httpV2.config()
+	if err != nil {
+		return []cel.EnvOption{func(*cel.Env) (*cel.Env, error) { return nil, err }}
+	}
+	var opts []cel.EnvOption
+	for _, name := range r.featureList() {
+		decl := features[Feature(name)]
+		for _, v := range next.Variables {
+			for _, attr := range decl.attributes {
+				if v.Name != attr {
+					continue
+				}
+				opts = append(opts, func(e *cel.Env) (*cel.Env, error) {
+					d, err := v.AsCELVariable(e.CELTypeProvider())
+					if err != nil {
+						return nil, err
+					}
+					return cel.VariableDecls(d)(e)
+				})
+			}
+		}
+		if decl.functions != nil {
+			opts = append(opts, decl.functions()...)
+		}
+	}
+	return opts
+}
@@ -0,0 +1,65 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EnvFingerprint returns the hex-encoded sha256 of the effective environment: the configuration
+// of its profile and version, the declared attributes and function overloads, and every option
+// which alters how expressions compile or evaluate.
+//
+// Recording the fingerprint alongside test results and exported rules proves that a rule was
+// validated with identical semantics, since any change to the environment changes the
+// fingerprint. Options which only select test cases, such as TestTags, are not included.
+func (r *Rules) EnvFingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "profile %s\nversion %d\npresence %d\nunknowns %t\ncheck_determinism %t\n",
+		r.profile, r.version, r.presence, r.unknowns, r.checkDeterminism)
+	fmt.Fprintf(h, "disabled_operators %s\nfeatures %s\nuntrusted %+v\nlimits %+v\n",
+		strings.Join(r.disabledOperatorList(), " "), strings.Join(r.featureList(), " "), r.untrusted, r.limits)
+	if r.validateRegionCodes {
+		fmt.Fprint(h, "validate_region_codes\n")
+	}
+	if r.validateASNs {
+		fmt.Fprint(h, "validate_asns\n")
+	}
+	if r.validateTLSFingerprints {
+		fmt.Fprint(h, "validate_tls_fingerprints\n")
+	}
+	for _, name := range fingerprintListNames(r.fingerprintLists) {
+		fmt.Fprintf(h, "fingerprint_list %s %s\n", name, strings.Join(r.fingerprintLists[name].Fingerprints, " "))
+	}
+	if config, err := lookupConfig(r.profile, r.version); err == nil {
+		fmt.Fprintf(h, "config %q\n", config.source())
+	}
+	var decls []string
+	for _, v := range r.env.Variables() {
+		decls = append(decls, fmt.Sprintf("variable %s %s", v.Name(), v.Type()))
+	}
+	for name, fn := range r.env.Functions() {
+		for _, o := range fn.OverloadDecls() {
+			decls = append(decls, fmt.Sprintf("function %s %s", name, o.ID()))
+		}
+	}
+	sort.Strings(decls)
+	fmt.Fprintln(h, strings.Join(decls, "\n"))
+	return hex.EncodeToString(h.Sum(nil))
+}
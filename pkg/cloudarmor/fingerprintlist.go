@@ -0,0 +1,292 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// FingerprintList is a named set of TLS fingerprints, such as a threat intelligence feed of the
+// JA4 fingerprints of malware, which rules match with inFingerprintList rather than with a
+// growing disjunction of literals:
+//
+//	inFingerprintList(origin.tls_ja4_fingerprint, 'malware-ja4')
+type FingerprintList struct {
+	Name string
+	// Fingerprints contains the lowercase JA3 and JA4 fingerprints of the list in lexical order,
+	// without duplicates.
+	Fingerprints []string
+}
+
+// fingerprintListName matches the names of fingerprint lists.
+var fingerprintListName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// fingerprintColumns contains the names of the CSV columns and JSON fields which hold the
+// fingerprints of a feed, such as the ja3_md5 column of the abuse.ch SSL blacklist.
+var fingerprintColumns = []string{"fingerprint", "ja4", "ja4_fingerprint", "ja3", "ja3_md5", "ja3_fingerprint"}
+
+// NewFingerprintList returns a list of the JA3 and JA4 fingerprints, which are lowercased. The
+// name is referenced by rules, and consists of letters, digits, '.', '_', and '-'.
+func NewFingerprintList(name string, fingerprints ...string) (*FingerprintList, error) {
+	if !fingerprintListName.MatchString(name) {
+		return nil, fmt.Errorf("invalid fingerprint list name %q: must consist of letters, digits, '.', '_', and '-'", name)
+	}
+	seen := map[string]bool{}
+	l := &FingerprintList{Name: name, Fingerprints: []string{}}
+	for _, fp := range fingerprints {
+		fp = strings.ToLower(strings.TrimSpace(fp))
+		if err := checkFingerprint(fp); err != nil {
+			return nil, fmt.Errorf("fingerprint list %q: %w", name, err)
+		}
+		if !seen[fp] {
+			seen[fp] = true
+			l.Fingerprints = append(l.Fingerprints, fp)
+		}
+	}
+	sort.Strings(l.Fingerprints)
+	return l, nil
+}
+
+// checkFingerprint returns an error if the fingerprint is neither a JA3 nor a JA4 fingerprint,
+// describing it as whichever it more closely resembles.
+func checkFingerprint(fp string) error {
+	if strings.Contains(fp, "_") {
+		return CheckJA4Fingerprint(fp)
+	}
+	return CheckJA3Fingerprint(fp)
+}
+
+// Contains reports whether the fingerprint is in the list. Fingerprints are compared exactly, as
+// by ==, so an uppercase fingerprint is never contained.
+func (l *FingerprintList) Contains(fp string) bool {
+	i := sort.SearchStrings(l.Fingerprints, fp)
+	return i < len(l.Fingerprints) && l.Fingerprints[i] == fp
+}
+
+// ReadFingerprintListCSV reads a feed with one fingerprint per record. If a header ahead of the
+// first fingerprint names a fingerprint, ja4, ja3, or ja3_md5 column, the fingerprints are read
+// from that column, and otherwise from the first column of every record, e.g.
+//
+//	# ja3_md5,Firstseen,Lastseen,Listingreason
+//	1aa7bf8b97e540ca5edd75f7b8384bfa,2017-07-14 18:08:15,2019-07-27 20:42:54,TrickBot
+//
+// Blank lines and lines beginning with '#' are ignored, other than a commented out header.
+func ReadFingerprintListCSV(name string, r io.Reader) (*FingerprintList, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	column := 0
+	var fps []string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fps == nil {
+			// Feeds such as the abuse.ch SSL blacklist comment out their header.
+			header := append([]string{strings.TrimPrefix(record[0], "#")}, record[1:]...)
+			if i := fingerprintColumn(header); i >= 0 {
+				column = i
+				continue
+			}
+		}
+		if strings.HasPrefix(record[0], "#") {
+			continue
+		}
+		if column >= len(record) {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: missing fingerprint column %d", line, column+1)
+		}
+		if fp := strings.TrimSpace(record[column]); fp != "" {
+			fps = append(fps, fp)
+		}
+	}
+	return NewFingerprintList(name, fps...)
+}
+
+// fingerprintColumn returns the index of the column of the header holding fingerprints, or -1
+// if the record is not such a header.
+func fingerprintColumn(header []string) int {
+	for _, col := range fingerprintColumns {
+		for i, field := range header {
+			if strings.EqualFold(strings.TrimSpace(field), col) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ReadFingerprintListJSON reads a feed which is a JSON array of fingerprints, or of objects
+// holding a fingerprint in a fingerprint, ja4, ja3, or ja3_md5 field, e.g.
+//
+//	[{"ja4": "t13d1516h2_8daaf6152771_b186095e22b6", "malware": "..."}]
+func ReadFingerprintListJSON(name string, r io.Reader) (*FingerprintList, error) {
+	var entries []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	fps := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		var fp string
+		if err := json.Unmarshal(entry, &fp); err == nil {
+			fps = append(fps, fp)
+			continue
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(entry, &fields); err != nil {
+			return nil, fmt.Errorf("entry %d: must be a fingerprint or an object", i)
+		}
+		var keys []string
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		col := fingerprintColumn(keys)
+		if col < 0 {
+			return nil, fmt.Errorf("entry %d: missing %s field", i, strings.Join(fingerprintColumns, ", "))
+		}
+		fp, ok := fields[keys[col]].(string)
+		if !ok {
+			return nil, fmt.Errorf("entry %d: %s must be a string", i, keys[col])
+		}
+		fps = append(fps, fp)
+	}
+	return NewFingerprintList(name, fps...)
+}
+
+// LoadFingerprintList reads a fingerprint list from a file, in the format of
+// ReadFingerprintListJSON if its name ends in .json and of ReadFingerprintListCSV otherwise.
+func LoadFingerprintList(name, path string) (*FingerprintList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	read := ReadFingerprintListCSV
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		read = ReadFingerprintListJSON
+	}
+	l, err := read(name, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
+
+// WithFingerprintLists makes the fingerprint lists available to the inFingerprintList function
+// by name. A name may be given only once.
+func WithFingerprintLists(lists ...*FingerprintList) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		if r.fingerprintLists == nil {
+			r.fingerprintLists = map[string]*FingerprintList{}
+		}
+		for _, l := range lists {
+			if _, found := r.fingerprintLists[l.Name]; found {
+				return nil, fmt.Errorf("fingerprint list %q is given more than once", l.Name)
+			}
+			r.fingerprintLists[l.Name] = l
+		}
+		return r, nil
+	}
+}
+
+// fingerprintListNames returns the names of the fingerprint lists in lexical order.
+func fingerprintListNames(lists map[string]*FingerprintList) []string {
+	var names []string
+	for name := range lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fingerprintListOptions returns the declaration of the inFingerprintList function, available in
+// VNext or with FeatureFingerprintLists.
+//
+//	inFingerprintList(fingerprint, list)
+//
+// The function is true if the fingerprint is in the list given to WithFingerprintLists with that
+// name. A list name which is a literal must name such a list at check time; any other name which
+// does not results in an error.
+func (r *Rules) fingerprintListOptions() []cel.EnvOption {
+	if r.version < VNext && !r.features[FeatureFingerprintLists] {
+		return nil
+	}
+	lists := r.fingerprintLists
+	return []cel.EnvOption{
+		cel.Function("inFingerprintList", cel.Overload("inFingerprintList_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+			cel.BinaryBinding(func(fp, name ref.Val) ref.Val {
+				l, found := lists[string(name.(types.String))]
+				if !found {
+					return evalErr(ErrorUnknownFingerprintList, "inFingerprintList: unknown fingerprint list %q", name)
+				}
+				return types.Bool(l.Contains(string(fp.(types.String))))
+			}))),
+		cel.ASTValidators(fingerprintListValidator{lists: lists}),
+	}
+}
+
+// fingerprintListValidator reports literal list names passed to inFingerprintList which do not
+// name a fingerprint list.
+type fingerprintListValidator struct {
+	lists map[string]*FingerprintList
+}
+
+// Name implements the cel.ASTValidator interface.
+func (fingerprintListValidator) Name() string {
+	return "cloudarmor.validator.fingerprint_list"
+}
+
+// Validate implements the cel.ASTValidator interface.
+func (v fingerprintListValidator) Validate(_ *cel.Env, _ cel.ValidatorConfig, a *ast.AST, iss *cel.Issues) {
+	for _, e := range ast.MatchDescendants(ast.NavigateAST(a), ast.FunctionMatcher("inFingerprintList")) {
+		args := e.AsCall().Args()
+		if len(args) != 2 || args[1].Kind() != ast.LiteralKind {
+			continue
+		}
+		name, ok := args[1].AsLiteral().Value().(string)
+		if !ok {
+			continue
+		}
+		if _, found := v.lists[name]; !found {
+			iss.ReportErrorAtID(args[1].ID(), "unknown fingerprint list %q%s", name, knownLists(v.lists))
+		}
+	}
+}
+
+// knownLists returns a suffix for errors naming the fingerprint lists, if any.
+func knownLists(lists map[string]*FingerprintList) string {
+	if len(lists) == 0 {
+		return ", no lists are loaded"
+	}
+	return ", must be one of " + strings.Join(fingerprintListNames(lists), ", ")
+}
@@ -0,0 +1,71 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// NonFiniteScoreError indicates that a score attribute is NaN or infinite.
+//
+// Comparisons against NaN are false in Go, whereas other implementations may order NaN or reject
+// it outright, so a rule evaluated against such a score could produce environment-dependent
+// results. Scores are instead required to be finite: VariablesFromYAML and TestSuiteFromYAML
+// reject non-finite scores when loading, and an expression which reads a non-finite score from
+// directly constructed Variables evaluates to a NonFiniteScoreError.
+type NonFiniteScoreError struct {
+	Attribute string
+	Value     float64
+}
+
+// Error implements the error interface.
+func (e *NonFiniteScoreError) Error() string {
+	return fmt.Sprintf("%s must be a finite number, got %v", e.Attribute, e.Value)
+}
+
+// checkScores returns a NonFiniteScoreError for the first score attribute which is NaN or
+// infinite.
+func (v *Variables) checkScores() error {
+	if ap := v.AdaptiveProtection; ap != nil && !isFinite(ap.AttackLikelihood) {
+		return &NonFiniteScoreError{Attribute: "adaptive_protection.attack_likelihood", Value: ap.AttackLikelihood}
+	}
+	if v.Token == nil {
+		return nil
+	}
+	if a := v.Token.RecaptchaAction; a != nil && !isFinite(a.Score) {
+		return &NonFiniteScoreError{Attribute: "token.recaptcha_action.score", Value: a.Score}
+	}
+	if s := v.Token.RecaptchaSession; s != nil && !isFinite(s.Score) {
+		return &NonFiniteScoreError{Attribute: "token.recaptcha_session.score", Value: s.Score}
+	}
+	return nil
+}
+
+// scoreVal converts a score attribute to its CEL value, or to an error value if the score is not
+// finite.
+func scoreVal(attr string, score float64) ref.Val {
+	if !isFinite(score) {
+		return types.WrapErr(&NonFiniteScoreError{Attribute: attr, Value: score})
+	}
+	return types.Double(score)
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
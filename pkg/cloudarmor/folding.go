@@ -0,0 +1,80 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// foldableFunctions maps the string transformation functions which are safe to evaluate at
+// compile time to their implementations.
+var foldableFunctions = map[string]func(string) ref.Val{
+	"base64Decode": base64DecodeString,
+	"urlDecode":    urlDecodeString,
+	"lower":        lowerASCII,
+	"upper":        upperASCII,
+	"toPunycode":   toPunycodeString,
+	"fromPunycode": fromPunycodeString,
+}
+
+// decodeFoldingOptimizer replaces calls to the decode and case-folding functions whose receiver
+// is a string literal with the literal result of the call.
+//
+// The cel-go constant folding optimizer reports an error when a fold fails, whereas a failed
+// decode must be preserved so that the error surfaces at evaluation time just as it would in
+// Cloud Armor. Calls which produce an error are therefore left untouched.
+type decodeFoldingOptimizer struct{}
+
+// Optimize implements the cel.ASTOptimizer interface.
+func (decodeFoldingOptimizer) Optimize(ctx *cel.OptimizerContext, a *ast.AST) *ast.AST {
+	// Post-order traversal ensures nested calls such as 'X'.urlDecode().lower() are folded from
+	// the innermost call outward.
+	ast.PostOrderVisit(a.Expr(), ast.NewExprVisitor(func(e ast.Expr) {
+		if e.Kind() != ast.CallKind {
+			return
+		}
+		call := e.AsCall()
+		fn, found := foldableFunctions[call.FunctionName()]
+		if !found || !call.IsMemberFunction() || len(call.Args()) != 0 {
+			return
+		}
+		target := call.Target()
+		if target.Kind() != ast.LiteralKind {
+			return
+		}
+		str, ok := target.AsLiteral().(types.String)
+		if !ok {
+			return
+		}
+		out := fn(string(str))
+		if types.IsError(out) {
+			return
+		}
+		ctx.UpdateExpr(e, ctx.NewLiteral(out))
+	}))
+	return a
+}
+
+// foldDecodeLiterals applies the decodeFoldingOptimizer to the given AST.
+func foldDecodeLiterals(env *cel.Env, a *cel.Ast) (*cel.Ast, error) {
+	folded, iss := cel.NewStaticOptimizer(decodeFoldingOptimizer{}).Optimize(env, a)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	return folded, nil
+}
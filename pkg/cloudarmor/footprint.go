@@ -0,0 +1,158 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"fmt"
+	"regexp/syntax"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/overloads"
+	"github.com/google/cel-go/common/types"
+)
+
+const (
+	// nodeBytes approximates the memory held for each node of a program: the checked AST node,
+	// its type and reference, and the interpretable planned from it.
+	nodeBytes = 256
+	// instBytes approximates the memory held for each instruction of a compiled regular expression.
+	instBytes = 40
+)
+
+// Footprint estimates the memory held by compiled rules, for capacity planning of validators
+// which keep many programs loaded at once.
+type Footprint struct {
+	// Rules is the number of compiled rules.
+	Rules int
+	// Nodes is the number of nodes within the checked ASTs.
+	Nodes int
+	// LiteralBytes is the total size of the string and bytes literals.
+	LiteralBytes int
+	// Regexes is the number of matches() patterns which are compiled into automata.
+	Regexes int
+	// AutomatonInsts is the total number of instructions within the compiled patterns.
+	AutomatonInsts int
+}
+
+// Bytes returns the estimated number of bytes held by the compiled rules.
+func (f *Footprint) Bytes() int {
+	return f.Nodes*nodeBytes + f.LiteralBytes + f.AutomatonInsts*instBytes
+}
+
+// Add accumulates the footprint of other compiled rules.
+func (f *Footprint) Add(other *Footprint) {
+	f.Rules += other.Rules
+	f.Nodes += other.Nodes
+	f.LiteralBytes += other.LiteralBytes
+	f.Regexes += other.Regexes
+	f.AutomatonInsts += other.AutomatonInsts
+}
+
+// String returns a single line summary of the footprint.
+func (f *Footprint) String() string {
+	return fmt.Sprintf("rules: %d, nodes: %d, literal bytes: %d, regexes: %d, automaton instructions: %d, estimated size: %.1f KiB",
+		f.Rules, f.Nodes, f.LiteralBytes, f.Regexes, f.AutomatonInsts, float64(f.Bytes())/1024)
+}
+
+// EstimateFootprint estimates the memory held by the program of a compiled rule.
+//
+// The estimate counts the nodes of the checked AST, the bytes of its literals, and the size of
+// the automaton compiled for each matches() call with a literal pattern.
+func EstimateFootprint(a *cel.Ast) *Footprint {
+	f := &Footprint{Rules: 1}
+	ast.PostOrderVisit(a.NativeRep().Expr(), ast.NewExprVisitor(func(e ast.Expr) {
+		f.Nodes++
+		switch e.Kind() {
+		case ast.LiteralKind:
+			switch lit := e.AsLiteral().(type) {
+			case types.String:
+				f.LiteralBytes += len(lit)
+			case types.Bytes:
+				f.LiteralBytes += len(lit)
+			}
+		case ast.CallKind:
+			if pattern, ok := matchesPattern(e.AsCall()); ok {
+				f.Regexes++
+				f.AutomatonInsts += automatonSize(pattern)
+			}
+		}
+	}))
+	return f
+}
+
+// VendorRulesetFootprint compiles every rule within the vendor rulesets and estimates the memory
+// they hold once loaded.
+//
+// The return value is an error naming each rule, by ruleset name and rule ID, which fails to
+// compile.
+func (r *Rules) VendorRulesetFootprint(c *VendorRulesetCollection) (*Footprint, error) {
+	return r.VendorRulesetFootprintContext(context.Background(), c)
+}
+
+// VendorRulesetFootprintContext is VendorRulesetFootprint which returns the context's error once
+// the context is done.
+func (r *Rules) VendorRulesetFootprintContext(ctx context.Context, c *VendorRulesetCollection) (*Footprint, error) {
+	exprs := map[string]string{}
+	for _, rs := range c.GetRuleSets() {
+		for _, rule := range rs.GetRules() {
+			name := rule.GetId()
+			if rs.GetName() != "" {
+				name = rs.GetName() + "/" + name
+			}
+			exprs[name] = rule.GetCelExpression()
+		}
+	}
+	asts, errs, err := r.CompileAllContext(ctx, exprs)
+	if err != nil {
+		return nil, err
+	}
+	if err := JoinErrors(errs); err != nil {
+		return nil, err
+	}
+	total := &Footprint{}
+	for _, a := range asts {
+		total.Add(EstimateFootprint(a))
+	}
+	return total, nil
+}
+
+// matchesPattern returns the literal pattern of a call to matches().
+func matchesPattern(call ast.CallExpr) (string, bool) {
+	if call.FunctionName() != overloads.Matches {
+		return "", false
+	}
+	args := call.Args()
+	if len(args) == 0 || args[len(args)-1].Kind() != ast.LiteralKind {
+		return "", false
+	}
+	pattern, ok := args[len(args)-1].AsLiteral().(types.String)
+	return string(pattern), ok
+}
+
+// automatonSize returns the number of instructions within the RE2 program compiled from the
+// pattern, or 0 if the pattern is invalid.
+func automatonSize(pattern string) int {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return 0
+	}
+	return len(prog.Inst)
+}
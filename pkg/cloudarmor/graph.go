@@ -0,0 +1,182 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/parser"
+)
+
+// GraphFormat is the notation in which Graph renders an expression.
+type GraphFormat int
+
+const (
+	// GraphDOT renders the expression as a Graphviz digraph.
+	GraphDOT GraphFormat = iota
+	// GraphMermaid renders the expression as a Mermaid flowchart.
+	GraphMermaid
+)
+
+// ParseGraphFormat returns the GraphFormat with the given name, either dot or mermaid.
+func ParseGraphFormat(name string) (GraphFormat, error) {
+	switch name {
+	case "dot":
+		return GraphDOT, nil
+	case "mermaid":
+		return GraphMermaid, nil
+	}
+	return 0, fmt.Errorf("unsupported graph format %q, must be dot or mermaid", name)
+}
+
+// graphNode is a single node of the rendered graph.
+type graphNode struct {
+	id       int64
+	label    []string
+	children []int64
+}
+
+// Graph renders the checked expression as a graph with one node per AST node, labeled with the
+// operator, function, attribute, or literal, the type of the node, and the estimated range of
+// the cost of evaluating the sub-expression rooted at the node.
+//
+// Costs are estimated assuming string and map attributes no larger than MaxAttributeSize of the
+// untrusted limits, or of DefaultUntrustedLimits when the Rules are not in untrusted mode.
+func (r *Rules) Graph(a *cel.Ast, name string, format GraphFormat) (string, error) {
+	native := a.NativeRep()
+	if !native.IsChecked() {
+		return "", fmt.Errorf("graph requires a checked expression")
+	}
+	maxSize := DefaultUntrustedLimits().MaxAttributeSize
+	if r.untrusted != nil {
+		maxSize = r.untrusted.MaxAttributeSize
+	}
+	estimator := attributeSizeEstimator{maxSize: maxSize}
+
+	var nodes []*graphNode
+	var visit func(nav ast.NavigableExpr) error
+	visit = func(nav ast.NavigableExpr) error {
+		sub := ast.NewCheckedAST(ast.NewAST(nav, native.SourceInfo()), native.TypeMap(), native.ReferenceMap())
+		cost, err := checker.Cost(sub, estimator)
+		if err != nil {
+			return err
+		}
+		n := &graphNode{id: nav.ID(), label: []string{
+			graphLabel(nav, native.SourceInfo()),
+			nav.Type().String(),
+			fmt.Sprintf("cost %s", costRange(cost)),
+		}}
+		nodes = append(nodes, n)
+		for _, child := range nav.Children() {
+			n.children = append(n.children, child.ID())
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(ast.NavigateAST(native)); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	switch format {
+	case GraphMermaid:
+		fmt.Fprintf(&sb, "---\ntitle: %s\n---\nflowchart TD\n", name)
+		for _, n := range nodes {
+			fmt.Fprintf(&sb, "  n%d[\"%s\"]\n", n.id, mermaidEscape(strings.Join(n.label, "<br/>")))
+		}
+		for _, n := range nodes {
+			for _, c := range n.children {
+				fmt.Fprintf(&sb, "  n%d --> n%d\n", n.id, c)
+			}
+		}
+	default:
+		fmt.Fprintf(&sb, "digraph %q {\n  node [shape=box, fontname=\"monospace\"];\n", name)
+		for _, n := range nodes {
+			fmt.Fprintf(&sb, "  n%d [label=%s];\n", n.id, dotQuote(n.label))
+		}
+		for _, n := range nodes {
+			for _, c := range n.children {
+				fmt.Fprintf(&sb, "  n%d -> n%d;\n", n.id, c)
+			}
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String(), nil
+}
+
+// graphLabel describes the node itself, excluding its children.
+func graphLabel(e ast.Expr, info *ast.SourceInfo) string {
+	switch e.Kind() {
+	case ast.CallKind:
+		call := e.AsCall()
+		if op, found := operators.FindReverse(call.FunctionName()); found && op != "" {
+			return op
+		}
+		if call.IsMemberFunction() {
+			return "." + call.FunctionName() + "()"
+		}
+		return call.FunctionName() + "()"
+	case ast.SelectKind:
+		if e.AsSelect().IsTestOnly() {
+			return "has(." + e.AsSelect().FieldName() + ")"
+		}
+		return "." + e.AsSelect().FieldName()
+	case ast.IdentKind:
+		return e.AsIdent()
+	case ast.LiteralKind:
+		lit, err := parser.Unparse(e, info, parser.WrapOnColumn(math.MaxInt))
+		if err != nil {
+			return fmt.Sprintf("%v", e.AsLiteral())
+		}
+		return lit
+	case ast.ListKind:
+		return "[]"
+	case ast.MapKind:
+		return "{}"
+	case ast.ComprehensionKind:
+		return "comprehension"
+	}
+	return "unspecified"
+}
+
+// costRange formats a cost estimate as a single value or a min..max range.
+func costRange(c checker.CostEstimate) string {
+	if c.Min == c.Max {
+		return fmt.Sprintf("%d", c.Min)
+	}
+	return fmt.Sprintf("%d..%d", c.Min, c.Max)
+}
+
+// dotQuote returns the lines as a quoted DOT label.
+func dotQuote(lines []string) string {
+	escaped := make([]string, len(lines))
+	for i, l := range lines {
+		escaped[i] = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(l)
+	}
+	return `"` + strings.Join(escaped, `\n`) + `"`
+}
+
+// mermaidEscape replaces the characters which cannot appear within a quoted Mermaid label.
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<br/>", "<br/>", "<", "#lt;", ">", "#gt;").Replace(s)
+}
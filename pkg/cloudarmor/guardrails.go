@@ -0,0 +1,142 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// defaultRulePriority is the priority of the default rule of every security policy.
+const defaultRulePriority = 2147483647
+
+// Guardrails are the constraints which an organization places on its security policies, declared
+// in YAML and checked in CI before a policy is deployed.
+type Guardrails struct {
+	// ForbidAllowRanges are the source IP ranges, e.g. 0.0.0.0/0, which no enforced allow rule may
+	// match in full through a SRC_IPS_V1 match.
+	ForbidAllowRanges []string `yaml:"forbid_allow_ranges"`
+	// RequirePreview requires each rule which is enforced by a policy but not by its baseline to
+	// have been deployed in preview by the baseline, so that its impact was observed before it
+	// was enforced. The default rule is exempt.
+	RequirePreview bool `yaml:"require_preview"`
+	// MinRateLimitCount is the smallest rateLimitThreshold count accepted on a throttle or
+	// rate_based_ban rule, or 0 for no minimum.
+	MinRateLimitCount int64 `yaml:"min_rate_limit_count"`
+
+	forbidden []netip.Prefix
+}
+
+// GuardrailViolation is a rule of a security policy which violates a guardrail.
+type GuardrailViolation struct {
+	// Guardrail is the field of the Guardrails which is violated, e.g. require_preview.
+	Guardrail string
+	Rule      *SecurityPolicyRule
+	Message   string
+}
+
+// String returns the violation in the form '<rule>: <guardrail>: <message>'.
+func (v GuardrailViolation) String() string {
+	return fmt.Sprintf("%s: %s: %s", v.Rule.Name(), v.Guardrail, v.Message)
+}
+
+// GuardrailsFromYAML converts a YAML declaration of guardrails to a Guardrails type. Unknown
+// fields and values of the wrong type are rejected, so that a misspelled guardrail is not
+// silently ignored.
+//
+// The return value is the Guardrails type or an error if the YAML is invalid or a forbidden range
+// is not a CIDR range.
+func GuardrailsFromYAML(yamlBytes []byte) (*Guardrails, error) {
+	g := &Guardrails{}
+	if err := checkSchema(yamlBytes, g); err != nil {
+		return nil, err
+	}
+	for _, r := range g.ForbidAllowRanges {
+		prefix, err := parseIPRange(r)
+		if err != nil {
+			return nil, fmt.Errorf("forbid_allow_ranges: %w", err)
+		}
+		g.forbidden = append(g.forbidden, prefix)
+	}
+	if g.MinRateLimitCount < 0 {
+		return nil, fmt.Errorf("min_rate_limit_count must not be negative, got %d", g.MinRateLimitCount)
+	}
+	return g, nil
+}
+
+// Check returns the violations of the guardrails by the rules of the policy, in priority order.
+// The baseline is the policy which is currently deployed, against which RequirePreview is
+// checked, or nil if there is none, in which case every enforced rule other than the default
+// rule violates RequirePreview.
+func (g *Guardrails) Check(p, baseline *SecurityPolicy) []GuardrailViolation {
+	deployed := map[string]*SecurityPolicyRule{}
+	if baseline != nil {
+		for _, rule := range baseline.Rules {
+			deployed[rule.Name()] = rule
+		}
+	}
+	rules := append([]*SecurityPolicyRule{}, p.Rules...)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+	var violations []GuardrailViolation
+	for _, rule := range rules {
+		violate := func(guardrail, format string, args ...any) {
+			violations = append(violations, GuardrailViolation{Guardrail: guardrail, Rule: rule, Message: fmt.Sprintf(format, args...)})
+		}
+		if rule.Action == ActionAllow && !rule.Preview {
+			if r, ok := g.allowedRange(rule); ok {
+				violate("forbid_allow_ranges", "allows %s, which covers a forbidden range", r)
+			}
+		}
+		if g.RequirePreview && !rule.Preview && rule.Priority != defaultRulePriority {
+			switch prev, found := deployed[rule.Name()]; {
+			case found && prev.Action == rule.Action:
+			case found && prev.Preview:
+				violate("require_preview", "%s is enforced, but %s was previewed", rule.Action, prev.Action)
+			default:
+				violate("require_preview", "%s is enforced without having been deployed in preview", rule.Action)
+			}
+		}
+		if g.MinRateLimitCount != 0 && rule.RateLimitOptions != nil && rule.RateLimitOptions.RateLimitThreshold != nil {
+			if count := rule.RateLimitOptions.RateLimitThreshold.Count; count < g.MinRateLimitCount {
+				violate("min_rate_limit_count", "rateLimitThreshold count %d is below %d", count, g.MinRateLimitCount)
+			}
+		}
+	}
+	return violations
+}
+
+// allowedRange returns the first source range of a SRC_IPS_V1 match which covers a forbidden
+// range.
+func (g *Guardrails) allowedRange(rule *SecurityPolicyRule) (string, bool) {
+	if rule.Match == nil || rule.Match.VersionedExpr != "SRC_IPS_V1" || rule.Match.Config == nil {
+		return "", false
+	}
+	for _, r := range rule.Match.Config.SrcIPRanges {
+		if r == "*" && len(g.forbidden) != 0 {
+			return r, true
+		}
+		prefix, err := parseIPRange(r)
+		if err != nil {
+			continue
+		}
+		for _, f := range g.forbidden {
+			if prefix.Bits() <= f.Bits() && prefix.Contains(f.Addr()) {
+				return r, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,110 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// headerAttributes are the map attributes whose values are header strings.
+var headerAttributes = []string{"request.headers", "response.headers"}
+
+// HeaderValueError indicates that a header within a YAML document has a value which is not a
+// string.
+type HeaderValueError struct {
+	Attribute string
+	Key       string
+	// Line is the line of the value within the document.
+	Line int
+	// Kind describes the value, e.g. "list" or "!!int".
+	Kind string
+}
+
+// Error implements the error interface.
+func (e *HeaderValueError) Error() string {
+	return fmt.Sprintf("line %d: %s[%q] must be a string, got %s", e.Line, e.Attribute, e.Key, e.Kind)
+}
+
+// StrictHeaderValues rejects header values which are numbers, booleans, or null rather than
+// strings. By default such values, which are common in fixtures generated from JSON logs, are
+// decoded as their literal text, e.g. `content-length: 0x10` becomes "0x10". Header values
+// which are lists or maps are always rejected.
+func StrictHeaderValues() YAMLOption {
+	return func(o *yamlOptions) {
+		o.strictHeaders = true
+	}
+}
+
+// checkHeaderValues returns a HeaderValueError for the first header value within the variables
+// node which is a list or a map, along with errors for each non-string scalar value, which are
+// decoded as their literal text unless StrictHeaderValues is set.
+func checkHeaderValues(node *yaml.Node) (coerced []*HeaderValueError, err error) {
+	for _, attr := range headerAttributes {
+		headers := lookupNode(node, strings.Split(attr, "."))
+		if headers == nil || headers.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(headers.Content); i += 2 {
+			key, val := headers.Content[i], headers.Content[i+1]
+			hvErr := &HeaderValueError{Attribute: attr, Key: key.Value, Line: val.Line}
+			switch val.Kind {
+			case yaml.SequenceNode:
+				hvErr.Kind = "a list"
+				return nil, hvErr
+			case yaml.MappingNode:
+				hvErr.Kind = "a map"
+				return nil, hvErr
+			case yaml.ScalarNode:
+				if tag := val.ShortTag(); tag != "!!str" {
+					hvErr.Kind = tag
+					coerced = append(coerced, hvErr)
+				}
+			}
+		}
+	}
+	return coerced, nil
+}
+
+// lookupNode returns the value of the nested mapping keys within node, or nil if absent.
+func lookupNode(node *yaml.Node, path []string) *yaml.Node {
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// checkHeaders returns the first non-string header value of the variables when
+// StrictHeaderValues is set.
+func (o *yamlOptions) checkHeaders(v *Variables) error {
+	if o.strictHeaders && len(v.coercedHeaders) != 0 {
+		return v.coercedHeaders[0]
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/common"
+)
+
+// SymbolHint reports that an attribute or function which is undeclared in the environment an
+// expression was compiled in is declared by another version or profile, or by a feature.
+type SymbolHint struct {
+	// Symbol is the undeclared attribute or function, e.g. request.body or inRange.
+	Symbol string
+	// In is the configuration of the environment in which the symbol is undeclared.
+	In ConfigKey
+	// Declared is a configuration which declares the symbol.
+	Declared ConfigKey
+	// Feature declares the symbol within a VCurrent environment, as an alternative to the
+	// version of Declared, or is empty if no feature does.
+	Feature Feature
+}
+
+// String returns the hint as an English sentence, e.g. "request.body requires VNext or the
+// request_body feature".
+func (h SymbolHint) String() string {
+	var r *Rules
+	id, args := h.message()
+	return r.message(id, args...)
+}
+
+// message returns the message describing the hint and its arguments.
+func (h SymbolHint) message() (MessageID, []any) {
+	version := VersionName(h.Declared.Version)
+	switch {
+	case h.Declared.Profile == h.In.Profile && h.Feature != "":
+		return MessageSymbolRequiresVersionOrFeature, []any{h.Symbol, version, h.Feature}
+	case h.Declared.Profile == h.In.Profile:
+		return MessageSymbolRequiresVersion, []any{h.Symbol, version}
+	case h.Declared.Version == h.In.Version:
+		return MessageSymbolRequiresProfile, []any{h.Symbol, h.Declared.Profile}
+	}
+	return MessageSymbolRequiresProfileVersion, []any{h.Symbol, h.Declared.Profile, version}
+}
+
+// CompileError is returned by Compile when an expression refers to attributes or functions
+// which the environment does not declare but another version or profile does.
+type CompileError struct {
+	err error
+	// Hints contains one hint per such symbol, in the order they appear in the expression.
+	Hints []SymbolHint
+}
+
+// Error implements the error interface.
+func (e *CompileError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying compilation error.
+func (e *CompileError) Unwrap() error {
+	return e.err
+}
+
+// VersionName returns the name of a version, e.g. VNext.
+func VersionName(version uint32) string {
+	switch version {
+	case VCurrent:
+		return "VCurrent"
+	case VNext:
+		return "VNext"
+	default:
+		return fmt.Sprintf("v%d", version)
+	}
+}
+
+// symbols contains the attributes and functions declared by an environment.
+type symbols struct {
+	attributes map[string]bool
+	functions  map[string]bool
+}
+
+// declares reports whether the environment declares the attribute or function.
+func (s *symbols) declares(name string) bool {
+	return s.attributes[name] || s.functions[name]
+}
+
+var (
+	symbolsMu sync.Mutex
+	// configSymbols caches the symbols of each registered configuration. Entries are keyed by
+	// the configuration rather than its ConfigKey so that re-registering a key replaces them.
+	configSymbols = map[*envConfig]*symbols{}
+	// featureSymbols caches the symbols of a VCurrent environment with each feature enabled.
+	featureSymbols = map[Feature]*symbols{}
+)
+
+// newSymbols returns the symbols declared by the environment of the Rules.
+func newSymbols(r *Rules) *symbols {
+	s := &symbols{attributes: map[string]bool{}, functions: map[string]bool{}}
+	for _, v := range r.env.Variables() {
+		s.attributes[v.Name()] = true
+	}
+	for name := range r.env.Functions() {
+		s.functions[name] = true
+	}
+	return s
+}
+
+// symbolsOf returns the symbols declared by the environment of a registered configuration.
+func symbolsOf(key ConfigKey) (*symbols, error) {
+	c, err := lookupConfig(key.Profile, key.Version)
+	if err != nil {
+		return nil, err
+	}
+	symbolsMu.Lock()
+	defer symbolsMu.Unlock()
+	if s, found := configSymbols[c]; found {
+		return s, nil
+	}
+	r, err := NewRules(Version(key.Version), Profile(key.Profile))
+	if err != nil {
+		return nil, err
+	}
+	s := newSymbols(r)
+	configSymbols[c] = s
+	return s, nil
+}
+
+// featureDeclaring returns the feature which declares the symbol within VCurrent, if any.
+func featureDeclaring(name string) Feature {
+	for _, f := range AllFeatures() {
+		symbolsMu.Lock()
+		s, found := featureSymbols[f]
+		if !found {
+			if r, err := NewRules(WithFeature(f)); err == nil {
+				s = newSymbols(r)
+				featureSymbols[f] = s
+			}
+		}
+		symbolsMu.Unlock()
+		if s != nil && s.declares(name) {
+			return f
+		}
+	}
+	return ""
+}
+
+// symbolHint returns a hint naming the environment which declares the symbol, preferring the
+// profile of the Rules and then its version, or false if no registered environment does.
+func (r *Rules) symbolHint(name string) (SymbolHint, bool) {
+	var best *ConfigKey
+	rank := func(k ConfigKey) int {
+		switch {
+		case k.Profile == r.profile:
+			return 0
+		case k.Version == r.version:
+			return 1
+		}
+		return 2
+	}
+	for _, key := range Configs() {
+		if key.Profile == r.profile && key.Version == r.version {
+			continue
+		}
+		s, err := symbolsOf(key)
+		if err != nil || !s.declares(name) {
+			continue
+		}
+		if best == nil || rank(key) < rank(*best) {
+			best = &key
+		}
+	}
+	if best == nil {
+		return SymbolHint{}, false
+	}
+	h := SymbolHint{Symbol: name, In: ConfigKey{Profile: r.profile, Version: r.version}, Declared: *best}
+	if r.profile == ProfileHTTP && r.version == VCurrent && best.Profile == ProfileHTTP {
+		h.Feature = featureDeclaring(name)
+	}
+	return h, true
+}
+
+var (
+	undeclaredReference = regexp.MustCompile(`^undeclared reference to '([^']+)'`)
+	qualifiedName       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*`)
+)
+
+// undeclaredName returns the reference named by an undeclared reference error, e.g. 'request',
+// and the qualified name at its location in the expression, e.g. request.body.contains, or false
+// if the message reports another error.
+//
+// The error names only the first segment of an attribute, so the attribute is recovered from the
+// expression text: it is a qualified prefix of the name, unless the reference is a function.
+func undeclaredName(src common.Source, message string, loc common.Location) (string, string, bool) {
+	m := undeclaredReference.FindStringSubmatch(message)
+	if m == nil {
+		return "", "", false
+	}
+	offset, found := src.LocationOffset(loc)
+	runes := []rune(src.Content())
+	if !found || int(offset) > len(runes) {
+		return "", "", false
+	}
+	return m[1], qualifiedName.FindString(string(runes[offset:])), true
+}
+
+// prefixes returns the qualified prefixes of a name with at least two segments, longest first.
+func prefixes(name string) []string {
+	var out []string
+	for segments := strings.Split(name, "."); len(segments) > 1; segments = segments[:len(segments)-1] {
+		out = append(out, strings.Join(segments, "."))
+	}
+	return out
+}
+
+// undeclaredHint returns a hint for the symbol reported by an undeclared reference error at the
+// given location, or false if the message reports another error or no environment declares it.
+// The longest prefix of the qualified name declared elsewhere is taken as the attribute.
+func (r *Rules) undeclaredHint(src common.Source, message string, loc common.Location) (SymbolHint, bool) {
+	ref, name, found := undeclaredName(src, message, loc)
+	if !found {
+		return SymbolHint{}, false
+	}
+	for _, prefix := range prefixes(name) {
+		if h, found := r.symbolHint(prefix); found {
+			return h, true
+		}
+	}
+	return r.symbolHint(ref)
+}
+
+// suggestion returns the attribute or function of the environment whose spelling is closest to
+// the symbol reported by an undeclared reference error at the given location, or false if none
+// is within two edits, and a third of the symbol's length, of it.
+func (r *Rules) suggestion(src common.Source, message string, loc common.Location) (string, bool) {
+	ref, name, found := undeclaredName(src, message, loc)
+	if !found {
+		return "", false
+	}
+	s := newSymbols(r)
+	closest := func(symbol string, candidates map[string]bool) (string, bool) {
+		best, bestDist := "", min(2, len(symbol)/3)+1
+		for c := range candidates {
+			// Operators and internal functions, e.g. _==_ and @in, cannot be misspelled names.
+			if qualifiedName.FindString(c) != c {
+				continue
+			}
+			if d := editDistance(symbol, c); d < bestDist || (d == bestDist && best != "" && c < best) {
+				best, bestDist = c, d
+			}
+		}
+		return best, best != ""
+	}
+	for _, prefix := range prefixes(name) {
+		if c, found := closest(prefix, s.attributes); found {
+			return c, true
+		}
+	}
+	return closest(ref, s.functions)
+}
+
+// editDistance returns the Levenshtein distance between two strings, i.e. the number of
+// single-character insertions, deletions, and substitutions which turn one into the other.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,307 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"golang.org/x/text/unicode/norm"
+)
+
+// idnFunctions returns the internationalized hostname functions available in VNext.
+//
+//	host.toPunycode()
+//	host.fromPunycode()
+//
+// Clients send internationalized hostnames in the Host header in their ASCII form, in which each
+// label containing non-ASCII characters is Punycode encoded with the "xn--" prefix, so that
+// bücher.example is sent as xn--bcher-kva.example. toPunycode converts a hostname to that form,
+// lowercasing it, so that rules can be written with the readable hostname:
+//
+//	request.headers['host'] == 'bücher.example'.toPunycode()
+//
+// fromPunycode converts the "xn--" labels of a hostname back to Unicode. An invalid label results
+// in an error.
+func idnFunctions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Function("toPunycode", cel.MemberOverload("toPunycode_string", []*cel.Type{cel.StringType}, cel.StringType,
+			cel.UnaryBinding(func(host ref.Val) ref.Val {
+				return toPunycodeString(string(host.(types.String)))
+			}))),
+		cel.Function("fromPunycode", cel.MemberOverload("fromPunycode_string", []*cel.Type{cel.StringType}, cel.StringType,
+			cel.UnaryBinding(func(host ref.Val) ref.Val {
+				return fromPunycodeString(string(host.(types.String)))
+			}))),
+	}
+}
+
+func toPunycodeString(host string) ref.Val {
+	ascii, err := hostToASCII(host)
+	if err != nil {
+		return evalErr(ErrorInvalidPunycode, "toPunycode: %v", err)
+	}
+	return types.String(ascii)
+}
+
+func fromPunycodeString(host string) ref.Val {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if len(label) < 4 || !strings.EqualFold(label[:4], acePrefix) {
+			continue
+		}
+		decoded, err := punycodeDecode(strings.ToLower(label[4:]))
+		if err != nil {
+			return evalErr(ErrorInvalidPunycode, "fromPunycode: label %q: %v", label, err)
+		}
+		labels[i] = decoded
+	}
+	return types.String(strings.Join(labels, "."))
+}
+
+// acePrefix is the prefix of Punycode encoded labels.
+const acePrefix = "xn--"
+
+// idnDots are the label separators which IDNA maps to a full stop.
+var idnDots = strings.NewReplacer("。", ".", "．", ".", "｡", ".")
+
+// hostToASCII lowercases a hostname, normalizes it to NFC as IDNA requires, and Punycode
+// encodes each of its labels which contains non-ASCII characters. Without normalization, a
+// decomposed name such as 'bu\u0308cher.example' would encode differently from its composed
+// form 'bücher.example'.
+func hostToASCII(host string) (string, error) {
+	labels := strings.Split(idnDots.Replace(norm.NFC.String(strings.ToLower(host))), ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		encoded, err := punycodeEncode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = acePrefix + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// normalizeHost returns the Host header value as clients send it: a hostname containing non-ASCII
+// characters, as often written in hand-authored test cases, is converted to its ASCII form, while
+// any port is preserved. ASCII values, and values which cannot be converted, are returned as is.
+func normalizeHost(value string) string {
+	if isASCII(value) {
+		return value
+	}
+	host, port := value, ""
+	if i := strings.LastIndexByte(value, ':'); i >= 0 && isASCIIDigits(value[i+1:]) {
+		host, port = value[:i], value[i:]
+	}
+	ascii, err := hostToASCII(host)
+	if err != nil {
+		return value
+	}
+	return ascii + port
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIIDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Punycode parameters of RFC 3492 section 5.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+var errPunycodeOverflow = errors.New("punycode overflow")
+
+// punycodeEncode encodes a label as by RFC 3492 section 6.3, without the "xn--" prefix.
+func punycodeEncode(label string) (string, error) {
+	input := []rune(label)
+	var out strings.Builder
+	for _, r := range input {
+		if r < utf8.RuneSelf {
+			out.WriteRune(r)
+		}
+	}
+	b := out.Len()
+	h := b
+	if b > 0 {
+		out.WriteByte('-')
+	}
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for h < len(input) {
+		m := rune(math.MaxInt32)
+		for _, r := range input {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		if int(m-n) > (math.MaxInt32-delta)/(h+1) {
+			return "", errPunycodeOverflow
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+		for _, r := range input {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punyThreshold(k, bias)
+				if q < t {
+					break
+				}
+				out.WriteByte(punyDigit(t + (q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out.WriteByte(punyDigit(q))
+			bias = punyAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return out.String(), nil
+}
+
+// punycodeDecode decodes a label as by RFC 3492 section 6.2, without the "xn--" prefix.
+func punycodeDecode(encoded string) (string, error) {
+	var output []rune
+	pos := 0
+	if b := strings.LastIndexByte(encoded, '-'); b >= 0 {
+		for i := 0; i < b; i++ {
+			if encoded[i] >= utf8.RuneSelf {
+				return "", errors.New("non-ASCII basic code point")
+			}
+			output = append(output, rune(encoded[i]))
+		}
+		pos = b + 1
+	}
+	n, i, bias := rune(punyInitialN), 0, punyInitialBias
+	for pos < len(encoded) {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(encoded) {
+				return "", errors.New("truncated input")
+			}
+			digit, ok := punyValue(encoded[pos])
+			pos++
+			if !ok {
+				return "", errors.New("invalid digit")
+			}
+			if digit > (math.MaxInt32-i)/w {
+				return "", errPunycodeOverflow
+			}
+			i += digit * w
+			t := punyThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			if w > math.MaxInt32/(punyBase-t) {
+				return "", errPunycodeOverflow
+			}
+			w *= punyBase - t
+		}
+		count := len(output) + 1
+		bias = punyAdapt(i-oldi, count, oldi == 0)
+		if i/count > math.MaxInt32-int(n) {
+			return "", errPunycodeOverflow
+		}
+		n += rune(i / count)
+		i %= count
+		if n < punyInitialN || n > utf8.MaxRune || (n >= 0xD800 && n <= 0xDFFF) {
+			return "", errors.New("invalid code point")
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = n
+		i++
+	}
+	return string(output), nil
+}
+
+// punyThreshold returns the threshold t of RFC 3492 section 6 for the position k.
+func punyThreshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return punyTMin
+	case k >= bias+punyTMax:
+		return punyTMax
+	}
+	return k - bias
+}
+
+// punyAdapt is the bias adaptation function of RFC 3492 section 6.1.
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyValue(c byte) (int, bool) {
+	switch {
+	case 'a' <= c && c <= 'z':
+		return int(c - 'a'), true
+	case 'A' <= c && c <= 'Z':
+		return int(c - 'A'), true
+	case '0' <= c && c <= '9':
+		return int(c-'0') + 26, true
+	}
+	return 0, false
+}
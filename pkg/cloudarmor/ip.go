@@ -0,0 +1,79 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// errIPZone is returned for addresses and ranges with an IPv6 zone identifier, e.g. fe80::1%eth0,
+// which identifies an interface of the host rather than part of the address, and which Cloud
+// Armor never presents to a rule.
+var errIPZone = errors.New("zone identifiers are not supported")
+
+// parseIP parses an IPv4 or IPv6 address in any of its textual forms, e.g. compressed or
+// expanded, returning IPv4-mapped IPv6 addresses such as ::ffff:1.2.3.4 as the IPv4 address they
+// map, so that an address compares the same whichever form the client connected with.
+func parseIP(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, errIPZone
+	}
+	return addr.Unmap(), nil
+}
+
+// parseIPRange parses a range in CIDR notation, returning an IPv4-mapped IPv6 range of at least
+// 96 bits, such as ::ffff:1.2.0.0/112, as the IPv4 range it maps, e.g. 1.2.0.0/16.
+func parseIPRange(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "%") {
+		return netip.Prefix{}, errIPZone
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		return netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96).Masked(), nil
+	}
+	return prefix.Masked(), nil
+}
+
+// inIPRange implements inIpRange. IPv4 addresses, including IPv4-mapped IPv6 addresses, are only
+// within IPv4 ranges and IPv4-mapped IPv6 ranges, and IPv6 addresses are only within IPv6 ranges.
+func inIPRange(ip, ipRange string) ref.Val {
+	addr, err := parseIP(ip)
+	if err != nil {
+		if errors.Is(err, errIPZone) {
+			return evalErr(ErrorInvalidIP, "invalid IP address: %s: %v", ip, err)
+		}
+		return evalErr(ErrorInvalidIP, "invalid IP address: %s", ip)
+	}
+	prefix, err := parseIPRange(ipRange)
+	if err != nil {
+		if errors.Is(err, errIPZone) {
+			return evalErr(ErrorInvalidIPRange, "invalid IP range: %s: %v", ipRange, err)
+		}
+		return evalErr(ErrorInvalidIPRange, "invalid IP range: %s", ipRange)
+	}
+	return types.Bool(prefix.Contains(addr))
+}
@@ -0,0 +1,291 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipset provides sets of IP addresses built from CIDR ranges, with the union,
+// intersection, and subtraction of sets and the conversion of a set to the fewest CIDR ranges
+// which cover it exactly, so that a list of ranges can be minimized before it is embedded in a
+// rule:
+//
+//	ranges, err := ipset.Minimize([]string{"192.0.2.0/25", "192.0.2.128/25", "192.0.2.7/32"})
+//	// ranges == []string{"192.0.2.0/24"}
+//
+// Ranges are interpreted as by inIpRange: IPv4-mapped IPv6 ranges of at least 96 bits, such as
+// ::ffff:192.0.2.0/120, are the IPv4 ranges they map, and IPv4 and IPv6 addresses never overlap.
+package ipset
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Set is an immutable set of IP addresses. The zero value is the empty set.
+type Set struct {
+	// ivs contains the sorted, disjoint, and non-adjacent intervals of the set.
+	ivs []interval
+}
+
+// interval contains the addresses from lo to hi inclusive, which belong to the same family.
+type interval struct {
+	lo, hi netip.Addr
+}
+
+// Parse returns the set of addresses within the CIDR ranges. A bare address is a range of a
+// single address. The return value is an error if a range is invalid or has an IPv6 zone
+// identifier.
+func Parse(cidrs ...string) (*Set, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := parsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return New(prefixes...), nil
+}
+
+// New returns the set of addresses within the prefixes. Invalid prefixes are ignored.
+func New(prefixes ...netip.Prefix) *Set {
+	ivs := make([]interval, 0, len(prefixes))
+	for _, p := range prefixes {
+		if !p.IsValid() {
+			continue
+		}
+		p = unmapPrefix(p)
+		ivs = append(ivs, interval{lo: p.Addr(), hi: lastAddr(p)})
+	}
+	return merge(ivs)
+}
+
+// Minimize returns the fewest CIDR ranges which cover exactly the addresses within the given
+// ranges, IPv4 ranges first, each in ascending order.
+func Minimize(cidrs []string) ([]string, error) {
+	s, err := Parse(cidrs...)
+	if err != nil {
+		return nil, err
+	}
+	return s.Strings(), nil
+}
+
+// Empty reports whether the set contains no addresses.
+func (s *Set) Empty() bool {
+	return s == nil || len(s.ivs) == 0
+}
+
+// Contains reports whether the set contains the address. An IPv4-mapped IPv6 address is
+// contained when the IPv4 address it maps is.
+func (s *Set) Contains(addr netip.Addr) bool {
+	if s == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	i := sort.Search(len(s.ivs), func(i int) bool { return addr.Compare(s.ivs[i].hi) <= 0 })
+	return i < len(s.ivs) && s.ivs[i].lo.Compare(addr) <= 0
+}
+
+// Union returns the addresses within either set.
+func (s *Set) Union(o *Set) *Set {
+	var ivs []interval
+	ivs = append(ivs, s.intervals()...)
+	ivs = append(ivs, o.intervals()...)
+	return merge(ivs)
+}
+
+// Intersect returns the addresses within both sets.
+func (s *Set) Intersect(o *Set) *Set {
+	a, b := s.intervals(), o.intervals()
+	var ivs []interval
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		lo, hi := maxAddr(a[i].lo, b[j].lo), minAddr(a[i].hi, b[j].hi)
+		if lo.Compare(hi) <= 0 {
+			ivs = append(ivs, interval{lo: lo, hi: hi})
+		}
+		if a[i].hi.Compare(b[j].hi) < 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+	return &Set{ivs: ivs}
+}
+
+// Subtract returns the addresses within s which are not within o.
+func (s *Set) Subtract(o *Set) *Set {
+	b := o.intervals()
+	var ivs []interval
+	j := 0
+	for _, iv := range s.intervals() {
+		lo := iv.lo
+		for j < len(b) && b[j].hi.Compare(lo) < 0 {
+			j++
+		}
+		covered := false
+		for k := j; k < len(b) && b[k].lo.Compare(iv.hi) <= 0; k++ {
+			if lo.Compare(b[k].lo) < 0 {
+				ivs = append(ivs, interval{lo: lo, hi: b[k].lo.Prev()})
+			}
+			if b[k].hi.Compare(iv.hi) >= 0 {
+				covered = true
+				break
+			}
+			lo = b[k].hi.Next()
+		}
+		if !covered {
+			ivs = append(ivs, interval{lo: lo, hi: iv.hi})
+		}
+	}
+	return &Set{ivs: ivs}
+}
+
+// Prefixes returns the fewest prefixes which cover exactly the addresses of the set, IPv4
+// prefixes first, each in ascending order.
+func (s *Set) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, iv := range s.intervals() {
+		lo := iv.lo
+		for {
+			p := largestPrefix(lo, iv.hi)
+			prefixes = append(prefixes, p)
+			last := lastAddr(p)
+			if last == iv.hi {
+				break
+			}
+			lo = last.Next()
+		}
+	}
+	return prefixes
+}
+
+// Strings returns the prefixes of the set in CIDR notation.
+func (s *Set) Strings() []string {
+	prefixes := s.Prefixes()
+	strs := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		strs[i] = p.String()
+	}
+	return strs
+}
+
+// String implements the fmt.Stringer interface.
+func (s *Set) String() string {
+	return "{" + strings.Join(s.Strings(), ", ") + "}"
+}
+
+func (s *Set) intervals() []interval {
+	if s == nil {
+		return nil
+	}
+	return s.ivs
+}
+
+// parsePrefix parses a range in CIDR notation, or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "%") {
+		return netip.Prefix{}, fmt.Errorf("%q: zone identifiers are not supported", s)
+	}
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%q is not a valid IP address or CIDR range", s)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not a valid CIDR range", s)
+	}
+	return p, nil
+}
+
+// unmapPrefix returns an IPv4-mapped IPv6 prefix of at least 96 bits as the IPv4 prefix it maps,
+// and masks the prefix.
+func unmapPrefix(p netip.Prefix) netip.Prefix {
+	if addr := p.Addr(); addr.Is4In6() && p.Bits() >= 96 {
+		return netip.PrefixFrom(addr.Unmap(), p.Bits()-96).Masked()
+	}
+	return p.Masked()
+}
+
+// merge sorts the intervals and joins those which overlap or are adjacent. Intervals of
+// different families are never joined: the successor of the last IPv4 address is invalid, and
+// every IPv4 address sorts before every IPv6 address.
+func merge(ivs []interval) *Set {
+	if len(ivs) == 0 {
+		return &Set{}
+	}
+	sorted := append([]interval(nil), ivs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].lo.Compare(sorted[j].lo) < 0 })
+	out := sorted[:1]
+	for _, iv := range sorted[1:] {
+		last := &out[len(out)-1]
+		if iv.lo.Compare(last.hi) <= 0 || last.hi.Next() == iv.lo {
+			last.hi = maxAddr(last.hi, iv.hi)
+			continue
+		}
+		out = append(out, iv)
+	}
+	return &Set{ivs: out}
+}
+
+// largestPrefix returns the largest prefix which begins at lo and ends at or before hi.
+func largestPrefix(lo, hi netip.Addr) netip.Prefix {
+	for bits := 0; bits < lo.BitLen(); bits++ {
+		p := netip.PrefixFrom(lo, bits)
+		if p.Masked().Addr() == lo && lastAddr(p).Compare(hi) <= 0 {
+			return p
+		}
+	}
+	return netip.PrefixFrom(lo, lo.BitLen())
+}
+
+// lastAddr returns the last address of the prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	addr := p.Masked().Addr()
+	if addr.Is4() {
+		b := addr.As4()
+		setHostBits(b[:], p.Bits())
+		return netip.AddrFrom4(b)
+	}
+	b := addr.As16()
+	setHostBits(b[:], p.Bits())
+	return netip.AddrFrom16(b)
+}
+
+func setHostBits(b []byte, bits int) {
+	for i := range b {
+		switch {
+		case bits >= (i+1)*8:
+		case bits <= i*8:
+			b[i] = 0xff
+		default:
+			b[i] |= 0xff >> (bits - i*8)
+		}
+	}
+}
+
+func minAddr(a, b netip.Addr) netip.Addr {
+	if a.Compare(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+func maxAddr(a, b netip.Addr) netip.Addr {
+	if a.Compare(b) >= 0 {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,164 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipset_test
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/ipset"
+)
+
+func mustParse(t *testing.T, cidrs ...string) *ipset.Set {
+	t.Helper()
+	s, err := ipset.Parse(cidrs...)
+	if err != nil {
+		t.Fatalf("ipset.Parse(%q) failed: %v", cidrs, err)
+	}
+	return s
+}
+
+func TestMinimize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{
+			name: "adjacent halves",
+			in:   []string{"192.0.2.128/25", "192.0.2.0/25"},
+			want: []string{"192.0.2.0/24"},
+		},
+		{
+			name: "contained and duplicate ranges",
+			in:   []string{"10.0.0.0/8", "10.1.2.3", "10.0.0.0/8", "10.255.0.0/16"},
+			want: []string{"10.0.0.0/8"},
+		},
+		{
+			name: "unaligned run",
+			in:   []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"},
+			want: []string{"192.0.2.1/32", "192.0.2.2/31", "192.0.2.4/32"},
+		},
+		{
+			name: "host bits are masked",
+			in:   []string{"198.51.100.77/24"},
+			want: []string{"198.51.100.0/24"},
+		},
+		{
+			name: "IPv4-mapped ranges",
+			in:   []string{"::ffff:192.0.2.0/121", "192.0.2.128/25"},
+			want: []string{"192.0.2.0/24"},
+		},
+		{
+			name: "families are not joined",
+			in:   []string{"2001:db8::/33", "255.255.255.255", "::/128", "2001:db8:8000::/33"},
+			want: []string{"255.255.255.255/32", "::/128", "2001:db8::/32"},
+		},
+		{
+			name: "whole address space",
+			in:   []string{"0.0.0.0/1", "128.0.0.0/1"},
+			want: []string{"0.0.0.0/0"},
+		},
+		{
+			name: "empty",
+			want: []string{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ipset.Minimize(tc.in)
+			if err != nil {
+				t.Fatalf("ipset.Minimize(%q) failed: %v", tc.in, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ipset.Minimize(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{"192.0.2.0/33", "not-an-ip", "fe80::1%eth0", "fe80::%eth0/64", ""} {
+		if _, err := ipset.Parse(in); err == nil {
+			t.Errorf("ipset.Parse(%q) succeeded, wanted an error", in)
+		}
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	a := mustParse(t, "10.0.0.0/24", "2001:db8::/126")
+	b := mustParse(t, "10.0.0.128/25", "10.0.1.0/24", "2001:db8::1")
+	tests := []struct {
+		name string
+		got  *ipset.Set
+		want []string
+	}{
+		{
+			name: "union",
+			got:  a.Union(b),
+			want: []string{"10.0.0.0/23", "2001:db8::/126"},
+		},
+		{
+			name: "intersect",
+			got:  a.Intersect(b),
+			want: []string{"10.0.0.128/25", "2001:db8::1/128"},
+		},
+		{
+			name: "subtract",
+			got:  a.Subtract(b),
+			want: []string{"10.0.0.0/25", "2001:db8::/128", "2001:db8::2/127"},
+		},
+		{
+			name: "subtract everything",
+			got:  b.Subtract(mustParse(t, "0.0.0.0/0", "::/0")),
+			want: []string{},
+		},
+		{
+			name: "subtract from the middle",
+			got:  mustParse(t, "192.0.2.0/24").Subtract(mustParse(t, "192.0.2.64/26")),
+			want: []string{"192.0.2.0/26", "192.0.2.128/25"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.got.Strings(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	s := mustParse(t, "192.0.2.0/24", "2001:db8::/32")
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"192.0.2.7", true},
+		{"::ffff:192.0.2.7", true},
+		{"192.0.3.0", false},
+		{"2001:db8:ffff::1", true},
+		{"2001:db9::", false},
+	}
+	for _, tc := range tests {
+		if got := s.Contains(netip.MustParseAddr(tc.addr)); got != tc.want {
+			t.Errorf("Contains(%s) = %t, want %t", tc.addr, got, tc.want)
+		}
+	}
+	if !new(ipset.Set).Empty() || s.Empty() {
+		t.Error("Empty() did not distinguish the empty set")
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+)
+
+// DefaultMaxBodySize is the number of bytes of the request body which Cloud Armor inspects by
+// default.
+const DefaultMaxBodySize = 8192
+
+// Limits configures the limits enforced by WithLimits, which differ between Cloud Armor tiers
+// and policy configurations.
+type Limits struct {
+	// MaxExpressionLength is the maximum length of an expression in characters.
+	MaxExpressionLength int `yaml:"max_expr_length"`
+	// CostLimit is the maximum cost of a single evaluation, or 0 for no limit.
+	CostLimit uint64 `yaml:"cost_limit"`
+	// MaxStringSize is the maximum length of a string produced by a string function or by
+	// concatenation, or 0 for no limit.
+	MaxStringSize int `yaml:"max_string_size"`
+	// MaxBodySize is the number of bytes of request.body visible to expressions; longer bodies
+	// are truncated before evaluation, as Cloud Armor inspects only the start of the body.
+	MaxBodySize int `yaml:"max_body_size"`
+}
+
+// DefaultLimits returns the limits which Cloud Armor enforces by default.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxExpressionLength: MaxExpressionLength,
+		MaxBodySize:         DefaultMaxBodySize,
+	}
+}
+
+// WithLimits enforces the given limits, so that rules are compiled and evaluated as they would be
+// by a Cloud Armor tier with those limits. Start from DefaultLimits and override the limits
+// which differ.
+//
+// Expressions longer than the maximum length fail to compile, and programs return an error
+// rather than a result when an evaluation exceeds the cost limit or produces a string longer than
+// the maximum string size. Where UntrustedMode is also enabled, the stricter of each limit
+// applies.
+func WithLimits(limits Limits) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		if limits.MaxExpressionLength <= 0 || limits.MaxStringSize < 0 || limits.MaxBodySize <= 0 {
+			return nil, fmt.Errorf("invalid limits: %+v", limits)
+		}
+		r.limits = &limits
+		return r, nil
+	}
+}
+
+// checkExpressionLength rejects the expression if it exceeds the maximum length of either the
+// limits or the untrusted limits.
+func (r *Rules) checkExpressionLength(expr string) error {
+	maxLen := 0
+	if r.limits != nil {
+		maxLen = r.limits.MaxExpressionLength
+	}
+	if r.untrusted != nil && (maxLen == 0 || r.untrusted.MaxExpressionLength < maxLen) {
+		maxLen = r.untrusted.MaxExpressionLength
+	}
+	if maxLen != 0 && len(expr) > maxLen {
+		return fmt.Errorf("expression length %d exceeds the maximum of %d", len(expr), maxLen)
+	}
+	return nil
+}
+
+// limitsProgramOptions returns the program options which enforce the evaluation limits.
+func (r *Rules) limitsProgramOptions() []cel.ProgramOption {
+	if r.limits == nil {
+		return nil
+	}
+	var opts []cel.ProgramOption
+	// Untrusted mode always sets a cost limit, so this one applies only when it is stricter.
+	if r.limits.CostLimit != 0 && (r.untrusted == nil || r.limits.CostLimit < r.untrusted.CostLimit) {
+		opts = append(opts, cel.CostLimit(r.limits.CostLimit))
+	}
+	if r.limits.MaxStringSize != 0 {
+		opts = append(opts, cel.CustomDecorator(stringSizeDecorator(r.limits.MaxStringSize)))
+	}
+	return opts
+}
+
+// bodyLimitProgram truncates request.body to the maximum body size before evaluating the wrapped
+// program.
+type bodyLimitProgram struct {
+	cel.Program
+	maxSize int
+}
+
+// Eval implements the cel.Program interface.
+func (p *bodyLimitProgram) Eval(input any) (ref.Val, *cel.EvalDetails, error) {
+	return p.Program.Eval(p.activation(input))
+}
+
+// ContextEval implements the cel.Program interface.
+func (p *bodyLimitProgram) ContextEval(ctx context.Context, input any) (ref.Val, *cel.EvalDetails, error) {
+	return p.Program.ContextEval(ctx, p.activation(input))
+}
+
+func (p *bodyLimitProgram) activation(input any) any {
+	act, ok := input.(interpreter.Activation)
+	if !ok {
+		return input
+	}
+	limited := &bodyLimitActivation{Activation: act, maxSize: p.maxSize}
+	// Partial evaluation recognizes only an input which is itself a PartialActivation, so the
+	// unknown attributes of a partial input must remain visible through the wrapper.
+	if partial, ok := act.(interpreter.PartialActivation); ok {
+		return &partialBodyLimitActivation{bodyLimitActivation: limited, partial: partial}
+	}
+	return limited
+}
+
+// partialBodyLimitActivation is a bodyLimitActivation over a PartialActivation, whose unknown
+// attribute patterns it forwards.
+type partialBodyLimitActivation struct {
+	*bodyLimitActivation
+	partial interpreter.PartialActivation
+}
+
+// UnknownAttributePatterns implements the interpreter.PartialActivation interface.
+func (a *partialBodyLimitActivation) UnknownAttributePatterns() []*interpreter.AttributePattern {
+	return a.partial.UnknownAttributePatterns()
+}
+
+// AsPartialActivation returns the activation as an interpreter.PartialActivation.
+func (a *partialBodyLimitActivation) AsPartialActivation() (interpreter.PartialActivation, bool) {
+	return a, true
+}
+
+// bodyLimitActivation resolves request.body truncated to the maximum body size.
+type bodyLimitActivation struct {
+	interpreter.Activation
+	maxSize int
+}
+
+// ResolveName implements the interpreter.Activation interface.
+func (a *bodyLimitActivation) ResolveName(name string) (any, bool) {
+	val, found := a.Activation.ResolveName(name)
+	if !found || name != "request.body" {
+		return val, found
+	}
+	switch body := val.(type) {
+	case string:
+		if len(body) > a.maxSize {
+			return body[:a.maxSize], true
+		}
+	case types.String:
+		if len(body) > a.maxSize {
+			return body[:a.maxSize], true
+		}
+	}
+	return val, true
+}
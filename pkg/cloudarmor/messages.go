@@ -0,0 +1,128 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"maps"
+)
+
+// MessageID identifies a user-facing diagnostic produced by the library, such as a compile hint
+// or a test failure, so that it can be localized.
+type MessageID string
+
+const (
+	// MessageSymbolRequiresVersion reports the version which declares an undeclared symbol.
+	MessageSymbolRequiresVersion MessageID = "symbol_requires_version"
+	// MessageSymbolRequiresVersionOrFeature reports the version and the feature which declare an
+	// undeclared symbol.
+	MessageSymbolRequiresVersionOrFeature MessageID = "symbol_requires_version_or_feature"
+	// MessageSymbolRequiresProfile reports the profile which declares an undeclared symbol.
+	MessageSymbolRequiresProfile MessageID = "symbol_requires_profile"
+	// MessageSymbolRequiresProfileVersion reports the profile and version which declare an
+	// undeclared symbol.
+	MessageSymbolRequiresProfileVersion MessageID = "symbol_requires_profile_version"
+	// MessageDidYouMean suggests the declared symbol closest to a misspelled one.
+	MessageDidYouMean MessageID = "did_you_mean"
+	// MessageRelationalMixed reports a relational operator applied to an int and a double.
+	MessageRelationalMixed MessageID = "relational_mixed"
+	// MessageRelationalTypes reports a relational operator applied to unsupported types.
+	MessageRelationalTypes MessageID = "relational_types"
+	// MessageUnexpectedResult reports a test case whose result differs from its expectation.
+	MessageUnexpectedResult MessageID = "unexpected_result"
+	// MessageUnexpectedError reports a test case whose error differs from its expectation.
+	MessageUnexpectedError MessageID = "unexpected_error"
+	// MessageUnexpectedErrorCode reports a test case whose error is not of the expected code.
+	MessageUnexpectedErrorCode MessageID = "unexpected_error_code"
+	// MessageMissingError reports a test case which expects an error code but evaluated to a
+	// result.
+	MessageMissingError MessageID = "missing_error"
+	// MessageUnexpectedAction reports a policy test case whose matched action differs from its
+	// expectation.
+	MessageUnexpectedAction MessageID = "unexpected_action"
+	// MessageUnexpectedHeaders reports a policy test case whose inserted request headers differ
+	// from its expectation.
+	MessageUnexpectedHeaders MessageID = "unexpected_headers"
+	// MessageCostTrackingRequired reports a max_cost budget on a program without cost tracking.
+	MessageCostTrackingRequired MessageID = "cost_tracking_required"
+	// MessageCostExceeded reports a test case which exceeded its max_cost budget.
+	MessageCostExceeded MessageID = "cost_exceeded"
+	// MessageLatencyExceeded reports a test case which exceeded its max_latency_ms budget.
+	MessageLatencyExceeded MessageID = "latency_exceeded"
+)
+
+// catalog contains the English format of each message. The arguments are referenced by index so
+// that translations may reorder them.
+var catalog = map[MessageID]string{
+	MessageSymbolRequiresVersion:          "%[1]s requires %[2]s",
+	MessageSymbolRequiresVersionOrFeature: "%[1]s requires %[2]s or the %[3]s feature",
+	MessageSymbolRequiresProfile:          "%[1]s requires the %[2]s profile",
+	MessageSymbolRequiresProfileVersion:   "%[1]s requires the %[2]s profile with %[3]s",
+	MessageDidYouMean:                     "did you mean '%[1]s'?",
+	MessageRelationalMixed: "int and double operands may not be mixed, write literals to match the attribute type, " +
+		"e.g. origin.asn %[1]s 64512 or token.recaptcha_action.score %[1]s 0.5",
+	MessageRelationalTypes:      "'%[1]s' is only supported between two int or two double operands",
+	MessageUnexpectedResult:     "expected result %[1]v, got %[2]v",
+	MessageUnexpectedError:      "got error %[1]q, wanted error containing %[2]q",
+	MessageUnexpectedErrorCode:  "got error %[1]q with code %[2]q, wanted error code %[3]q",
+	MessageMissingError:         "got result %[1]v, wanted error code %[2]q",
+	MessageUnexpectedAction:     "expected action %[1]q, got %[2]q",
+	MessageUnexpectedHeaders:    "expected headers %[1]v, got %[2]v",
+	MessageCostTrackingRequired: "max_cost requires a program created with cel.CostTracking",
+	MessageCostExceeded:         "cost %[1]d exceeds max_cost %[2]d",
+	MessageLatencyExceeded:      "latency %[1]v exceeds max_latency_ms %[2]v",
+}
+
+// MessageCatalog returns the English format of each message, in the fmt syntax with indexed
+// arguments, as the source for translations.
+func MessageCatalog() map[MessageID]string {
+	return maps.Clone(catalog)
+}
+
+// Localizer returns the localized text of a message given its arguments, or the empty string to
+// fall back to English.
+type Localizer func(id MessageID, args ...any) string
+
+// CatalogLocalizer returns a Localizer which formats the arguments with the translated formats
+// of a catalog, which use the same indexed arguments as MessageCatalog.
+func CatalogLocalizer(formats map[MessageID]string) Localizer {
+	return func(id MessageID, args ...any) string {
+		format, found := formats[id]
+		if !found {
+			return ""
+		}
+		return fmt.Sprintf(format, args...)
+	}
+}
+
+// WithLocalizer localizes the diagnostics which the Rules add to compile errors and the failure
+// messages of test cases. Errors reported by CEL itself, such as undeclared references, and by
+// evaluations remain in English.
+func WithLocalizer(l Localizer) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.localizer = l
+		return r, nil
+	}
+}
+
+// message returns the text of a message, localized if the Rules have a Localizer.
+func (r *Rules) message(id MessageID, args ...any) string {
+	if r != nil && r.localizer != nil {
+		if msg := r.localizer(id, args...); msg != "" {
+			return msg
+		}
+	}
+	return fmt.Sprintf(catalog[id], args...)
+}
@@ -0,0 +1,324 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mutation measures the quality of a rule's test suite by systematically perturbing the
+// rule expression and reporting the mutants which none of the test cases detect.
+package mutation
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/parser"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// Mutant is a perturbed version of a rule expression.
+type Mutant struct {
+	// Description explains the mutation, e.g. `replace && with ||`.
+	Description string
+	// Expr is the mutated expression.
+	Expr string
+	// KilledBy is the name of the first test case which failed against the mutant, or the empty
+	// string if the mutant survived.
+	KilledBy string
+}
+
+// Killed reports whether at least one test case failed against the mutant.
+func (m Mutant) Killed() bool {
+	return m.KilledBy != ""
+}
+
+// Report summarizes how many of the mutants of an expression the test suite killed.
+type Report struct {
+	Expr string
+	// Mutants contains every mutant which compiled, in the order they were generated.
+	Mutants []Mutant
+	// Invalid is the number of mutants which failed to compile and were not run.
+	Invalid int
+}
+
+// Survivors returns the mutants which no test case killed.
+func (r *Report) Survivors() []Mutant {
+	var survivors []Mutant
+	for _, m := range r.Mutants {
+		if !m.Killed() {
+			survivors = append(survivors, m)
+		}
+	}
+	return survivors
+}
+
+// Score returns the fraction of mutants which were killed, or 1 if there are no mutants.
+func (r *Report) Score() float64 {
+	if len(r.Mutants) == 0 {
+		return 1
+	}
+	return float64(len(r.Mutants)-len(r.Survivors())) / float64(len(r.Mutants))
+}
+
+// Run generates the mutants of the expression and runs the test cases against each of them.
+//
+// The return value is an error if the expression fails to compile or if any test case fails
+// against the original expression, since mutants can only be judged by a passing suite.
+func Run(r *cloudarmor.Rules, expr string, tests []*cloudarmor.TestCase) (*Report, error) {
+	a, err := r.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	prg, err := r.Program(a)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range r.RunRuleValidation(prg, tests) {
+		if !s.Pass {
+			return nil, fmt.Errorf("test case %q fails against the original expression: %s", s.Name, s.Fail)
+		}
+	}
+	mutants, err := Generate(r, expr)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Expr: expr}
+	for _, m := range mutants {
+		a, err := r.Compile(m.Expr)
+		if err != nil {
+			report.Invalid++
+			continue
+		}
+		prg, err := r.Program(a)
+		if err != nil {
+			report.Invalid++
+			continue
+		}
+		for _, s := range r.RunRuleValidation(prg, tests) {
+			if !s.Pass {
+				m.KilledBy = s.Name
+				break
+			}
+		}
+		report.Mutants = append(report.Mutants, m)
+	}
+	return report, nil
+}
+
+// Generate returns the distinct mutants of the expression, each differing from the original by
+// a single mutation:
+//
+//   - negating an operand of && or ||, or the whole expression when it is a single clause
+//   - swapping && and ||
+//   - removing a negation
+//   - swapping == and !=, and moving the boundary of <, <=, >, and >=
+//   - changing a string, numeric, or boolean literal
+//
+// Mutants are not compiled, so some of them may not type-check.
+func Generate(r *cloudarmor.Rules, expr string) ([]Mutant, error) {
+	env, err := r.Env().Extend(cel.EnableMacroCallTracking())
+	if err != nil {
+		return nil, err
+	}
+	a, iss := env.Parse(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	g := &generator{
+		root: a.NativeRep().Expr(),
+		info: a.NativeRep().SourceInfo(),
+		fac:  ast.NewExprFactory(),
+		seen: map[string]bool{},
+	}
+	g.nextID = maxID(g.root) + 1
+	original, err := g.unparse(g.root)
+	if err != nil {
+		return nil, err
+	}
+	g.seen[original] = true
+
+	if !isLogical(g.root) {
+		g.add(g.root.ID(), "negate the expression", g.negate)
+	}
+	ast.PreOrderVisit(g.root, ast.NewExprVisitor(g.visit))
+	return g.mutants, nil
+}
+
+// generator accumulates the mutants of a parsed expression.
+type generator struct {
+	root    ast.Expr
+	info    *ast.SourceInfo
+	fac     ast.ExprFactory
+	nextID  int64
+	seen    map[string]bool
+	mutants []Mutant
+}
+
+// swaps pairs each mutated operator with its replacement.
+var swaps = map[string]string{
+	operators.LogicalAnd:    operators.LogicalOr,
+	operators.LogicalOr:     operators.LogicalAnd,
+	operators.Equals:        operators.NotEquals,
+	operators.NotEquals:     operators.Equals,
+	operators.Less:          operators.LessEquals,
+	operators.LessEquals:    operators.Less,
+	operators.Greater:       operators.GreaterEquals,
+	operators.GreaterEquals: operators.Greater,
+}
+
+// visit adds the mutants of a single node.
+func (g *generator) visit(e ast.Expr) {
+	switch e.Kind() {
+	case ast.CallKind:
+		call := e.AsCall()
+		fn := call.FunctionName()
+		if swap, found := swaps[fn]; found {
+			desc := fmt.Sprintf("replace %s with %s", displayOp(fn), displayOp(swap))
+			g.add(e.ID(), desc, func(e ast.Expr) ast.Expr {
+				return g.fac.NewCall(e.ID(), swap, call.Args()...)
+			})
+		}
+		if isLogical(e) {
+			for _, arg := range call.Args() {
+				if isLogical(arg) {
+					continue
+				}
+				clause, _ := g.unparse(arg)
+				g.add(arg.ID(), fmt.Sprintf("negate %s", clause), g.negate)
+			}
+		}
+		if fn == operators.LogicalNot {
+			clause, _ := g.unparse(e)
+			g.add(e.ID(), fmt.Sprintf("remove the negation of %s", clause), func(e ast.Expr) ast.Expr {
+				return e.AsCall().Args()[0]
+			})
+		}
+	case ast.LiteralKind:
+		mutated, ok := mutateLiteral(e.AsLiteral())
+		if !ok {
+			return
+		}
+		lit, _ := g.unparse(e)
+		m := g.fac.NewLiteral(e.ID(), mutated)
+		to, _ := g.unparse(m)
+		g.add(e.ID(), fmt.Sprintf("change %s to %s", lit, to), func(e ast.Expr) ast.Expr { return m })
+	}
+}
+
+// add records the mutant produced by replacing the node with the given ID, unless an identical
+// mutant has already been recorded or the mutation is not visible in the expression text.
+func (g *generator) add(id int64, desc string, mutate func(ast.Expr) ast.Expr) {
+	mutated := g.replace(g.root, id, mutate)
+	text, err := g.unparse(mutated)
+	if err != nil || g.seen[text] {
+		return
+	}
+	g.seen[text] = true
+	g.mutants = append(g.mutants, Mutant{Description: desc, Expr: text})
+}
+
+// negate wraps the expression in a logical negation.
+func (g *generator) negate(e ast.Expr) ast.Expr {
+	g.nextID++
+	return g.fac.NewCall(g.nextID, operators.LogicalNot, e)
+}
+
+// replace returns a copy of the expression in which the node with the given ID is replaced by
+// the result of mutate.
+func (g *generator) replace(e ast.Expr, id int64, mutate func(ast.Expr) ast.Expr) ast.Expr {
+	if e.ID() == id {
+		return mutate(g.fac.CopyExpr(e))
+	}
+	switch e.Kind() {
+	case ast.CallKind:
+		call := e.AsCall()
+		args := make([]ast.Expr, len(call.Args()))
+		for i, arg := range call.Args() {
+			args[i] = g.replace(arg, id, mutate)
+		}
+		if call.IsMemberFunction() {
+			return g.fac.NewMemberCall(e.ID(), call.FunctionName(), g.replace(call.Target(), id, mutate), args...)
+		}
+		return g.fac.NewCall(e.ID(), call.FunctionName(), args...)
+	case ast.SelectKind:
+		sel := e.AsSelect()
+		operand := g.replace(sel.Operand(), id, mutate)
+		if sel.IsTestOnly() {
+			return g.fac.NewPresenceTest(e.ID(), operand, sel.FieldName())
+		}
+		return g.fac.NewSelect(e.ID(), operand, sel.FieldName())
+	case ast.ListKind:
+		list := e.AsList()
+		elems := make([]ast.Expr, len(list.Elements()))
+		for i, elem := range list.Elements() {
+			elems[i] = g.replace(elem, id, mutate)
+		}
+		return g.fac.NewList(e.ID(), elems, list.OptionalIndices())
+	}
+	return g.fac.CopyExpr(e)
+}
+
+// unparse returns the single line form of an expression.
+func (g *generator) unparse(e ast.Expr) (string, error) {
+	return parser.Unparse(e, g.info, parser.WrapOnColumn(math.MaxInt))
+}
+
+// mutateLiteral returns a different literal of the same type.
+func mutateLiteral(v any) (ref.Val, bool) {
+	switch v := v.(type) {
+	case types.String:
+		if v == "" {
+			return types.String("mutant"), true
+		}
+		return types.String(""), true
+	case types.Int:
+		return v + 1, true
+	case types.Uint:
+		return v + 1, true
+	case types.Double:
+		return v + 1, true
+	case types.Bool:
+		return !v, true
+	}
+	return nil, false
+}
+
+// isLogical reports whether the expression is a call to && or ||.
+func isLogical(e ast.Expr) bool {
+	if e.Kind() != ast.CallKind {
+		return false
+	}
+	fn := e.AsCall().FunctionName()
+	return fn == operators.LogicalAnd || fn == operators.LogicalOr
+}
+
+// displayOp returns the symbol of an operator function, e.g. && for _&&_.
+func displayOp(fn string) string {
+	if op, found := operators.FindReverse(fn); found {
+		return op
+	}
+	return fn
+}
+
+// maxID returns the largest expression ID within the expression.
+func maxID(e ast.Expr) int64 {
+	var id int64
+	ast.PostOrderVisit(e, ast.NewExprVisitor(func(e ast.Expr) {
+		id = max(id, e.ID())
+	}))
+	return id
+}
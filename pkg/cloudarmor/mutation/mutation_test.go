@@ -0,0 +1,122 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutation_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/mutation"
+)
+
+func TestGenerate(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	mutants, err := mutation.Generate(rules,
+		"request.method == 'POST' && !has(request.headers['x-token']) && origin.asn > 100")
+	if err != nil {
+		t.Fatalf("mutation.Generate() returned error: %v", err)
+	}
+	got := map[mutation.Mutant]bool{}
+	for _, m := range mutants {
+		got[m] = true
+	}
+	want := map[string]string{
+		"replace && with ||":                                      `(request.method == "POST" || !has(request.headers["x-token"])) && origin.asn > 100`,
+		"replace == with !=":                                      `request.method != "POST" && !has(request.headers["x-token"]) && origin.asn > 100`,
+		"replace > with >=":                                       `request.method == "POST" && !has(request.headers["x-token"]) && origin.asn >= 100`,
+		`negate request.method == "POST"`:                         `!(request.method == "POST") && !has(request.headers["x-token"]) && origin.asn > 100`,
+		`remove the negation of !has(request.headers["x-token"])`: `request.method == "POST" && has(request.headers["x-token"]) && origin.asn > 100`,
+		`change "POST" to ""`:                                     `request.method == "" && !has(request.headers["x-token"]) && origin.asn > 100`,
+		"change 100 to 101":                                       `request.method == "POST" && !has(request.headers["x-token"]) && origin.asn > 101`,
+	}
+	for desc, expr := range want {
+		if !got[mutation.Mutant{Description: desc, Expr: expr}] {
+			t.Errorf("mutation.Generate() is missing mutant %q: %s", desc, expr)
+		}
+	}
+	seen := map[string]bool{}
+	for _, m := range mutants {
+		if seen[m.Expr] {
+			t.Errorf("duplicate mutant %q", m.Expr)
+		}
+		seen[m.Expr] = true
+	}
+}
+
+func TestRun(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	expr := "request.method == 'POST' && request.path.startsWith('/admin')"
+	tests := []*cloudarmor.TestCase{
+		cloudarmor.SafeTestCase(&cloudarmor.TestCase{
+			Name:         "admin-post",
+			ExpectOutput: true,
+			When: &cloudarmor.Variables{Request: &cloudarmor.Request{
+				Method: "POST",
+				Path:   "/admin/users",
+			}},
+		}),
+	}
+	report, err := mutation.Run(rules, expr, tests)
+	if err != nil {
+		t.Fatalf("mutation.Run() returned error: %v", err)
+	}
+	if len(report.Mutants) == 0 {
+		t.Fatal("mutation.Run() produced no mutants")
+	}
+	// A single positive test case cannot kill the mutants which widen the rule.
+	var survived bool
+	for _, m := range report.Survivors() {
+		if m.Description == "replace && with ||" {
+			survived = true
+		}
+	}
+	if !survived {
+		t.Errorf("report.Survivors() = %v, wanted the && to || mutant to survive", report.Survivors())
+	}
+
+	tests = append(tests, cloudarmor.SafeTestCase(&cloudarmor.TestCase{
+		Name: "get",
+		When: &cloudarmor.Variables{Request: &cloudarmor.Request{
+			Method: "GET",
+			Path:   "/admin/users",
+		}},
+	}), cloudarmor.SafeTestCase(&cloudarmor.TestCase{
+		Name: "public-post",
+		When: &cloudarmor.Variables{Request: &cloudarmor.Request{
+			Method: "POST",
+			Path:   "/public",
+		}},
+	}))
+	report, err = mutation.Run(rules, expr, tests)
+	if err != nil {
+		t.Fatalf("mutation.Run() returned error: %v", err)
+	}
+	if report.Score() != 1 {
+		t.Errorf("report.Score() = %v, want 1; survivors: %v", report.Score(), report.Survivors())
+	}
+
+	tests[0].ExpectOutput = false
+	_, err = mutation.Run(rules, expr, tests)
+	if err == nil || !strings.Contains(err.Error(), `test case "admin-post" fails against the original expression`) {
+		t.Errorf("mutation.Run() returned error %v, wanted admin-post to fail against the original", err)
+	}
+}
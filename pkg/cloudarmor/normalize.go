@@ -0,0 +1,197 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PercentDecoding selects which percent-encoded octets of a request attribute are decoded.
+type PercentDecoding int
+
+const (
+	// DecodeNone leaves the attribute as received.
+	DecodeNone PercentDecoding = iota
+	// DecodeUnreserved decodes the octets of unreserved characters, i.e. letters, digits, '-',
+	// '.', '_', and '~', and upper-cases the hexadecimal digits of the remaining escapes, as by
+	// the syntax-based normalization of RFC 3986 section 6.2.2, so that %7e, %7E, and ~ compare
+	// equal while %2F remains distinct from a path separator.
+	DecodeUnreserved
+	// DecodeAll decodes every valid escape. Invalid escapes are left as received.
+	DecodeAll
+)
+
+// Normalization configures the preprocessing of request attributes before rules evaluate them,
+// so that local evaluation of path-based rules matches the requests as the front end which
+// routes them sees them, rather than as they were recorded by a client or a log.
+type Normalization struct {
+	// PathDecoding selects the percent-encoded octets of request.path which are decoded.
+	PathDecoding PercentDecoding
+	// QueryDecoding selects the percent-encoded octets of request.query which are decoded.
+	QueryDecoding PercentDecoding
+	// CollapseSlashes replaces each run of slashes within request.path with a single slash.
+	CollapseSlashes bool
+	// RemoveDotSegments resolves the "." and ".." segments of request.path as by RFC 3986
+	// section 5.2.4, after decoding and collapsing slashes, so that /a/%2E%2E/admin becomes
+	// /admin when unreserved characters are decoded.
+	RemoveDotSegments bool
+}
+
+// Normalization profiles.
+var (
+	// NormalizeNone leaves every attribute as received, which is the default.
+	NormalizeNone = Normalization{}
+	// NormalizeGFE approximates the preprocessing of the Google Front End, which normalizes the
+	// path of a request before routing it and before Cloud Armor evaluates it: unreserved
+	// characters are decoded and dot segments are removed, while the query is left as received.
+	NormalizeGFE = Normalization{PathDecoding: DecodeUnreserved, RemoveDotSegments: true}
+	// NormalizeStrict additionally collapses duplicate slashes and decodes every escape of the
+	// path, as servers which resolve paths to files commonly do, so that rules can be tested
+	// against the most aggressive interpretation of a path.
+	NormalizeStrict = Normalization{PathDecoding: DecodeAll, CollapseSlashes: true, RemoveDotSegments: true}
+)
+
+// normalizations contains the named Normalization profiles accepted by ParseNormalization.
+var normalizations = map[string]Normalization{
+	"none":   NormalizeNone,
+	"gfe":    NormalizeGFE,
+	"strict": NormalizeStrict,
+}
+
+// ParseNormalization returns the Normalization profile with the given name: none, gfe, or
+// strict.
+func ParseNormalization(name string) (Normalization, error) {
+	if n, found := normalizations[name]; found {
+		return n, nil
+	}
+	names := make([]string, 0, len(normalizations))
+	for name := range normalizations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return Normalization{}, fmt.Errorf("unknown normalization %q, must be one of %s", name, strings.Join(names, ", "))
+}
+
+// NormalizeRequests applies the normalization to the request attributes of decoded variables,
+// test cases, and corpus requests.
+func NormalizeRequests(n Normalization) YAMLOption {
+	return func(o *yamlOptions) {
+		o.normalization = n
+	}
+}
+
+// Normalize applies the normalization to the request attributes of the variables.
+func (v *Variables) Normalize(n Normalization) {
+	if v.Request == nil || n == NormalizeNone {
+		return
+	}
+	v.Request.Path = n.normalizePath(v.Request.Path)
+	v.Request.Query = percentDecode(v.Request.Query, n.QueryDecoding)
+}
+
+// normalizePath decodes, collapses, and resolves a path in that order.
+func (n Normalization) normalizePath(path string) string {
+	path = percentDecode(path, n.PathDecoding)
+	if n.CollapseSlashes {
+		var b strings.Builder
+		for i := 0; i < len(path); i++ {
+			if path[i] == '/' && i > 0 && path[i-1] == '/' {
+				continue
+			}
+			b.WriteByte(path[i])
+		}
+		path = b.String()
+	}
+	if n.RemoveDotSegments {
+		path = removeDotSegments(path)
+	}
+	return path
+}
+
+// percentDecode decodes the escapes of s selected by the mode.
+func percentDecode(s string, mode PercentDecoding) string {
+	if mode == DecodeNone || !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if mode == DecodeAll || isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// removeDotSegments implements the algorithm of RFC 3986 section 5.2.4.
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+	var out []string
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 || len(out) == 1 && out[0] != "" {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	result := strings.Join(out, "/")
+	if strings.HasPrefix(path, "/") && !strings.HasPrefix(result, "/") {
+		result = "/" + result
+	}
+	return result
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	}
+	return c - 'a' + 10
+}
+
+// isUnreserved reports whether c is an unreserved character of RFC 3986 section 2.3.
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
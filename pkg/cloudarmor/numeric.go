@@ -0,0 +1,60 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// ScoreEpsilon is the tolerance applied to the bounds of inRange for double values.
+//
+// reCAPTCHA scores are reported in increments of 0.1, and a score which is nominally equal to a
+// bound, e.g. 0.7, may not be represented exactly once it has been serialized and parsed. Widening
+// each bound by ScoreEpsilon ensures such a score is always treated as within the range, while
+// remaining far smaller than the difference between two adjacent scores.
+const ScoreEpsilon = 1e-9
+
+// numericFunctions returns the numeric helper functions available in VNext.
+//
+//	inRange(x, lo, hi)
+//
+// inRange reports whether lo <= x <= hi, with both bounds inclusive. Double bounds are widened by
+// ScoreEpsilon, so inRange(token.recaptcha_action.score, 0.7, 1.0) holds for a score of 0.7
+// regardless of floating-point rounding. A range whose lower bound exceeds its upper bound
+// results in an error rather than silently never matching.
+func numericFunctions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Function("inRange",
+			cel.Overload("inRange_int64_int64_int64", []*cel.Type{cel.IntType, cel.IntType, cel.IntType}, cel.BoolType,
+				cel.FunctionBinding(func(args ...ref.Val) ref.Val {
+					x, lo, hi := args[0].(types.Int), args[1].(types.Int), args[2].(types.Int)
+					if lo > hi {
+						return evalErr(ErrorInvalidRange, "inRange: lower bound %d exceeds upper bound %d", lo, hi)
+					}
+					return types.Bool(lo <= x && x <= hi)
+				})),
+			cel.Overload("inRange_double_double_double", []*cel.Type{cel.DoubleType, cel.DoubleType, cel.DoubleType}, cel.BoolType,
+				cel.FunctionBinding(func(args ...ref.Val) ref.Val {
+					x, lo, hi := args[0].(types.Double), args[1].(types.Double), args[2].(types.Double)
+					if lo > hi {
+						return evalErr(ErrorInvalidRange, "inRange: lower bound %v exceeds upper bound %v", lo, hi)
+					}
+					return types.Bool(lo-ScoreEpsilon <= x && x <= hi+ScoreEpsilon)
+				})),
+		),
+	}
+}
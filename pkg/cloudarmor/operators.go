@@ -0,0 +1,162 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+)
+
+// operatorSymbols maps the operator symbols accepted by DisableOperators to the CEL function
+// which implements the operator, in the order reported by Features.
+var operatorSymbols = []struct {
+	symbol   string
+	function string
+}{
+	{"?:", operators.Conditional},
+	{"in", operators.In},
+	{"!", operators.LogicalNot},
+	{"&&", operators.LogicalAnd},
+	{"||", operators.LogicalOr},
+	{"==", operators.Equals},
+	{"!=", operators.NotEquals},
+	{"<", operators.Less},
+	{"<=", operators.LessEquals},
+	{">", operators.Greater},
+	{">=", operators.GreaterEquals},
+	{"+", operators.Add},
+	{"-", operators.Subtract},
+	{"*", operators.Multiply},
+	{"/", operators.Divide},
+	{"%", operators.Modulo},
+	{"-x", operators.Negate},
+	{"[]", operators.Index},
+}
+
+// Features describes the language features permitted by a Rules environment.
+type Features struct {
+	Version   uint32
+	Operators []OperatorFeature
+}
+
+// OperatorFeature describes whether an operator is permitted and the operand types it accepts.
+type OperatorFeature struct {
+	// Symbol is the operator as written in an expression, or "-x" for unary negation.
+	Symbol  string
+	Enabled bool
+	// Signatures lists the accepted operand types, e.g. "int, int", when the operator is enabled.
+	Signatures []string
+}
+
+// Features reports which operators the Rules environment permits and for which operand types,
+// taking into account both the version and any operators disabled with DisableOperators.
+func (r *Rules) Features() *Features {
+	f := &Features{Version: r.version}
+	funcs := r.env.Functions()
+	for _, op := range operatorSymbols {
+		feature := OperatorFeature{Symbol: op.symbol}
+		if fn, found := funcs[op.function]; found && !r.disabledOperators[op.symbol] {
+			feature.Enabled = true
+			for _, o := range fn.OverloadDecls() {
+				var args []string
+				for _, t := range o.ArgTypes() {
+					args = append(args, t.String())
+				}
+				feature.Signatures = append(feature.Signatures, strings.Join(args, ", "))
+			}
+			sort.Strings(feature.Signatures)
+		}
+		f.Operators = append(f.Operators, feature)
+	}
+	return f
+}
+
+// DisableOperators rejects expressions which use any of the given operators at check time, so
+// that local validation can match restrictions planned for production before the environment
+// configuration itself changes.
+//
+// Operators are identified by their symbol, e.g. "?:", "in", "<", or "-x" for unary negation.
+func DisableOperators(symbols ...string) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		if r.disabledOperators == nil {
+			r.disabledOperators = map[string]bool{}
+		}
+		for _, sym := range symbols {
+			if operatorFunction(sym) == "" {
+				return nil, fmt.Errorf("unknown operator: %q", sym)
+			}
+			r.disabledOperators[sym] = true
+		}
+		return r, nil
+	}
+}
+
+// operatorFunction returns the CEL function implementing the operator symbol, or the empty string
+// if the symbol is not recognized.
+func operatorFunction(symbol string) string {
+	for _, op := range operatorSymbols {
+		if op.symbol == symbol {
+			return op.function
+		}
+	}
+	return ""
+}
+
+// disabledOperatorList returns the sorted symbols of the disabled operators.
+func (r *Rules) disabledOperatorList() []string {
+	var syms []string
+	for sym := range r.disabledOperators {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	return syms
+}
+
+// operatorValidator reports calls to operators which have been disabled.
+type operatorValidator struct {
+	// disabled maps the CEL function name of each disabled operator to its symbol.
+	disabled map[string]string
+}
+
+// Name implements the cel.ASTValidator interface.
+func (operatorValidator) Name() string {
+	return "cloudarmor.validator.operators"
+}
+
+// Validate implements the cel.ASTValidator interface.
+func (v operatorValidator) Validate(_ *cel.Env, _ cel.ValidatorConfig, a *ast.AST, iss *cel.Issues) {
+	for _, e := range ast.MatchDescendants(ast.NavigateAST(a), ast.KindMatcher(ast.CallKind)) {
+		if sym, found := v.disabled[e.AsCall().FunctionName()]; found {
+			iss.ReportErrorAtID(e.ID(), "operator '%s' is disabled", sym)
+		}
+	}
+}
+
+// operatorOptions returns the environment options which enforce the disabled operators.
+func (r *Rules) operatorOptions() []cel.EnvOption {
+	if len(r.disabledOperators) == 0 {
+		return nil
+	}
+	v := operatorValidator{disabled: map[string]string{}}
+	for sym := range r.disabledOperators {
+		v.disabled[operatorFunction(sym)] = sym
+	}
+	return []cel.EnvOption{cel.ASTValidators(v)}
+}
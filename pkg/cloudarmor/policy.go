@@ -0,0 +1,241 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+)
+
+// ErrorPolicy determines how a rule whose evaluation fails affects the evaluation of a security
+// policy.
+type ErrorPolicy int
+
+const (
+	// ErrorAsNonMatch treats a rule whose evaluation fails as not matching, so that evaluation
+	// continues with the rule of the next priority. This is how Cloud Armor evaluates rules in
+	// production, and is the default.
+	ErrorAsNonMatch ErrorPolicy = iota
+	// ErrorAbort stops the evaluation of the policy at the first rule whose evaluation fails,
+	// so that errors which production would silently skip surface during testing.
+	ErrorAbort
+)
+
+// PolicyOption configures a PolicyEvaluator.
+type PolicyOption func(*PolicyEvaluator)
+
+// WithErrorPolicy selects how rules whose evaluation fails are treated, ErrorAsNonMatch unless
+// configured.
+func WithErrorPolicy(p ErrorPolicy) PolicyOption {
+	return func(e *PolicyEvaluator) {
+		e.errorPolicy = p
+	}
+}
+
+// PolicyEvaluator evaluates requests against the rules of a security policy in priority order, as
+// Cloud Armor does, and reports the action of the first rule which matches.
+type PolicyEvaluator struct {
+	policy      *SecurityPolicy
+	rules       []policyRule
+	errorPolicy ErrorPolicy
+}
+
+type policyRule struct {
+	rule *SecurityPolicyRule
+	prg  cel.Program
+}
+
+// PolicyResult is the outcome of evaluating a request against a security policy.
+type PolicyResult struct {
+	// Rule is the enforced rule of the highest priority which matched, or nil if none matched.
+	Rule *SecurityPolicyRule
+	// Action is the action of Rule, or the empty string if no rule matched.
+	Action string
+	// Status is the status code with which a deny Action responds, or 0 for other actions.
+	// Custom error responses are configured on the load balancer and selected by this status.
+	Status int
+	// Headers are the request headers which the HeaderAction of Rule inserts into the forwarded
+	// request.
+	Headers []*RequestHeader
+	// Previewed contains the preview rules which matched before Rule, whose actions were not
+	// enforced.
+	Previewed []*SecurityPolicyRule
+	// Errors contains the rules whose evaluation failed, in priority order.
+	Errors []RuleError
+}
+
+// RuleError is the error of a security policy rule whose evaluation failed.
+type RuleError struct {
+	Rule *SecurityPolicyRule
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("rule %s: %v", e.Rule.Name(), e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// NewPolicyEvaluator compiles the rules of the security policy. Rules with a SRC_IPS_V1 match
+// are evaluated as the equivalent inIpRange expression on origin.ip.
+//
+// The return value is an error if the match of a rule fails to compile or is not supported.
+func (r *Rules) NewPolicyEvaluator(p *SecurityPolicy, opts ...PolicyOption) (*PolicyEvaluator, error) {
+	e := &PolicyEvaluator{policy: p}
+	for _, opt := range opts {
+		opt(e)
+	}
+	for _, rule := range p.Rules {
+		expr, err := rule.matchExpression()
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: rule %s: %w", p.Name, rule.Name(), err)
+		}
+		a, err := r.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: rule %s: %w", p.Name, rule.Name(), err)
+		}
+		prg, err := r.Program(a)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: rule %s: %w", p.Name, rule.Name(), err)
+		}
+		e.rules = append(e.rules, policyRule{rule: rule, prg: prg})
+	}
+	sort.SliceStable(e.rules, func(i, j int) bool { return e.rules[i].rule.Priority < e.rules[j].rule.Priority })
+	return e, nil
+}
+
+// matchExpression returns the CEL expression equivalent to the match of the rule.
+func (r *SecurityPolicyRule) matchExpression() (string, error) {
+	if expr := r.Expression(); expr != "" {
+		return expr, nil
+	}
+	if r.Match == nil || r.Match.VersionedExpr != "SRC_IPS_V1" || r.Match.Config == nil {
+		return "", fmt.Errorf("unsupported match, wanted an expression or SRC_IPS_V1")
+	}
+	var ranges []string
+	for _, cidr := range r.Match.Config.SrcIPRanges {
+		if cidr == "*" {
+			return "true", nil
+		}
+		ranges = append(ranges, "inIpRange(origin.ip, "+strconv.Quote(cidr)+")")
+	}
+	if len(ranges) == 0 {
+		return "", fmt.Errorf("SRC_IPS_V1 match has no srcIpRanges")
+	}
+	return strings.Join(ranges, " || "), nil
+}
+
+// Evaluate evaluates the request against the rules of the policy in priority order, stopping at
+// the first enforced rule which matches. A rule whose evaluation fails, or does not produce a
+// bool, is recorded in the Errors of the result and treated according to the ErrorPolicy.
+//
+// The return value is an error only under ErrorAbort, when a rule fails, in which case the result
+// describes the evaluation up to and including the failed rule.
+func (e *PolicyEvaluator) Evaluate(vars *Variables) (*PolicyResult, error) {
+	res := &PolicyResult{}
+	for _, pr := range e.rules {
+		out, _, err := pr.prg.Eval(vars)
+		matched, ok := out.(types.Bool)
+		if err == nil && !ok {
+			err = fmt.Errorf("evaluated to %v, wanted a bool", out)
+		}
+		if err != nil {
+			ruleErr := RuleError{Rule: pr.rule, Err: err}
+			res.Errors = append(res.Errors, ruleErr)
+			if e.errorPolicy == ErrorAbort {
+				return res, fmt.Errorf("policy %s: %w", e.policy.Name, &ruleErr)
+			}
+			continue
+		}
+		if !matched {
+			continue
+		}
+		if pr.rule.Preview {
+			res.Previewed = append(res.Previewed, pr.rule)
+			continue
+		}
+		res.Rule = pr.rule
+		res.Action = pr.rule.Action
+		res.Status, _ = pr.rule.DenyStatus()
+		if pr.rule.HeaderAction != nil {
+			res.Headers = pr.rule.HeaderAction.RequestHeadersToAdds
+		}
+		break
+	}
+	return res, nil
+}
+
+// RunPolicyValidation evaluates each test case selected by the TestTags option against the
+// security policy, and compares the action and inserted headers of the matched rule with the
+// expect_action and expect_headers of the test case. Header names are compared
+// case-insensitively. Performance budgets are not checked.
+func (r *Rules) RunPolicyValidation(e *PolicyEvaluator, testCases []*TestCase) []TestStatus {
+	var statuses []TestStatus
+	for i, tc := range testCases {
+		if !r.selected(tc) {
+			continue
+		}
+		s := r.evalPolicyTestCase(e, tc)
+		s.Fail = r.RedactMessage(s.Fail, tc.When)
+		s.CaseIndex = i
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+func (r *Rules) evalPolicyTestCase(e *PolicyEvaluator, tc *TestCase) TestStatus {
+	s := TestStatus{Name: tc.Name, Expected: tc.ExpectAction}
+	start := time.Now()
+	res, err := e.Evaluate(tc.When)
+	s.Duration = time.Since(start)
+	if err != nil {
+		s.Actual = err.Error()
+		s.Fail = err.Error()
+		s.Err = err
+		return s
+	}
+	s.Actual = res.Action
+	headers := map[string]string{}
+	for _, h := range res.Headers {
+		headers[strings.ToLower(h.HeaderName)] = h.HeaderValue
+	}
+	switch {
+	case tc.ExpectAction != "" && res.Action != tc.ExpectAction:
+		s.Fail = r.message(MessageUnexpectedAction, tc.ExpectAction, res.Action)
+	case tc.ExpectHeaders != nil:
+		want := map[string]string{}
+		for name, value := range tc.ExpectHeaders {
+			want[strings.ToLower(name)] = value
+		}
+		if !maps.Equal(headers, want) {
+			s.Expected = want
+			s.Actual = headers
+			s.Fail = r.message(MessageUnexpectedHeaders, want, headers)
+		}
+	}
+	s.Pass = s.Fail == ""
+	return s
+}
@@ -0,0 +1,103 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyFormatVersion is the version of the on-disk YAML format of security policies written by
+// SecurityPolicyToYAML.
+//
+// Fields are only ever added to the format, never removed or given a new meaning, and each
+// addition increments the version. Documents are read with the schema of the current version, so
+// that documents written by older versions of the library, whose fields are a subset of the
+// current ones, keep loading, while documents of a newer version are rejected rather than having
+// the fields which this version does not know silently dropped.
+const PolicyFormatVersion = 1
+
+// policyDocument is the on-disk form of a security policy: the fields of the YAML export of
+// `gcloud compute security-policies export`, along with the version of the format.
+type policyDocument struct {
+	FormatVersion  int `yaml:"formatVersion"`
+	SecurityPolicy `yaml:",inline"`
+}
+
+// SecurityPolicyToYAML converts a security policy to the versioned YAML format read by
+// SecurityPolicyFromYAML. The expression of each rule is compiled and written in its canonical
+// single line form, so that the files of equivalent policies differ only where the policies do.
+//
+// The return value is an error if an expression fails to compile.
+func (r *Rules) SecurityPolicyToYAML(p *SecurityPolicy) ([]byte, error) {
+	compile, err := r.canonicalCompiler()
+	if err != nil {
+		return nil, err
+	}
+	doc := &policyDocument{FormatVersion: PolicyFormatVersion, SecurityPolicy: SecurityPolicy{Name: p.Name}}
+	for _, rule := range p.Rules {
+		out := *rule
+		if expr := rule.Expression(); expr != "" {
+			a, err := compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: rule %s: %w", p.Name, rule.Name(), err)
+			}
+			canonical, err := unparse(a.NativeRep().Expr(), a.NativeRep().SourceInfo())
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: rule %s: %w", p.Name, rule.Name(), err)
+			}
+			match := *rule.Match
+			match.Expr = &MatchExpr{Expression: canonical}
+			out.Match = &match
+		}
+		doc.Rules = append(doc.Rules, &out)
+	}
+	return yaml.Marshal(doc)
+}
+
+// SecurityPolicyFromYAML converts a security policy written in the versioned YAML format, see
+// PolicyFormatVersion, to a SecurityPolicy type. Unknown fields, duplicate keys, values of the
+// wrong type, and rules without a match are rejected. As with SecurityPolicyFromJSON, the actions
+// of the rules are checked by Validate rather than on load.
+//
+// The return value is the SecurityPolicy type, or an error if the document has no formatVersion,
+// a formatVersion newer than PolicyFormatVersion, or does not match the schema.
+func SecurityPolicyFromYAML(data []byte) (*SecurityPolicy, error) {
+	var header struct {
+		FormatVersion int `yaml:"formatVersion"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	switch {
+	case header.FormatVersion < 1:
+		return nil, errors.New("formatVersion is required and must be positive")
+	case header.FormatVersion > PolicyFormatVersion:
+		return nil, fmt.Errorf("formatVersion %d is newer than version %d supported by this library",
+			header.FormatVersion, PolicyFormatVersion)
+	}
+	doc := &policyDocument{}
+	if err := checkSchema(data, doc); err != nil {
+		return nil, err
+	}
+	for _, rule := range doc.Rules {
+		if rule.Match == nil {
+			return nil, fmt.Errorf("policy %s: rule %s has no match", doc.Name, rule.Name())
+		}
+	}
+	return &doc.SecurityPolicy, nil
+}
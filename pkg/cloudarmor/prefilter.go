@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"regexp/syntax"
+	"strings"
+
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+)
+
+// PrefilterStats summarizes how effective the literal prefilter was at avoiding full rule
+// evaluation over a corpus.
+type PrefilterStats struct {
+	// RulesWithPrefilter is the number of rules for which at least one required literal was found.
+	RulesWithPrefilter int
+	// Evaluations is the total number of rule evaluations requested.
+	Evaluations int64
+	// Skipped is the number of rule evaluations avoided because a required literal was absent.
+	Skipped int64
+}
+
+// SkipRate returns the fraction of rule evaluations which were skipped by the prefilter.
+func (s PrefilterStats) SkipRate() float64 {
+	if s.Evaluations == 0 {
+		return 0
+	}
+	return float64(s.Skipped) / float64(s.Evaluations)
+}
+
+// literalPrefilter is a literal which must be present within the value of a string variable
+// in order for a rule to match.
+type literalPrefilter struct {
+	variable string
+	function string
+	literal  string
+}
+
+// admits reports whether the value could satisfy the prefilter.
+func (p literalPrefilter) admits(val string) bool {
+	switch p.function {
+	case "startsWith":
+		return strings.HasPrefix(val, p.literal)
+	case "endsWith":
+		return strings.HasSuffix(val, p.literal)
+	default:
+		return strings.Contains(val, p.literal)
+	}
+}
+
+// extractPrefilters returns the literals which are required for the expression to evaluate to
+// true.
+//
+// Only the conjuncts of the top-level logical AND are considered since a false conjunct makes
+// the whole expression false regardless of whether the other conjuncts would produce an error.
+func extractPrefilters(e ast.Expr) []literalPrefilter {
+	if e.Kind() != ast.CallKind {
+		return nil
+	}
+	call := e.AsCall()
+	if call.FunctionName() == operators.LogicalAnd {
+		var filters []literalPrefilter
+		for _, arg := range call.Args() {
+			filters = append(filters, extractPrefilters(arg)...)
+		}
+		return filters
+	}
+	if !call.IsMemberFunction() || len(call.Args()) != 1 {
+		return nil
+	}
+	target := call.Target()
+	arg := call.Args()[0]
+	if target.Kind() != ast.IdentKind || arg.Kind() != ast.LiteralKind {
+		return nil
+	}
+	lit, ok := arg.AsLiteral().(types.String)
+	if !ok {
+		return nil
+	}
+	switch call.FunctionName() {
+	case "contains", "startsWith", "endsWith":
+		if lit == "" {
+			return nil
+		}
+		return []literalPrefilter{{variable: target.AsIdent(), function: call.FunctionName(), literal: string(lit)}}
+	case "matches":
+		required := requiredRegexLiteral(string(lit))
+		if required == "" {
+			return nil
+		}
+		return []literalPrefilter{{variable: target.AsIdent(), function: "contains", literal: required}}
+	}
+	return nil
+}
+
+// requiredRegexLiteral returns the longest case-sensitive literal which every match of the
+// pattern must contain, or the empty string if there is none.
+func requiredRegexLiteral(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+	if isRequiredLiteral(re) {
+		return string(re.Rune)
+	}
+	if re.Op != syntax.OpConcat {
+		return ""
+	}
+	longest := ""
+	for _, sub := range re.Sub {
+		if isRequiredLiteral(sub) && len(string(sub.Rune)) > len(longest) {
+			longest = string(sub.Rune)
+		}
+	}
+	return longest
+}
+
+func isRequiredLiteral(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpLiteral && re.Flags&syntax.FoldCase == 0
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/env"
+	"gopkg.in/yaml.v3"
+)
+
+// AttributePresence determines how scalar attributes which were not set in the input are exposed
+// to expressions.
+type AttributePresence int
+
+const (
+	// PresenceZeroValue evaluates unset scalar attributes as their zero values, so an unset
+	// request.method is indistinguishable from an empty one. This is the default for all versions.
+	PresenceZeroValue AttributePresence = iota
+
+	// PresenceAbsent treats unset scalar attributes as absent: has(request.method) evaluates to
+	// false and any other reference to the attribute produces a 'no such attribute' error.
+	PresenceAbsent
+)
+
+// presencePrefix is the prefix of the synthetic boolean variables which the has() macro refers
+// to when testing for the presence of a scalar attribute.
+const presencePrefix = "@present."
+
+// Presence selects the presence semantics of scalar attributes, for either version.
+func Presence(p AttributePresence) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.presence = p
+		return r, nil
+	}
+}
+
+// scalarAttributes returns the names of the non-map variables declared within the config.
+func scalarAttributes(c *env.Config) map[string]bool {
+	attrs := map[string]bool{}
+	for _, v := range c.Variables {
+		if v.TypeDesc != nil && v.TypeName != "map" {
+			attrs[v.Name] = true
+		}
+	}
+	return attrs
+}
+
+// presenceDecls declares the synthetic presence variable for each scalar attribute.
+func presenceDecls(attrs map[string]bool) []cel.EnvOption {
+	var decls []cel.EnvOption
+	for attr := range attrs {
+		decls = append(decls, cel.Variable(presencePrefix+attr, cel.BoolType))
+	}
+	return decls
+}
+
+// hasMacroFactory returns the has() macro which, in addition to the field selection and index
+// forms, rewrites presence tests of the given scalar attributes, e.g. has(request.method), to a
+// reference to the attribute's synthetic presence variable.
+func hasMacroFactory(attrs map[string]bool) cel.MacroFactory {
+	return func(mef cel.MacroExprFactory, target ast.Expr, args []ast.Expr) (ast.Expr, *cel.Error) {
+		if name, ok := attributeName(args[0]); ok && attrs[name] {
+			return mef.NewIdent(presencePrefix + name), nil
+		}
+		return hasWithIndexMacroFactory(mef, target, args)
+	}
+}
+
+// attributeName returns the dotted name formed by a chain of field selections on an identifier.
+func attributeName(e ast.Expr) (string, bool) {
+	switch e.Kind() {
+	case ast.IdentKind:
+		return e.AsIdent(), true
+	case ast.SelectKind:
+		sel := e.AsSelect()
+		if sel.IsTestOnly() {
+			return "", false
+		}
+		operand, ok := attributeName(sel.Operand())
+		if !ok {
+			return "", false
+		}
+		return operand + "." + sel.FieldName(), true
+	}
+	return "", false
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, recording which attributes were set
+// so that they can be distinguished from attributes left at their zero values.
+func (v *Variables) UnmarshalYAML(node *yaml.Node) error {
+	coerced, err := checkHeaderValues(node)
+	if err != nil {
+		return err
+	}
+	type plain Variables
+	if err := node.Decode((*plain)(v)); err != nil {
+		return err
+	}
+	v.coercedHeaders = coerced
+	v.present = map[string]bool{}
+	recordPresent(node, "", v.present)
+	return v.checkScores()
+}
+
+func recordPresent(node *yaml.Node, prefix string, present map[string]bool) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := prefix + node.Content[i].Value
+		present[name] = true
+		recordPresent(node.Content[i+1], name+".", present)
+	}
+}
+
+// isSet reports whether the attribute was set within the variables.
+//
+// Variables decoded from YAML record the attributes which were present in the document. For
+// variables constructed directly, an attribute is considered set when it has a non-zero value.
+func (v *Variables) isSet(attr string) bool {
+	if v.isUnset(attr) {
+		return false
+	}
+	if v.present != nil {
+		return v.present[attr]
+	}
+	val, found := v.ResolveName(attr)
+	return found && val != nil && !reflect.ValueOf(val).IsZero()
+}
+
+// presenceActivation hides the unset scalar attributes of the variables and resolves the
+// synthetic presence variables referenced by the has() macro.
+type presenceActivation struct {
+	*Variables
+	attrs map[string]bool
+}
+
+// ResolveName implements the interpreter.Activation interface.
+func (a presenceActivation) ResolveName(name string) (any, bool) {
+	if attr, found := strings.CutPrefix(name, presencePrefix); found {
+		return a.isSet(attr), true
+	}
+	if a.attrs[name] && !a.isSet(name) {
+		return nil, false
+	}
+	return a.Variables.ResolveName(name)
+}
@@ -0,0 +1,78 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+)
+
+// PolicyProfile selects the kind of security policy whose attributes are exposed to expressions.
+type PolicyProfile int
+
+const (
+	// ProfileHTTP exposes the request, origin, and token attributes of backend security policies
+	// which inspect HTTP(S) requests. This is the default.
+	ProfileHTTP PolicyProfile = iota
+
+	// ProfileNetwork exposes the origin and connection attributes of network edge security
+	// policies, which match L3/L4 traffic such as ports, transport protocols, and packet sizes.
+	// Only VCurrent is supported for network policies.
+	ProfileNetwork
+
+	// ProfileResponse is an experimental profile which adds the response attributes, such as
+	// response.status_code, to the VNext request attributes so that response-based policies can
+	// be prototyped and analyzed against logs. Cloud Armor does not evaluate rules against
+	// responses, so these rules cannot be deployed. Only VNext is supported.
+	ProfileResponse
+)
+
+// String returns the name of the profile as accepted by ParsePolicyProfile.
+func (p PolicyProfile) String() string {
+	switch p {
+	case ProfileHTTP:
+		return "http"
+	case ProfileNetwork:
+		return "network"
+	case ProfileResponse:
+		return "response"
+	default:
+		return fmt.Sprintf("PolicyProfile(%d)", int(p))
+	}
+}
+
+// ParsePolicyProfile returns the profile with the given name: "http", "network", or "response".
+func ParsePolicyProfile(name string) (PolicyProfile, error) {
+	switch name {
+	case "http":
+		return ProfileHTTP, nil
+	case "network":
+		return ProfileNetwork, nil
+	case "response":
+		return ProfileResponse, nil
+	default:
+		return 0, fmt.Errorf("unknown policy profile %q, must be http, network, or response", name)
+	}
+}
+
+// Profile selects the policy profile of the Cloud Armor rules environment.
+//
+// NewRules returns an error if no configuration is registered for the profile and version, see
+// Configs.
+func Profile(p PolicyProfile) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.profile = p
+		return r, nil
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import "context"
+
+// ProgressFunc is called as a long-running operation completes each unit of work, such as a
+// rule compiled, a request evaluated, or a test case run. done is the number of units completed
+// so far, and total is the number of units in the operation, or -1 when it is not known in
+// advance, as when reading a corpus stream.
+//
+// The function is called synchronously, so it should return quickly.
+type ProgressFunc func(done, total int)
+
+type progressKey struct{}
+
+// WithProgress returns a context which reports the progress of the operations it is passed to,
+// namely CompileAllContext, VendorRulesetFootprintContext, RunRuleValidationContext,
+// CorpusEvaluator.EvaluateContext, EvaluateCorpusContext, and CanaryContext.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progressFrom returns the ProgressFunc of the context, or a function which does nothing.
+func progressFrom(ctx context.Context) ProgressFunc {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && fn != nil {
+		return fn
+	}
+	return func(int, int) {}
+}
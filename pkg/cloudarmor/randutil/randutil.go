@@ -0,0 +1,39 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package randutil provides the seeded sources of randomness shared by the input generators, so
+// that any generated input can be reproduced exactly from the seed recorded alongside it.
+package randutil
+
+import (
+	"math/rand"
+	"time"
+)
+
+// New returns a pseudo-random source which produces the same sequence for the same seed.
+//
+// The source is not safe for concurrent use.
+func New(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// Resolve returns the seed unchanged unless it is zero, in which case it returns a fresh seed
+// drawn from the clock. A run which did not ask for a particular seed can then still be
+// reproduced from the seed it reports.
+func Resolve(seed int64) int64 {
+	for seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return seed
+}
@@ -0,0 +1,42 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package randutil_test
+
+import (
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/randutil"
+)
+
+func TestNew(t *testing.T) {
+	a, b := randutil.New(42), randutil.New(42)
+	for i := range 100 {
+		if x, y := a.Int63(), b.Int63(); x != y {
+			t.Fatalf("draw %d differs for the same seed: %d != %d", i, x, y)
+		}
+	}
+	if randutil.New(1).Int63() == randutil.New(2).Int63() {
+		t.Error("seeds 1 and 2 produced the same first draw")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	if got := randutil.Resolve(7); got != 7 {
+		t.Errorf("randutil.Resolve(7) = %d, want 7", got)
+	}
+	if got := randutil.Resolve(0); got == 0 {
+		t.Error("randutil.Resolve(0) = 0, wanted a fresh seed")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+)
+
+// Debug enables diagnostics which are too costly or too revealing for production use. The stack
+// of each panic recovered during evaluation is captured in the Stack of its EvalError.
+func Debug() RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.debug = true
+		return r, nil
+	}
+}
+
+// customFunctions contains the functions bound by the Cloud Armor environment, whose calls
+// recover from panics.
+var customFunctions = map[string]bool{
+	"lower":             true,
+	"upper":             true,
+	"base64Decode":      true,
+	"urlDecode":         true,
+	"urlDecodeUni":      true,
+	"utf8ToUnicode":     true,
+	"inIpRange":         true,
+	"inFingerprintList": true,
+	"inRange":           true,
+	"toPunycode":        true,
+	"fromPunycode":      true,
+}
+
+// panicErr returns the error of type ErrorInternal reporting a recovered panic, along with the
+// stack of the panicking goroutine if stack is set.
+func panicErr(where string, p any, stack bool) *EvalError {
+	e := &EvalError{Code: ErrorInternal, Message: fmt.Sprintf("%s: internal error: %v", where, p)}
+	if stack {
+		e.Stack = debug.Stack()
+	}
+	return e
+}
+
+// recoverProgramOptions returns the program options which convert panics within custom function
+// bindings into errors. The decorator is applied last, so that it also covers the decorators of
+// the caller.
+func (r *Rules) recoverProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{cel.CustomDecorator(recoverDecorator(r.debug))}
+}
+
+// recoverDecorator wraps calls to custom functions so that a panic within the binding results in
+// an error of type ErrorInternal rather than unwinding the evaluation.
+func recoverDecorator(stack bool) interpreter.InterpretableDecorator {
+	return func(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+		call, ok := i.(interpreter.InterpretableCall)
+		if !ok || !customFunctions[call.Function()] {
+			return i, nil
+		}
+		return &recoverCall{InterpretableCall: call, stack: stack}, nil
+	}
+}
+
+type recoverCall struct {
+	interpreter.InterpretableCall
+	stack bool
+}
+
+// Eval implements the interpreter.Interpretable interface.
+func (c *recoverCall) Eval(act interpreter.Activation) (out ref.Val) {
+	defer func() {
+		if p := recover(); p != nil {
+			// Cancellation, such as by a cost limit, unwinds the whole evaluation.
+			if _, ok := p.(interpreter.EvalCancelledError); ok {
+				panic(p)
+			}
+			out = types.LabelErrNode(c.ID(), types.WrapErr(panicErr(c.Function(), p, c.stack)))
+		}
+	}()
+	return c.InterpretableCall.Eval(act)
+}
+
+// recoverProgram converts a panic within the evaluation of the wrapped program, such as one
+// raised by the conversion of its input, into an error of type ErrorInternal, so that a single
+// malformed input cannot take down the process embedding the rules.
+type recoverProgram struct {
+	cel.Program
+	stack bool
+}
+
+// Eval implements the cel.Program interface.
+func (p *recoverProgram) Eval(input any) (out ref.Val, det *cel.EvalDetails, err error) {
+	defer p.recover(&err)
+	return p.Program.Eval(input)
+}
+
+// ContextEval implements the cel.Program interface.
+func (p *recoverProgram) ContextEval(ctx context.Context, input any) (out ref.Val, det *cel.EvalDetails, err error) {
+	defer p.recover(&err)
+	return p.Program.ContextEval(ctx, input)
+}
+
+// recover sets the error to that of a panic, if any.
+func (p *recoverProgram) recover(err *error) {
+	if r := recover(); r != nil {
+		if cancelled, ok := r.(interpreter.EvalCancelledError); ok {
+			*err = cancelled
+			return
+		}
+		*err = panicErr("eval", r, p.stack)
+	}
+}
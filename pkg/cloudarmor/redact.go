@@ -0,0 +1,157 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// Redacted is the placeholder which replaces the values of sensitive attributes in diagnostic
+// output.
+const Redacted = "[REDACTED]"
+
+// defaultSensitive contains the attributes redacted by default: the headers which carry
+// credentials, API keys, and session cookies.
+var defaultSensitive = map[string]bool{
+	"request.headers['authorization']":       true,
+	"request.headers['proxy-authorization']": true,
+	"request.headers['cookie']":              true,
+	"request.headers['set-cookie']":          true,
+	"request.headers['x-api-key']":           true,
+}
+
+var headerAttribute = regexp.MustCompile(`^request\.headers\['([^']+)'\]$`)
+
+// DefaultSensitiveAttributes returns the attributes whose values are redacted from diagnostic
+// output unless allowed with AllowAttributes, in lexical order.
+func DefaultSensitiveAttributes() []string {
+	return SortedNames(defaultSensitive)
+}
+
+// RedactAttributes adds attributes to those whose values are replaced with Redacted in test
+// failure messages and in the reports of the CLI, so that the output is safe to share. Headers
+// are named by index, e.g. request.headers['x-session-id'], and other attributes by their path,
+// e.g. request.query.
+func RedactAttributes(attrs ...string) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		names, err := redactionNames(attrs)
+		if err != nil {
+			return nil, err
+		}
+		if r.redact == nil {
+			r.redact = map[string]bool{}
+		}
+		for _, name := range names {
+			r.redact[name] = true
+		}
+		return r, nil
+	}
+}
+
+// AllowAttributes excludes attributes from redaction, including those redacted by default, for
+// environments where their values are not sensitive, such as synthetic test data.
+func AllowAttributes(attrs ...string) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		names, err := redactionNames(attrs)
+		if err != nil {
+			return nil, err
+		}
+		if r.redactAllow == nil {
+			r.redactAllow = map[string]bool{}
+		}
+		for _, name := range names {
+			r.redactAllow[name] = true
+		}
+		return r, nil
+	}
+}
+
+// redactionNames validates the attribute names and returns them with header names in lower case.
+func redactionNames(attrs []string) ([]string, error) {
+	names := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		if m := headerAttribute.FindStringSubmatch(attr); m != nil {
+			names = append(names, fmt.Sprintf("request.headers['%s']", strings.ToLower(m[1])))
+			continue
+		}
+		if _, found := attributes[attr]; !found {
+			return nil, fmt.Errorf("cannot redact %q: not an attribute or request.headers['<name>']", attr)
+		}
+		names = append(names, attr)
+	}
+	return names, nil
+}
+
+// Sensitive reports whether the values of the attribute are redacted.
+func (r *Rules) Sensitive(attr string) bool {
+	if m := headerAttribute.FindStringSubmatch(attr); m != nil {
+		attr = fmt.Sprintf("request.headers['%s']", strings.ToLower(m[1]))
+	}
+	return (defaultSensitive[attr] || r.redact[attr]) && !r.redactAllow[attr]
+}
+
+// Redact returns Redacted if the attribute is sensitive and the value otherwise.
+func (r *Rules) Redact(attr, value string) string {
+	if value != "" && r.Sensitive(attr) {
+		return Redacted
+	}
+	return value
+}
+
+// RedactMessage replaces the values which the sensitive attributes of the request hold within a
+// message, such as an evaluation error, with Redacted.
+func (r *Rules) RedactMessage(message string, vars *Variables) string {
+	for _, value := range r.sensitiveValues(vars) {
+		message = strings.ReplaceAll(message, value, Redacted)
+	}
+	return message
+}
+
+// sensitiveValues returns the non-empty values of the sensitive attributes of the request,
+// longest first so that values containing others are replaced whole.
+func (r *Rules) sensitiveValues(vars *Variables) []string {
+	if vars == nil {
+		return nil
+	}
+	var values []string
+	if vars.Request != nil {
+		for name, value := range vars.Request.Headers {
+			if value != "" && r.Sensitive(fmt.Sprintf("request.headers['%s']", name)) {
+				values = append(values, value)
+			}
+		}
+	}
+	for attr := range r.redact {
+		if r.redactAllow[attr] || headerAttribute.MatchString(attr) {
+			continue
+		}
+		val, _ := vars.ResolveName(attr)
+		if rv, ok := val.(ref.Val); ok {
+			val = rv.Value()
+		}
+		// Numbers and booleans are not redacted from messages, where they are indistinguishable
+		// from other numbers and words.
+		if s, ok := val.(string); ok && s != "" {
+			values = append(values, s)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
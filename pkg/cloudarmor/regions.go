@@ -0,0 +1,220 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+)
+
+// Region is an ISO 3166-1 alpha-2 region code, the form of origin.region_code, and the English
+// short name of the region.
+type Region struct {
+	Code string
+	Name string
+}
+
+// regionNames maps the ISO 3166-1 alpha-2 codes to the English short names of their regions.
+var regionNames = map[string]string{
+	"AD": "Andorra", "AE": "United Arab Emirates", "AF": "Afghanistan",
+	"AG": "Antigua and Barbuda", "AI": "Anguilla", "AL": "Albania", "AM": "Armenia",
+	"AO": "Angola", "AQ": "Antarctica", "AR": "Argentina", "AS": "American Samoa",
+	"AT": "Austria", "AU": "Australia", "AW": "Aruba", "AX": "Åland Islands",
+	"AZ": "Azerbaijan", "BA": "Bosnia and Herzegovina", "BB": "Barbados",
+	"BD": "Bangladesh", "BE": "Belgium", "BF": "Burkina Faso", "BG": "Bulgaria",
+	"BH": "Bahrain", "BI": "Burundi", "BJ": "Benin", "BL": "Saint Barthélemy",
+	"BM": "Bermuda", "BN": "Brunei Darussalam", "BO": "Bolivia",
+	"BQ": "Bonaire, Sint Eustatius and Saba", "BR": "Brazil", "BS": "Bahamas",
+	"BT": "Bhutan", "BV": "Bouvet Island", "BW": "Botswana", "BY": "Belarus",
+	"BZ": "Belize", "CA": "Canada", "CC": "Cocos (Keeling) Islands",
+	"CD": "Congo, Democratic Republic of the", "CF": "Central African Republic",
+	"CG": "Congo", "CH": "Switzerland", "CI": "Côte d'Ivoire", "CK": "Cook Islands",
+	"CL": "Chile", "CM": "Cameroon", "CN": "China", "CO": "Colombia", "CR": "Costa Rica",
+	"CU": "Cuba", "CV": "Cabo Verde", "CW": "Curaçao", "CX": "Christmas Island",
+	"CY": "Cyprus", "CZ": "Czechia", "DE": "Germany", "DJ": "Djibouti", "DK": "Denmark",
+	"DM": "Dominica", "DO": "Dominican Republic", "DZ": "Algeria", "EC": "Ecuador",
+	"EE": "Estonia", "EG": "Egypt", "EH": "Western Sahara", "ER": "Eritrea",
+	"ES": "Spain", "ET": "Ethiopia", "FI": "Finland", "FJ": "Fiji",
+	"FK": "Falkland Islands (Malvinas)", "FM": "Micronesia", "FO": "Faroe Islands",
+	"FR": "France", "GA": "Gabon", "GB": "United Kingdom", "GD": "Grenada",
+	"GE": "Georgia", "GF": "French Guiana", "GG": "Guernsey", "GH": "Ghana",
+	"GI": "Gibraltar", "GL": "Greenland", "GM": "Gambia", "GN": "Guinea",
+	"GP": "Guadeloupe", "GQ": "Equatorial Guinea", "GR": "Greece",
+	"GS": "South Georgia and the South Sandwich Islands", "GT": "Guatemala",
+	"GU": "Guam", "GW": "Guinea-Bissau", "GY": "Guyana", "HK": "Hong Kong",
+	"HM": "Heard Island and McDonald Islands", "HN": "Honduras", "HR": "Croatia",
+	"HT": "Haiti", "HU": "Hungary", "ID": "Indonesia", "IE": "Ireland", "IL": "Israel",
+	"IM": "Isle of Man", "IN": "India", "IO": "British Indian Ocean Territory",
+	"IQ": "Iraq", "IR": "Iran", "IS": "Iceland", "IT": "Italy", "JE": "Jersey",
+	"JM": "Jamaica", "JO": "Jordan", "JP": "Japan", "KE": "Kenya", "KG": "Kyrgyzstan",
+	"KH": "Cambodia", "KI": "Kiribati", "KM": "Comoros", "KN": "Saint Kitts and Nevis",
+	"KP": "Korea, Democratic People's Republic of", "KR": "Korea, Republic of",
+	"KW": "Kuwait", "KY": "Cayman Islands", "KZ": "Kazakhstan",
+	"LA": "Lao People's Democratic Republic", "LB": "Lebanon", "LC": "Saint Lucia",
+	"LI": "Liechtenstein", "LK": "Sri Lanka", "LR": "Liberia", "LS": "Lesotho",
+	"LT": "Lithuania", "LU": "Luxembourg", "LV": "Latvia", "LY": "Libya",
+	"MA": "Morocco", "MC": "Monaco", "MD": "Moldova", "ME": "Montenegro",
+	"MF": "Saint Martin (French part)", "MG": "Madagascar", "MH": "Marshall Islands",
+	"MK": "North Macedonia", "ML": "Mali", "MM": "Myanmar", "MN": "Mongolia",
+	"MO": "Macao", "MP": "Northern Mariana Islands", "MQ": "Martinique",
+	"MR": "Mauritania", "MS": "Montserrat", "MT": "Malta", "MU": "Mauritius",
+	"MV": "Maldives", "MW": "Malawi", "MX": "Mexico", "MY": "Malaysia",
+	"MZ": "Mozambique", "NA": "Namibia", "NC": "New Caledonia", "NE": "Niger",
+	"NF": "Norfolk Island", "NG": "Nigeria", "NI": "Nicaragua", "NL": "Netherlands",
+	"NO": "Norway", "NP": "Nepal", "NR": "Nauru", "NU": "Niue", "NZ": "New Zealand",
+	"OM": "Oman", "PA": "Panama", "PE": "Peru", "PF": "French Polynesia",
+	"PG": "Papua New Guinea", "PH": "Philippines", "PK": "Pakistan", "PL": "Poland",
+	"PM": "Saint Pierre and Miquelon", "PN": "Pitcairn", "PR": "Puerto Rico",
+	"PS": "Palestine, State of", "PT": "Portugal", "PW": "Palau", "PY": "Paraguay",
+	"QA": "Qatar", "RE": "Réunion", "RO": "Romania", "RS": "Serbia",
+	"RU": "Russian Federation", "RW": "Rwanda", "SA": "Saudi Arabia",
+	"SB": "Solomon Islands", "SC": "Seychelles", "SD": "Sudan", "SE": "Sweden",
+	"SG": "Singapore", "SH": "Saint Helena, Ascension and Tristan da Cunha",
+	"SI": "Slovenia", "SJ": "Svalbard and Jan Mayen", "SK": "Slovakia",
+	"SL": "Sierra Leone", "SM": "San Marino", "SN": "Senegal", "SO": "Somalia",
+	"SR": "Suriname", "SS": "South Sudan", "ST": "Sao Tome and Principe",
+	"SV": "El Salvador", "SX": "Sint Maarten (Dutch part)",
+	"SY": "Syrian Arab Republic", "SZ": "Eswatini", "TC": "Turks and Caicos Islands",
+	"TD": "Chad", "TF": "French Southern Territories", "TG": "Togo", "TH": "Thailand",
+	"TJ": "Tajikistan", "TK": "Tokelau", "TL": "Timor-Leste", "TM": "Turkmenistan",
+	"TN": "Tunisia", "TO": "Tonga", "TR": "Türkiye", "TT": "Trinidad and Tobago",
+	"TV": "Tuvalu", "TW": "Taiwan", "TZ": "Tanzania", "UA": "Ukraine", "UG": "Uganda",
+	"UM": "United States Minor Outlying Islands", "US": "United States",
+	"UY": "Uruguay", "UZ": "Uzbekistan", "VA": "Holy See", "VC": "Saint Vincent and the Grenadines",
+	"VE": "Venezuela", "VG": "Virgin Islands (British)", "VI": "Virgin Islands (U.S.)",
+	"VN": "Viet Nam", "VU": "Vanuatu", "WF": "Wallis and Futuna", "WS": "Samoa",
+	"YE": "Yemen", "YT": "Mayotte", "ZA": "South Africa", "ZM": "Zambia", "ZW": "Zimbabwe",
+}
+
+// regionCodeAliases maps codes which are commonly mistaken for ISO 3166-1 alpha-2 codes, such as
+// the exceptionally reserved codes used by the European Union, to the code of their region.
+var regionCodeAliases = map[string]string{
+	"UK": "GB",
+	"EL": "GR",
+	"FX": "FR",
+}
+
+// Regions returns the ISO 3166-1 alpha-2 regions ordered by code, such as for the region pickers
+// of rule builders.
+func Regions() []Region {
+	regions := make([]Region, 0, len(regionNames))
+	for code, name := range regionNames {
+		regions = append(regions, Region{Code: code, Name: name})
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Code < regions[j].Code })
+	return regions
+}
+
+// LookupRegion returns the region of an ISO 3166-1 alpha-2 code, as origin.region_code presents
+// it, and whether the code is one.
+func LookupRegion(code string) (Region, bool) {
+	name, found := regionNames[code]
+	return Region{Code: code, Name: name}, found
+}
+
+// CheckRegionCode returns an error describing why the value of origin.region_code can never be
+// the code, suggesting the intended code where one is apparent, e.g. "GB" for "UK", or nil if the
+// code is an ISO 3166-1 alpha-2 code.
+func CheckRegionCode(code string) error {
+	if _, found := regionNames[code]; found {
+		return nil
+	}
+	upper := strings.ToUpper(code)
+	if _, found := regionNames[upper]; found {
+		return fmt.Errorf("%q must be uppercase, e.g. %q", code, upper)
+	}
+	if alias, found := regionCodeAliases[upper]; found {
+		return fmt.Errorf("%q is not an ISO 3166-1 alpha-2 region code, did you mean %q (%s)?", code, alias, regionNames[alias])
+	}
+	return fmt.Errorf("%q is not an ISO 3166-1 alpha-2 region code", code)
+}
+
+// ValidateRegionCodes rejects expressions which compare origin.region_code with a string literal
+// which is not an ISO 3166-1 alpha-2 code at check time, such as origin.region_code == 'UK', since
+// Cloud Armor accepts the expression but the comparison never matches.
+func ValidateRegionCodes() RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.validateRegionCodes = true
+		return r, nil
+	}
+}
+
+// regionCodeOptions returns the environment options which enforce ValidateRegionCodes.
+func (r *Rules) regionCodeOptions() []cel.EnvOption {
+	if !r.validateRegionCodes {
+		return nil
+	}
+	return []cel.EnvOption{cel.ASTValidators(regionCodeValidator{})}
+}
+
+// regionCodeValidator reports string literals compared with origin.region_code by == or != which
+// are not ISO 3166-1 alpha-2 codes.
+type regionCodeValidator struct{}
+
+// Name implements the cel.ASTValidator interface.
+func (regionCodeValidator) Name() string {
+	return "cloudarmor.validator.region_code"
+}
+
+// Validate implements the cel.ASTValidator interface.
+func (regionCodeValidator) Validate(_ *cel.Env, _ cel.ValidatorConfig, a *ast.AST, iss *cel.Issues) {
+	check := func(e ast.Expr) {
+		if e.Kind() != ast.LiteralKind {
+			return
+		}
+		code, ok := e.AsLiteral().Value().(string)
+		if !ok {
+			return
+		}
+		if err := CheckRegionCode(code); err != nil {
+			iss.ReportErrorAtID(e.ID(), "origin.region_code: %v", err)
+		}
+	}
+	for _, e := range ast.MatchDescendants(ast.NavigateAST(a), ast.KindMatcher(ast.CallKind)) {
+		call := e.AsCall()
+		args := call.Args()
+		if len(args) != 2 {
+			continue
+		}
+		if fn := call.FunctionName(); fn != operators.Equals && fn != operators.NotEquals {
+			continue
+		}
+		if isAttribute(args[0], "origin", "region_code") {
+			check(args[1])
+		} else if isAttribute(args[1], "origin", "region_code") {
+			check(args[0])
+		}
+	}
+}
+
+// isAttribute reports whether the expression refers to the attribute object.field, which the
+// checker resolves to an identifier of the declared attribute, e.g. origin.region_code.
+func isAttribute(e ast.Expr, object, field string) bool {
+	switch e.Kind() {
+	case ast.IdentKind:
+		return e.AsIdent() == object+"."+field
+	case ast.SelectKind:
+		sel := e.AsSelect()
+		operand := sel.Operand()
+		return !sel.IsTestOnly() && sel.FieldName() == field &&
+			operand.Kind() == ast.IdentKind && operand.AsIdent() == object
+	}
+	return false
+}
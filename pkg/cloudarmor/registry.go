@@ -0,0 +1,162 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/env"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed config/cloud-armor-v1.yaml
+var cloudArmorV1 string
+
+//go:embed config/cloud-armor-v2.yaml
+var cloudArmorV2 string
+
+//go:embed config/cloud-armor-network-v1.yaml
+var cloudArmorNetworkV1 string
+
+//go:embed config/cloud-armor-response-v2.yaml
+var cloudArmorResponseV2 string
+
+// ConfigKey identifies an environment configuration by policy profile and version.
+type ConfigKey struct {
+	Profile PolicyProfile
+	Version uint32
+}
+
+// String returns the key in the form profile/vN.
+func (k ConfigKey) String() string {
+	return fmt.Sprintf("%s/v%d", k.Profile, k.Version)
+}
+
+// envConfig is a registered environment configuration.
+type envConfig struct {
+	// yaml is the cel-go environment configuration declaring the variables and functions.
+	yaml string
+	// base is the configuration whose variables and functions are extended by yaml, if any.
+	base *envConfig
+	// functions returns the implementations of the functions declared by the configuration.
+	functions func() []cel.EnvOption
+}
+
+var (
+	httpV2 = &envConfig{yaml: cloudArmorV2, functions: httpFunctions(VNext)}
+
+	configsMu sync.RWMutex
+	configs   = map[ConfigKey]*envConfig{
+		{ProfileHTTP, VCurrent}:    {yaml: cloudArmorV1, functions: httpFunctions(VCurrent)},
+		{ProfileHTTP, VNext}:       httpV2,
+		{ProfileNetwork, VCurrent}: {yaml: cloudArmorNetworkV1, functions: coreFunctions},
+		{ProfileResponse, VNext}:   {yaml: cloudArmorResponseV2, base: httpV2, functions: httpFunctions(VNext)},
+	}
+)
+
+// config decodes the environment configuration, adding the variables and functions declared by
+// the configuration to those of its base.
+func (c *envConfig) config() (*env.Config, error) {
+	conf := env.NewConfig("")
+	if err := yaml.Unmarshal([]byte(c.yaml), conf); err != nil {
+		return nil, err
+	}
+	if c.base == nil {
+		return conf, nil
+	}
+	base, err := c.base.config()
+	if err != nil {
+		return nil, err
+	}
+	base.Name = conf.Name
+	base.Variables = append(base.Variables, conf.Variables...)
+	base.Functions = append(base.Functions, conf.Functions...)
+	return base, nil
+}
+
+// source returns the YAML of the configuration and its bases, which together determine the
+// declarations of the environment.
+func (c *envConfig) source() string {
+	if c.base == nil {
+		return c.yaml
+	}
+	return c.base.source() + "\n---\n" + c.yaml
+}
+
+// httpFunctions returns the function implementations of the HTTP profile for the given version.
+func httpFunctions(version uint32) func() []cel.EnvOption {
+	return func() []cel.EnvOption {
+		return append(cloudArmorFunctions(version), bindings(version)...)
+	}
+}
+
+// RegisterConfig makes an additional environment configuration, such as a regional variant or a
+// preview of upcoming attributes, available to NewRules for the given profile and version.
+//
+// The configuration is a cel-go environment configuration in YAML, in the same format as the
+// embedded configurations, and is combined with the function implementations of the HTTP profile
+// for the given version.
+//
+// The return value is an error if the configuration is invalid or the profile and version are
+// already registered.
+func RegisterConfig(profile PolicyProfile, version uint32, config string) error {
+	if err := yaml.Unmarshal([]byte(config), env.NewConfig("")); err != nil {
+		return fmt.Errorf("invalid config for %v: %w", ConfigKey{profile, version}, err)
+	}
+	key := ConfigKey{Profile: profile, Version: version}
+	configsMu.Lock()
+	defer configsMu.Unlock()
+	if _, found := configs[key]; found {
+		return fmt.Errorf("config for %v is already registered", key)
+	}
+	configs[key] = &envConfig{yaml: config, functions: httpFunctions(version)}
+	return nil
+}
+
+// Configs returns the registered combinations of profile and version, ordered by profile and
+// then by version.
+func Configs() []ConfigKey {
+	configsMu.RLock()
+	defer configsMu.RUnlock()
+	keys := make([]ConfigKey, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Profile != keys[j].Profile {
+			return keys[i].Profile < keys[j].Profile
+		}
+		return keys[i].Version < keys[j].Version
+	})
+	return keys
+}
+
+// lookupConfig returns the registered configuration for the given profile and version.
+func lookupConfig(profile PolicyProfile, version uint32) (*envConfig, error) {
+	configsMu.RLock()
+	defer configsMu.RUnlock()
+	c, found := configs[ConfigKey{Profile: profile, Version: version}]
+	if !found {
+		if profile == ProfileHTTP {
+			return nil, fmt.Errorf("unsupported cloud armor version: v%d", version)
+		}
+		return nil, fmt.Errorf("unsupported cloud armor version for %v policies: v%d", profile, version)
+	}
+	return c, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/google/cel-go/cel"
+)
+
+// RegressionTolerance bounds how much the set of requests matched by each rule may change between
+// a base and a head revision of a bundle before ReplayRegression reports a regression.
+type RegressionTolerance struct {
+	// MaxBroadened is the fraction of the corpus which a rule may newly match, such as a deny
+	// rule whose condition was accidentally broadened.
+	MaxBroadened MatchRate
+	// MaxNarrowed is the fraction of the corpus which a rule may no longer match.
+	MaxNarrowed MatchRate
+}
+
+// RegressionReport compares the requests of a corpus matched by the rules of two revisions of a
+// bundle.
+type RegressionReport struct {
+	Requests int
+	// Rules contains one entry per rule of either revision, in head order followed by the rules
+	// which were removed, in base order.
+	Rules []*RuleRegression
+}
+
+// Regressed returns the rules whose changes exceed the tolerance.
+func (rep *RegressionReport) Regressed() []*RuleRegression {
+	var regressed []*RuleRegression
+	for _, rule := range rep.Rules {
+		if rule.Exceeded {
+			regressed = append(regressed, rule)
+		}
+	}
+	return regressed
+}
+
+// RuleRegression counts the requests whose outcome under a rule changed between revisions. A
+// rule which only exists in one revision matches no requests in the other, and a rule which
+// fails to compile or evaluate does not match, as in Cloud Armor.
+type RuleRegression struct {
+	Name string
+	// BaseMatches and HeadMatches are the number of requests matched by each revision.
+	BaseMatches, HeadMatches int
+	// Broadened is the number of requests matched by the head revision but not the base.
+	Broadened int
+	// Narrowed is the number of requests matched by the base revision but not the head.
+	Narrowed int
+	// BroadenedLines contains the lines of the first requests within the corpus which the rule
+	// newly matches.
+	BroadenedLines []int
+	// Exceeded reports whether Broadened or Narrowed exceeds the tolerance.
+	Exceeded bool
+}
+
+// Changed reports whether the rule matches a different set of requests in the head revision.
+func (rule *RuleRegression) Changed() bool {
+	return rule.Broadened != 0 || rule.Narrowed != 0
+}
+
+// ReplayRegression evaluates the rules of a base and a head revision of a bundle, such as those
+// of a pull request, against every request of a pinned corpus, and reports the rules whose set of
+// matched requests changed by more than the tolerance, so that a CI gate can catch the accidental
+// broadening of a deny rule which a semantic diff alone cannot quantify. Rules are matched by
+// name, as by DiffBundles.
+//
+// The corpus is read one request at a time, as by EvaluateCorpus. The return value is an error
+// if the definitions of either bundle are invalid or a request cannot be decoded.
+func (r *Rules) ReplayRegression(base, head *RuleBundle, corpus io.Reader, tol RegressionTolerance, opts ...YAMLOption) (*RegressionReport, error) {
+	return r.ReplayRegressionContext(context.Background(), base, head, corpus, tol, opts...)
+}
+
+// ReplayRegressionContext is ReplayRegression which returns the context's error once the context
+// is done. The context is also passed to the evaluation of each request.
+func (r *Rules) ReplayRegressionContext(ctx context.Context, base, head *RuleBundle, corpus io.Reader, tol RegressionTolerance, opts ...YAMLOption) (*RegressionReport, error) {
+	basePrgs, err := r.regressionPrograms(base)
+	if err != nil {
+		return nil, err
+	}
+	headPrgs, err := r.regressionPrograms(head)
+	if err != nil {
+		return nil, err
+	}
+	report := &RegressionReport{}
+	var pairs [][2]cel.Program
+	inHead := map[string]bool{}
+	for _, rule := range head.Rules {
+		inHead[rule.Name] = true
+		report.Rules = append(report.Rules, &RuleRegression{Name: rule.Name})
+		pairs = append(pairs, [2]cel.Program{basePrgs[rule.Name], headPrgs[rule.Name]})
+	}
+	for _, rule := range base.Rules {
+		if !inHead[rule.Name] {
+			report.Rules = append(report.Rules, &RuleRegression{Name: rule.Name})
+			pairs = append(pairs, [2]cel.Program{basePrgs[rule.Name], nil})
+		}
+	}
+	matches := func(prg cel.Program, vars *Variables) bool {
+		return prg != nil && replayOutcome(prg.ContextEval(ctx, vars)) == ReplayMatch
+	}
+	next := corpusRequests(corpus, opts)
+	progress := progressFrom(ctx)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vars, line, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Requests++
+		vars = SafeVariables(vars)
+		for i, rule := range report.Rules {
+			before, after := matches(pairs[i][0], vars), matches(pairs[i][1], vars)
+			if before {
+				rule.BaseMatches++
+			}
+			if after {
+				rule.HeadMatches++
+			}
+			switch {
+			case after && !before:
+				rule.Broadened++
+				if len(rule.BroadenedLines) < maxMatchedLines {
+					rule.BroadenedLines = append(rule.BroadenedLines, line)
+				}
+			case before && !after:
+				rule.Narrowed++
+			}
+		}
+		progress(report.Requests, -1)
+	}
+	if report.Requests != 0 {
+		for _, rule := range report.Rules {
+			rule.Exceeded = MatchRate(float64(rule.Broadened)/float64(report.Requests)) > tol.MaxBroadened ||
+				MatchRate(float64(rule.Narrowed)/float64(report.Requests)) > tol.MaxNarrowed
+		}
+	}
+	return report, nil
+}
+
+// regressionPrograms returns the program of each rule of a bundle by name, omitting the rules
+// which fail to compile.
+func (r *Rules) regressionPrograms(b *RuleBundle) (map[string]cel.Program, error) {
+	exprs, err := r.replayExprs(b)
+	if err != nil {
+		return nil, err
+	}
+	prgs := map[string]cel.Program{}
+	for i, rule := range b.Rules {
+		if prg, err := r.replayProgram(exprs[i]); err == nil {
+			prgs[rule.Name] = prg
+		}
+	}
+	return prgs, nil
+}
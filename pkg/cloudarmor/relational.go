@@ -0,0 +1,94 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"regexp"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common"
+	"github.com/google/cel-go/common/operators"
+)
+
+// relationalSymbols maps the relational operator functions to their symbols.
+//
+// Cloud Armor supports relational operators between two int operands, e.g. origin.asn, and
+// between two double operands, e.g. token.recaptcha_action.score. The overloads themselves are
+// declared in the environment configuration.
+var relationalSymbols = map[string]string{
+	operators.Less:          "<",
+	operators.LessEquals:    "<=",
+	operators.Greater:       ">",
+	operators.GreaterEquals: ">=",
+}
+
+var relationalMismatch = regexp.MustCompile(`^found no matching overload for '(_[<>]=?_)' applied to '\(([^,]+), ([^)]+)\)'$`)
+
+// relationalHint returns guidance on the operand types accepted by a relational operator when the
+// message reports an overload mismatch for one, or false otherwise.
+func (r *Rules) relationalHint(message string) (string, bool) {
+	m := relationalMismatch.FindStringSubmatch(message)
+	if m == nil {
+		return "", false
+	}
+	sym := relationalSymbols[m[1]]
+	lhs, rhs := m[2], m[3]
+	numeric := func(t string) bool { return t == "int" || t == "double" }
+	if numeric(lhs) && numeric(rhs) {
+		return r.message(MessageRelationalMixed, sym), true
+	}
+	return r.message(MessageRelationalTypes, sym), true
+}
+
+// explainIssues appends targeted guidance to the compilation errors which have one, so that users
+// learn which operand types are supported rather than only which overload was missing, which
+// version or profile declares an undeclared attribute or function, and which declared attribute
+// or function a misspelled one most likely refers to. The return value is a
+// *CompileError when any symbol hints were found.
+func (r *Rules) explainIssues(expr string, iss *cel.Issues) error {
+	src := common.NewTextSource(expr)
+	errs := common.NewErrors(src)
+	explained := false
+	var hints []SymbolHint
+	hinted := map[string]bool{}
+	for _, e := range iss.Errors() {
+		msg := e.Message
+		if hint, found := r.relationalHint(msg); found {
+			msg = msg + ": " + hint
+			explained = true
+		}
+		if h, found := r.undeclaredHint(src, msg, e.Location); found {
+			id, args := h.message()
+			msg = msg + ": " + r.message(id, args...)
+			explained = true
+			if !hinted[h.Symbol] {
+				hinted[h.Symbol] = true
+				hints = append(hints, h)
+			}
+		} else if s, found := r.suggestion(src, msg, e.Location); found {
+			msg = msg + ": " + r.message(MessageDidYouMean, s)
+			explained = true
+		}
+		errs.ReportErrorAtID(e.ExprID, e.Location, "%s", msg)
+	}
+	if !explained {
+		return iss.Err()
+	}
+	err := cel.NewIssues(errs).Err()
+	if len(hints) != 0 {
+		return &CompileError{err: err, Hints: hints}
+	}
+	return err
+}
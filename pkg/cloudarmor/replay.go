@@ -0,0 +1,255 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+)
+
+// Replay outcomes recorded for each rule and request.
+const (
+	ReplayMatch        = "match"
+	ReplayNoMatch      = "no-match"
+	ReplayEvalError    = "eval-error"
+	ReplayCompileError = "compile-error"
+)
+
+// ReplayReport summarizes the replay of a corpus against the rules of a bundle.
+type ReplayReport struct {
+	Requests int
+	Rules    int
+	// CompileErrors is the number of rules which failed to compile.
+	CompileErrors int
+	// Digest is the hex-encoded SHA-256 hash of the canonical outcomes of the replay.
+	Digest string
+	// Impact contains the outcomes of each rule over the corpus, in bundle order.
+	Impact []*RuleImpact
+}
+
+// topSignatures is the number of signatures reported by RuleImpact.
+const topSignatures = 5
+
+// maxSignatures bounds the number of distinct signatures counted per rule, so that memory use
+// does not grow with the corpus. Signatures first seen beyond the bound are not counted.
+const maxSignatures = 1024
+
+// RuleImpact counts the outcomes of a rule over the requests of a corpus.
+type RuleImpact struct {
+	Name     string `json:"name"`
+	Requests int    `json:"requests"`
+	Matches  int    `json:"matches"`
+	Errors   int    `json:"errors"`
+	// TopSignatures are the signatures of the requests matched most often, most frequent first.
+	TopSignatures []SignatureCount `json:"top_signatures,omitempty"`
+	// TopASNs are the autonomous systems of the requests matched most often, most frequent first.
+	// Requests without an origin.asn are not counted.
+	TopASNs []ASNCount `json:"top_asns,omitempty"`
+	// Reproducer is the first request which the rule failed to evaluate, reduced to the
+	// attributes which cause the error.
+	Reproducer *Reproducer `json:"reproducer,omitempty"`
+
+	signatures map[string]int
+	asns       map[int64]int
+}
+
+// SignatureCount is the number of requests with a signature, the request method and path, e.g.
+// "POST /login", which a rule matched.
+type SignatureCount struct {
+	Signature string `json:"signature"`
+	Count     int    `json:"count"`
+}
+
+// MatchRate returns the fraction of the corpus which the rule matched.
+func (i *RuleImpact) MatchRate() MatchRate {
+	if i.Requests == 0 {
+		return 0
+	}
+	return MatchRate(float64(i.Matches) / float64(i.Requests))
+}
+
+// add counts the outcome of the rule for a request.
+func (i *RuleImpact) add(vars *Variables, outcome string) {
+	i.Requests++
+	switch outcome {
+	case ReplayMatch:
+		i.Matches++
+		sig := vars.Request.Method + " " + vars.Request.Path
+		if _, found := i.signatures[sig]; found || len(i.signatures) < maxSignatures {
+			i.signatures[sig]++
+		}
+		if asn := vars.Origin.ASN; asn != 0 {
+			if _, found := i.asns[asn]; found || len(i.asns) < maxSignatures {
+				i.asns[asn]++
+			}
+		}
+	case ReplayEvalError, ReplayCompileError:
+		i.Errors++
+	}
+}
+
+// finish selects the most frequent signatures and autonomous systems, breaking ties by signature
+// and number.
+func (i *RuleImpact) finish() {
+	for sig, count := range i.signatures {
+		i.TopSignatures = append(i.TopSignatures, SignatureCount{Signature: sig, Count: count})
+	}
+	sort.Slice(i.TopSignatures, func(a, b int) bool {
+		sa, sb := i.TopSignatures[a], i.TopSignatures[b]
+		return sa.Count > sb.Count || sa.Count == sb.Count && sa.Signature < sb.Signature
+	})
+	if len(i.TopSignatures) > topSignatures {
+		i.TopSignatures = i.TopSignatures[:topSignatures]
+	}
+	i.TopASNs = topASNs(i.asns)
+	i.signatures, i.asns = nil, nil
+}
+
+// Replay evaluates every rule of the bundle against every request of a corpus and returns a
+// digest of the outcomes, so that the semantics of a rule set can be compared across versions of
+// this library: replaying the same bundle and corpus under two versions yields the same digest
+// unless an outcome changed.
+//
+// The canonical outcomes are written to w, if it is not nil, with one line per rule and then one
+// line per request and rule, in corpus and then bundle order, e.g. "12\tblock-admin\tmatch",
+// where 12 is the line of the request within the corpus. Outcomes are ReplayMatch,
+// ReplayNoMatch, ReplayEvalError, or ReplayCompileError; error messages are omitted, since their
+// wording may change without a change in semantics. Diffing the outcomes of two replays locates
+// the requests whose outcomes differ.
+//
+// The first request which each rule fails to evaluate is reduced by ShrinkOutcome and reported as
+// the Reproducer of its impact.
+//
+// The corpus is read one request at a time, as by EvaluateCorpus. The return value is an error
+// if the bundle definitions are invalid, a request cannot be decoded, or w cannot be written.
+func (r *Rules) Replay(b *RuleBundle, corpus io.Reader, w io.Writer, opts ...YAMLOption) (*ReplayReport, error) {
+	return r.ReplayContext(context.Background(), b, corpus, w, opts...)
+}
+
+// ReplayContext is Replay which returns the context's error once the context is done. The
+// context is also passed to the evaluation of each request.
+func (r *Rules) ReplayContext(ctx context.Context, b *RuleBundle, corpus io.Reader, w io.Writer, opts ...YAMLOption) (*ReplayReport, error) {
+	exprs, err := r.replayExprs(b)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	out := io.Writer(h)
+	if w != nil {
+		out = io.MultiWriter(h, w)
+	}
+	report := &ReplayReport{Rules: len(b.Rules), Impact: make([]*RuleImpact, len(b.Rules))}
+	prgs := make([]cel.Program, len(b.Rules))
+	for i, rule := range b.Rules {
+		report.Impact[i] = &RuleImpact{Name: rule.Name, signatures: map[string]int{}, asns: map[int64]int{}}
+		outcome := "compiled"
+		if prgs[i], err = r.replayProgram(exprs[i]); err != nil {
+			outcome = ReplayCompileError
+			report.CompileErrors++
+		}
+		if err := writeOutcome(out, "-", rule.Name, outcome); err != nil {
+			return nil, err
+		}
+	}
+	next := corpusRequests(corpus, opts)
+	progress := progressFrom(ctx)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vars, line, err := next()
+		if errors.Is(err, io.EOF) {
+			report.Digest = digest(h)
+			for _, impact := range report.Impact {
+				impact.finish()
+			}
+			return report, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Requests++
+		vars = SafeVariables(vars)
+		for i, rule := range b.Rules {
+			outcome := ReplayCompileError
+			if prgs[i] != nil {
+				outcome = replayOutcome(prgs[i].ContextEval(ctx, vars))
+			}
+			report.Impact[i].add(vars, outcome)
+			if outcome == ReplayEvalError && report.Impact[i].Reproducer == nil {
+				report.Impact[i].Reproducer = newReproducer(prgs[i], vars, line, outcome)
+			}
+			if err := writeOutcome(out, fmt.Sprint(line), rule.Name, outcome); err != nil {
+				return nil, err
+			}
+		}
+		progress(report.Requests, -1)
+	}
+}
+
+// replayExprs returns the expanded expression of each rule of the bundle, or the empty string
+// for a rule whose expansion fails, which is then reported as failing to compile.
+func (r *Rules) replayExprs(b *RuleBundle) ([]string, error) {
+	x, err := r.newDefinitionExpander(b.Definitions)
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", b.Name, err)
+	}
+	exprs := make([]string, len(b.Rules))
+	for i, rule := range b.Rules {
+		exprs[i], _ = x.expand(rule.Expr)
+	}
+	return exprs, nil
+}
+
+// replayProgram compiles an expanded rule expression into a program.
+func (r *Rules) replayProgram(expr string) (cel.Program, error) {
+	if expr == "" {
+		return nil, errors.New("rule failed to expand")
+	}
+	ast, err := r.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return r.Program(ast)
+}
+
+// replayOutcome returns the outcome of an evaluation.
+func replayOutcome(out any, _ *cel.EvalDetails, err error) string {
+	switch {
+	case err != nil:
+		return ReplayEvalError
+	case out == types.True:
+		return ReplayMatch
+	}
+	return ReplayNoMatch
+}
+
+func writeOutcome(w io.Writer, line, rule, outcome string) error {
+	_, err := fmt.Fprintf(w, "%s\t%s\t%s\n", line, rule, outcome)
+	return err
+}
+
+func digest(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,236 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+)
+
+// CaseOutcome is the aggregate outcome of a test case over repeated runs.
+type CaseOutcome string
+
+const (
+	// OutcomePass is the outcome of a test case which passed in every run.
+	OutcomePass CaseOutcome = "pass"
+	// OutcomeFail is the outcome of a test case which failed in every run.
+	OutcomeFail CaseOutcome = "fail"
+	// OutcomeFlaky is the outcome of a test case which passed in some runs and failed in others,
+	// such as one whose latency budget is close to its actual latency.
+	OutcomeFlaky CaseOutcome = "flaky"
+)
+
+// Report aggregates the results of one or more runs of a rule bundle or test suite, grouping the
+// outcomes of test cases by rule and detecting the test cases which are flaky across runs, along
+// with the impact of each rule on a corpus replay, so that a single artifact can be published for
+// the whole run, as JSON with WriteJSON or as HTML with WriteHTML.
+type Report struct {
+	Name    string        `json:"name"`
+	Summary ReportSummary `json:"summary"`
+	// Rules contains one entry per rule, in the order the rules were first reported.
+	Rules []*RuleReport `json:"rules"`
+
+	rules map[string]*RuleReport
+}
+
+// ReportSummary counts the rules and test cases of a Report by outcome.
+type ReportSummary struct {
+	Runs        int `json:"runs"`
+	Rules       int `json:"rules"`
+	FailedRules int `json:"failed_rules"`
+	Cases       int `json:"cases"`
+	FailedCases int `json:"failed_cases"`
+	FlakyCases  int `json:"flaky_cases"`
+	// Requests is the number of requests in the corpus of a replay recorded with AddImpact.
+	Requests int `json:"requests,omitempty"`
+}
+
+// RuleReport aggregates the runs of a single rule.
+type RuleReport struct {
+	Name string `json:"name"`
+	Expr string `json:"expr,omitempty"`
+	// CompileError is the message of the error with which the rule last failed to compile.
+	CompileError string `json:"compile_error,omitempty"`
+	// Cases contains one entry per test case, in the order the cases were first reported.
+	Cases []*CaseReport `json:"cases"`
+	// Coverage counts the test cases of the rule by expectation.
+	Coverage Coverage `json:"coverage"`
+	// Impact is the impact of the rule on a corpus replay, if one was recorded with AddImpact.
+	Impact *RuleImpact `json:"impact,omitempty"`
+
+	cases map[string]*CaseReport
+}
+
+// Coverage counts the test cases of a rule by expectation, so that rules which are tested only
+// with matching, or only with non-matching, requests stand out.
+type Coverage struct {
+	Match   int `json:"match"`
+	NoMatch int `json:"no_match"`
+	Error   int `json:"error"`
+}
+
+// Complete reports whether the rule is tested with both matching and non-matching requests.
+func (c Coverage) Complete() bool {
+	return c.Match != 0 && c.NoMatch != 0
+}
+
+// CaseReport aggregates the runs of a single test case.
+type CaseReport struct {
+	Name    string      `json:"name"`
+	Outcome CaseOutcome `json:"outcome"`
+	Runs    int         `json:"runs"`
+	Passes  int         `json:"passes"`
+	// Failures contains the distinct failure messages of the test case, in the order they first
+	// occurred.
+	Failures []string `json:"failures,omitempty"`
+	// Trace contains the values of the subexpressions of the rule in the first failed run of the
+	// test case, if the Rules were created with ExplainFailures.
+	Trace []TraceStep `json:"trace,omitempty"`
+}
+
+// NewReport returns an empty Report with the given name, e.g. the name of a bundle.
+func NewReport(name string) *Report {
+	return &Report{Name: name, Rules: []*RuleReport{}, rules: map[string]*RuleReport{}}
+}
+
+// Add records the results of one run of a bundle, as returned by RunBundle.
+func (rep *Report) Add(results []RuleResult) {
+	rep.Summary.Runs++
+	for _, res := range results {
+		rule := rep.rule(res.Name)
+		if res.Expr != "" {
+			rule.Expr = res.Expr
+		}
+		if res.CompileError != nil {
+			rule.CompileError = res.CompileError.Error()
+		}
+		for _, s := range res.Statuses {
+			rule.add(s)
+		}
+	}
+	rep.summarize()
+}
+
+// AddSuite records the statuses of one run of a test suite, which is reported as a rule named
+// after the suite.
+func (rep *Report) AddSuite(ts *TestSuite, statuses []TestStatus) {
+	rep.Add([]RuleResult{{Name: ts.Name, Expr: ts.Expr, Statuses: statuses}})
+}
+
+// AddImpact records the impact of each rule on the corpus of a replay.
+func (rep *Report) AddImpact(replay *ReplayReport) {
+	for _, impact := range replay.Impact {
+		rep.rule(impact.Name).Impact = impact
+	}
+	rep.Summary.Requests = replay.Requests
+	rep.summarize()
+}
+
+// Passed reports whether every rule compiled and every test case passed in every run.
+func (rep *Report) Passed() bool {
+	return rep.Summary.FailedRules == 0
+}
+
+// WriteJSON writes the report as indented JSON.
+func (rep *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(rep)
+}
+
+// rule returns the report of the named rule, adding it if it has not been reported yet.
+func (rep *Report) rule(name string) *RuleReport {
+	if rule, found := rep.rules[name]; found {
+		return rule
+	}
+	rule := &RuleReport{Name: name, Cases: []*CaseReport{}, cases: map[string]*CaseReport{}}
+	rep.rules[name] = rule
+	rep.Rules = append(rep.Rules, rule)
+	return rule
+}
+
+// add records the status of one run of a test case of the rule.
+func (rule *RuleReport) add(s TestStatus) {
+	c, found := rule.cases[s.Name]
+	if !found {
+		c = &CaseReport{Name: s.Name}
+		rule.cases[s.Name] = c
+		rule.Cases = append(rule.Cases, c)
+		switch expected := s.Expected.(type) {
+		case bool:
+			if expected {
+				rule.Coverage.Match++
+			} else {
+				rule.Coverage.NoMatch++
+			}
+		case string, ErrorCode:
+			rule.Coverage.Error++
+		}
+	}
+	c.Runs++
+	if s.Fail == "" {
+		c.Passes++
+	} else {
+		if !slices.Contains(c.Failures, s.Fail) {
+			c.Failures = append(c.Failures, s.Fail)
+		}
+		if c.Trace == nil {
+			c.Trace = s.Trace
+		}
+	}
+	switch c.Passes {
+	case c.Runs:
+		c.Outcome = OutcomePass
+	case 0:
+		c.Outcome = OutcomeFail
+	default:
+		c.Outcome = OutcomeFlaky
+	}
+}
+
+// Passed reports whether the rule compiled and every test case passed in every run.
+func (rule *RuleReport) Passed() bool {
+	if rule.CompileError != "" {
+		return false
+	}
+	for _, c := range rule.Cases {
+		if c.Outcome != OutcomePass {
+			return false
+		}
+	}
+	return true
+}
+
+// summarize recounts the rules and test cases by outcome.
+func (rep *Report) summarize() {
+	s := ReportSummary{Runs: rep.Summary.Runs, Rules: len(rep.Rules), Requests: rep.Summary.Requests}
+	for _, rule := range rep.Rules {
+		if !rule.Passed() {
+			s.FailedRules++
+		}
+		for _, c := range rule.Cases {
+			s.Cases++
+			switch c.Outcome {
+			case OutcomeFail:
+				s.FailedCases++
+			case OutcomeFlaky:
+				s.FlakyCases++
+			}
+		}
+	}
+	rep.Summary = s
+}
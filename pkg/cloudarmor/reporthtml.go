@@ -0,0 +1,109 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// WriteHTML writes the report as a self-contained HTML page, with inline styles and no scripts or
+// external resources, so that it can be shared with those who do not use the CLI.
+func (rep *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, rep)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(rate MatchRate) string {
+		return fmt.Sprintf("%.2f%%", 100*float64(rate))
+	},
+	// width is the width of a match rate bar, with a minimum so that rare matches remain visible.
+	"width": func(rate MatchRate) string {
+		if rate > 0 && rate < 0.005 {
+			rate = 0.005
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(rate))
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #202124; }
+table { border-collapse: collapse; margin: 0.5em 0 1em; }
+th, td { border: 1px solid #dadce0; padding: 0.25em 0.75em; text-align: left; vertical-align: top; }
+code { background: #f1f3f4; padding: 0.1em 0.3em; }
+.pass { color: #188038; } .fail { color: #d93025; } .flaky { color: #e37400; }
+.bar { background: #f1f3f4; width: 20em; height: 1em; }
+.bar div { background: #1a73e8; height: 100%; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{with .Summary}}<table>
+<tr><th>Runs</th><td>{{.Runs}}</td></tr>
+<tr><th>Rules</th><td>{{.Rules}} ({{.FailedRules}} failed)</td></tr>
+<tr><th>Tests</th><td>{{.Cases}} ({{.FailedCases}} failed, {{.FlakyCases}} flaky)</td></tr>
+{{- if .Requests}}
+<tr><th>Corpus requests</th><td>{{.Requests}}</td></tr>
+{{- end}}
+</table>{{end}}
+{{range .Rules}}
+<h2 class="{{if .Passed}}pass{{else}}fail{{end}}">{{.Name}}</h2>
+{{- if .Expr}}
+<p><code>{{.Expr}}</code></p>
+{{- end}}
+{{- if .CompileError}}
+<p class="fail">Failed to compile: {{.CompileError}}</p>
+{{- end}}
+{{- if .Cases}}
+<p>Coverage: {{.Coverage.Match}} matching, {{.Coverage.NoMatch}} non-matching, {{.Coverage.Error}} error
+{{- if not .Coverage.Complete}} <span class="flaky">(not tested with both matching and non-matching requests)</span>{{end}}</p>
+<table>
+<tr><th>Test</th><th>Outcome</th><th>Passed runs</th><th>Failures</th></tr>
+{{- range .Cases}}
+<tr><td>{{.Name}}</td><td class="{{.Outcome}}">{{.Outcome}}</td><td>{{.Passes}} of {{.Runs}}</td><td>{{range .Failures}}{{.}}<br>{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- with .Impact}}
+<p>Matched {{.Matches}} of {{.Requests}} corpus requests ({{percent .MatchRate}}){{if .Errors}}, {{.Errors}} errors{{end}}</p>
+<div class="bar"><div style="width: {{width .MatchRate}}"></div></div>
+{{- if .TopSignatures}}
+<table>
+<tr><th>Top matched signatures</th><th>Requests</th></tr>
+{{- range .TopSignatures}}
+<tr><td><code>{{.Signature}}</code></td><td>{{.Count}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .TopASNs}}
+<table>
+<tr><th>Top matched autonomous systems</th><th>Requests</th></tr>
+{{- range .TopASNs}}
+<tr><td>{{.}}</td><td>{{.Count}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- with .Reproducer}}
+<p>First error on corpus line {{.Line}}, reduced to <code>{{.When}}</code></p>
+{{- end}}
+{{- end}}
+{{end}}
+</body>
+</html>
+`))
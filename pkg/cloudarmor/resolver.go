@@ -0,0 +1,185 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// attribute describes how a Cloud Armor attribute is read from the fields of Variables.
+type attribute struct {
+	name string
+	// index is the path of field indices from Variables to the attribute's field.
+	index []int
+	typ   reflect.Type
+}
+
+// attributes maps each attribute name to its field within Variables. The names are derived from
+// the YAML tags of the fields, so declaring an attribute within a config and adding the
+// corresponding tagged field is all that is required for the attribute to be resolved.
+var attributes = collectAttributes(reflect.TypeOf(Variables{}), "", nil, map[string]*attribute{})
+
+// collectAttributes adds the exported fields of the struct type t, and of the structs it points
+// to, to attrs. Fields which are neither structs nor pointers to structs are attributes.
+func collectAttributes(t reflect.Type, prefix string, index []int, attrs map[string]*attribute) map[string]*attribute {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + tag
+		fieldIndex := append(append([]int(nil), index...), i)
+		if f.Type.Kind() == reflect.Pointer && f.Type.Elem().Kind() == reflect.Struct {
+			collectAttributes(f.Type.Elem(), name+".", fieldIndex, attrs)
+			continue
+		}
+		attrs[name] = &attribute{name: name, index: fieldIndex, typ: f.Type}
+	}
+	return attrs
+}
+
+// AttributeNames returns the sorted names of the attributes which Variables can resolve.
+func AttributeNames() []string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// value returns the Go value of the attribute, or its zero value when a subtree containing the
+// attribute has not been initialized.
+func (a *attribute) value(v *Variables) any {
+	f, found := a.field(v)
+	if !found {
+		return reflect.Zero(a.typ).Interface()
+	}
+	return f.Interface()
+}
+
+// field returns the field of the attribute, or false when a subtree containing the attribute has
+// not been initialized.
+func (a *attribute) field(v *Variables) (reflect.Value, bool) {
+	f := reflect.ValueOf(v).Elem()
+	for _, i := range a.index {
+		if f.Kind() == reflect.Pointer {
+			if f.IsNil() {
+				return reflect.Value{}, false
+			}
+			f = f.Elem()
+		}
+		f = f.Field(i)
+	}
+	return f, true
+}
+
+// holds reports whether the scalar attribute currently holds src, reading the field without
+// converting it to an interface value so that the check does not allocate.
+func (a *attribute) holds(v *Variables, src any) bool {
+	f, found := a.field(v)
+	if !found {
+		return false
+	}
+	switch f.Kind() {
+	case reflect.String:
+		s, ok := src.(string)
+		return ok && f.String() == s
+	case reflect.Int64:
+		i, ok := src.(int64)
+		return ok && f.Int() == i
+	case reflect.Bool:
+		b, ok := src.(bool)
+		return ok && f.Bool() == b
+	case reflect.Float64:
+		d, ok := src.(float64)
+		return ok && math.Float64bits(f.Float()) == math.Float64bits(d)
+	}
+	return false
+}
+
+// celValue returns the CEL value of a scalar attribute, or nil for map and list attributes.
+//
+// Double attributes are scores, which evaluate to a NonFiniteScoreError unless they are finite.
+func (a *attribute) celValue(v *Variables) ref.Val {
+	switch val := a.value(v).(type) {
+	case string:
+		return types.String(val)
+	case int64:
+		return types.Int(val)
+	case bool:
+		return types.Bool(val)
+	case float64:
+		return scoreVal(a.name, val)
+	}
+	return nil
+}
+
+// VerifyEnvBindings checks that every attribute declared by the environment of the Rules can be
+// resolved from Variables and that the Go type of the corresponding field matches the declared
+// CEL type. It is intended for the tests of users who extend the environment, e.g. with
+// RegisterConfig, to catch declarations which would fail at evaluation time.
+//
+// The return value joins an error for each mismatched attribute, or is nil if there are none.
+func VerifyEnvBindings(r *Rules) error {
+	var errs []error
+	for _, v := range r.env.Variables() {
+		name := v.Name()
+		if v.Type().Kind() == types.TypeKind || strings.HasPrefix(name, presencePrefix) {
+			continue
+		}
+		attr, found := attributes[name]
+		if !found {
+			errs = append(errs, fmt.Errorf("%s: declared as %v but not resolved by Variables", name, v.Type()))
+			continue
+		}
+		if !bindable(v.Type(), attr.typ) {
+			errs = append(errs, fmt.Errorf("%s: declared as %v but resolved from a field of type %v", name, v.Type(), attr.typ))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+// bindable reports whether values of the Go type are adapted to the declared CEL type.
+func bindable(declared *types.Type, goType reflect.Type) bool {
+	switch declared.Kind() {
+	case types.StringKind:
+		return goType.Kind() == reflect.String
+	case types.IntKind:
+		return goType.Kind() == reflect.Int64
+	case types.BoolKind:
+		return goType.Kind() == reflect.Bool
+	case types.DoubleKind:
+		return goType.Kind() == reflect.Float64
+	case types.ListKind:
+		return goType.Kind() == reflect.Slice && bindable(declared.Parameters()[0], goType.Elem())
+	case types.MapKind:
+		return goType.Kind() == reflect.Map && bindable(declared.Parameters()[0], goType.Key()) &&
+			bindable(declared.Parameters()[1], goType.Elem())
+	case types.DynKind:
+		return true
+	}
+	return false
+}
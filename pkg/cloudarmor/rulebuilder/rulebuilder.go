@@ -0,0 +1,300 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rulebuilder constructs Cloud Armor rule expressions programmatically for platforms
+// which generate rules from structured selections rather than free-form CEL.
+//
+// Conditions are joined with And() and Or(), which follow the usual CEL precedence where &&
+// binds more tightly than ||. Group() may be used to parenthesize a nested builder:
+//
+//	expr, err := rulebuilder.New().
+//		OriginInCIDR("192.0.2.0/24").
+//		And().
+//		Group(rulebuilder.New().PathPrefix("/admin").Or().PathPrefix("/internal")).
+//		Build()
+package rulebuilder
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+)
+
+// Option configures a Builder.
+type Option func(*Builder)
+
+// Version sets the Cloud Armor environment version the built expression must be compatible
+// with. The default is cloudarmor.VCurrent.
+func Version(version uint32) Option {
+	return func(b *Builder) {
+		b.version = version
+	}
+}
+
+// Builder accumulates conditions into a Cloud Armor rule expression.
+//
+// The first error encountered while building is retained and reported by Build, so calls may
+// be chained without intermediate error checks.
+type Builder struct {
+	version uint32
+	parts   []string
+	// expectCondition is true when the next call must add a condition rather than a connective.
+	expectCondition bool
+	negate          bool
+	err             error
+}
+
+// New creates an empty Builder.
+func New(opts ...Option) *Builder {
+	b := &Builder{version: cloudarmor.VCurrent, expectCondition: true}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// And joins the previous condition to the next one with a logical AND.
+func (b *Builder) And() *Builder {
+	return b.connective("&&")
+}
+
+// Or joins the previous condition to the next one with a logical OR.
+func (b *Builder) Or() *Builder {
+	return b.connective("||")
+}
+
+// Not negates the next condition.
+func (b *Builder) Not() *Builder {
+	if b.err == nil && !b.expectCondition {
+		b.err = fmt.Errorf("Not() must precede a condition, call And() or Or() first")
+	}
+	b.negate = !b.negate
+	return b
+}
+
+// Group adds the expression built by the inner builder as a single parenthesized condition.
+func (b *Builder) Group(inner *Builder) *Builder {
+	if inner.err != nil {
+		return b.fail(inner.err)
+	}
+	if inner.version != b.version {
+		return b.fail(fmt.Errorf("Group() builder targets version %d, wanted %d", inner.version, b.version))
+	}
+	expr, err := inner.expr()
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.condition("(" + expr + ")")
+}
+
+// OriginInCIDR matches requests whose origin IP address is within the CIDR range.
+func (b *Builder) OriginInCIDR(cidr string) *Builder {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return b.fail(fmt.Errorf("OriginInCIDR(%q): invalid CIDR range", cidr))
+	}
+	return b.condition(fmt.Sprintf("inIpRange(origin.ip, %s)", strconv.Quote(cidr)))
+}
+
+// OriginRegion matches requests originating from any of the ISO 3166-1 alpha-2 region codes.
+func (b *Builder) OriginRegion(codes ...string) *Builder {
+	for _, code := range codes {
+		if err := cloudarmor.CheckRegionCode(code); err != nil {
+			return b.fail(fmt.Errorf("OriginRegion(%q): %w", code, err))
+		}
+	}
+	return b.anyEqual("OriginRegion", "origin.region_code", quoteAll(codes))
+}
+
+// OriginASN matches requests originating from any of the autonomous system numbers.
+func (b *Builder) OriginASN(asns ...int64) *Builder {
+	lits := make([]string, len(asns))
+	for i, asn := range asns {
+		if err := cloudarmor.CheckASN(asn); err != nil {
+			return b.fail(fmt.Errorf("OriginASN(%d): %w", asn, err))
+		}
+		lits[i] = strconv.FormatInt(asn, 10)
+	}
+	return b.anyEqual("OriginASN", "origin.asn", lits)
+}
+
+// Method matches requests using any of the HTTP methods.
+func (b *Builder) Method(methods ...string) *Builder {
+	for _, m := range methods {
+		if !methodPattern.MatchString(m) {
+			return b.fail(fmt.Errorf("Method(%q): not an uppercase HTTP method", m))
+		}
+	}
+	return b.anyEqual("Method", "request.method", quoteAll(methods))
+}
+
+// PathPrefix matches requests whose path begins with the prefix.
+func (b *Builder) PathPrefix(prefix string) *Builder {
+	if !strings.HasPrefix(prefix, "/") {
+		return b.fail(fmt.Errorf("PathPrefix(%q): prefix must begin with '/'", prefix))
+	}
+	return b.condition(fmt.Sprintf("request.path.startsWith(%s)", strconv.Quote(prefix)))
+}
+
+// PathMatches matches requests whose path matches the RE2 regular expression.
+func (b *Builder) PathMatches(pattern string) *Builder {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return b.fail(fmt.Errorf("PathMatches(%q): %w", pattern, err))
+	}
+	return b.condition(fmt.Sprintf("request.path.matches(%s)", strconv.Quote(pattern)))
+}
+
+// QueryContains matches requests whose raw query string contains the substring.
+func (b *Builder) QueryContains(substr string) *Builder {
+	return b.condition(fmt.Sprintf("request.query.contains(%s)", strconv.Quote(substr)))
+}
+
+// HeaderEquals matches requests whose header has exactly the given value. Header names are
+// case-insensitive.
+func (b *Builder) HeaderEquals(name, value string) *Builder {
+	return b.condition(fmt.Sprintf("request.headers[%s] == %s",
+		strconv.Quote(strings.ToLower(name)), strconv.Quote(value)))
+}
+
+// HeaderContains matches requests whose header value contains the substring. Header names are
+// case-insensitive.
+func (b *Builder) HeaderContains(name, substr string) *Builder {
+	return b.condition(fmt.Sprintf("request.headers[%s].contains(%s)",
+		strconv.Quote(strings.ToLower(name)), strconv.Quote(substr)))
+}
+
+// JA4Fingerprint matches requests whose TLS JA4 fingerprint is any of the fingerprints.
+func (b *Builder) JA4Fingerprint(fingerprints ...string) *Builder {
+	for _, fp := range fingerprints {
+		if err := cloudarmor.CheckJA4Fingerprint(fp); err != nil {
+			return b.fail(fmt.Errorf("JA4Fingerprint(%q): %w", fp, err))
+		}
+	}
+	return b.anyEqual("JA4Fingerprint", "origin.tls_ja4_fingerprint", quoteAll(fingerprints))
+}
+
+// TokenScoreBelow matches requests whose reCAPTCHA action token score is below the threshold.
+func (b *Builder) TokenScoreBelow(threshold float64) *Builder {
+	if threshold < 0 || threshold > 1 {
+		return b.fail(fmt.Errorf("TokenScoreBelow(%v): threshold must be between 0.0 and 1.0", threshold))
+	}
+	lit := strconv.FormatFloat(threshold, 'f', -1, 64)
+	if !strings.Contains(lit, ".") {
+		lit += ".0"
+	}
+	return b.condition("token.recaptcha_action.score < " + lit)
+}
+
+// BodyContains matches requests whose body contains the substring. Requires VNext.
+func (b *Builder) BodyContains(substr string) *Builder {
+	if b.version < cloudarmor.VNext {
+		return b.fail(fmt.Errorf("BodyContains() requires VNext, request.body is not available in version %d", b.version))
+	}
+	return b.condition(fmt.Sprintf("request.body.contains(%s)", strconv.Quote(substr)))
+}
+
+// Build returns the constructed expression after verifying that it compiles within the target
+// version of the Cloud Armor environment.
+func (b *Builder) Build() (string, error) {
+	expr, err := b.expr()
+	if err != nil {
+		return "", err
+	}
+	r, err := cloudarmor.NewRules(cloudarmor.Version(b.version))
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.Compile(expr); err != nil {
+		return "", fmt.Errorf("built expression failed to compile: %w", err)
+	}
+	return expr, nil
+}
+
+func (b *Builder) expr() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	if len(b.parts) == 0 {
+		return "", fmt.Errorf("no conditions were added")
+	}
+	if b.expectCondition {
+		return "", fmt.Errorf("expression ends with a dangling %s", b.parts[len(b.parts)-1])
+	}
+	return strings.Join(b.parts, " "), nil
+}
+
+var (
+	methodPattern = regexp.MustCompile(`^[A-Z]+$`)
+)
+
+func (b *Builder) connective(op string) *Builder {
+	if b.err == nil && b.expectCondition {
+		b.err = fmt.Errorf("%s must follow a condition", op)
+	}
+	b.parts = append(b.parts, op)
+	b.expectCondition = true
+	return b
+}
+
+func (b *Builder) condition(cond string) *Builder {
+	if b.err == nil && !b.expectCondition {
+		b.err = fmt.Errorf("condition %s must be joined to the previous condition with And() or Or()", cond)
+	}
+	if b.negate {
+		// Conditions which begin with a parenthesis are always a single parenthesized group.
+		if strings.HasPrefix(cond, "(") {
+			cond = "!" + cond
+		} else {
+			cond = "!(" + cond + ")"
+		}
+		b.negate = false
+	}
+	b.parts = append(b.parts, cond)
+	b.expectCondition = false
+	return b
+}
+
+// anyEqual adds a condition comparing the attribute against each literal, parenthesizing the
+// comparisons when there is more than one so that the condition composes with And().
+func (b *Builder) anyEqual(method, attr string, lits []string) *Builder {
+	if len(lits) == 0 {
+		return b.fail(fmt.Errorf("%s() requires at least one value", method))
+	}
+	terms := make([]string, len(lits))
+	for i, lit := range lits {
+		terms[i] = fmt.Sprintf("%s == %s", attr, lit)
+	}
+	if len(terms) == 1 {
+		return b.condition(terms[0])
+	}
+	return b.condition("(" + strings.Join(terms, " || ") + ")")
+}
+
+func (b *Builder) fail(err error) *Builder {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
+
+func quoteAll(vals []string) []string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = strconv.Quote(v)
+	}
+	return out
+}
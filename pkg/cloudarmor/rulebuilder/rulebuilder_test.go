@@ -0,0 +1,141 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rulebuilder_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/rulebuilder"
+)
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *rulebuilder.Builder
+		want    string
+	}{
+		{
+			name:    "and",
+			builder: rulebuilder.New().OriginInCIDR("192.0.2.0/24").And().PathPrefix("/admin"),
+			want:    `inIpRange(origin.ip, "192.0.2.0/24") && request.path.startsWith("/admin")`,
+		},
+		{
+			name:    "multiple values",
+			builder: rulebuilder.New().OriginRegion("AQ", "BV").Or().OriginASN(64496),
+			want:    `(origin.region_code == "AQ" || origin.region_code == "BV") || origin.asn == 64496`,
+		},
+		{
+			name: "group and not",
+			builder: rulebuilder.New().PathPrefix("/admin").And().Not().
+				Group(rulebuilder.New().Method("GET").Or().HeaderEquals("X-Admin", "1")),
+			want: `request.path.startsWith("/admin") && !(request.method == "GET" || request.headers["x-admin"] == "1")`,
+		},
+		{
+			name:    "not condition",
+			builder: rulebuilder.New().Not().PathMatches(`^/api/v[0-9]+/`),
+			want:    `!(request.path.matches("^/api/v[0-9]+/"))`,
+		},
+		{
+			name:    "token score",
+			builder: rulebuilder.New().TokenScoreBelow(1).And().QueryContains("debug"),
+			want:    `token.recaptcha_action.score < 1.0 && request.query.contains("debug")`,
+		},
+		{
+			name:    "vnext body",
+			builder: rulebuilder.New(rulebuilder.Version(cloudarmor.VNext)).BodyContains("<script"),
+			want:    `request.body.contains("<script")`,
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			got, err := tst.builder.Build()
+			if err != nil {
+				t.Fatalf("Build() returned error: %v", err)
+			}
+			if got != tst.want {
+				t.Errorf("Build() = %q, wanted %q", got, tst.want)
+			}
+		})
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *rulebuilder.Builder
+		wantErr string
+	}{
+		{
+			name:    "empty",
+			builder: rulebuilder.New(),
+			wantErr: "no conditions",
+		},
+		{
+			name:    "missing connective",
+			builder: rulebuilder.New().PathPrefix("/a").PathPrefix("/b"),
+			wantErr: "must be joined",
+		},
+		{
+			name:    "dangling connective",
+			builder: rulebuilder.New().PathPrefix("/a").And(),
+			wantErr: "dangling &&",
+		},
+		{
+			name:    "leading connective",
+			builder: rulebuilder.New().Or().PathPrefix("/a"),
+			wantErr: "|| must follow a condition",
+		},
+		{
+			name:    "invalid cidr",
+			builder: rulebuilder.New().OriginInCIDR("192.0.2.0"),
+			wantErr: "invalid CIDR range",
+		},
+		{
+			name:    "invalid regex",
+			builder: rulebuilder.New().PathMatches("(?<=a)"),
+			wantErr: "PathMatches",
+		},
+		{
+			name:    "body in vcurrent",
+			builder: rulebuilder.New().BodyContains("x"),
+			wantErr: "requires VNext",
+		},
+		{
+			name:    "truncated JA4 fingerprint",
+			builder: rulebuilder.New().JA4Fingerprint("t13d1516h2_8daaf6152771"),
+			wantErr: "not a JA4 fingerprint",
+		},
+		{
+			name:    "asn out of range",
+			builder: rulebuilder.New().OriginASN(1 << 32),
+			wantErr: "OriginASN(4294967296): 4294967296 is outside the range 0 to 4294967295",
+		},
+		{
+			name:    "first error retained",
+			builder: rulebuilder.New().OriginRegion("usa").And().OriginASN(-1),
+			wantErr: `OriginRegion("usa")`,
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			_, err := tst.builder.Build()
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("Build() returned error %v, wanted error containing %q", err, tst.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"strings"
+)
+
+// RuleFileEntry is a single expression within a rule file.
+type RuleFileEntry struct {
+	// Expr is the expression, without its leading comments.
+	Expr string
+	// Comments is the text of the block of // comment lines immediately preceding the expression,
+	// with the comment markers removed, e.g. "added during incident 2024-11-02".
+	Comments []string
+	// Line is the line of the rule file on which the expression begins.
+	Line int
+}
+
+// ParseRuleFile splits the contents of a rule file into its expressions, which are separated by
+// ';'. Separators within string literals and comments are ignored, and the comment lines
+// directly above an expression are attached to it so that tooling which rewrites or exports the
+// rules can carry their operational context along. A blank line ends a comment block, so a
+// comment separated from the next expression by a blank line is not attached to it.
+func ParseRuleFile(content string) []RuleFileEntry {
+	var entries []RuleFileEntry
+	start, line := 0, 1
+	for i, end := range ruleSeparators(content) {
+		if entry, ok := parseRuleFileEntry(content[start:end], line, i > 0); ok {
+			entries = append(entries, entry)
+		}
+		line += strings.Count(content[start:end], "\n")
+		start = end + 1
+	}
+	if entry, ok := parseRuleFileEntry(content[start:], line, start > 0); ok {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ruleSeparators returns the offsets of the ';' characters which separate expressions, skipping
+// those within string literals and comments.
+func ruleSeparators(content string) []int {
+	var seps []int
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == ';':
+			seps = append(seps, i)
+		case c == '/' && strings.HasPrefix(content[i:], "//"):
+			if end := strings.IndexByte(content[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(content)
+			}
+		case c == '"' || c == '\'':
+			i = stringEnd(content, i)
+		}
+	}
+	return seps
+}
+
+// stringEnd returns the offset of the closing quote of the string literal starting at i, or the
+// end of the content if the literal is unterminated.
+func stringEnd(content string, i int) int {
+	quote := content[i : i+1]
+	if strings.HasPrefix(content[i:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	raw := i > 0 && (content[i-1] == 'r' || content[i-1] == 'R')
+	for j := i + len(quote); j < len(content); j++ {
+		if content[j] == '\\' && !raw {
+			j++
+			continue
+		}
+		if strings.HasPrefix(content[j:], quote) {
+			return j + len(quote) - 1
+		}
+		if content[j] == '\n' && len(quote) == 1 {
+			return j
+		}
+	}
+	return len(content)
+}
+
+// parseRuleFileEntry separates the leading comments of a segment of a rule file, beginning on the
+// given line, from its expression. A comment on the same line as the separator which precedes
+// the segment belongs to the previous expression, so it is not attached. It returns false if the
+// segment has no expression.
+func parseRuleFileEntry(segment string, line int, separated bool) (RuleFileEntry, bool) {
+	var comments []string
+	rest := segment
+	for first := true; rest != ""; first = false {
+		text, remainder, _ := strings.Cut(rest, "\n")
+		trimmed := strings.TrimSpace(text)
+		comment, isComment := strings.CutPrefix(trimmed, "//")
+		switch {
+		case trimmed == "":
+			comments = nil
+		case isComment && first && separated:
+		case isComment:
+			comments = append(comments, strings.TrimSpace(comment))
+		default:
+			return RuleFileEntry{Expr: strings.TrimSpace(rest), Comments: comments, Line: line}, true
+		}
+		rest = remainder
+		line++
+	}
+	return RuleFileEntry{}, false
+}
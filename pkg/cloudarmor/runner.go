@@ -0,0 +1,154 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Runner runs test suites, calling its hooks as each test case starts and completes and as each
+// suite completes, so that IDE plugins and CI integrations can report results as they are
+// produced rather than once the whole suite has run. Hooks which are nil are not called. Hooks
+// are called on the goroutine running the suite, so a slow hook delays the next test case.
+type Runner struct {
+	// OnCaseStart is called before each test case selected by the TestTags option is evaluated.
+	OnCaseStart func(suite string, tc *TestCase)
+	// OnCaseDone is called with the status of each test case once it has been evaluated.
+	OnCaseDone func(suite string, s TestStatus)
+	// OnSuiteDone is called once a suite has run, including when it stopped early.
+	OnSuiteDone func(res SuiteResult)
+
+	rules *Rules
+}
+
+// SuiteResult summarizes the run of a test suite.
+type SuiteResult struct {
+	Name   string
+	Passed int
+	Failed int
+	// Err is the error which prevented the suite from running, or stopped it early, such as a
+	// compile error, an unreadable test case, or the error of a done context.
+	Err error
+}
+
+// NewRunner returns a Runner which runs test suites in the environment of the Rules. Its hooks
+// are set by the caller before running a suite.
+func (r *Rules) NewRunner() *Runner {
+	return &Runner{rules: r}
+}
+
+// Run runs the test cases against an expression, as RunRuleValidationContext does, under the
+// given suite name. Progress is reported to the ProgressFunc of the context.
+func (rn *Runner) Run(ctx context.Context, suite string, prg cel.Program, testCases []*TestCase) ([]TestStatus, error) {
+	var statuses []TestStatus
+	res := SuiteResult{Name: suite}
+	progress := progressFrom(ctx)
+	for i, tc := range testCases {
+		if res.Err = ctx.Err(); res.Err != nil {
+			break
+		}
+		if rn.rules.selected(tc) {
+			statuses = append(statuses, rn.runCase(suite, prg, i, tc, &res))
+		}
+		progress(i+1, len(testCases))
+	}
+	rn.suiteDone(res)
+	return statuses, res.Err
+}
+
+// RunStream runs each test case read from the stream against an expression, as
+// RunStreamValidation does, under the name of the stream's suite. Statuses are only passed to
+// OnCaseDone, so memory use is bounded by the largest test case.
+func (rn *Runner) RunStream(ctx context.Context, prg cel.Program, tr *TestCaseReader) error {
+	res := SuiteResult{Name: tr.Suite.Name}
+	for i := 0; ; i++ {
+		if res.Err = ctx.Err(); res.Err != nil {
+			break
+		}
+		tc, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			res.Err = err
+			break
+		}
+		if rn.rules.selected(tc) {
+			rn.runCase(res.Name, prg, i, tc, &res)
+		}
+	}
+	rn.suiteDone(res)
+	return res.Err
+}
+
+// RunBundle compiles every rule within the bundle and runs its test cases, as the RunBundle
+// method of the Rules does, with each rule run as a suite named <bundle>/<rule>. Rules which fail
+// to compile are reported to OnSuiteDone with the compile error.
+func (rn *Runner) RunBundle(ctx context.Context, b *RuleBundle) ([]RuleResult, error) {
+	r := rn.rules
+	x, err := r.newDefinitionExpander(b.Definitions)
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s: %w", b.Name, err)
+	}
+	results := make([]RuleResult, len(b.Rules))
+	for i, rule := range b.Rules {
+		if err := ctx.Err(); err != nil {
+			return results[:i], err
+		}
+		results[i].Name = rule.Name
+		suite := b.Name + "/" + rule.Name
+		prg, expr, err := r.bundleProgram(x, rule)
+		results[i].Expr = expr
+		if err != nil {
+			results[i].CompileError = err
+			rn.suiteDone(SuiteResult{Name: suite, Err: err})
+			continue
+		}
+		if results[i].Statuses, err = rn.Run(ctx, suite, prg, rule.Tests); err != nil {
+			return results[:i+1], err
+		}
+	}
+	return results, nil
+}
+
+// runCase runs a single test case between the OnCaseStart and OnCaseDone hooks and counts its
+// outcome within the suite result.
+func (rn *Runner) runCase(suite string, prg cel.Program, index int, tc *TestCase, res *SuiteResult) TestStatus {
+	if rn.OnCaseStart != nil {
+		rn.OnCaseStart(suite, tc)
+	}
+	s := rn.rules.runTestCase(prg, tc)
+	s.CaseIndex = index
+	if s.Pass {
+		res.Passed++
+	} else {
+		res.Failed++
+	}
+	if rn.OnCaseDone != nil {
+		rn.OnCaseDone(suite, s)
+	}
+	return s
+}
+
+func (rn *Runner) suiteDone(res SuiteResult) {
+	if rn.OnSuiteDone != nil {
+		rn.OnSuiteDone(res)
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+)
+
+// maxShrinkEvaluations bounds the number of candidates evaluated by ShrinkVariables, so that a
+// large request body cannot stall a replay.
+const maxShrinkEvaluations = 5000
+
+// ShrinkVariables returns a reduced copy of the variables for which keep still returns true, so
+// that a request found by a corpus replay or by generated inputs can be reported as a minimal
+// reproducer. Headers, params, and attack signatures are dropped, other attributes are reset to
+// their zero values, and strings are shortened, until no single reduction preserves keep or the
+// number of candidates evaluated reaches a bound.
+//
+// The candidates passed to keep have been initialized with SafeVariables. If keep does not hold
+// for the variables themselves, they are returned unreduced.
+func ShrinkVariables(vars *Variables, keep func(*Variables) bool) *Variables {
+	s := &shrinker{cur: copyVariables(vars), keep: keep}
+	if !s.try() {
+		return SafeVariables(copyVariables(vars))
+	}
+	for progress := true; progress && s.evals < maxShrinkEvaluations; {
+		s.progress = false
+		s.shrinkValue(reflect.ValueOf(s.cur).Elem())
+		progress = s.progress
+	}
+	return SafeVariables(copyVariables(s.cur))
+}
+
+// ShrinkOutcome returns the variables reduced by ShrinkVariables while preserving the outcome of
+// the program: whether it matches, or the ErrorCode of its error.
+func ShrinkOutcome(prg cel.Program, vars *Variables) *Variables {
+	want := shrinkOutcome(prg, vars)
+	return ShrinkVariables(vars, func(v *Variables) bool {
+		return shrinkOutcome(prg, v) == want
+	})
+}
+
+// shrinkOutcome returns the replay outcome of the program for the variables, qualified by the
+// code of its error.
+func shrinkOutcome(prg cel.Program, vars *Variables) string {
+	out, det, err := prg.Eval(vars)
+	if err != nil {
+		return ReplayEvalError + " " + string(ErrorCodeOf(err))
+	}
+	return replayOutcome(out, det, err)
+}
+
+// shrinker reduces the attributes of cur in place, keeping each reduction for which a copy of cur
+// satisfies keep.
+type shrinker struct {
+	cur      *Variables
+	keep     func(*Variables) bool
+	evals    int
+	progress bool
+}
+
+// try reports whether the current variables satisfy keep, within the evaluation bound.
+func (s *shrinker) try() bool {
+	if s.evals >= maxShrinkEvaluations {
+		return false
+	}
+	s.evals++
+	if !s.keep(SafeVariables(copyVariables(s.cur))) {
+		return false
+	}
+	s.progress = true
+	return true
+}
+
+// shrinkValue reduces the exported fields of the value, which is addressable within cur.
+func (s *shrinker) shrinkValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			s.shrinkValue(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				s.shrinkValue(v.Field(i))
+			}
+		}
+	case reflect.Map:
+		s.shrinkMap(v)
+	case reflect.Slice:
+		s.shrinkSlice(v)
+	case reflect.String:
+		s.shrinkString(v.String(), v.SetString)
+	case reflect.Bool, reflect.Int64, reflect.Float64:
+		s.reset(v)
+	}
+}
+
+// reset tries the zero value of a scalar, restoring it if keep no longer holds.
+func (s *shrinker) reset(v reflect.Value) {
+	if v.IsZero() {
+		return
+	}
+	orig := reflect.ValueOf(v.Interface())
+	v.SetZero()
+	if !s.try() {
+		v.Set(orig)
+	}
+}
+
+// shrinkMap drops each entry of the map in key order, and shortens the string values of those
+// which must be kept.
+func (s *shrinker) shrinkMap(m reflect.Value) {
+	if m.IsNil() {
+		return
+	}
+	var keys []string
+	for _, k := range m.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key := reflect.ValueOf(k).Convert(m.Type().Key())
+		val := m.MapIndex(key)
+		m.SetMapIndex(key, reflect.Value{})
+		if s.try() {
+			continue
+		}
+		m.SetMapIndex(key, val)
+		if str, ok := val.Interface().(string); ok {
+			s.shrinkString(str, func(str string) { m.SetMapIndex(key, reflect.ValueOf(str).Convert(m.Type().Elem())) })
+		}
+	}
+}
+
+// shrinkSlice drops each element of the slice, and shortens the strings which must be kept.
+func (s *shrinker) shrinkSlice(v reflect.Value) {
+	for i := 0; i < v.Len(); {
+		orig := reflect.ValueOf(v.Interface())
+		dropped := reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()-1), v.Slice(0, i))
+		v.Set(reflect.AppendSlice(dropped, v.Slice(i+1, v.Len())))
+		if s.try() {
+			continue
+		}
+		v.Set(orig)
+		s.shrinkValue(v.Index(i))
+		i++
+	}
+}
+
+// shrinkString tries the empty string, and then removes ever smaller runs of characters from the
+// string, keeping each removal for which keep still holds.
+func (s *shrinker) shrinkString(orig string, set func(string)) {
+	if orig == "" {
+		return
+	}
+	set("")
+	if s.try() {
+		return
+	}
+	runes := []rune(orig)
+	for chunk := len(runes) / 2; chunk >= 1; chunk /= 2 {
+		for start := 0; start+chunk <= len(runes); {
+			candidate := append(append([]rune{}, runes[:start]...), runes[start+chunk:]...)
+			set(string(candidate))
+			if s.try() {
+				runes = candidate
+			} else {
+				start += chunk
+			}
+		}
+	}
+	set(string(runes))
+}
+
+// copyVariables returns a deep copy of the attributes of the variables, along with the record of
+// the attributes which were set or unset, without the values precomputed by SafeVariables.
+func copyVariables(v *Variables) *Variables {
+	c := copyValue(reflect.ValueOf(v)).Interface().(*Variables)
+	c.present = v.present
+	c.unset = append([]string(nil), v.unset...)
+	return c
+}
+
+// copyValue returns a deep copy of the exported fields of the value.
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyValue(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				c.Field(i).Set(copyValue(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for it := v.MapRange(); it.Next(); {
+			c.SetMapIndex(it.Key(), it.Value())
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		return reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()), v)
+	}
+	return v
+}
+
+// Reproducer is a request of a corpus reduced by ShrinkOutcome to the attributes which determine
+// the outcome of a rule.
+type Reproducer struct {
+	// Line is the line of the original request within the corpus.
+	Line int `json:"line"`
+	// Outcome is the outcome of the rule for the request, e.g. ReplayEvalError.
+	Outcome string `json:"outcome"`
+	// When is the reduced request, as formatted by FormatVariables.
+	When string `json:"when"`
+}
+
+// newReproducer returns the reproducer of the outcome of the program for a request of a corpus.
+func newReproducer(prg cel.Program, vars *Variables, line int, outcome string) *Reproducer {
+	return &Reproducer{Line: line, Outcome: outcome, When: FormatVariables(ShrinkOutcome(prg, vars))}
+}
+
+// FormatVariables returns the attributes of the variables which are not zero values as a single
+// line of YAML in flow style, e.g. {request: {path: /admin}}, which can be pasted into the when
+// block of a test case.
+func FormatVariables(v *Variables) string {
+	var node yaml.Node
+	val := nonZeroValue(reflect.ValueOf(v))
+	if val == nil {
+		return "{}"
+	}
+	if err := node.Encode(val); err != nil {
+		return err.Error()
+	}
+	node.Style = yaml.FlowStyle
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		return err.Error()
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// nonZeroValue converts the value to maps keyed by the YAML names of its fields, omitting zero
+// values and empty structs, maps, and slices, or returns nil if nothing remains.
+func nonZeroValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return nonZeroValue(v.Elem())
+	case reflect.Struct:
+		m := map[string]any{}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			if val := nonZeroValue(v.Field(i)); val != nil {
+				m[name] = val
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	case reflect.Map, reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		return v.Interface()
+	}
+	if v.IsZero() {
+		return nil
+	}
+	return v.Interface()
+}
@@ -0,0 +1,212 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+)
+
+// TestCaseReader reads the test cases of a streamed test suite one at a time, so that suites
+// with tens of thousands of cases, such as those generated from traffic, can be run without
+// loading the entire file into memory.
+//
+// A stream begins with a header holding the name, expr, strict_vars, and budget fields of the suite,
+// followed by one test case per entry. Entries are either YAML documents separated by `---`, or
+// JSON objects with one object per line.
+type TestCaseReader struct {
+	// Suite is the header of the stream. Its Tests are always empty.
+	Suite *TestSuite
+
+	o    *yamlOptions
+	next func() (node *yaml.Node, line int, err error)
+}
+
+// NewTestCaseReader reads the header of a streamed test suite, detecting from the first
+// character of the stream whether it is newline-delimited JSON or a sequence of YAML documents.
+//
+// The return value is the reader or an error if the header is invalid.
+func NewTestCaseReader(r io.Reader, opts ...YAMLOption) (*TestCaseReader, error) {
+	br := bufio.NewReader(r)
+	tr := &TestCaseReader{o: newYAMLOptions(opts)}
+	if isJSONStream(br) {
+		tr.next = jsonLines(br)
+	} else {
+		tr.next = yamlDocuments(br)
+	}
+	node, line, err := tr.next()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("test stream is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tr.o.strict {
+		if err := checkNode(node, &TestSuite{}, testSuiteVariables...); err != nil {
+			return nil, fmt.Errorf("header at line %d: %w", line, err)
+		}
+	}
+	tr.Suite = &TestSuite{}
+	if err := node.Decode(tr.Suite); err != nil {
+		return nil, fmt.Errorf("header at line %d: %w", line, err)
+	}
+	if len(tr.Suite.Tests) != 0 {
+		return nil, fmt.Errorf("header at line %d: tests must follow the header, one per entry", line)
+	}
+	if tr.Suite.Options != nil && tr.Suite.Options.Strict && !tr.o.strict {
+		if err := checkNode(node, &TestSuite{}, testSuiteVariables...); err != nil {
+			return nil, fmt.Errorf("header at line %d: %w", line, err)
+		}
+		tr.o.strict = true
+	}
+	if err := tr.Suite.checkSetup(); err != nil {
+		return nil, fmt.Errorf("header at line %d: %w", line, err)
+	}
+	return tr, nil
+}
+
+// Next returns the next test case of the stream, initialized in the same manner as the test
+// cases of TestSuiteFromYAML.
+//
+// The return value is io.EOF once all of the test cases have been read.
+func (tr *TestCaseReader) Next() (*TestCase, error) {
+	node, line, err := tr.next()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tr.o.strict:
+		err = checkNode(node, &TestCase{}, testCaseVariables...)
+	case tr.Suite.StrictVars:
+		err = checkVariables(node, testCaseVariables...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("test case at line %d: %w", line, err)
+	}
+	t := &TestCase{}
+	if err := node.Decode(t); err != nil {
+		return nil, fmt.Errorf("test case at line %d: %w", line, err)
+	}
+	if err := tr.o.validateTestCase(t); err != nil {
+		return nil, err
+	}
+	tr.Suite.applyBudgets(t)
+	return SafeTestCase(t), nil
+}
+
+// RunStreamValidation runs each test case read from the stream against an expression, calling
+// report with the status of each case as it completes. Statuses are not retained, so memory use
+// is bounded by the largest test case rather than by the size of the suite. Test cases which
+// are not selected by the TestTags option are skipped.
+//
+// The return value is an error if a test case cannot be read; the cases before it have
+// already been reported.
+func (r *Rules) RunStreamValidation(prg cel.Program, tr *TestCaseReader, report func(TestStatus)) error {
+	rn := r.NewRunner()
+	rn.OnCaseDone = func(_ string, s TestStatus) { report(s) }
+	return rn.RunStream(context.Background(), prg, tr)
+}
+
+// isJSONStream reports whether the first non-whitespace character of the stream opens a JSON
+// object.
+func isJSONStream(br *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if len(b) < n {
+			return false
+		}
+		switch c := b[n-1]; c {
+		case ' ', '\t', '\r', '\n':
+			if err != nil {
+				return false
+			}
+		default:
+			return c == '{'
+		}
+	}
+}
+
+// jsonLines returns the entries of a newline-delimited JSON stream, skipping blank lines.
+func jsonLines(br *bufio.Reader) func() (*yaml.Node, int, error) {
+	line := 0
+	return func() (*yaml.Node, int, error) {
+		for {
+			b, err := br.ReadBytes('\n')
+			if len(b) == 0 && err != nil {
+				return nil, line, err
+			}
+			line++
+			if len(bytes.TrimSpace(b)) == 0 {
+				continue
+			}
+			node := &yaml.Node{}
+			if err := yaml.Unmarshal(b, node); err != nil {
+				return nil, line, fmt.Errorf("line %d: %w", line, err)
+			}
+			shiftLines(node, line-1)
+			return node, line, nil
+		}
+	}
+}
+
+// yamlDocuments returns the documents of a YAML stream, skipping empty documents.
+func yamlDocuments(br *bufio.Reader) func() (*yaml.Node, int, error) {
+	dec := yaml.NewDecoder(br)
+	return func() (*yaml.Node, int, error) {
+		for {
+			node := &yaml.Node{}
+			if err := dec.Decode(node); err != nil {
+				return nil, 0, err
+			}
+			if len(node.Content) == 0 || node.Content[0].ShortTag() == "!!null" {
+				continue
+			}
+			return node, node.Content[0].Line, nil
+		}
+	}
+}
+
+// shiftLines offsets the line of the node and its descendants, so that errors decoding an entry
+// of a JSON stream report its line within the stream.
+func shiftLines(node *yaml.Node, offset int) {
+	node.Line += offset
+	for _, n := range node.Content {
+		shiftLines(n, offset)
+	}
+}
+
+// checkNode decodes the node into the schema with known-field validation, and then validates the
+// Variables mappings found at path. The node is re-encoded after padding lines so that errors
+// report lines within the stream.
+func checkNode(node *yaml.Node, schema any, path ...string) error {
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	if len(node.Content) != 0 && node.Content[0].Line > 1 {
+		b = append(bytes.Repeat([]byte{'\n'}, node.Content[0].Line-1), b...)
+	}
+	if err := checkSchema(b, schema); err != nil || len(path) == 0 {
+		return err
+	}
+	return checkVariables(node, path...)
+}
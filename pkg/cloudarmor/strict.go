@@ -0,0 +1,131 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLOption configures the decoding of YAML documents such as test suites and variables.
+type YAMLOption func(*yamlOptions)
+
+type yamlOptions struct {
+	strict        bool
+	validate      bool
+	strictHeaders bool
+	normalization Normalization
+}
+
+func newYAMLOptions(opts []YAMLOption) *yamlOptions {
+	o := &yamlOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// StrictYAML rejects documents containing unknown fields, duplicate keys, or values whose type
+// does not match the schema, reporting the line of the first offending entry.
+func StrictYAML() YAMLOption {
+	return func(o *yamlOptions) {
+		o.strict = true
+	}
+}
+
+// ValidateVariables rejects variables and test cases whose values fail Variables.Validate, such
+// as an unparseable origin.ip or a region code which is not ISO 3166-1 alpha-2.
+func ValidateVariables() YAMLOption {
+	return func(o *yamlOptions) {
+		o.validate = true
+	}
+}
+
+// plainVariables is the Variables type without its yaml.Unmarshaler implementation.
+//
+// The yaml package does not carry known-field validation into custom unmarshalers, so the
+// Variables mappings within a document are validated by decoding them into this type.
+type plainVariables Variables
+
+// The paths of the Variables mappings within each type of document. Sequences along a path are
+// traversed implicitly.
+var (
+	testCaseVariables   = []string{"when"}
+	testSuiteVariables  = []string{"tests", "when"}
+	ruleBundleVariables = []string{"rules", "tests", "when"}
+)
+
+// checkSchema decodes the document into schema, a pointer to a new value of the document type,
+// with known-field validation, and then validates the Variables mappings found at path.
+func checkSchema(yamlBytes []byte, schema any, path ...string) error {
+	dec := yaml.NewDecoder(bytes.NewReader(yamlBytes))
+	dec.KnownFields(true)
+	if err := dec.Decode(schema); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if len(path) == 0 {
+		return nil
+	}
+	return checkVariablesYAML(yamlBytes, path...)
+}
+
+// checkVariablesYAML validates only the Variables mappings found at path within the document.
+func checkVariablesYAML(yamlBytes []byte, path ...string) error {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(yamlBytes, doc); err != nil {
+		return err
+	}
+	return checkVariables(doc, path...)
+}
+
+// checkVariables validates the Variables mappings found by following path from the node against
+// the Variables schema.
+func checkVariables(node *yaml.Node, path ...string) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			if err := checkVariables(n, path...); err != nil {
+				return err
+			}
+		}
+	case yaml.AliasNode:
+		return checkVariables(node.Alias, path...)
+	case yaml.SequenceNode:
+		if len(path) == 0 {
+			return nil
+		}
+		for _, n := range node.Content {
+			if err := checkVariables(n, path...); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if len(path) == 0 {
+			return checkNode(node, &plainVariables{})
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value != path[0] {
+				continue
+			}
+			if err := checkVariables(node.Content[i+1], path[1:]...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// SuiteOptions configures the environment in which the test cases of a suite run, so that a
+// suite is self-describing rather than relying on matching flags or options.
+type SuiteOptions struct {
+	// Strict decodes the suite as under the StrictYAML option, rejecting unknown fields,
+	// duplicate keys, and mistyped values.
+	Strict bool `yaml:"strict"`
+	// Features enables VNext features within VCurrent, as WithFeature does.
+	Features []Feature `yaml:"features"`
+	// Limits overrides the limits enforced by WithLimits. Limits which are omitted, or zero,
+	// keep their DefaultLimits values.
+	Limits *Limits `yaml:"limits"`
+}
+
+// ParseVersion returns the version with the given name, VCurrent or VNext. The empty string is
+// treated as VCurrent.
+func ParseVersion(name string) (uint32, error) {
+	switch name {
+	case "", "VCurrent":
+		return VCurrent, nil
+	case "VNext":
+		return VNext, nil
+	default:
+		return 0, fmt.Errorf("unsupported version: %q, must be VCurrent or VNext", name)
+	}
+}
+
+// HasSetup reports whether the suite declares a version or options, which NewRulesForSuite
+// applies.
+func (ts *TestSuite) HasSetup() bool {
+	return ts.Version != "" || ts.Options != nil
+}
+
+// RulesOptions returns the options which configure Rules as declared by the version and options
+// of the suite.
+func (ts *TestSuite) RulesOptions() ([]RulesOption, error) {
+	var opts []RulesOption
+	if ts.Version != "" {
+		version, err := ParseVersion(ts.Version)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, Version(version))
+	}
+	if ts.Options == nil {
+		return opts, nil
+	}
+	for _, f := range ts.Options.Features {
+		if _, err := ParseFeature(string(f)); err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithFeature(f))
+	}
+	if l := ts.Options.Limits; l != nil {
+		limits := DefaultLimits()
+		if l.MaxExpressionLength != 0 {
+			limits.MaxExpressionLength = l.MaxExpressionLength
+		}
+		if l.MaxBodySize != 0 {
+			limits.MaxBodySize = l.MaxBodySize
+		}
+		limits.CostLimit = l.CostLimit
+		limits.MaxStringSize = l.MaxStringSize
+		if limits.MaxExpressionLength < 0 || limits.MaxStringSize < 0 || limits.MaxBodySize < 0 {
+			return nil, fmt.Errorf("invalid limits: %+v", *l)
+		}
+		opts = append(opts, WithLimits(limits))
+	}
+	return opts, nil
+}
+
+// checkSetup validates the version and options of the suite when it is loaded.
+func (ts *TestSuite) checkSetup() error {
+	if _, err := ts.RulesOptions(); err != nil {
+		return fmt.Errorf("suite %q: %w", ts.Name, err)
+	}
+	return nil
+}
+
+// NewRulesForSuite returns Rules configured by the given options and then by the version and
+// options of the suite, which take precedence over the given options.
+func NewRulesForSuite(ts *TestSuite, opts ...RulesOption) (*Rules, error) {
+	suiteOpts, err := ts.RulesOptions()
+	if err != nil {
+		return nil, err
+	}
+	return NewRules(append(append([]RulesOption{}, opts...), suiteOpts...)...)
+}
+
+// RunTestSuite compiles the expression of the suite within Rules configured as by
+// NewRulesForSuite, and runs its test cases as RunRuleValidation does. Programs track their cost,
+// so that max_cost budgets are enforced.
+//
+// The return value is an error if the environment cannot be created, the expression fails to
+// compile, or the suite has a Policy, whose path is resolved by the caller, see
+// RunPolicyValidation.
+func RunTestSuite(ts *TestSuite, opts ...RulesOption) ([]TestStatus, error) {
+	if ts.Policy != "" {
+		return nil, fmt.Errorf("suite %q has a policy, see RunPolicyValidation", ts.Name)
+	}
+	r, err := NewRulesForSuite(ts, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ast, err := r.Compile(ts.Expr)
+	if err != nil {
+		return nil, err
+	}
+	prg, err := r.Program(ast, cel.CostTracking(nil))
+	if err != nil {
+		return nil, err
+	}
+	return r.RunRuleValidation(prg, ts.Tests), nil
+}
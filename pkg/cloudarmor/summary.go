@@ -0,0 +1,363 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/parser"
+)
+
+// attributeDescriptions maps attributes to the names used for them within summaries.
+var attributeDescriptions = map[string]string{
+	"request.method":                        "method",
+	"request.headers":                       "headers",
+	"request.path":                          "path",
+	"request.query":                         "query",
+	"request.scheme":                        "scheme",
+	"request.params":                        "params",
+	"request.body":                          "body",
+	"request.backend_service":               "backend service",
+	"request.matched_url_map":               "URL map",
+	"origin.ip":                             "client IP",
+	"origin.region_code":                    "client region",
+	"origin.asn":                            "client ASN",
+	"origin.user_ip":                        "user IP",
+	"origin.tls_ja3_fingerprint":            "JA3 fingerprint",
+	"origin.tls_ja4_fingerprint":            "JA4 fingerprint",
+	"token.recaptcha_exemption.valid":       "reCAPTCHA exemption validity",
+	"token.recaptcha_action.score":          "reCAPTCHA action score",
+	"token.recaptcha_action.captcha_status": "reCAPTCHA action captcha status",
+	"token.recaptcha_action.action":         "reCAPTCHA action name",
+	"token.recaptcha_action.valid":          "reCAPTCHA action validity",
+	"token.recaptcha_session.score":         "reCAPTCHA session score",
+	"token.recaptcha_session.valid":         "reCAPTCHA session validity",
+	"connection.src_port":                   "source port",
+	"connection.dst_ip":                     "destination IP",
+	"connection.dst_port":                   "destination port",
+	"connection.protocol":                   "transport protocol",
+	"connection.bytes":                      "packet size",
+	"response.status_code":                  "response status code",
+	"response.headers":                      "response headers",
+	"adaptive_protection.attack_likelihood": "Adaptive Protection attack likelihood (preview)",
+	"adaptive_protection.attack_signatures": "Adaptive Protection attack signatures (preview)",
+}
+
+// transformationDescriptions maps the string transformation functions to the adjective used to
+// describe their result.
+var transformationDescriptions = map[string]string{
+	"lower":         "lowercased",
+	"upper":         "uppercased",
+	"base64Decode":  "base64-decoded",
+	"urlDecode":     "URL-decoded",
+	"urlDecodeUni":  "Unicode URL-decoded",
+	"utf8ToUnicode": "Unicode-escaped",
+	"toPunycode":    "Punycode-encoded",
+	"fromPunycode":  "Punycode-decoded",
+}
+
+// comparisonDescriptions maps the comparison operators to their English phrasing.
+var comparisonDescriptions = map[string]string{
+	operators.Equals:        "is",
+	operators.NotEquals:     "is not",
+	operators.Less:          "is less than",
+	operators.LessEquals:    "is at most",
+	operators.Greater:       "is greater than",
+	operators.GreaterEquals: "is at least",
+}
+
+// matchDescriptions maps the string matching functions to their English phrasing.
+var matchDescriptions = map[string]string{
+	"startsWith": "starts with",
+	"endsWith":   "ends with",
+	"contains":   "contains",
+	"matches":    "matches",
+}
+
+// Summarize renders the compiled rule as a structured English description intended for
+// reviewers who are not fluent in CEL, e.g.
+//
+//	Matches when client IP is in 2 ranges (192.0.2.0/24, 198.51.100.0/24) AND path starts with "/admin"
+//
+// Constructs without a dedicated description are rendered as CEL within backticks.
+func Summarize(a *cel.Ast, opts ...SummaryOption) string {
+	s := &summarizer{info: a.NativeRep().SourceInfo()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return "Matches when " + s.describe(a.NativeRep().Expr())
+}
+
+// SummaryOption configures Summarize.
+type SummaryOption func(*summarizer)
+
+// SummaryASNs describes the numbers compared with origin.asn with the names of their
+// organizations from the directory, e.g. "client ASN is AS15169 (Google LLC)".
+func SummaryASNs(d ASNDirectory) SummaryOption {
+	return func(s *summarizer) {
+		s.asns = d
+	}
+}
+
+type summarizer struct {
+	info *ast.SourceInfo
+	asns ASNDirectory
+}
+
+func (s *summarizer) describe(e ast.Expr) string {
+	switch e.Kind() {
+	case ast.LiteralKind:
+		if e.AsLiteral() == types.True {
+			return "always"
+		}
+		if e.AsLiteral() == types.False {
+			return "never"
+		}
+	case ast.IdentKind:
+		if attr, found := strings.CutPrefix(e.AsIdent(), presencePrefix); found {
+			return s.attribute(attr) + " is present"
+		}
+		return s.attribute(e.AsIdent()) + " is true"
+	case ast.SelectKind:
+		if sel := e.AsSelect(); sel.IsTestOnly() {
+			return fmt.Sprintf("%s %q is present", s.subject(sel.Operand()), sel.FieldName())
+		}
+	case ast.CallKind:
+		return s.describeCall(e)
+	case ast.ComprehensionKind:
+		if isBind(e) {
+			comp := e.AsComprehension()
+			return fmt.Sprintf("%s, where %s is %s", s.describe(comp.Result()), comp.AccuVar(), s.subject(comp.AccuInit()))
+		}
+		// Macro calls are not retained by the compiler, so the comprehension cannot be unparsed.
+		return "a condition holds over the entries of " + s.subject(e.AsComprehension().IterRange())
+	}
+	return s.unparse(e)
+}
+
+func (s *summarizer) describeCall(e ast.Expr) string {
+	call := e.AsCall()
+	fn := call.FunctionName()
+	args := call.Args()
+	switch fn {
+	case operators.LogicalAnd:
+		return s.describeLogical(e, operators.LogicalAnd, " AND ")
+	case operators.LogicalOr:
+		return s.describeLogical(e, operators.LogicalOr, " OR ")
+	case operators.LogicalNot:
+		return "NOT " + s.describeOperand(args[0])
+	case "inIpRange":
+		if len(args) == 2 {
+			return fmt.Sprintf("%s is in range %s", s.subject(args[0]), s.ipRange(args[1]))
+		}
+	case "inRange":
+		if len(args) == 3 {
+			return fmt.Sprintf("%s is between %s and %s", s.subject(args[0]), s.valueOf(args[0], args[1]), s.valueOf(args[0], args[2]))
+		}
+	}
+	if phrase, found := comparisonDescriptions[fn]; found && len(args) == 2 {
+		return fmt.Sprintf("%s %s %s", s.subject(args[0]), phrase, s.valueOf(args[0], args[1]))
+	}
+	if phrase, found := matchDescriptions[fn]; found && call.IsMemberFunction() && len(args) == 1 {
+		return fmt.Sprintf("%s %s %s", s.subject(call.Target()), phrase, s.value(args[0]))
+	}
+	return s.unparse(e)
+}
+
+// describeLogical describes a chain of the same logical operator, collapsing disjunctions of
+// comparisons against a single subject into one clause.
+func (s *summarizer) describeLogical(e ast.Expr, op, sep string) string {
+	type clause struct {
+		text            string
+		subject, phrase string
+		vals            []string
+	}
+	var clauses []*clause
+	groups := map[string]*clause{}
+	for _, o := range flattenLogical(e, op) {
+		if op == operators.LogicalOr {
+			if subject, val, phrase, ok := s.collapsible(o); ok {
+				key := subject + "\x00" + phrase
+				if c, found := groups[key]; found {
+					c.vals = append(c.vals, val)
+					continue
+				}
+				c := &clause{subject: subject, phrase: phrase, vals: []string{val}}
+				groups[key] = c
+				clauses = append(clauses, c)
+				continue
+			}
+		}
+		clauses = append(clauses, &clause{text: s.describeOperand(o)})
+	}
+	out := make([]string, len(clauses))
+	for i, c := range clauses {
+		out[i] = c.text
+		if c.vals != nil {
+			out[i] = describeValues(c.subject, c.phrase, c.vals)
+		}
+	}
+	return strings.Join(out, sep)
+}
+
+// collapsible determines whether the expression is an equality or range test of a subject
+// against a literal which may be merged with similar tests within a disjunction.
+func (s *summarizer) collapsible(e ast.Expr) (subject, val, phrase string, ok bool) {
+	if e.Kind() != ast.CallKind {
+		return "", "", "", false
+	}
+	call := e.AsCall()
+	args := call.Args()
+	if len(args) != 2 || args[1].Kind() != ast.LiteralKind {
+		return "", "", "", false
+	}
+	switch call.FunctionName() {
+	case operators.Equals:
+		return s.subject(args[0]), s.valueOf(args[0], args[1]), "is", true
+	case "inIpRange":
+		return s.subject(args[0]), s.ipRange(args[1]), "is in", true
+	}
+	return "", "", "", false
+}
+
+func describeValues(subject, phrase string, vals []string) string {
+	if len(vals) == 1 {
+		if phrase == "is in" {
+			return fmt.Sprintf("%s is in range %s", subject, vals[0])
+		}
+		return fmt.Sprintf("%s %s %s", subject, phrase, vals[0])
+	}
+	if phrase == "is in" {
+		return fmt.Sprintf("%s is in %d ranges (%s)", subject, len(vals), strings.Join(vals, ", "))
+	}
+	return fmt.Sprintf("%s is one of %s", subject, strings.Join(vals, ", "))
+}
+
+// describeOperand describes an operand of a logical operator, parenthesizing nested logical
+// operators so that the grouping of the original expression is preserved.
+func (s *summarizer) describeOperand(e ast.Expr) string {
+	desc := s.describe(e)
+	if e.Kind() == ast.CallKind {
+		switch e.AsCall().FunctionName() {
+		case operators.LogicalAnd, operators.LogicalOr:
+			return "(" + desc + ")"
+		}
+	}
+	return desc
+}
+
+func flattenLogical(e ast.Expr, op string) []ast.Expr {
+	if e.Kind() != ast.CallKind || e.AsCall().FunctionName() != op {
+		return []ast.Expr{e}
+	}
+	var operands []ast.Expr
+	for _, arg := range e.AsCall().Args() {
+		operands = append(operands, flattenLogical(arg, op)...)
+	}
+	return operands
+}
+
+// subject describes an expression which is the subject of a comparison.
+func (s *summarizer) subject(e ast.Expr) string {
+	switch e.Kind() {
+	case ast.IdentKind:
+		return s.attribute(e.AsIdent())
+	case ast.SelectKind:
+		sel := e.AsSelect()
+		if !sel.IsTestOnly() {
+			return fmt.Sprintf("%s %q", s.subject(sel.Operand()), sel.FieldName())
+		}
+	case ast.CallKind:
+		call := e.AsCall()
+		args := call.Args()
+		if adj, found := transformationDescriptions[call.FunctionName()]; found && call.IsMemberFunction() && len(args) == 0 {
+			return adj + " " + s.subject(call.Target())
+		}
+		if call.FunctionName() == operators.Index && len(args) == 2 {
+			return fmt.Sprintf("%s %s", singular(s.subject(args[0])), s.value(args[1]))
+		}
+		if call.FunctionName() == "size" {
+			target := call.Target()
+			if !call.IsMemberFunction() && len(args) == 1 {
+				target = args[0]
+			}
+			if target != nil {
+				return "length of " + s.subject(target)
+			}
+		}
+	}
+	return s.unparse(e)
+}
+
+// value describes an expression which is compared against a subject.
+func (s *summarizer) value(e ast.Expr) string {
+	if e.Kind() != ast.LiteralKind {
+		return s.subject(e)
+	}
+	switch lit := e.AsLiteral().(type) {
+	case types.String:
+		return strconv.Quote(string(lit))
+	default:
+		return fmt.Sprint(lit.Value())
+	}
+}
+
+// valueOf describes an expression which is compared against the subject, naming the
+// organizations of the numbers compared with origin.asn when a directory was given.
+func (s *summarizer) valueOf(subject, e ast.Expr) string {
+	if s.asns != nil && e.Kind() == ast.LiteralKind && isAttribute(subject, "origin", "asn") {
+		if asn, ok := e.AsLiteral().(types.Int); ok {
+			return s.asns.Format(int64(asn))
+		}
+	}
+	return s.value(e)
+}
+
+// ipRange describes a CIDR range, omitting the quotes of string literals.
+func (s *summarizer) ipRange(e ast.Expr) string {
+	if e.Kind() == ast.LiteralKind {
+		if lit, ok := e.AsLiteral().(types.String); ok {
+			return string(lit)
+		}
+	}
+	return s.value(e)
+}
+
+func (s *summarizer) attribute(name string) string {
+	if desc, found := attributeDescriptions[name]; found {
+		return desc
+	}
+	return name
+}
+
+func (s *summarizer) unparse(e ast.Expr) string {
+	str, err := parser.Unparse(e, s.info)
+	if err != nil {
+		return "<expression>"
+	}
+	return "`" + str + "`"
+}
+
+// singular converts the description of a map attribute to the description of one of its
+// entries, e.g. headers to header.
+func singular(desc string) string {
+	return strings.TrimSuffix(desc, "s")
+}
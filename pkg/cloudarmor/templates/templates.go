@@ -0,0 +1,333 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package templates provides parameterized Cloud Armor rule templates for common protections
+// such as geo blocking and reCAPTCHA score thresholds.
+//
+// Rendered templates are compiled against the Cloud Armor environment before being returned,
+// so a successfully rendered expression is always valid for its version.
+package templates
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/ipset"
+)
+
+// ParamKind describes the accepted format of a template parameter.
+type ParamKind int
+
+const (
+	// String is a single string value.
+	String ParamKind = iota
+	// StringList is a comma-separated list of string values.
+	StringList
+	// IntList is a comma-separated list of integer values.
+	IntList
+	// Double is a single floating point value.
+	Double
+)
+
+// String implements the fmt.Stringer interface.
+func (k ParamKind) String() string {
+	switch k {
+	case StringList:
+		return "string list"
+	case IntList:
+		return "int list"
+	case Double:
+		return "double"
+	default:
+		return "string"
+	}
+}
+
+// Param describes a single template parameter.
+type Param struct {
+	Name        string
+	Description string
+	Kind        ParamKind
+	// validate checks a single value of the parameter, or each element of a list parameter.
+	validate func(string) error
+}
+
+// Template is a parameterized Cloud Armor rule.
+type Template struct {
+	Name        string
+	Description string
+	Params      []Param
+	// Version is the minimum environment version the rendered expression requires.
+	Version uint32
+	render  func(args map[string][]string) string
+}
+
+var methodPattern = regexp.MustCompile(`^[A-Z]+$`)
+
+var templates = []*Template{
+	{
+		Name:        "geo-block",
+		Description: "Matches requests originating from any of the given regions.",
+		Params: []Param{{
+			Name:        "regions",
+			Description: "ISO 3166-1 alpha-2 region codes, e.g. AQ,BV",
+			Kind:        StringList,
+			validate:    cloudarmor.CheckRegionCode,
+		}},
+		Version: cloudarmor.VCurrent,
+		render: func(args map[string][]string) string {
+			return anyEqual("origin.region_code", quoteAll(args["regions"]))
+		},
+	},
+	{
+		Name:        "asn-block",
+		Description: "Matches requests originating from any of the given autonomous systems.",
+		Params: []Param{{
+			Name:        "asns",
+			Description: "autonomous system numbers, e.g. 64496,64511",
+			Kind:        IntList,
+			validate:    validASN,
+		}},
+		Version: cloudarmor.VCurrent,
+		render: func(args map[string][]string) string {
+			return anyEqual("origin.asn", args["asns"])
+		},
+	},
+	{
+		Name:        "cidr-block",
+		Description: "Matches requests whose origin IP address falls within any of the given ranges.",
+		Params: []Param{{
+			Name:        "ranges",
+			Description: "IPv4 or IPv6 CIDR ranges, e.g. 192.0.2.0/24,2001:db8::/32",
+			Kind:        StringList,
+			validate:    validCIDR,
+		}},
+		Version: cloudarmor.VCurrent,
+		render: func(args map[string][]string) string {
+			// The ranges are validated, so minimizing them cannot fail.
+			ranges, err := ipset.Minimize(args["ranges"])
+			if err != nil {
+				ranges = args["ranges"]
+			}
+			var terms []string
+			for _, r := range ranges {
+				terms = append(terms, fmt.Sprintf("inIpRange(origin.ip, %s)", quote(r)))
+			}
+			return strings.Join(terms, " || ")
+		},
+	},
+	{
+		Name:        "path-method-guard",
+		Description: "Matches requests beneath a path prefix which use a method other than the allowed ones.",
+		Params: []Param{
+			{
+				Name:        "path_prefix",
+				Description: "path prefix to guard, e.g. /admin",
+				Kind:        String,
+				validate:    validPathPrefix,
+			},
+			{
+				Name:        "methods",
+				Description: "allowed HTTP methods, e.g. GET,HEAD",
+				Kind:        StringList,
+				validate:    matching(methodPattern, "an uppercase HTTP method"),
+			},
+		},
+		Version: cloudarmor.VCurrent,
+		render: func(args map[string][]string) string {
+			return fmt.Sprintf("request.path.startsWith(%s) && !(%s)",
+				quote(args["path_prefix"][0]), anyEqual("request.method", quoteAll(args["methods"])))
+		},
+	},
+	{
+		Name:        "ja4-blocklist",
+		Description: "Matches requests whose TLS JA4 fingerprint is any of the given fingerprints.",
+		Params: []Param{{
+			Name:        "fingerprints",
+			Description: "JA4 fingerprints, e.g. t13d1516h2_8daaf6152771_b186095e22b6",
+			Kind:        StringList,
+			validate:    cloudarmor.CheckJA4Fingerprint,
+		}},
+		Version: cloudarmor.VCurrent,
+		render: func(args map[string][]string) string {
+			return anyEqual("origin.tls_ja4_fingerprint", quoteAll(args["fingerprints"]))
+		},
+	},
+	{
+		Name:        "token-score-threshold",
+		Description: "Matches requests whose reCAPTCHA action token score is below the threshold.",
+		Params: []Param{{
+			Name:        "threshold",
+			Description: "minimum acceptable score between 0.0 and 1.0, e.g. 0.5",
+			Kind:        Double,
+			validate:    validScore,
+		}},
+		Version: cloudarmor.VCurrent,
+		render: func(args map[string][]string) string {
+			return fmt.Sprintf("token.recaptcha_action.score < %s", args["threshold"][0])
+		},
+	},
+}
+
+// List returns the available templates sorted by name.
+func List() []*Template {
+	out := append([]*Template{}, templates...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Lookup returns the template with the given name.
+func Lookup(name string) (*Template, bool) {
+	for _, t := range templates {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Render instantiates the named template with the given parameters, where list parameters are
+// comma-separated.
+//
+// The return value is the rendered expression or an error if the template does not exist, a
+// parameter is missing or invalid, or the rendered expression fails to compile.
+func Render(name string, params map[string]string) (string, error) {
+	t, found := Lookup(name)
+	if !found {
+		return "", fmt.Errorf("unknown template: %q", name)
+	}
+	return t.Render(params)
+}
+
+// Render instantiates the template with the given parameters.
+func (t *Template) Render(params map[string]string) (string, error) {
+	args := map[string][]string{}
+	for _, p := range t.Params {
+		raw, found := params[p.Name]
+		if !found || strings.TrimSpace(raw) == "" {
+			return "", fmt.Errorf("template %s: missing parameter %q", t.Name, p.Name)
+		}
+		vals := []string{strings.TrimSpace(raw)}
+		if p.Kind == StringList || p.Kind == IntList {
+			vals = splitList(raw)
+		}
+		for _, v := range vals {
+			if err := p.validate(v); err != nil {
+				return "", fmt.Errorf("template %s: parameter %q: %w", t.Name, p.Name, err)
+			}
+		}
+		args[p.Name] = vals
+	}
+	for name := range params {
+		if !t.hasParam(name) {
+			return "", fmt.Errorf("template %s: unknown parameter %q", t.Name, name)
+		}
+	}
+	expr := t.render(args)
+	r, err := cloudarmor.NewRules(cloudarmor.Version(t.Version))
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.Compile(expr); err != nil {
+		return "", fmt.Errorf("template %s: rendered expression failed to compile: %w", t.Name, err)
+	}
+	return expr, nil
+}
+
+func (t *Template) hasParam(name string) bool {
+	for _, p := range t.Params {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(raw string) []string {
+	var vals []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// anyEqual returns a disjunction comparing the attribute against each of the literals.
+func anyEqual(attr string, literals []string) string {
+	terms := make([]string, len(literals))
+	for i, lit := range literals {
+		terms[i] = fmt.Sprintf("%s == %s", attr, lit)
+	}
+	return strings.Join(terms, " || ")
+}
+
+func quote(s string) string {
+	return strconv.Quote(s)
+}
+
+func quoteAll(vals []string) []string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = quote(v)
+	}
+	return out
+}
+
+func matching(re *regexp.Regexp, desc string) func(string) error {
+	return func(v string) error {
+		if !re.MatchString(v) {
+			return fmt.Errorf("%q is not %s", v, desc)
+		}
+		return nil
+	}
+}
+
+func validASN(v string) error {
+	asn, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", v)
+	}
+	return cloudarmor.CheckASN(asn)
+}
+
+func validCIDR(v string) error {
+	if _, _, err := net.ParseCIDR(v); err != nil {
+		return fmt.Errorf("%q is not a valid CIDR range", v)
+	}
+	return nil
+}
+
+func validPathPrefix(v string) error {
+	if !strings.HasPrefix(v, "/") {
+		return fmt.Errorf("%q must begin with '/'", v)
+	}
+	return nil
+}
+
+func validScore(v string) error {
+	score, err := strconv.ParseFloat(v, 64)
+	if err != nil || score < 0 || score > 1 {
+		return fmt.Errorf("%q is not a score between 0.0 and 1.0", v)
+	}
+	if !strings.Contains(v, ".") {
+		return fmt.Errorf("%q must be written as a double, e.g. %s.0", v, v)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package templates_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/templates"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		vars   *cloudarmor.Variables
+		want   string
+	}{
+		{
+			name:   "geo-block",
+			params: map[string]string{"regions": "AQ, BV"},
+			vars:   &cloudarmor.Variables{Origin: &cloudarmor.Origin{RegionCode: "BV"}},
+			want:   `origin.region_code == "AQ" || origin.region_code == "BV"`,
+		},
+		{
+			name:   "asn-block",
+			params: map[string]string{"asns": "64496,64511"},
+			vars:   &cloudarmor.Variables{Origin: &cloudarmor.Origin{ASN: 64511}},
+			want:   `origin.asn == 64496 || origin.asn == 64511`,
+		},
+		{
+			name:   "cidr-block",
+			params: map[string]string{"ranges": "192.0.2.0/25,192.0.2.128/25,192.0.2.7/32"},
+			vars:   &cloudarmor.Variables{Origin: &cloudarmor.Origin{IP: "192.0.2.7"}},
+			want:   `inIpRange(origin.ip, "192.0.2.0/24")`,
+		},
+		{
+			name:   "path-method-guard",
+			params: map[string]string{"path_prefix": "/admin", "methods": "GET,HEAD"},
+			vars:   &cloudarmor.Variables{Request: &cloudarmor.Request{Path: "/admin/users", Method: "DELETE"}},
+			want:   `request.path.startsWith("/admin") && !(request.method == "GET" || request.method == "HEAD")`,
+		},
+		{
+			name:   "ja4-blocklist",
+			params: map[string]string{"fingerprints": "t13d1516h2_8daaf6152771_b186095e22b6"},
+			vars: &cloudarmor.Variables{Origin: &cloudarmor.Origin{
+				TLSJA4Fingerprint: "t13d1516h2_8daaf6152771_b186095e22b6",
+			}},
+			want: `origin.tls_ja4_fingerprint == "t13d1516h2_8daaf6152771_b186095e22b6"`,
+		},
+		{
+			name:   "token-score-threshold",
+			params: map[string]string{"threshold": "0.5"},
+			vars: &cloudarmor.Variables{Token: &cloudarmor.Token{
+				RecaptchaAction: &cloudarmor.RecaptchaAction{Score: 0.1},
+			}},
+			want: `token.recaptcha_action.score < 0.5`,
+		},
+	}
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			expr, err := templates.Render(tst.name, tst.params)
+			if err != nil {
+				t.Fatalf("templates.Render() returned error: %v", err)
+			}
+			if expr != tst.want {
+				t.Errorf("templates.Render() = %q, wanted %q", expr, tst.want)
+			}
+			ast, err := rules.Compile(expr)
+			if err != nil {
+				t.Fatalf("rules.Compile() returned error: %v", err)
+			}
+			prg, err := rules.Program(ast)
+			if err != nil {
+				t.Fatalf("rules.Program() returned error: %v", err)
+			}
+			out, _, err := prg.Eval(cloudarmor.SafeVariables(tst.vars))
+			if err != nil || out.Value() != true {
+				t.Errorf("prg.Eval() = %v, %v, wanted true", out, err)
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		wantErr string
+	}{
+		{name: "no-such-template", wantErr: "unknown template"},
+		{name: "geo-block", wantErr: `missing parameter "regions"`},
+		{name: "geo-block", params: map[string]string{"regions": "usa"}, wantErr: "not an ISO 3166-1 alpha-2 region code"},
+		{name: "geo-block", params: map[string]string{"regions": "UK"}, wantErr: `did you mean "GB"`},
+		{name: "geo-block", params: map[string]string{"regions": "US", "asns": "1"}, wantErr: `unknown parameter "asns"`},
+		{name: "asn-block", params: map[string]string{"asns": "-1"}, wantErr: "-1 is outside the range 0 to 4294967295"},
+		{name: "asn-block", params: map[string]string{"asns": "AS64496"}, wantErr: `"AS64496" is not an integer`},
+		{name: "cidr-block", params: map[string]string{"ranges": "192.0.2.0"}, wantErr: "not a valid CIDR range"},
+		{name: "path-method-guard", params: map[string]string{"path_prefix": "admin", "methods": "GET"}, wantErr: "must begin with '/'"},
+		{name: "ja4-blocklist", params: map[string]string{"fingerprints": "abc"}, wantErr: "not a JA4 fingerprint"},
+		{name: "token-score-threshold", params: map[string]string{"threshold": "1"}, wantErr: "must be written as a double"},
+		{name: "token-score-threshold", params: map[string]string{"threshold": "2.0"}, wantErr: "not a score between"},
+	}
+	for _, tst := range tests {
+		_, err := templates.Render(tst.name, tst.params)
+		if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+			t.Errorf("templates.Render(%q, %v) returned error %v, wanted error containing %q", tst.name, tst.params, err, tst.wantErr)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	list := templates.List()
+	if len(list) == 0 {
+		t.Fatal("templates.List() returned no templates")
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Name >= list[i].Name {
+			t.Errorf("templates.List() is not sorted: %q before %q", list[i-1].Name, list[i].Name)
+		}
+	}
+}
@@ -16,15 +16,38 @@ package cloudarmor
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // TestSuite represents a set of tests for a Cloud Armor rule expression.
 type TestSuite struct {
-	Name  string      `yaml:"name"`
-	Expr  string      `yaml:"expr"`
-	Tests []*TestCase `yaml:"tests"`
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+	// Version is the version, VCurrent or VNext, in which the suite runs, see NewRulesForSuite.
+	Version string `yaml:"version"`
+	// Options configures the environment in which the suite runs, see NewRulesForSuite.
+	Options *SuiteOptions `yaml:"options"`
+	// StrictVars rejects test cases whose when block contains keys which are not recognized
+	// by the Variables schema, such as a misspelled `requst:`.
+	StrictVars bool `yaml:"strict_vars"`
+	// MaxCost and MaxLatencyMs are the performance budgets of test cases which do not declare
+	// their own.
+	MaxCost      uint64  `yaml:"max_cost"`
+	MaxLatencyMs float64 `yaml:"max_latency_ms"`
+	// MaxMatchRate is the largest fraction of the known-good requests within Corpus which the
+	// expression may match, see EvaluateCorpus and CheckMatchRate.
+	MaxMatchRate *MatchRate `yaml:"max_match_rate"`
+	// Corpus is the path of the known-good traffic, relative to the suite file.
+	Corpus string `yaml:"corpus"`
+	// Policy is the path of a security policy, relative to the suite file, against which the
+	// test cases are evaluated instead of Expr, asserting on the action and inserted headers of
+	// the rule which matches, see RunPolicyValidation. The CLI reads files ending in .yaml or
+	// .yml with SecurityPolicyFromYAML, and others as JSON exports.
+	Policy string      `yaml:"policy"`
+	Tests  []*TestCase `yaml:"tests"`
 }
 
 // TestCase represents a single test case for a Cloud Armor rule expression.
@@ -33,6 +56,24 @@ type TestCase struct {
 	When         *Variables `yaml:"when"`
 	ExpectOutput bool       `yaml:"expect"`
 	ExpectError  string     `yaml:"error"`
+	// ExpectErrorCode expects the evaluation to fail with an error of the given code, see
+	// ErrorCodeOf, which unlike the substring of ExpectError is stable across changes to error
+	// messages. When both are set, the error must match both.
+	ExpectErrorCode ErrorCode `yaml:"error_code"`
+	// Tags label the test case, e.g. smoke or regression, so that subsets of a suite can be
+	// selected with the TestTags option.
+	Tags []string `yaml:"tags"`
+	// MaxCost fails the test case when the actual cost of its evaluation exceeds the budget. The
+	// program must be created with cel.CostTracking for the cost to be measured.
+	MaxCost uint64 `yaml:"max_cost"`
+	// MaxLatencyMs fails the test case when its evaluation takes longer than the budget in
+	// milliseconds.
+	MaxLatencyMs float64 `yaml:"max_latency_ms"`
+	// ExpectAction and ExpectHeaders are the expectations of a test case of a suite with a
+	// Policy: the action of the rule which matches, e.g. deny(403), and the request headers
+	// which it inserts into the forwarded request, by header name.
+	ExpectAction  string            `yaml:"expect_action"`
+	ExpectHeaders map[string]string `yaml:"expect_headers"`
 }
 
 // TestStatus represents the result of a single test case.
@@ -40,6 +81,83 @@ type TestStatus struct {
 	Name string
 	Pass bool
 	Fail string
+	// Expected is the expectation of the test case: the bool result it expects, the ErrorCode of
+	// the error it expects, or the substring of the error message it expects as a string. For
+	// the test cases of a policy, it is the expected action or the expected headers.
+	Expected any
+	// Actual is the result of the evaluation as a Go value, e.g. true, or the message of its error
+	// as a string if the evaluation failed. For the test cases of a policy, it is the action or
+	// the inserted headers.
+	Actual any
+	// Err is the error returned by the evaluation when the test case failed because evaluation
+	// failed unexpectedly, rather than because of a mismatched result.
+	Err error
+	// Duration is the time taken by the evaluation.
+	Duration time.Duration
+	// CaseIndex is the position of the test case within its suite or stream, starting at 0 and
+	// counting the test cases which are not selected by the TestTags option.
+	CaseIndex int
+	// Trace contains the values of the subexpressions of the rule when the test case failed,
+	// if the Rules were created with ExplainFailures.
+	Trace []TraceStep
+}
+
+// TestTags selects the test cases which are run by RunRuleValidation, RunStreamValidation, and
+// RunBundle to those tagged with at least one of the given tags. Empty tags are ignored, and
+// when no tags are given every test case is run.
+func TestTags(tags ...string) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		for _, tag := range tags {
+			if tag = strings.TrimSpace(tag); tag == "" {
+				continue
+			}
+			if r.testTags == nil {
+				r.testTags = map[string]bool{}
+			}
+			r.testTags[tag] = true
+		}
+		return r, nil
+	}
+}
+
+// selected reports whether the test case is run under the TestTags option.
+func (r *Rules) selected(t *TestCase) bool {
+	if r.testTags == nil {
+		return true
+	}
+	for _, tag := range t.Tags {
+		if r.testTags[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// applyBudgets sets the suite's performance budgets on a test case which does not declare its own.
+func (ts *TestSuite) applyBudgets(t *TestCase) {
+	if t.MaxCost == 0 {
+		t.MaxCost = ts.MaxCost
+	}
+	if t.MaxLatencyMs == 0 {
+		t.MaxLatencyMs = ts.MaxLatencyMs
+	}
+}
+
+// checkPolicy checks that a suite with a Policy has neither an expression nor expectations of
+// one, and that only the test cases of such a suite have expectations of a policy.
+func (ts *TestSuite) checkPolicy() error {
+	if ts.Policy != "" && (ts.Expr != "" || ts.Corpus != "") {
+		return fmt.Errorf("suite %q: policy may not be combined with expr or corpus", ts.Name)
+	}
+	for _, t := range ts.Tests {
+		switch {
+		case ts.Policy == "" && (t.ExpectAction != "" || t.ExpectHeaders != nil):
+			return fmt.Errorf("test case %q: expect_action and expect_headers require a suite policy", t.Name)
+		case ts.Policy != "" && (t.ExpectOutput || t.ExpectError != "" || t.ExpectErrorCode != ""):
+			return fmt.Errorf("test case %q: expect, error, and error_code are not supported with a suite policy, use expect_action", t.Name)
+		}
+	}
+	return nil
 }
 
 // SafeTestCase ensures that all of the variables are initialized to their default values.
@@ -56,15 +174,41 @@ func SafeTestCase(t *TestCase) *TestCase {
 // The YAML representation is expected to be a map of test suite name to a list of test cases.
 //
 // The return value is the TestSuite type or an error if the YAML is invalid.
-func TestSuiteFromYAML(yamlBytes []byte) (*TestSuite, error) {
+func TestSuiteFromYAML(yamlBytes []byte, opts ...YAMLOption) (*TestSuite, error) {
+	o := newYAMLOptions(opts)
+	if o.strict {
+		if err := checkSchema(yamlBytes, &TestSuite{}, testSuiteVariables...); err != nil {
+			return nil, err
+		}
+	}
 	ts := &TestSuite{}
 	if err := yaml.Unmarshal(yamlBytes, &ts); err != nil {
 		return nil, err
 	}
+	if ts.Options != nil && ts.Options.Strict && !o.strict {
+		if err := checkSchema(yamlBytes, &TestSuite{}, testSuiteVariables...); err != nil {
+			return nil, err
+		}
+	}
+	if err := ts.checkSetup(); err != nil {
+		return nil, err
+	}
+	if (ts.MaxMatchRate == nil) != (ts.Corpus == "") {
+		return nil, fmt.Errorf("max_match_rate and corpus must be set together")
+	}
+	if err := ts.checkPolicy(); err != nil {
+		return nil, err
+	}
+	if ts.StrictVars && !o.strict {
+		if err := checkVariablesYAML(yamlBytes, testSuiteVariables...); err != nil {
+			return nil, fmt.Errorf("strict_vars: %w", err)
+		}
+	}
 	for i, t := range ts.Tests {
-		if t.ExpectOutput && t.ExpectError != "" {
-			return nil, fmt.Errorf("test case %q has both expect and error", t.Name)
+		if err := o.validateTestCase(t); err != nil {
+			return nil, err
 		}
+		ts.applyBudgets(t)
 		ts.Tests[i] = SafeTestCase(t)
 	}
 	return ts, nil
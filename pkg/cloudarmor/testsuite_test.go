@@ -15,10 +15,16 @@
 package cloudarmor_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+
+	"github.com/google/cel-go/cel"
 )
 
 func TestTestSuiteFromYAML(t *testing.T) {
@@ -38,3 +44,715 @@ func TestTestSuiteFromYAML(t *testing.T) {
 	}
 	t.Logf("ts.Tests[0]: %+v", ts.Tests[0])
 }
+
+func TestTestSuiteFromYAMLContradictoryExpectations(t *testing.T) {
+	for _, tst := range []struct {
+		expectation string
+		wantErr     string
+	}{
+		{expectation: "error: boom", wantErr: `test case "t" has both expect and error`},
+		{expectation: "error_code: internal", wantErr: `test case "t" has both expect and error_code`},
+	} {
+		suite := "name: s\nexpr: 'true'\ntests:\n  - name: t\n    expect: true\n    " + tst.expectation + "\n"
+		if _, err := cloudarmor.TestSuiteFromYAML([]byte(suite)); err == nil || err.Error() != tst.wantErr {
+			t.Errorf("cloudarmor.TestSuiteFromYAML() returned error %v, wanted %q", err, tst.wantErr)
+		}
+	}
+}
+
+func TestTestSuiteFromYAMLStrictVars(t *testing.T) {
+	suite := `
+name: strict
+expr: request.method == 'GET'
+strict_vars: %s
+tests:
+  - name: typo
+    expect: true
+    when:
+      request:
+        method: GET
+      requst:
+        path: /
+`
+	if _, err := cloudarmor.TestSuiteFromYAML([]byte(fmt.Sprintf(suite, "false"))); err != nil {
+		t.Errorf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	_, err := cloudarmor.TestSuiteFromYAML([]byte(fmt.Sprintf(suite, "true")))
+	if err == nil || !strings.Contains(err.Error(), "line 11: field requst not found") {
+		t.Errorf("cloudarmor.TestSuiteFromYAML() returned error %v, wanted unknown field requst on line 11", err)
+	}
+}
+
+func TestTestSuiteFromYAMLStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		suite   string
+		wantErr string
+	}{
+		{
+			name:    "unknown field",
+			suite:   "name: strict\nexpct: true\n",
+			wantErr: "line 2: field expct not found",
+		},
+		{
+			name:    "unknown variable",
+			suite:   "name: strict\ntests:\n  - name: t\n    when:\n      origin:\n        ipp: 1.2.3.4\n",
+			wantErr: "line 6: field ipp not found",
+		},
+		{
+			name:    "duplicate key",
+			suite:   "name: strict\nname: again\n",
+			wantErr: "line 2: mapping key \"name\" already defined at line 1",
+		},
+		{
+			name:    "type mismatch",
+			suite:   "name: strict\ntests:\n  - name: t\n    when:\n      origin:\n        asn: many\n",
+			wantErr: "line 6: cannot unmarshal !!str `many`",
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			_, err := cloudarmor.TestSuiteFromYAML([]byte(tst.suite), cloudarmor.StrictYAML())
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("cloudarmor.TestSuiteFromYAML() returned error %v, wanted error containing %q", err, tst.wantErr)
+			}
+		})
+	}
+	testSuiteBytes, err := os.ReadFile("../../test/complex-tests.yaml")
+	if err != nil {
+		t.Fatalf("os.ReadFile() returned error: %v", err)
+	}
+	if _, err := cloudarmor.TestSuiteFromYAML(testSuiteBytes, cloudarmor.StrictYAML()); err != nil {
+		t.Errorf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	// Strict decoding validates against the TestSuite and TestCase types themselves, so every
+	// field they declare is accepted.
+	allFields := `
+name: strict
+expr: origin.ip.inIpRange('10.0.0.0/8')
+version: VCurrent
+options:
+  strict: true
+max_cost: 100
+max_latency_ms: 10
+tests:
+  - name: t
+    tags: [smoke]
+    error_code: invalid_ip
+    max_cost: 50
+    max_latency_ms: 5
+    when:
+      origin:
+        ip: not-an-ip
+`
+	if _, err := cloudarmor.TestSuiteFromYAML([]byte(allFields), cloudarmor.StrictYAML()); err != nil {
+		t.Errorf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+}
+
+func TestTestCaseReader(t *testing.T) {
+	streams := map[string]string{
+		"yaml": `name: stream
+expr: request.method == 'GET'
+---
+name: get
+expect: true
+when:
+  request:
+    method: GET
+---
+name: post
+expect: true
+when:
+  request:
+    method: POST
+---
+`,
+		"ndjson": `{"name": "stream", "expr": "request.method == 'GET'"}
+{"name": "get", "expect": true, "when": {"request": {"method": "GET"}}}
+
+{"name": "post", "expect": true, "when": {"request": {"method": "POST"}}}
+`,
+	}
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	for format, stream := range streams {
+		t.Run(format, func(t *testing.T) {
+			tr, err := cloudarmor.NewTestCaseReader(strings.NewReader(stream))
+			if err != nil {
+				t.Fatalf("cloudarmor.NewTestCaseReader() returned error: %v", err)
+			}
+			if tr.Suite.Name != "stream" {
+				t.Errorf("tr.Suite.Name = %q, want %q", tr.Suite.Name, "stream")
+			}
+			ast, err := r.Compile(tr.Suite.Expr)
+			if err != nil {
+				t.Fatalf("r.Compile() returned error: %v", err)
+			}
+			prg, err := r.Program(ast)
+			if err != nil {
+				t.Fatalf("r.Program() returned error: %v", err)
+			}
+			var got []string
+			err = r.RunStreamValidation(prg, tr, func(s cloudarmor.TestStatus) {
+				got = append(got, fmt.Sprintf("%s:%t", s.Name, s.Pass))
+			})
+			if err != nil {
+				t.Fatalf("r.RunStreamValidation() returned error: %v", err)
+			}
+			if want := "get:true,post:false"; strings.Join(got, ",") != want {
+				t.Errorf("r.RunStreamValidation() reported %v, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestTestCaseReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		opts    []cloudarmor.YAMLOption
+		wantErr string
+	}{
+		{
+			name:    "empty",
+			stream:  "\n",
+			wantErr: "test stream is empty",
+		},
+		{
+			name:    "inline tests",
+			stream:  "name: s\ntests:\n  - name: t\n",
+			wantErr: "header at line 1: tests must follow the header",
+		},
+		{
+			name:    "invalid json",
+			stream:  "{\"name\": \"s\"}\n{\"name\": \"t\", \"when\": {\"origin\": {\"asn\": \"many\"}}}\n",
+			wantErr: "line 2: cannot unmarshal !!str `many`",
+		},
+		{
+			name:    "strict vars",
+			stream:  "name: s\nstrict_vars: true\n---\nname: t\nwhen:\n  requst:\n    path: /\n",
+			wantErr: "line 6: field requst not found",
+		},
+		{
+			name:    "strict",
+			stream:  "name: s\n---\nname: t\nexpct: true\n",
+			opts:    []cloudarmor.YAMLOption{cloudarmor.StrictYAML()},
+			wantErr: "line 4: field expct not found",
+		},
+		{
+			name:    "strict json",
+			stream:  "{\"name\": \"s\"}\n{\"name\": \"t\", \"expct\": true}\n",
+			opts:    []cloudarmor.YAMLOption{cloudarmor.StrictYAML()},
+			wantErr: "line 2: field expct not found",
+		},
+		{
+			name:    "expect and error",
+			stream:  "name: s\n---\nname: t\nexpect: true\nerror: boom\n",
+			wantErr: `test case "t" has both expect and error`,
+		},
+		{
+			name:    "expect and error code",
+			stream:  "name: s\n---\nname: t\nexpect: true\nerror_code: internal\n",
+			wantErr: `test case "t" has both expect and error_code`,
+		},
+	}
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			tr, err := cloudarmor.NewTestCaseReader(strings.NewReader(tst.stream), tst.opts...)
+			if err == nil {
+				_, err = tr.Next()
+			}
+			if err == nil || !strings.Contains(err.Error(), tst.wantErr) {
+				t.Errorf("reading the stream returned error %v, wanted error containing %q", err, tst.wantErr)
+			}
+		})
+	}
+}
+
+func TestTestTags(t *testing.T) {
+	suite := `
+name: tagged
+expr: request.method == 'GET'
+tests:
+  - name: smoke
+    tags: [smoke]
+    expect: true
+    when:
+      request:
+        method: GET
+  - name: regression
+    tags: [regression, slow]
+    expect: false
+  - name: untagged
+    expect: false
+`
+	ts, err := cloudarmor.TestSuiteFromYAML([]byte(suite), cloudarmor.StrictYAML())
+	if err != nil {
+		t.Fatalf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	tests := []struct {
+		tags []string
+		want string
+	}{
+		{want: "smoke,regression,untagged"},
+		{tags: []string{""}, want: "smoke,regression,untagged"},
+		{tags: []string{"smoke"}, want: "smoke"},
+		{tags: []string{"smoke", " slow"}, want: "smoke,regression"},
+		{tags: []string{"nightly"}, want: ""},
+	}
+	for _, tst := range tests {
+		r, err := cloudarmor.NewRules(cloudarmor.TestTags(tst.tags...))
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+		}
+		ast, err := r.Compile(ts.Expr)
+		if err != nil {
+			t.Fatalf("r.Compile() returned error: %v", err)
+		}
+		prg, err := r.Program(ast)
+		if err != nil {
+			t.Fatalf("r.Program() returned error: %v", err)
+		}
+		var got []string
+		for _, s := range r.RunRuleValidation(prg, ts.Tests) {
+			if !s.Pass {
+				t.Errorf("test case %s failed: %s", s.Name, s.Fail)
+			}
+			got = append(got, s.Name)
+		}
+		if strings.Join(got, ",") != tst.want {
+			t.Errorf("TestTags(%q) ran %v, want %s", tst.tags, got, tst.want)
+		}
+	}
+}
+
+func TestPerformanceBudgets(t *testing.T) {
+	suite := `
+name: budgets
+expr: request.path.lower().startsWith('/admin')
+max_cost: 1000
+tests:
+  - name: suite-budget
+    expect: true
+    when:
+      request:
+        path: /Admin/users
+  - name: over-budget
+    max_cost: 2
+    expect: true
+    when:
+      request:
+        path: /Admin/users
+  - name: latency
+    max_latency_ms: 60000
+    expect: false
+`
+	ts, err := cloudarmor.TestSuiteFromYAML([]byte(suite), cloudarmor.StrictYAML())
+	if err != nil {
+		t.Fatalf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	if ts.Tests[2].MaxCost != 1000 || ts.Tests[1].MaxCost != 2 {
+		t.Errorf("MaxCost = %d, %d, wanted the suite budget 1000 and the case budget 2", ts.Tests[2].MaxCost, ts.Tests[1].MaxCost)
+	}
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := r.Compile(ts.Expr)
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast, cel.CostTracking(nil))
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	statuses := r.RunRuleValidation(prg, ts.Tests)
+	if !statuses[0].Pass || !statuses[2].Pass {
+		t.Errorf("r.RunRuleValidation() = %+v, wanted suite-budget and latency to pass", statuses)
+	}
+	if !strings.HasPrefix(statuses[1].Fail, "cost ") || !strings.HasSuffix(statuses[1].Fail, "exceeds max_cost 2") {
+		t.Errorf("over-budget failed with %q, wanted cost exceeding max_cost 2", statuses[1].Fail)
+	}
+
+	untracked, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	statuses = r.RunRuleValidation(untracked, ts.Tests)
+	if !strings.Contains(statuses[0].Fail, "cel.CostTracking") {
+		t.Errorf("suite-budget failed with %q, wanted a cost tracking error", statuses[0].Fail)
+	}
+}
+
+func TestTestStatusErr(t *testing.T) {
+	stream := `name: s
+expr: request.headers['x-missing'] == 'a'
+---
+name: present
+expect: false
+when:
+  request:
+    headers:
+      x-missing: b
+---
+name: missing
+expect: false
+when:
+  request:
+    method: GET
+`
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	tr, err := cloudarmor.NewTestCaseReader(strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewTestCaseReader() returned error: %v", err)
+	}
+	ast, err := r.Compile(tr.Suite.Expr)
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	var got []string
+	err = r.RunStreamValidation(prg, tr, func(s cloudarmor.TestStatus) {
+		got = append(got, fmt.Sprintf("%s:%t", s.Name, s.Err != nil))
+	})
+	if err != nil {
+		t.Fatalf("r.RunStreamValidation() returned error: %v", err)
+	}
+	if want := "present:false,missing:true"; strings.Join(got, ",") != want {
+		t.Errorf("r.RunStreamValidation() reported errors %v, want %s", got, want)
+	}
+}
+
+func TestRunner(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	b, err := cloudarmor.RuleBundleFromYAML([]byte(`
+name: b
+rules:
+  - name: get
+    expr: "request.method == 'GET'"
+    tests:
+      - name: get
+        expect: true
+        when:
+          request:
+            method: GET
+      - name: post
+        expect: true
+        when:
+          request:
+            method: POST
+  - name: broken
+    expr: "request.nope == 1"
+`), "")
+	if err != nil {
+		t.Fatalf("cloudarmor.RuleBundleFromYAML() returned error: %v", err)
+	}
+	var events []string
+	runner := r.NewRunner()
+	runner.OnCaseStart = func(suite string, tc *cloudarmor.TestCase) {
+		events = append(events, "start "+suite+"/"+tc.Name)
+	}
+	runner.OnCaseDone = func(suite string, s cloudarmor.TestStatus) {
+		events = append(events, fmt.Sprintf("done %s/%s %t", suite, s.Name, s.Pass))
+	}
+	runner.OnSuiteDone = func(res cloudarmor.SuiteResult) {
+		events = append(events, fmt.Sprintf("suite %s %d/%d %t", res.Name, res.Passed, res.Failed, res.Err != nil))
+	}
+	results, err := runner.RunBundle(context.Background(), b)
+	if err != nil {
+		t.Fatalf("runner.RunBundle() returned error: %v", err)
+	}
+	if len(results) != 2 || len(results[0].Statuses) != 2 || results[1].CompileError == nil {
+		t.Errorf("runner.RunBundle() returned %+v, wanted two test statuses and a compile error", results)
+	}
+	want := []string{
+		"start b/get/get", "done b/get/get true",
+		"start b/get/post", "done b/get/post false",
+		"suite b/get 1/1 false",
+		"suite b/broken 0/0 true",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("runner.RunBundle() called hooks:\n%s\nwant:\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	events = nil
+	if _, err := runner.Run(ctx, "cancelled", nil, b.Rules[0].Tests); !errors.Is(err, context.Canceled) {
+		t.Errorf("runner.Run() returned error %v, wanted context.Canceled", err)
+	}
+	if want := "suite cancelled 0/0 true"; strings.Join(events, "\n") != want {
+		t.Errorf("runner.Run() called hooks %v, want %s", events, want)
+	}
+}
+
+func TestTestStatusFields(t *testing.T) {
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ts, err := cloudarmor.TestSuiteFromYAML([]byte(`
+name: s
+expr: "request.headers['x'] == 'a' && int(request.query) > 0"
+tests:
+  - name: skipped
+    tags: [other]
+    expect: false
+  - name: mismatch
+    tags: [smoke]
+    expect: true
+    when:
+      request:
+        headers:
+          x: b
+  - name: wrong-error
+    tags: [smoke]
+    error: "no such overload"
+    when:
+      request:
+        headers:
+          x: a
+        query: secret
+`))
+	if err != nil {
+		t.Fatalf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	ast, err := r.Compile(ts.Expr)
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	tagged, err := cloudarmor.NewRules(cloudarmor.TestTags("smoke"))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	statuses := tagged.RunRuleValidation(prg, ts.Tests)
+	if len(statuses) != 2 {
+		t.Fatalf("RunRuleValidation() returned %d statuses, wanted 2", len(statuses))
+	}
+	mismatch, wrongErr := statuses[0], statuses[1]
+	if mismatch.Expected != true || mismatch.Actual != false || mismatch.CaseIndex != 1 || mismatch.Duration <= 0 {
+		t.Errorf("RunRuleValidation() returned %+v, wanted expected true, actual false, and case index 1", mismatch)
+	}
+	actual, _ := wrongErr.Actual.(string)
+	if wrongErr.Expected != "no such overload" || wrongErr.CaseIndex != 2 || !strings.Contains(actual, "type conversion error") {
+		t.Errorf("RunRuleValidation() returned %+v, wanted the error message as the actual value", wrongErr)
+	}
+}
+
+func TestExpectErrorCode(t *testing.T) {
+	ts, err := cloudarmor.TestSuiteFromYAML([]byte(`
+name: codes
+expr: "inIpRange(request.headers['x-ip'], '10.0.0.0/8') && int(request.query) > 0"
+tests:
+  - name: invalid-ip
+    error_code: invalid_ip
+    when:
+      request:
+        headers:
+          x-ip: nope
+  - name: substring-and-code
+    error: "invalid IP"
+    error_code: invalid_ip
+    when:
+      request:
+        headers:
+          x-ip: nope
+  - name: wrong-code
+    error_code: invalid_ip
+    when:
+      request:
+        headers:
+          x-ip: 10.0.0.1
+        query: abc
+  - name: no-error
+    error_code: invalid_ip
+    when:
+      request:
+        headers:
+          x-ip: 192.0.2.1
+`), cloudarmor.StrictYAML())
+	if err != nil {
+		t.Fatalf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := r.Compile(ts.Expr)
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	want := map[string]string{
+		"invalid-ip":         "",
+		"substring-and-code": "",
+		"wrong-code":         `got error "type conversion error from 'string' to 'int'" with code "type_conversion", wanted error code "invalid_ip"`,
+		"no-error":           `got result false, wanted error code "invalid_ip"`,
+	}
+	for _, s := range r.RunRuleValidation(prg, ts.Tests) {
+		if s.Fail != want[s.Name] {
+			t.Errorf("test case %s failed with %q, want %q", s.Name, s.Fail, want[s.Name])
+		}
+	}
+	for _, yamlText := range []string{
+		"name: s\nexpr: 'true'\ntests:\n  - name: t\n    error_code: nope\n",
+		"name: s\nexpr: 'true'\ntests:\n  - name: t\n    expect: true\n    error_code: invalid_ip\n",
+	} {
+		if _, err := cloudarmor.TestSuiteFromYAML([]byte(yamlText)); err == nil {
+			t.Errorf("cloudarmor.TestSuiteFromYAML(%q) succeeded, wanted error", yamlText)
+		}
+	}
+}
+
+func TestSuiteSetup(t *testing.T) {
+	ts, err := cloudarmor.TestSuiteFromYAML([]byte(`
+name: setup
+expr: "request.body.contains('attack')"
+version: VCurrent
+options:
+  strict: true
+  features: [request_body]
+  limits:
+    max_body_size: 4
+tests:
+  - name: within-limit
+    expect: true
+    when:
+      request:
+        body: attack
+  - name: beyond-limit
+    expect: false
+    when:
+      request:
+        body: "....attack"
+`))
+	if err != nil {
+		t.Fatalf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	for _, tc := range []struct {
+		maxBodySize int
+		want        string
+	}{
+		{maxBodySize: 4, want: "within-limit:false,beyond-limit:true"},
+		{maxBodySize: 6, want: "within-limit:true,beyond-limit:true"},
+	} {
+		ts.Options.Limits.MaxBodySize = tc.maxBodySize
+		statuses, err := cloudarmor.RunTestSuite(ts)
+		if err != nil {
+			t.Fatalf("cloudarmor.RunTestSuite() returned error: %v", err)
+		}
+		var got []string
+		for _, s := range statuses {
+			got = append(got, fmt.Sprintf("%s:%t", s.Name, s.Pass))
+		}
+		if strings.Join(got, ",") != tc.want {
+			t.Errorf("cloudarmor.RunTestSuite() with max_body_size %d returned %v, want %s", tc.maxBodySize, got, tc.want)
+		}
+	}
+	for _, yamlText := range []string{
+		"name: s\nexpr: 'true'\nversion: V3\n",
+		"name: s\nexpr: 'true'\noptions:\n  features: [nope]\n",
+		"name: s\nexpr: 'true'\noptions:\n  limits:\n    max_body_size: -1\n",
+		"name: s\nexpr: 'true'\noptions:\n  strict: true\ntests:\n  - name: t\n    expct: true\n",
+	} {
+		if _, err := cloudarmor.TestSuiteFromYAML([]byte(yamlText)); err == nil {
+			t.Errorf("cloudarmor.TestSuiteFromYAML(%q) succeeded, wanted error", yamlText)
+		}
+	}
+}
+
+func TestPolicySuite(t *testing.T) {
+	suite := `
+name: edge
+policy: edge-policy.json
+tests:
+  - name: internal
+    expect_action: allow
+    expect_headers:
+      x-internal: "1"
+    when:
+      origin:
+        ip: 10.1.2.3
+  - name: admin
+    expect_action: deny(403)
+    expect_headers: {}
+    when:
+      request:
+        path: /admin
+      origin:
+        ip: 203.0.113.7
+  - name: wrong-header
+    expect_headers:
+      X-Internal: "2"
+    when:
+      origin:
+        ip: 10.1.2.3
+  - name: wrong-action
+    expect_action: allow
+    when:
+      origin:
+        ip: 203.0.113.7
+`
+	ts, err := cloudarmor.TestSuiteFromYAML([]byte(suite), cloudarmor.StrictYAML())
+	if err != nil {
+		t.Fatalf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	if _, err := cloudarmor.RunTestSuite(ts); err == nil {
+		t.Errorf("cloudarmor.RunTestSuite() of a policy suite succeeded, wanted error")
+	}
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() failed: %v", err)
+	}
+	policy, err := cloudarmor.SecurityPolicyFromJSON([]byte(evaluatedPolicy))
+	if err != nil {
+		t.Fatalf("cloudarmor.SecurityPolicyFromJSON() failed: %v", err)
+	}
+	e, err := rules.NewPolicyEvaluator(policy)
+	if err != nil {
+		t.Fatalf("NewPolicyEvaluator() failed: %v", err)
+	}
+	want := map[string]string{
+		"internal":     "",
+		"admin":        "",
+		"wrong-header": "expected headers map[x-internal:2], got map[x-internal:1]",
+		"wrong-action": `expected action "allow", got "deny(502)"`,
+	}
+	statuses := rules.RunPolicyValidation(e, ts.Tests)
+	if len(statuses) != len(want) {
+		t.Fatalf("RunPolicyValidation() returned %d statuses, wanted %d", len(statuses), len(want))
+	}
+	for _, s := range statuses {
+		if s.Fail != want[s.Name] || s.Pass != (want[s.Name] == "") {
+			t.Errorf("RunPolicyValidation() case %s failed with %q, wanted %q", s.Name, s.Fail, want[s.Name])
+		}
+	}
+
+	for _, yamlText := range []string{
+		"name: s\nexpr: 'true'\npolicy: p.json\n",
+		"name: s\nexpr: 'true'\ntests:\n  - name: t\n    expect_action: allow\n",
+		"name: s\npolicy: p.json\ntests:\n  - name: t\n    expect: true\n",
+	} {
+		if _, err := cloudarmor.TestSuiteFromYAML([]byte(yamlText)); err == nil {
+			t.Errorf("cloudarmor.TestSuiteFromYAML(%q) succeeded, wanted error", yamlText)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+)
+
+// ja3Length is the length of a JA3 fingerprint, the hex-encoded MD5 hash of the JA3 string.
+const ja3Length = 2 * md5.Size
+
+// ja4Example is a JA4 fingerprint quoted by error messages.
+const ja4Example = "t13d1516h2_8daaf6152771_b186095e22b6"
+
+var (
+	// ja3StringPattern matches the JA3 string which is hashed to form a JA3 fingerprint: the TLS
+	// version, ciphers, extensions, elliptic curves, and point formats of the ClientHello.
+	ja3StringPattern = regexp.MustCompile(`^\d+,[\d-]*,[\d-]*,[\d-]*,[\d-]*$`)
+	// ja4Pattern matches a JA4 fingerprint: the protocol, TLS version, SNI, cipher and extension
+	// counts, and ALPN of the ClientHello, followed by the truncated SHA-256 hashes of its sorted
+	// ciphers and of its extensions and signature algorithms.
+	ja4Pattern = regexp.MustCompile(`^[tqd][0-9a-z]{2}[di]\d{4}[0-9a-z]{2}_[0-9a-f]{12}_[0-9a-f]{12}$`)
+)
+
+// CheckJA3Fingerprint returns an error describing why origin.tls_ja3_fingerprint can never be
+// the value, such as a truncated hash or one with uppercase hex digits, or nil if it is 32
+// lowercase hex digits. The error for an unhashed JA3 string suggests its hash.
+func CheckJA3Fingerprint(fp string) error {
+	if ja3StringPattern.MatchString(fp) {
+		sum := md5.Sum([]byte(fp))
+		return fmt.Errorf("%q is a JA3 string rather than its MD5 hash, did you mean %q?", fp, hex.EncodeToString(sum[:]))
+	}
+	if !isHexString(fp) {
+		return fmt.Errorf("%q is not a JA3 fingerprint, which is %d hex digits", fp, ja3Length)
+	}
+	if len(fp) != ja3Length {
+		return fmt.Errorf("%q is not a JA3 fingerprint: it has %d hex digits rather than %d", fp, len(fp), ja3Length)
+	}
+	if lower := strings.ToLower(fp); fp != lower {
+		return fmt.Errorf("%q must be lowercase, e.g. %q", fp, lower)
+	}
+	return nil
+}
+
+// CheckJA4Fingerprint returns an error describing why origin.tls_ja4_fingerprint can never be
+// the value, such as a truncated fingerprint or one with uppercase characters, or nil if it has
+// the form of a JA4 fingerprint, e.g. t13d1516h2_8daaf6152771_b186095e22b6.
+func CheckJA4Fingerprint(fp string) error {
+	if ja4Pattern.MatchString(fp) {
+		return nil
+	}
+	if lower := strings.ToLower(fp); ja4Pattern.MatchString(lower) {
+		return fmt.Errorf("%q must be lowercase, e.g. %q", fp, lower)
+	}
+	if len(fp) != len(ja4Example) {
+		return fmt.Errorf("%q is not a JA4 fingerprint: it has %d characters rather than %d, e.g. %q", fp, len(fp), len(ja4Example), ja4Example)
+	}
+	return fmt.Errorf("%q is not a JA4 fingerprint, e.g. %q", fp, ja4Example)
+}
+
+func isHexString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isHex(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ValidateTLSFingerprints rejects expressions which compare origin.tls_ja3_fingerprint or
+// origin.tls_ja4_fingerprint with a string literal which does not have the form of such a
+// fingerprint at check time, such as a truncated or uppercase fingerprint, since Cloud Armor
+// accepts the expression but the comparison never matches.
+func ValidateTLSFingerprints() RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.validateTLSFingerprints = true
+		return r, nil
+	}
+}
+
+// tlsFingerprintOptions returns the environment options which enforce ValidateTLSFingerprints.
+func (r *Rules) tlsFingerprintOptions() []cel.EnvOption {
+	if !r.validateTLSFingerprints {
+		return nil
+	}
+	return []cel.EnvOption{cel.ASTValidators(tlsFingerprintValidator{})}
+}
+
+// tlsFingerprintChecks maps the fields of origin holding TLS fingerprints to their checks.
+var tlsFingerprintChecks = map[string]func(string) error{
+	"tls_ja3_fingerprint": CheckJA3Fingerprint,
+	"tls_ja4_fingerprint": CheckJA4Fingerprint,
+}
+
+// tlsFingerprintValidator reports string literals compared with a TLS fingerprint attribute by
+// == or != which do not have the form of such a fingerprint.
+type tlsFingerprintValidator struct{}
+
+// Name implements the cel.ASTValidator interface.
+func (tlsFingerprintValidator) Name() string {
+	return "cloudarmor.validator.tls_fingerprint"
+}
+
+// Validate implements the cel.ASTValidator interface.
+func (tlsFingerprintValidator) Validate(_ *cel.Env, _ cel.ValidatorConfig, a *ast.AST, iss *cel.Issues) {
+	check := func(field string, e ast.Expr) {
+		if e.Kind() != ast.LiteralKind {
+			return
+		}
+		fp, ok := e.AsLiteral().Value().(string)
+		if !ok {
+			return
+		}
+		if err := tlsFingerprintChecks[field](fp); err != nil {
+			iss.ReportErrorAtID(e.ID(), "origin.%s: %v", field, err)
+		}
+	}
+	for _, e := range ast.MatchDescendants(ast.NavigateAST(a), ast.KindMatcher(ast.CallKind)) {
+		call := e.AsCall()
+		args := call.Args()
+		if len(args) != 2 {
+			continue
+		}
+		if fn := call.FunctionName(); fn != operators.Equals && fn != operators.NotEquals {
+			continue
+		}
+		for field := range tlsFingerprintChecks {
+			if isAttribute(args[0], "origin", field) {
+				check(field, args[1])
+			} else if isAttribute(args[1], "origin", field) {
+				check(field, args[0])
+			}
+		}
+	}
+}
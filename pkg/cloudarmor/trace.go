@@ -0,0 +1,161 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// traceValueLength is the length beyond which the values of a trace are truncated, so that a
+// request body does not swamp a CI log.
+const traceValueLength = 80
+
+// TraceStep is the value of a subexpression of a rule during the evaluation of a failed test case.
+type TraceStep struct {
+	// Expr is the subexpression, e.g. request.path.startsWith('/admin').
+	Expr string `json:"expr"`
+	// Value is the value of the subexpression, e.g. false or "/login", or its error.
+	Value string `json:"value"`
+}
+
+// String returns the step in the form expr = value.
+func (s TraceStep) String() string {
+	return s.Expr + " = " + s.Value
+}
+
+// ExplainFailures records the values of the subexpressions of a rule in the Trace of the
+// TestStatus of each failed test case, so that CI logs show which clause of the rule diverged
+// from the expectation without the test case being re-run. Subexpressions which were not
+// evaluated, such as the right operand of a short-circuited &&, are omitted.
+//
+// Failed test cases are evaluated a second time to record the trace, so passing test cases are
+// evaluated at their usual cost.
+func ExplainFailures() RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.explainFailures = true
+		return r, nil
+	}
+}
+
+// explainProgram returns the program wrapped with a second program which records the values of
+// the subexpressions of the AST, applying the same input semantics.
+func (r *Rules) explainProgram(env *cel.Env, prg cel.Program, a *cel.Ast, prgOpts []cel.ProgramOption) (cel.Program, error) {
+	traced, err := env.Program(a, append(prgOpts, cel.EvalOptions(cel.OptTrackState))...)
+	if err != nil {
+		return nil, err
+	}
+	if r.limits != nil {
+		traced = &bodyLimitProgram{Program: traced, maxSize: r.limits.MaxBodySize}
+	}
+	return &explainProgram{Program: prg, ast: a.NativeRep(), traced: &recoverProgram{Program: r.variablesProgram(traced), stack: r.debug}}, nil
+}
+
+// explainProgram evaluates the wrapped program, and traces the evaluation of an input on request.
+type explainProgram struct {
+	cel.Program
+	ast    *ast.AST
+	traced cel.Program
+}
+
+// trace evaluates the input, returning the values of the subexpressions which were evaluated in
+// the order they appear within the expression, outermost first. The whole expression, whose value
+// is the result of the test case, literals, and attributes which are maps or messages rather than
+// values are omitted. The values of the attributes which are sensitive are Redacted.
+func (p *explainProgram) trace(input any, sensitive func(attr string) bool) []TraceStep {
+	_, det, _ := p.traced.Eval(input)
+	if det == nil || det.State() == nil {
+		return nil
+	}
+	var steps []TraceStep
+	root := p.ast.Expr()
+	ast.PreOrderVisit(root, ast.NewExprVisitor(func(e ast.Expr) {
+		if e == root {
+			return
+		}
+		switch e.Kind() {
+		case ast.CallKind, ast.SelectKind, ast.IdentKind:
+		default:
+			return
+		}
+		val, found := det.State().Value(e.ID())
+		if !found {
+			return
+		}
+		value, ok := traceValue(val)
+		if !ok {
+			return
+		}
+		if attr, ok := traceAttribute(e); ok && sensitive(attr) {
+			value = fmt.Sprintf("%q", Redacted)
+		}
+		expr, err := unparse(e, p.ast.SourceInfo())
+		if err != nil {
+			return
+		}
+		steps = append(steps, TraceStep{Expr: expr, Value: value})
+	}))
+	return steps
+}
+
+// traceAttribute returns the name of the attribute which the expression selects, as accepted by
+// Rules.Sensitive, e.g. request.headers['cookie'].
+func traceAttribute(e ast.Expr) (string, bool) {
+	if e.Kind() != ast.CallKind || e.AsCall().FunctionName() != operators.Index {
+		return attributeName(e)
+	}
+	args := e.AsCall().Args()
+	if name, ok := attributeName(args[0]); !ok || name != "request.headers" || args[1].Kind() != ast.LiteralKind {
+		return "", false
+	}
+	key, ok := args[1].AsLiteral().Value().(string)
+	return fmt.Sprintf("request.headers['%s']", key), ok
+}
+
+// traceValue formats a value of a trace, or returns false if it is a map or message.
+func traceValue(val ref.Val) (string, bool) {
+	switch v := val.(type) {
+	case *types.Err:
+		return "error: " + v.Error(), true
+	case *types.Unknown:
+		return "unknown", true
+	case types.String:
+		return fmt.Sprintf("%q", string(v)), true
+	case types.Bytes:
+		return fmt.Sprintf("b%q", []byte(v)), true
+	case types.Bool, types.Int, types.Uint, types.Double, types.Null:
+		return fmt.Sprint(v.Value()), true
+	}
+	return "", false
+}
+
+// truncateTraceValue shortens a value of a trace to traceValueLength bytes, without splitting a
+// UTF-8 sequence.
+func truncateTraceValue(value string) string {
+	if len(value) <= traceValueLength {
+		return value
+	}
+	i := traceValueLength
+	for i > 0 && !utf8.RuneStart(value[i]) {
+		i--
+	}
+	return value[:i] + "..."
+}
@@ -0,0 +1,264 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package traffic generates randomized but realistic request variables for load testing Cloud
+// Armor rules.
+package traffic
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/randutil"
+)
+
+// Config describes the distribution of the generated requests.
+type Config struct {
+	// Seed makes the generated sequence of requests reproducible. Reports of the generated
+	// requests should record it, see randutil.Resolve.
+	Seed int64
+	// Methods maps HTTP methods to their relative weights.
+	Methods map[string]int
+	// PathTemplates are the request paths to draw from. The placeholder {id} is replaced with a
+	// random number and {name} with a random word.
+	PathTemplates []string
+	// Queries are the query strings to draw from, which may also contain placeholders.
+	Queries []string
+	// HeaderSets are the sets of request headers to draw from.
+	HeaderSets []map[string]string
+	// CIDRs are the IPv4 or IPv6 ranges from which origin.ip is drawn.
+	CIDRs []string
+	// Regions are the region codes to draw from.
+	Regions []string
+	// ASNs are the autonomous system numbers to draw from.
+	ASNs []int64
+	// Rate is the maximum number of requests per second produced by Stream, or zero for no limit.
+	Rate int
+}
+
+// DefaultConfig returns a configuration which resembles the traffic of a typical web application,
+// dominated by GET requests to static assets and API endpoints.
+func DefaultConfig() Config {
+	return Config{
+		Seed: 1,
+		Methods: map[string]int{
+			"GET":     80,
+			"POST":    12,
+			"PUT":     3,
+			"DELETE":  2,
+			"HEAD":    2,
+			"OPTIONS": 1,
+		},
+		PathTemplates: []string{
+			"/",
+			"/index.html",
+			"/static/js/app.{id}.js",
+			"/static/img/{name}.png",
+			"/api/v1/users/{id}",
+			"/api/v1/users/{id}/orders",
+			"/api/v2/search",
+			"/login",
+			"/admin/{name}",
+			"/wp-login.php",
+		},
+		Queries: []string{
+			"",
+			"",
+			"q={name}",
+			"page={id}&sort=asc",
+			"id={id}%27%20OR%20%271%27%3D%271",
+			"redirect=%2Fhome%3Fref%3D{name}",
+		},
+		HeaderSets: []map[string]string{
+			{"host": "www.example.com", "user-agent": "Mozilla/5.0 (X11; Linux x86_64)", "accept": "text/html"},
+			{"host": "www.example.com", "user-agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0)", "accept": "*/*"},
+			{"host": "api.example.com", "user-agent": "okhttp/4.12.0", "content-type": "application/json"},
+			{"host": "www.example.com", "user-agent": "curl/8.4.0"},
+			{"host": "www.example.com", "user-agent": "python-requests/2.31.0"},
+		},
+		CIDRs:   []string{"192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "10.0.0.0/8", "2001:db8::/32"},
+		Regions: []string{"US", "US", "US", "GB", "DE", "FR", "JP", "BR", "IN", "CN", "RU"},
+		ASNs:    []int64{15169, 16509, 8075, 13335, 3356, 4134, 64512},
+	}
+}
+
+// Generator produces pseudo-random request variables according to a Config.
+//
+// Generator instances are not safe for concurrent use.
+type Generator struct {
+	cfg         Config
+	rnd         *rand.Rand
+	methods     []string
+	cumWeights  []int
+	totalWeight int
+	nets        []*net.IPNet
+}
+
+// New creates a Generator from the given configuration.
+//
+// The return value is the Generator or an error if the configuration is invalid.
+func New(cfg Config) (*Generator, error) {
+	g := &Generator{cfg: cfg, rnd: randutil.New(cfg.Seed)}
+	for m := range cfg.Methods {
+		g.methods = append(g.methods, m)
+	}
+	sort.Strings(g.methods)
+	for _, m := range g.methods {
+		w := cfg.Methods[m]
+		if w < 0 {
+			return nil, fmt.Errorf("method %s has negative weight %d", m, w)
+		}
+		g.totalWeight += w
+		g.cumWeights = append(g.cumWeights, g.totalWeight)
+	}
+	if g.totalWeight == 0 {
+		return nil, fmt.Errorf("at least one method must have a positive weight")
+	}
+	if len(cfg.PathTemplates) == 0 {
+		return nil, fmt.Errorf("at least one path template is required")
+	}
+	for _, cidr := range cfg.CIDRs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		g.nets = append(g.nets, n)
+	}
+	if cfg.Rate < 0 {
+		return nil, fmt.Errorf("rate must not be negative, got %d", cfg.Rate)
+	}
+	return g, nil
+}
+
+// Next returns the next generated request, initialized with cloudarmor.SafeVariables.
+func (g *Generator) Next() *cloudarmor.Variables {
+	headers := map[string]string{}
+	if len(g.cfg.HeaderSets) != 0 {
+		for k, v := range g.cfg.HeaderSets[g.rnd.Intn(len(g.cfg.HeaderSets))] {
+			headers[k] = v
+		}
+	}
+	scheme := "https"
+	if g.rnd.Intn(10) == 0 {
+		scheme = "http"
+	}
+	v := &cloudarmor.Variables{
+		Request: &cloudarmor.Request{
+			Method:  g.method(),
+			Headers: headers,
+			Path:    g.expand(g.pick(g.cfg.PathTemplates)),
+			Query:   g.expand(g.pick(g.cfg.Queries)),
+			Scheme:  scheme,
+		},
+		Origin: &cloudarmor.Origin{
+			IP:         g.ip(),
+			RegionCode: g.pick(g.cfg.Regions),
+		},
+		Token: &cloudarmor.Token{
+			RecaptchaAction: &cloudarmor.RecaptchaAction{
+				// reCAPTCHA reports scores in increments of 0.1.
+				Score: float64(g.rnd.Intn(11)) / 10,
+				Valid: g.rnd.Intn(4) != 0,
+			},
+		},
+	}
+	if len(g.cfg.ASNs) != 0 {
+		v.Origin.ASN = g.cfg.ASNs[g.rnd.Intn(len(g.cfg.ASNs))]
+	}
+	return cloudarmor.SafeVariables(v)
+}
+
+// Generate returns the next n generated requests.
+func (g *Generator) Generate(n int) []*cloudarmor.Variables {
+	vars := make([]*cloudarmor.Variables, n)
+	for i := range vars {
+		vars[i] = g.Next()
+	}
+	return vars
+}
+
+// Stream sends n generated requests on the returned channel no faster than the configured rate,
+// closing the channel once all of the requests have been sent or the context is done.
+func (g *Generator) Stream(ctx context.Context, n int) <-chan *cloudarmor.Variables {
+	ch := make(chan *cloudarmor.Variables)
+	go func() {
+		defer close(ch)
+		var tick <-chan time.Time
+		if g.cfg.Rate > 0 {
+			ticker := time.NewTicker(time.Second / time.Duration(g.cfg.Rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i := 0; i < n; i++ {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case ch <- g.Next():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (g *Generator) method() string {
+	w := g.rnd.Intn(g.totalWeight)
+	i := sort.SearchInts(g.cumWeights, w+1)
+	return g.methods[i]
+}
+
+func (g *Generator) pick(vals []string) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[g.rnd.Intn(len(vals))]
+}
+
+var words = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+
+func (g *Generator) expand(tmpl string) string {
+	for strings.Contains(tmpl, "{id}") {
+		tmpl = strings.Replace(tmpl, "{id}", strconv.Itoa(g.rnd.Intn(100000)), 1)
+	}
+	for strings.Contains(tmpl, "{name}") {
+		tmpl = strings.Replace(tmpl, "{name}", words[g.rnd.Intn(len(words))], 1)
+	}
+	return tmpl
+}
+
+// ip returns a random address within one of the configured ranges.
+func (g *Generator) ip() string {
+	if len(g.nets) == 0 {
+		return ""
+	}
+	n := g.nets[g.rnd.Intn(len(g.nets))]
+	ip := make(net.IP, len(n.IP))
+	for i := range ip {
+		ip[i] = n.IP[i] | byte(g.rnd.Intn(256))&^n.Mask[i]
+	}
+	return ip.String()
+}
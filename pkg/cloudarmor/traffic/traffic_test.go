@@ -0,0 +1,104 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package traffic_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor/traffic"
+)
+
+func TestGenerate(t *testing.T) {
+	cfg := traffic.DefaultConfig()
+	cfg.Methods = map[string]int{"GET": 9, "POST": 1, "PUT": 0}
+	cfg.CIDRs = []string{"192.0.2.0/24", "2001:db8::/32"}
+	g, err := traffic.New(cfg)
+	if err != nil {
+		t.Fatalf("traffic.New() returned error: %v", err)
+	}
+	reqs := g.Generate(2000)
+	_, v4, _ := net.ParseCIDR("192.0.2.0/24")
+	_, v6, _ := net.ParseCIDR("2001:db8::/32")
+	methods := map[string]int{}
+	for _, v := range reqs {
+		methods[v.Request.Method]++
+		ip := net.ParseIP(v.Origin.IP)
+		if ip == nil || (!v4.Contains(ip) && !v6.Contains(ip)) {
+			t.Fatalf("origin.ip %q is not within the configured ranges", v.Origin.IP)
+		}
+		if strings.Contains(v.Request.Path, "{") || strings.Contains(v.Request.Query, "{") {
+			t.Fatalf("request %s?%s contains an unexpanded placeholder", v.Request.Path, v.Request.Query)
+		}
+		if vs := v.Validate(); len(vs) != 0 {
+			t.Fatalf("generated request has violations: %v", vs)
+		}
+	}
+	if methods["PUT"] != 0 {
+		t.Errorf("generated %d PUT requests, wanted none for a zero weight", methods["PUT"])
+	}
+	if got := float64(methods["GET"]) / float64(len(reqs)); got < 0.85 || got > 0.95 {
+		t.Errorf("GET requests make up %.2f of the traffic, wanted roughly 0.9", got)
+	}
+
+	again, err := traffic.New(cfg)
+	if err != nil {
+		t.Fatalf("traffic.New() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(again.Generate(10), reqs[:10]) {
+		t.Error("generators with the same seed produced different requests")
+	}
+}
+
+func TestStreamRate(t *testing.T) {
+	cfg := traffic.DefaultConfig()
+	cfg.Rate = 100
+	g, err := traffic.New(cfg)
+	if err != nil {
+		t.Fatalf("traffic.New() returned error: %v", err)
+	}
+	start := time.Now()
+	n := 0
+	for range g.Stream(context.Background(), 10) {
+		n++
+	}
+	if n != 10 {
+		t.Errorf("Stream() sent %d requests, wanted 10", n)
+	}
+	// Ten requests at 100 per second take at least 100ms.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Stream() sent 10 requests in %v, wanted the rate to be limited", elapsed)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	for name, mutate := range map[string]func(*traffic.Config){
+		"no methods":      func(c *traffic.Config) { c.Methods = nil },
+		"negative weight": func(c *traffic.Config) { c.Methods = map[string]int{"GET": -1} },
+		"no paths":        func(c *traffic.Config) { c.PathTemplates = nil },
+		"bad cidr":        func(c *traffic.Config) { c.CIDRs = []string{"10.0.0.0/33"} },
+		"negative rate":   func(c *traffic.Config) { c.Rate = -1 },
+	} {
+		cfg := traffic.DefaultConfig()
+		mutate(&cfg)
+		if _, err := traffic.New(cfg); err == nil {
+			t.Errorf("traffic.New() with %s succeeded, wanted error", name)
+		}
+	}
+}
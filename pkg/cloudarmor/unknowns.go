@@ -0,0 +1,81 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"strings"
+)
+
+// WithUnknowns configures the Rules so that attribute subtrees which were not populated in the
+// input Variables, such as a missing token block, evaluate as CEL unknowns rather than as zero
+// values.
+//
+// An expression which depends on an unknown attribute evaluates to an unknown value rather than
+// a potentially misleading false, while expressions whose outcome does not depend on the missing
+// attributes, e.g. `request.method == 'GET' || token.recaptcha_action.valid` for a GET request,
+// still produce a result.
+func WithUnknowns() RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		r.unknowns = true
+		return r, nil
+	}
+}
+
+// unsetSubtrees returns the names of the attribute subtrees which have not been populated.
+func (v *Variables) unsetSubtrees() []string {
+	var unset []string
+	if v.Request == nil {
+		unset = append(unset, "request")
+	}
+	if v.Origin == nil {
+		unset = append(unset, "origin")
+	}
+	if v.Connection == nil {
+		unset = append(unset, "connection")
+	}
+	if v.Response == nil {
+		unset = append(unset, "response")
+	}
+	if v.AdaptiveProtection == nil {
+		unset = append(unset, "adaptive_protection")
+	}
+	if v.Token == nil {
+		unset = append(unset, "token")
+		return unset
+	}
+	if v.Token.RecaptchaExemption == nil {
+		unset = append(unset, "token.recaptcha_exemption")
+	}
+	if v.Token.RecaptchaAction == nil {
+		unset = append(unset, "token.recaptcha_action")
+	}
+	if v.Token.RecaptchaSession == nil {
+		unset = append(unset, "token.recaptcha_session")
+	}
+	return unset
+}
+
+// isUnset reports whether the attribute belongs to a subtree which was not populated, either
+// currently or prior to the variables being initialized by SafeVariables.
+func (v *Variables) isUnset(attr string) bool {
+	for _, subtrees := range [][]string{v.unset, v.unsetSubtrees()} {
+		for _, subtree := range subtrees {
+			if strings.HasPrefix(attr, subtree+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/parser"
+)
+
+// unparseColumn is the column after which Unparse wraps long expressions.
+const unparseColumn = 80
+
+// identifierPattern matches the field names which may follow a '.' in a Cloud Armor expression.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// PresenceStyle is the surface form in which Unparse renders presence tests of map keys.
+type PresenceStyle int
+
+const (
+	// PresenceField renders presence tests as field selections, e.g. has(request.headers.token),
+	// falling back to the index form for keys which are not identifiers, such as x-token.
+	PresenceField PresenceStyle = iota
+	// PresenceIndex renders presence tests of map keys as index operations, e.g.
+	// has(request.headers['token']).
+	PresenceIndex
+)
+
+// ParsePresenceStyle returns the PresenceStyle with the given name, either field or index.
+func ParsePresenceStyle(name string) (PresenceStyle, error) {
+	switch name {
+	case "field":
+		return PresenceField, nil
+	case "index":
+		return PresenceIndex, nil
+	}
+	return 0, fmt.Errorf("unsupported presence style %q, must be field or index", name)
+}
+
+// UnparseOption configures the output of Unparse.
+type UnparseOption func(*unparser)
+
+// UnparsePresenceStyle selects the form of the has() calls rendered for presence tests of map
+// keys. Both forms compile to the same expression, so the form an author used is not recorded
+// in a CheckedExpr; the default is PresenceField.
+func UnparsePresenceStyle(style PresenceStyle) UnparseOption {
+	return func(u *unparser) {
+		u.style = style
+	}
+}
+
+// Unparse converts a compiled expression, such as one restored from a CheckedExpr written with
+// -output_format=binarypb, back into a readable Cloud Armor expression.
+//
+// Compiled expressions do not record the has() macro calls they were written with, so presence
+// tests are reconstructed in a form Cloud Armor accepts, selected by UnparsePresenceStyle. The
+// presence of scalar attributes under PresenceAbsent is always rendered as has(request.method).
+// Custom functions such as lower() and urlDecode() keep their member-call syntax, and
+// expressions longer than 80 columns are wrapped after && and || operators.
+func Unparse(a *cel.Ast, opts ...UnparseOption) (string, error) {
+	native := a.NativeRep()
+	u := &unparser{fac: ast.NewExprFactory(), types: native.TypeMap()}
+	for _, opt := range opts {
+		opt(u)
+	}
+	ast.PostOrderVisit(native.Expr(), ast.NewExprVisitor(func(e ast.Expr) {
+		u.nextID = max(u.nextID, e.ID())
+	}))
+	return parser.Unparse(u.rewrite(native.Expr()), native.SourceInfo(), parser.WrapOnColumn(unparseColumn))
+}
+
+// unparser rewrites the presence tests of a compiled expression into their surface syntax.
+type unparser struct {
+	fac    ast.ExprFactory
+	types  map[int64]*types.Type
+	style  PresenceStyle
+	nextID int64
+}
+
+func (u *unparser) id() int64 {
+	u.nextID++
+	return u.nextID
+}
+
+// indexed reports whether a presence test on the operand is rendered in the index form, which is
+// only valid for maps.
+func (u *unparser) indexed(operand ast.Expr) bool {
+	t, found := u.types[operand.ID()]
+	return u.style == PresenceIndex && found && t.Kind() == types.MapKind
+}
+
+// rewrite returns a copy of the expression with its presence tests rewritten.
+func (u *unparser) rewrite(e ast.Expr) ast.Expr {
+	switch e.Kind() {
+	case ast.IdentKind:
+		attr, found := strings.CutPrefix(e.AsIdent(), presencePrefix)
+		if !found {
+			break
+		}
+		parts := strings.Split(attr, ".")
+		var operand ast.Expr = u.fac.NewIdent(u.id(), parts[0])
+		for _, field := range parts[1 : len(parts)-1] {
+			operand = u.fac.NewSelect(u.id(), operand, field)
+		}
+		return u.fac.NewPresenceTest(e.ID(), operand, parts[len(parts)-1])
+	case ast.SelectKind:
+		sel := e.AsSelect()
+		operand := u.rewrite(sel.Operand())
+		if !sel.IsTestOnly() {
+			return u.fac.NewSelect(e.ID(), operand, sel.FieldName())
+		}
+		if identifierPattern.MatchString(sel.FieldName()) && !u.indexed(sel.Operand()) {
+			return u.fac.NewPresenceTest(e.ID(), operand, sel.FieldName())
+		}
+		index := u.fac.NewCall(u.id(), operators.Index, operand, u.fac.NewLiteral(u.id(), types.String(sel.FieldName())))
+		return u.fac.NewCall(e.ID(), "has", index)
+	case ast.CallKind:
+		call := e.AsCall()
+		args := make([]ast.Expr, len(call.Args()))
+		for i, arg := range call.Args() {
+			args[i] = u.rewrite(arg)
+		}
+		if call.IsMemberFunction() {
+			return u.fac.NewMemberCall(e.ID(), call.FunctionName(), u.rewrite(call.Target()), args...)
+		}
+		return u.fac.NewCall(e.ID(), call.FunctionName(), args...)
+	case ast.ListKind:
+		list := e.AsList()
+		elems := make([]ast.Expr, len(list.Elements()))
+		for i, elem := range list.Elements() {
+			elems[i] = u.rewrite(elem)
+		}
+		return u.fac.NewList(e.ID(), elems, list.OptionalIndices())
+	}
+	return u.fac.CopyExpr(e)
+}
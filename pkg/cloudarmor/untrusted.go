@@ -0,0 +1,239 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+)
+
+// UntrustedLimits configures the limits enforced by UntrustedModeWithLimits.
+type UntrustedLimits struct {
+	// MaxExpressionLength is the maximum length of an expression in characters.
+	MaxExpressionLength int
+	// MaxRecursionDepth is the maximum depth of nested calls, selections, and parentheses.
+	MaxRecursionDepth int
+	// MaxComprehensionNesting is the maximum number of nested macros such as exists() or all().
+	MaxComprehensionNesting int
+	// MaxAttributeSize is the assumed upper bound on the length of string attributes and the
+	// number of entries in map attributes when estimating the cost of an expression.
+	MaxAttributeSize uint64
+	// MaxEstimatedCost is the maximum worst-case cost of an expression estimated at compile time.
+	MaxEstimatedCost uint64
+	// CostLimit is the maximum cost of a single evaluation.
+	CostLimit uint64
+	// EvalTimeout is the maximum duration of a single evaluation.
+	EvalTimeout time.Duration
+	// InterruptCheckFrequency is the number of comprehension iterations between checks for an
+	// expired evaluation.
+	InterruptCheckFrequency uint
+	// MaxStringSize is the maximum length of a string produced by a string function or by
+	// concatenation.
+	MaxStringSize int
+	// BannedFunctions are the functions which may not be called, e.g. "matches".
+	BannedFunctions []string
+}
+
+// DefaultUntrustedLimits returns the limits applied by UntrustedMode.
+func DefaultUntrustedLimits() UntrustedLimits {
+	return UntrustedLimits{
+		MaxExpressionLength:     MaxExpressionLength,
+		MaxRecursionDepth:       32,
+		MaxComprehensionNesting: 1,
+		MaxAttributeSize:        8192,
+		MaxEstimatedCost:        1000000,
+		CostLimit:               1000000,
+		EvalTimeout:             10 * time.Millisecond,
+		InterruptCheckFrequency: 100,
+		MaxStringSize:           65536,
+	}
+}
+
+// UntrustedMode hardens the Rules for services which compile and evaluate expressions submitted
+// by untrusted users, using DefaultUntrustedLimits.
+//
+// At compile time, expressions are rejected when they exceed the maximum length, recursion depth,
+// or comprehension nesting, call a banned function, or have an estimated worst-case cost above
+// the maximum given attributes no larger than MaxAttributeSize.
+//
+// At evaluation time, programs return an error rather than a result when an evaluation exceeds
+// the cost limit, runs for longer than the evaluation timeout, or produces a string longer than
+// the maximum string size. Together these bound the CPU time and memory consumed by any single
+// evaluation regardless of the expression or the input.
+func UntrustedMode() RulesOption {
+	return UntrustedModeWithLimits(DefaultUntrustedLimits())
+}
+
+// UntrustedModeWithLimits hardens the Rules as described by UntrustedMode using the given limits.
+func UntrustedModeWithLimits(limits UntrustedLimits) RulesOption {
+	return func(r *Rules) (*Rules, error) {
+		if limits.MaxExpressionLength <= 0 || limits.MaxRecursionDepth <= 0 || limits.MaxComprehensionNesting < 0 ||
+			limits.CostLimit == 0 || limits.MaxEstimatedCost == 0 || limits.EvalTimeout <= 0 ||
+			limits.InterruptCheckFrequency == 0 || limits.MaxStringSize <= 0 {
+			return nil, fmt.Errorf("invalid untrusted limits: %+v", limits)
+		}
+		r.untrusted = &limits
+		return r, nil
+	}
+}
+
+// untrustedCompileOptions returns the environment options which enforce the compile-time limits.
+func (r *Rules) untrustedCompileOptions() []cel.EnvOption {
+	if r.untrusted == nil {
+		return nil
+	}
+	opts := []cel.EnvOption{
+		cel.ParserRecursionLimit(r.untrusted.MaxRecursionDepth),
+		cel.ParserExpressionSizeLimit(r.untrusted.MaxExpressionLength),
+		cel.ASTValidators(cel.ValidateComprehensionNestingLimit(r.untrusted.MaxComprehensionNesting)),
+	}
+	if len(r.untrusted.BannedFunctions) != 0 {
+		banned := map[string]bool{}
+		for _, fn := range r.untrusted.BannedFunctions {
+			banned[fn] = true
+		}
+		opts = append(opts, cel.ASTValidators(bannedFunctionValidator{banned: banned}))
+	}
+	return opts
+}
+
+// checkEstimatedCost rejects the expression if its worst-case cost exceeds the configured
+// maximum.
+func (r *Rules) checkEstimatedCost(a *cel.Ast) error {
+	if r.untrusted == nil {
+		return nil
+	}
+	est, err := r.env.EstimateCost(a, attributeSizeEstimator{maxSize: r.untrusted.MaxAttributeSize})
+	if err != nil {
+		return err
+	}
+	if est.Max > r.untrusted.MaxEstimatedCost {
+		return fmt.Errorf("estimated cost %d exceeds the maximum of %d", est.Max, r.untrusted.MaxEstimatedCost)
+	}
+	return nil
+}
+
+// untrustedProgramOptions returns the program options which enforce the evaluation limits.
+func (r *Rules) untrustedProgramOptions() []cel.ProgramOption {
+	if r.untrusted == nil {
+		return nil
+	}
+	return []cel.ProgramOption{
+		cel.CostLimit(r.untrusted.CostLimit),
+		cel.InterruptCheckFrequency(r.untrusted.InterruptCheckFrequency),
+		cel.CustomDecorator(stringSizeDecorator(r.untrusted.MaxStringSize)),
+	}
+}
+
+// bannedFunctionValidator reports calls to banned functions.
+type bannedFunctionValidator struct {
+	banned map[string]bool
+}
+
+// Name implements the cel.ASTValidator interface.
+func (bannedFunctionValidator) Name() string {
+	return "cloudarmor.validator.banned_functions"
+}
+
+// Validate implements the cel.ASTValidator interface.
+func (v bannedFunctionValidator) Validate(_ *cel.Env, _ cel.ValidatorConfig, a *ast.AST, iss *cel.Issues) {
+	for _, e := range ast.MatchDescendants(ast.NavigateAST(a), ast.KindMatcher(ast.CallKind)) {
+		if fn := e.AsCall().FunctionName(); v.banned[fn] {
+			iss.ReportErrorAtID(e.ID(), "function '%s' is not permitted", fn)
+		}
+	}
+}
+
+// attributeSizeEstimator bounds the size of string and map attributes so that the cost estimate
+// of an expression is finite.
+type attributeSizeEstimator struct {
+	maxSize uint64
+}
+
+// EstimateSize implements the checker.CostEstimator interface.
+func (e attributeSizeEstimator) EstimateSize(checker.AstNode) *checker.SizeEstimate {
+	return &checker.SizeEstimate{Min: 0, Max: e.maxSize}
+}
+
+// EstimateCallCost implements the checker.CostEstimator interface.
+func (attributeSizeEstimator) EstimateCallCost(string, string, *checker.AstNode, []checker.AstNode) *checker.CallEstimate {
+	return nil
+}
+
+// stringFunctions contains the functions whose string results are subject to MaxStringSize.
+var stringFunctions = map[string]bool{
+	"_+_":           true,
+	"lower":         true,
+	"upper":         true,
+	"base64Decode":  true,
+	"urlDecode":     true,
+	"urlDecodeUni":  true,
+	"utf8ToUnicode": true,
+	"toPunycode":    true,
+	"fromPunycode":  true,
+}
+
+// stringSizeDecorator wraps calls to string functions so that results longer than maxSize are
+// replaced with an error.
+func stringSizeDecorator(maxSize int) interpreter.InterpretableDecorator {
+	return func(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+		call, ok := i.(interpreter.InterpretableCall)
+		if !ok || !stringFunctions[call.Function()] {
+			return i, nil
+		}
+		return &stringSizeLimit{InterpretableCall: call, maxSize: maxSize}, nil
+	}
+}
+
+type stringSizeLimit struct {
+	interpreter.InterpretableCall
+	maxSize int
+}
+
+// Eval implements the interpreter.Interpretable interface.
+func (s *stringSizeLimit) Eval(act interpreter.Activation) ref.Val {
+	out := s.InterpretableCall.Eval(act)
+	if str, ok := out.(types.String); ok && len(str) > s.maxSize {
+		return types.LabelErrNode(s.ID(), evalErr(ErrorStringTooLong, "%s produced a string of %d bytes, exceeding the maximum of %d",
+			s.Function(), len(str), s.maxSize))
+	}
+	return out
+}
+
+// timeoutProgram bounds the duration of each evaluation of the wrapped program.
+type timeoutProgram struct {
+	cel.Program
+	timeout time.Duration
+}
+
+// Eval implements the cel.Program interface.
+func (p *timeoutProgram) Eval(input any) (ref.Val, *cel.EvalDetails, error) {
+	return p.ContextEval(context.Background(), input)
+}
+
+// ContextEval implements the cel.Program interface.
+func (p *timeoutProgram) ContextEval(ctx context.Context, input any) (ref.Val, *cel.EvalDetails, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.Program.ContextEval(ctx, input)
+}
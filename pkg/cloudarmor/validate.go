@@ -0,0 +1,190 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudarmor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Violation describes an attribute whose value could never be observed by a Cloud Armor rule.
+type Violation struct {
+	Attribute string
+	Message   string
+}
+
+// String implements the fmt.Stringer interface.
+func (v Violation) String() string {
+	return v.Attribute + ": " + v.Message
+}
+
+// ValidationError reports the violations found within a set of variables.
+type ValidationError struct {
+	Violations []Violation
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return "invalid variables: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks that the variables hold values which Cloud Armor could present to a rule, so
+// that test fixtures with typos such as an unparseable IP address or a lowercase region code fail
+// loudly rather than silently exercising an impossible request.
+//
+// Unset attributes are not checked. The checks are:
+//
+//   - origin.ip, origin.user_ip, and connection.dst_ip are IPv4 or IPv6 addresses without a
+//     zone identifier.
+//   - origin.region_code is an ISO 3166-1 alpha-2 code, e.g. "US".
+//   - origin.asn is within the 32-bit ASN range.
+//   - origin.tls_ja3_fingerprint is 32 lowercase hex digits, and origin.tls_ja4_fingerprint has
+//     the form of a JA4 fingerprint, e.g. "t13d1516h2_8daaf6152771_b186095e22b6".
+//   - request.scheme is either "http" or "https".
+//   - request.headers keys are lowercase, as Cloud Armor presents header names in lowercase.
+//
+// Header keys are lowercased by SafeVariables, which retains the original keys, so Validate
+// should be called on variables before SafeVariables is applied.
+//
+// The returned violations are sorted by attribute and are empty when the variables are valid.
+func (v *Variables) Validate() []Violation {
+	var vs []Violation
+	report := func(attr, format string, args ...any) {
+		vs = append(vs, Violation{Attribute: attr, Message: fmt.Sprintf(format, args...)})
+	}
+	checkIP := func(attr, ip string) {
+		if ip == "" {
+			return
+		}
+		if _, err := parseIP(ip); errors.Is(err, errIPZone) {
+			report(attr, "%q has a zone identifier, which Cloud Armor never presents", ip)
+		} else if err != nil {
+			report(attr, "%q is not an IP address", ip)
+		}
+	}
+	if o := v.Origin; o != nil {
+		checkIP("origin.ip", o.IP)
+		checkIP("origin.user_ip", o.UserIP)
+		if o.RegionCode != "" {
+			if err := CheckRegionCode(o.RegionCode); err != nil {
+				report("origin.region_code", "%v", err)
+			}
+		}
+		if err := CheckASN(o.ASN); err != nil {
+			report("origin.asn", "%v", err)
+		}
+		if o.TLSJA3Fingerprint != "" {
+			if err := CheckJA3Fingerprint(o.TLSJA3Fingerprint); err != nil {
+				report("origin.tls_ja3_fingerprint", "%v", err)
+			}
+		}
+		if o.TLSJA4Fingerprint != "" {
+			if err := CheckJA4Fingerprint(o.TLSJA4Fingerprint); err != nil {
+				report("origin.tls_ja4_fingerprint", "%v", err)
+			}
+		}
+	}
+	if r := v.Request; r != nil {
+		if r.Scheme != "" && r.Scheme != "http" && r.Scheme != "https" {
+			report("request.scheme", "%q must be either \"http\" or \"https\"", r.Scheme)
+		}
+		for k := range r.Headers {
+			if k != strings.ToLower(k) {
+				report("request.headers", "key %q must be lowercase, e.g. %q", k, strings.ToLower(k))
+			}
+		}
+	}
+	if c := v.Connection; c != nil {
+		checkIP("connection.dst_ip", c.DstIP)
+		for attr, port := range map[string]int64{"connection.src_port": c.SrcPort, "connection.dst_port": c.DstPort} {
+			if port < 0 || port > math.MaxUint16 {
+				report(attr, "%d is outside the range 0 to %d", port, math.MaxUint16)
+			}
+		}
+		if c.Protocol != "" && c.Protocol != strings.ToUpper(c.Protocol) {
+			report("connection.protocol", "%q must be uppercase, e.g. %q", c.Protocol, strings.ToUpper(c.Protocol))
+		}
+		if c.Bytes < 0 {
+			report("connection.bytes", "%d must not be negative", c.Bytes)
+		}
+	}
+	if r := v.Response; r != nil {
+		if r.StatusCode != 0 && (r.StatusCode < 100 || r.StatusCode > 599) {
+			report("response.status_code", "%d is not an HTTP status code", r.StatusCode)
+		}
+		for k := range r.Headers {
+			if k != strings.ToLower(k) {
+				report("response.headers", "key %q must be lowercase, e.g. %q", k, strings.ToLower(k))
+			}
+		}
+	}
+	if ap := v.AdaptiveProtection; ap != nil && (ap.AttackLikelihood < 0 || ap.AttackLikelihood > 1) {
+		report("adaptive_protection.attack_likelihood", "%v is outside the range 0 to 1", ap.AttackLikelihood)
+	}
+	sort.SliceStable(vs, func(i, j int) bool {
+		if vs[i].Attribute != vs[j].Attribute {
+			return vs[i].Attribute < vs[j].Attribute
+		}
+		return vs[i].Message < vs[j].Message
+	})
+	return vs
+}
+
+// validationError returns a ValidationError for the variables if they have any violations.
+func (v *Variables) validationError() error {
+	if vs := v.Validate(); len(vs) != 0 {
+		return &ValidationError{Violations: vs}
+	}
+	return nil
+}
+
+// validateTestCase rejects test cases which expect both a result and an error, returns the
+// non-string header values of the test case's variables when StrictHeaderValues is set, and
+// their violations when validation is enabled. The variables are normalized as configured by
+// NormalizeRequests before they are validated.
+func (o *yamlOptions) validateTestCase(t *TestCase) error {
+	if t.ExpectOutput && t.ExpectError != "" {
+		return fmt.Errorf("test case %q has both expect and error", t.Name)
+	}
+	if t.ExpectErrorCode != "" {
+		if t.ExpectOutput {
+			return fmt.Errorf("test case %q has both expect and error_code", t.Name)
+		}
+		if _, err := ParseErrorCode(string(t.ExpectErrorCode)); err != nil {
+			return fmt.Errorf("test case %q: %w", t.Name, err)
+		}
+	}
+	if t.When == nil {
+		return nil
+	}
+	if err := o.checkHeaders(t.When); err != nil {
+		return fmt.Errorf("test case %q: %w", t.Name, err)
+	}
+	t.When.Normalize(o.normalization)
+	if !o.validate {
+		return nil
+	}
+	if err := t.When.validationError(); err != nil {
+		return fmt.Errorf("test case %q: %w", t.Name, err)
+	}
+	return nil
+}
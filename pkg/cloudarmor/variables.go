@@ -17,6 +17,7 @@ package cloudarmor
 import (
 	"strings"
 
+	"github.com/google/cel-go/common/types/ref"
 	"github.com/google/cel-go/interpreter"
 	"gopkg.in/yaml.v3"
 )
@@ -27,6 +28,22 @@ type Variables struct {
 	Request *Request `yaml:"request"`
 	Origin  *Origin  `yaml:"origin"`
 	Token   *Token   `yaml:"token"`
+	// Connection holds the L3/L4 attributes of network edge policies, see ProfileNetwork.
+	Connection *Connection `yaml:"connection"`
+	// Response holds the response attributes of the experimental ProfileResponse.
+	Response *Response `yaml:"response"`
+	// AdaptiveProtection holds the preview Adaptive Protection signals available in VNext.
+	AdaptiveProtection *AdaptiveProtection `yaml:"adaptive_protection"`
+
+	// vals holds the precomputed CEL values for scalar attributes so that hot attributes do not
+	// need to be adapted from Go-native types on every access. Populated by SafeVariables.
+	vals map[string]cachedVal
+	// unset records the attribute subtrees which were nil prior to SafeVariables.
+	unset []string
+	// present records the attributes which were set when decoded from YAML.
+	present map[string]bool
+	// coercedHeaders records the header values which were not strings when decoded from YAML.
+	coercedHeaders []*HeaderValueError
 }
 
 // VariablesFromYAML converts a YAML representation of the variables to a Variables type.
@@ -35,16 +52,32 @@ type Variables struct {
 // are expected to match the names that are defined in the Cloud Armor expression language.
 //
 // The return value is the Variables type or an error if the YAML is invalid.
-func VariablesFromYAML(yamlBytes []byte) (*Variables, error) {
+func VariablesFromYAML(yamlBytes []byte, opts ...YAMLOption) (*Variables, error) {
+	o := newYAMLOptions(opts)
+	if o.strict {
+		if err := checkSchema(yamlBytes, &plainVariables{}); err != nil {
+			return nil, err
+		}
+	}
 	v := &Variables{}
 	if err := yaml.Unmarshal(yamlBytes, v); err != nil {
 		return nil, err
 	}
+	if err := o.checkHeaders(v); err != nil {
+		return nil, err
+	}
+	v.Normalize(o.normalization)
+	if o.validate {
+		if err := v.validationError(); err != nil {
+			return nil, err
+		}
+	}
 	return SafeVariables(v), nil
 }
 
 // SafeVariables ensures that all of the variables are initialized to their default values.
 func SafeVariables(v *Variables) *Variables {
+	v.unset = append(v.unset, v.unsetSubtrees()...)
 	if v.Request == nil {
 		v.Request = &Request{}
 	}
@@ -54,6 +87,9 @@ func SafeVariables(v *Variables) *Variables {
 	for k, val := range v.Request.Headers {
 		v.Request.Headers[strings.ToLower(k)] = val
 	}
+	if host, found := v.Request.Headers["host"]; found {
+		v.Request.Headers["host"] = normalizeHost(host)
+	}
 	if v.Request.Params == nil {
 		v.Request.Params = make(map[string]any)
 	}
@@ -72,9 +108,61 @@ func SafeVariables(v *Variables) *Variables {
 	if v.Token.RecaptchaSession == nil {
 		v.Token.RecaptchaSession = &RecaptchaSession{}
 	}
+	if v.Connection == nil {
+		v.Connection = &Connection{}
+	}
+	if v.Response == nil {
+		v.Response = &Response{}
+	}
+	if v.Response.Headers == nil {
+		v.Response.Headers = make(Headers)
+	}
+	for k, val := range v.Response.Headers {
+		v.Response.Headers[strings.ToLower(k)] = val
+	}
+	if v.AdaptiveProtection == nil {
+		v.AdaptiveProtection = &AdaptiveProtection{}
+	}
+	v.vals = precomputeVals(v)
 	return v
 }
 
+// cachedVal is a precomputed CEL value together with the Go value it was converted from.
+type cachedVal struct {
+	src any
+	val ref.Val
+}
+
+// precomputeVals converts the scalar attributes to their CEL value equivalents once so that
+// repeated evaluations against the same Variables do not allocate per attribute access.
+//
+// The source of each value is retained so that ResolveName can detect fields which have been
+// changed after SafeVariables and convert their current value instead.
+func precomputeVals(v *Variables) map[string]cachedVal {
+	vals := make(map[string]cachedVal, len(attributes))
+	for name, attr := range attributes {
+		if val := attr.celValue(v); val != nil {
+			vals[name] = cachedVal{src: attr.value(v), val: val}
+		}
+	}
+	return vals
+}
+
+// previewAttributes are the attributes declared for drafting rules ahead of their availability
+// within Cloud Armor.
+var previewAttributes = []string{
+	"adaptive_protection.attack_likelihood",
+	"adaptive_protection.attack_signatures",
+}
+
+// PreviewAttributes returns the VNext attributes which are not yet exposed by Cloud Armor.
+//
+// Expressions referring to these attributes can be compiled and tested so that rules may be
+// prepared in advance, but they cannot be deployed until Cloud Armor supports the attributes.
+func PreviewAttributes() []string {
+	return append([]string(nil), previewAttributes...)
+}
+
 // ResolveName resolves the given name to a value in the variables container.
 //
 // The name is expected to be in the format of the variables that are defined in the Cloud Armor
@@ -82,50 +170,19 @@ func SafeVariables(v *Variables) *Variables {
 //
 // The return value is the resolved value and a boolean indicating if the name was resolved.
 func (v *Variables) ResolveName(name string) (any, bool) {
-	switch name {
-	case "request.method":
-		return v.Request.Method, true
-	case "request.headers":
-		return v.Request.Headers, true
-	case "request.path":
-		return v.Request.Path, true
-	case "request.query":
-		return v.Request.Query, true
-	case "request.scheme":
-		return v.Request.Scheme, true
-	case "request.params":
-		return v.Request.Params, true
-	case "request.body":
-		return v.Request.Body, true
-	case "origin.ip":
-		return v.Origin.IP, true
-	case "origin.region_code":
-		return v.Origin.RegionCode, true
-	case "origin.asn":
-		return v.Origin.ASN, true
-	case "origin.user_ip":
-		return v.Origin.UserIP, true
-	case "origin.tls_ja3_fingerprint":
-		return v.Origin.TLSJA3Fingerprint, true
-	case "origin.tls_ja4_fingerprint":
-		return v.Origin.TLSJA4Fingerprint, true
-	case "token.recaptcha_exemption.valid":
-		return v.Token.RecaptchaExemption.Valid, true
-	case "token.recaptcha_action.score":
-		return v.Token.RecaptchaAction.Score, true
-	case "token.recaptcha_action.captcha_status":
-		return v.Token.RecaptchaAction.CaptchaStatus, true
-	case "token.recaptcha_action.action":
-		return v.Token.RecaptchaAction.Action, true
-	case "token.recaptcha_action.valid":
-		return v.Token.RecaptchaAction.Valid, true
-	case "token.recaptcha_session.score":
-		return v.Token.RecaptchaSession.Score, true
-	case "token.recaptcha_session.valid":
-		return v.Token.RecaptchaSession.Valid, true
-	default:
+	attr, found := attributes[name]
+	if !found {
 		return nil, false
 	}
+	if c, found := v.vals[name]; found {
+		if attr.holds(v, c.src) {
+			return c.val, true
+		}
+		if val := attr.celValue(v); val != nil {
+			return val, true
+		}
+	}
+	return attr.value(v), true
 }
 
 // Parent returns nil as hierarchical context building is not supported within Cloud Armor.
@@ -163,6 +220,11 @@ type Request struct {
 	Scheme  string            `yaml:"scheme"`
 	Params  map[string]any    `yaml:"params"`
 	Body    string            `yaml:"body"`
+	// BackendService and MatchedURLMap identify the backend service and URL map which the load
+	// balancer routed the request to. They are available in VNext for analyzing replayed load
+	// balancer logs.
+	BackendService string `yaml:"backend_service"`
+	MatchedURLMap  string `yaml:"matched_url_map"`
 }
 
 // Origin represents the origin attributes available to the Cloud Armor expression.
@@ -193,3 +255,33 @@ type RecaptchaSession struct {
 	Score float64 `yaml:"score"`
 	Valid bool    `yaml:"valid"`
 }
+
+// Connection represents the L3/L4 attributes available to network edge policy expressions.
+type Connection struct {
+	SrcPort int64  `yaml:"src_port"`
+	DstIP   string `yaml:"dst_ip"`
+	DstPort int64  `yaml:"dst_port"`
+	// Protocol is the IP protocol name, e.g. "TCP", "UDP", or "ICMP".
+	Protocol string `yaml:"protocol"`
+	// Bytes is the size of the packet in bytes.
+	Bytes int64 `yaml:"bytes"`
+}
+
+// Response represents the response attributes available to expressions of the experimental
+// ProfileResponse, which cannot be deployed to Cloud Armor.
+type Response struct {
+	StatusCode int64             `yaml:"status_code"`
+	Headers    map[string]string `yaml:"headers"`
+}
+
+// AdaptiveProtection represents the preview Adaptive Protection signals available to VNext
+// expressions.
+//
+// These attributes are not yet exposed by Cloud Armor; they allow rules which act on Adaptive
+// Protection alerts to be drafted and tested ahead of the feature. See PreviewAttributes.
+type AdaptiveProtection struct {
+	// AttackLikelihood is the confidence, between 0 and 1, that the request is part of an attack.
+	AttackLikelihood float64 `yaml:"attack_likelihood"`
+	// AttackSignatures are the tags of the attack signatures which the request matched.
+	AttackSignatures []string `yaml:"attack_signatures"`
+}
@@ -15,10 +15,15 @@
 package cloudarmor_test
 
 import (
+	"errors"
+	"math"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/cel-expr/cloud-armor-rules/pkg/cloudarmor"
+
+	"github.com/google/cel-go/common/types"
 )
 
 func TestVariablesFromYAML(t *testing.T) {
@@ -88,3 +93,547 @@ func TestRequestParams(t *testing.T) {
 		t.Errorf("v.Request.Params['nested']['key2'] = %v, want nestedvalue", nestedParams["key2"])
 	}
 }
+
+func TestVariablesFromYAMLStrict(t *testing.T) {
+	varBytes, err := os.ReadFile("../../test/variables.yaml")
+	if err != nil {
+		t.Fatalf("os.ReadFile() returned error: %v", err)
+	}
+	if _, err := cloudarmor.VariablesFromYAML(varBytes, cloudarmor.StrictYAML()); err != nil {
+		t.Errorf("cloudarmor.VariablesFromYAML() returned error: %v", err)
+	}
+	_, err = cloudarmor.VariablesFromYAML([]byte("request:\n  methd: GET\n"), cloudarmor.StrictYAML())
+	if err == nil || !strings.Contains(err.Error(), "line 2: field methd not found") {
+		t.Errorf("cloudarmor.VariablesFromYAML() returned error %v, wanted unknown field methd on line 2", err)
+	}
+}
+
+func BenchmarkResolveName(b *testing.B) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		b.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := rules.Compile(`request.method == 'GET' && request.path.startsWith('/search') &&
+		origin.asn == 15169 && token.recaptcha_action.score >= 0.5`)
+	if err != nil {
+		b.Fatalf("rules.Compile() returned error: %v", err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		b.Fatalf("rules.Program() returned error: %v", err)
+	}
+	newVars := func() *cloudarmor.Variables {
+		return &cloudarmor.Variables{
+			Request: &cloudarmor.Request{Method: "GET", Path: "/search"},
+			Origin:  &cloudarmor.Origin{ASN: 15169},
+			Token: &cloudarmor.Token{
+				RecaptchaAction:    &cloudarmor.RecaptchaAction{Score: 0.9},
+				RecaptchaExemption: &cloudarmor.RecaptchaExemption{},
+				RecaptchaSession:   &cloudarmor.RecaptchaSession{},
+			},
+		}
+	}
+	b.Run("native", func(b *testing.B) {
+		vars := newVars()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			prg.Eval(vars)
+		}
+	})
+	b.Run("precomputed", func(b *testing.B) {
+		vars := cloudarmor.SafeVariables(newVars())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			prg.Eval(vars)
+		}
+	})
+}
+
+func TestNonFiniteScores(t *testing.T) {
+	for _, doc := range []string{
+		"token:\n  recaptcha_action:\n    score: .nan\n",
+		"token:\n  recaptcha_session:\n    score: -.inf\n",
+	} {
+		_, err := cloudarmor.VariablesFromYAML([]byte(doc))
+		var scoreErr *cloudarmor.NonFiniteScoreError
+		if !errors.As(err, &scoreErr) {
+			t.Errorf("cloudarmor.VariablesFromYAML(%q) got error %v, wanted NonFiniteScoreError", doc, err)
+		}
+	}
+	suite := "expr: \"true\"\ntests:\n  - name: nan\n    expect: true\n    when:\n      token:\n        recaptcha_action:\n          score: .NaN\n"
+	if _, err := cloudarmor.TestSuiteFromYAML([]byte(suite)); err == nil || !strings.Contains(err.Error(), "must be a finite number") {
+		t.Errorf("cloudarmor.TestSuiteFromYAML() got error %v, wanted non-finite score error", err)
+	}
+
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Method: "GET"},
+		Token: &cloudarmor.Token{
+			RecaptchaAction: &cloudarmor.RecaptchaAction{Score: math.NaN()},
+		},
+	})
+	for _, expr := range []string{"token.recaptcha_action.score < 0.5", "token.recaptcha_action.score >= 0.5"} {
+		ast, err := rules.Compile(expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program(%q) returned error: %v", expr, err)
+		}
+		var scoreErr *cloudarmor.NonFiniteScoreError
+		if out, _, err := prg.Eval(vars); !errors.As(err, &scoreErr) {
+			t.Errorf("prg.Eval(%q) = %v, %v, wanted NonFiniteScoreError", expr, out, err)
+		}
+	}
+	ast, err := rules.Compile("request.method == 'GET'")
+	if err != nil {
+		t.Fatalf("rules.Compile() returned error: %v", err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	if out, _, err := prg.Eval(vars); err != nil || out != types.True {
+		t.Errorf("prg.Eval() = %v, %v, wanted true for a rule which does not read the score", out, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	v := &cloudarmor.Variables{
+		Request: &cloudarmor.Request{
+			Scheme:  "ftp",
+			Headers: map[string]string{"Host": "example.com", "user-agent": "curl"},
+		},
+		Origin: &cloudarmor.Origin{
+			IP:         "1.2.3",
+			UserIP:     "2001:db8::1",
+			RegionCode: "us",
+			ASN:        1 << 33,
+		},
+	}
+	var got []string
+	for _, vi := range v.Validate() {
+		got = append(got, vi.String())
+	}
+	want := []string{
+		`origin.asn: 8589934592 is outside the range 0 to 4294967295`,
+		`origin.ip: "1.2.3" is not an IP address`,
+		`origin.region_code: "us" must be uppercase, e.g. "US"`,
+		`request.headers: key "Host" must be lowercase, e.g. "host"`,
+		`request.scheme: "ftp" must be either "http" or "https"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("v.Validate() = %q, wanted %q", got, want)
+	}
+	if vs := (&cloudarmor.Variables{Origin: &cloudarmor.Origin{RegionCode: "ZZ"}}).Validate(); len(vs) != 1 ||
+		!strings.Contains(vs[0].Message, "not an ISO 3166-1 alpha-2 region code") {
+		t.Errorf("Validate() of region code ZZ = %v, wanted one region code violation", vs)
+	}
+	if vs := (&cloudarmor.Variables{Origin: &cloudarmor.Origin{
+		TLSJA3Fingerprint: "E7D705A3286E19EA42F587B344EE6865",
+		TLSJA4Fingerprint: "t13d1516h2_8daaf6152771_b186095e22b6",
+	}}).Validate(); len(vs) != 1 || vs[0].Attribute != "origin.tls_ja3_fingerprint" || !strings.Contains(vs[0].Message, "must be lowercase") {
+		t.Errorf("Validate() of an uppercase JA3 fingerprint = %v, wanted one lowercase violation", vs)
+	}
+	if vs := (&cloudarmor.Variables{Origin: &cloudarmor.Origin{RegionCode: "UK"}}).Validate(); len(vs) != 1 ||
+		!strings.Contains(vs[0].Message, `did you mean "GB"`) {
+		t.Errorf("Validate() of region code UK = %v, wanted a violation suggesting GB", vs)
+	}
+	if vs := (&cloudarmor.Variables{Origin: &cloudarmor.Origin{IP: "fe80::1%eth0", UserIP: "::ffff:1.2.3.4"}}).Validate(); len(vs) != 1 ||
+		vs[0].Attribute != "origin.ip" || !strings.Contains(vs[0].Message, "zone identifier") {
+		t.Errorf("Validate() of a zoned origin.ip = %v, wanted one zone identifier violation", vs)
+	}
+	if vs := (&cloudarmor.Variables{}).Validate(); len(vs) != 0 {
+		t.Errorf("Validate() of empty variables = %v, wanted no violations", vs)
+	}
+}
+
+func TestValidateVariablesOption(t *testing.T) {
+	doc := []byte("origin:\n  ip: 10.0.0.300\n")
+	if _, err := cloudarmor.VariablesFromYAML(doc); err != nil {
+		t.Errorf("cloudarmor.VariablesFromYAML() returned error: %v", err)
+	}
+	_, err := cloudarmor.VariablesFromYAML(doc, cloudarmor.ValidateVariables())
+	var valErr *cloudarmor.ValidationError
+	if !errors.As(err, &valErr) || len(valErr.Violations) != 1 || valErr.Violations[0].Attribute != "origin.ip" {
+		t.Errorf("cloudarmor.VariablesFromYAML() got error %v, wanted origin.ip ValidationError", err)
+	}
+	suite := []byte("expr: \"true\"\ntests:\n  - name: bad-scheme\n    expect: true\n    when:\n      request:\n        scheme: HTTPS\n")
+	_, err = cloudarmor.TestSuiteFromYAML(suite, cloudarmor.ValidateVariables())
+	if err == nil || !strings.Contains(err.Error(), `test case "bad-scheme"`) || !strings.Contains(err.Error(), "request.scheme") {
+		t.Errorf("cloudarmor.TestSuiteFromYAML() got error %v, wanted request.scheme violation for bad-scheme", err)
+	}
+}
+
+func TestHeaderValues(t *testing.T) {
+	doc := []byte("request:\n  headers:\n    content-length: 0x10\n    x-retry: true\n    x-ratio: 1.50\n    x-empty: ~\n")
+	vars, err := cloudarmor.VariablesFromYAML(doc)
+	if err != nil {
+		t.Fatalf("cloudarmor.VariablesFromYAML() returned error: %v", err)
+	}
+	want := map[string]string{"content-length": "0x10", "x-retry": "true", "x-ratio": "1.50", "x-empty": ""}
+	for k, v := range want {
+		if got := vars.Request.Headers[k]; got != v {
+			t.Errorf("request.headers[%q] = %q, wanted %q", k, got, v)
+		}
+	}
+
+	_, err = cloudarmor.VariablesFromYAML(doc, cloudarmor.StrictHeaderValues())
+	var hvErr *cloudarmor.HeaderValueError
+	if !errors.As(err, &hvErr) || hvErr.Key != "content-length" || hvErr.Kind != "!!int" || hvErr.Line != 3 {
+		t.Errorf("cloudarmor.VariablesFromYAML() got error %v, wanted content-length HeaderValueError", err)
+	}
+
+	list := []byte("response:\n  status: 200\n  headers:\n    set-cookie:\n      - a=1\n      - b=2\n")
+	_, err = cloudarmor.VariablesFromYAML(list)
+	if err == nil || err.Error() != `line 5: response.headers["set-cookie"] must be a string, got a list` {
+		t.Errorf("cloudarmor.VariablesFromYAML() got error %v, wanted set-cookie list error", err)
+	}
+
+	suite := []byte("expr: \"true\"\ntests:\n  - name: numeric\n    expect: true\n    when:\n      request:\n        headers:\n          x-count: 3\n")
+	if _, err := cloudarmor.TestSuiteFromYAML(suite); err != nil {
+		t.Errorf("cloudarmor.TestSuiteFromYAML() returned error: %v", err)
+	}
+	_, err = cloudarmor.TestSuiteFromYAML(suite, cloudarmor.StrictHeaderValues())
+	if err == nil || !strings.Contains(err.Error(), `test case "numeric"`) || !strings.Contains(err.Error(), `request.headers["x-count"]`) {
+		t.Errorf("cloudarmor.TestSuiteFromYAML() got error %v, wanted x-count error for numeric", err)
+	}
+}
+
+func TestAdaptiveProtection(t *testing.T) {
+	vars, err := cloudarmor.VariablesFromYAML([]byte(`
+adaptive_protection:
+  attack_likelihood: 0.9
+  attack_signatures: ["http_flood", "sqli"]
+`))
+	if err != nil {
+		t.Fatalf("cloudarmor.VariablesFromYAML() returned error: %v", err)
+	}
+	expr := "adaptive_protection.attack_likelihood > 0.8 && adaptive_protection.attack_signatures.exists(s, s == 'sqli')"
+	current, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := current.Compile(expr); err == nil {
+		t.Errorf("rules.Compile(%q) succeeded in VCurrent, wanted an undeclared reference error", expr)
+	}
+	next, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := next.Compile(expr)
+	if err != nil {
+		t.Fatalf("rules.Compile(%q) returned error: %v", expr, err)
+	}
+	prg, err := next.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	if out, _, err := prg.Eval(vars); err != nil || out != types.True {
+		t.Errorf("prg.Eval() = %v, %v, wanted true", out, err)
+	}
+	if out, _, err := prg.Eval(cloudarmor.SafeVariables(&cloudarmor.Variables{})); err != nil || out != types.False {
+		t.Errorf("prg.Eval() without signals = %v, %v, wanted false", out, err)
+	}
+
+	for _, attr := range cloudarmor.PreviewAttributes() {
+		if _, found := vars.ResolveName(attr); !found {
+			t.Errorf("vars.ResolveName(%q) not found", attr)
+		}
+	}
+	vars.AdaptiveProtection.AttackLikelihood = 1.5
+	if vs := vars.Validate(); len(vs) != 1 || vs[0].Attribute != "adaptive_protection.attack_likelihood" {
+		t.Errorf("vars.Validate() = %v, wanted an attack_likelihood violation", vs)
+	}
+	if _, err := cloudarmor.VariablesFromYAML([]byte("adaptive_protection:\n  attack_likelihood: .nan\n")); err == nil {
+		t.Error("cloudarmor.VariablesFromYAML() with a NaN attack_likelihood succeeded, wanted error")
+	}
+}
+
+func TestBackendContext(t *testing.T) {
+	vars, err := cloudarmor.VariablesFromYAML([]byte(`
+request:
+  path: /api/v1/users
+  backend_service: projects/p/global/backendServices/api
+  matched_url_map: web-map
+`))
+	if err != nil {
+		t.Fatalf("cloudarmor.VariablesFromYAML() returned error: %v", err)
+	}
+	expr := "request.backend_service.endsWith('/api') && request.matched_url_map == 'web-map'"
+	current, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	if _, err := current.Compile(expr); err == nil {
+		t.Errorf("rules.Compile(%q) succeeded in VCurrent, wanted an undeclared reference error", expr)
+	}
+	next, err := cloudarmor.NewRules(cloudarmor.Version(cloudarmor.VNext))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := next.Compile(expr)
+	if err != nil {
+		t.Fatalf("rules.Compile(%q) returned error: %v", expr, err)
+	}
+	prg, err := next.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	if out, _, err := prg.Eval(vars); err != nil || out != types.True {
+		t.Errorf("prg.Eval() = %v, %v, wanted true", out, err)
+	}
+	if out, _, err := prg.Eval(cloudarmor.SafeVariables(&cloudarmor.Variables{})); err != nil || out != types.False {
+		t.Errorf("prg.Eval() without routing context = %v, %v, wanted false", out, err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Method: "GET"},
+		Origin:  &cloudarmor.Origin{IP: "192.0.2.1", RegionCode: "GB"},
+	})
+	enriched := cloudarmor.Overlay(vars, cloudarmor.Attributes{
+		"origin.asn":         int64(64512),
+		"origin.region_code": "US",
+	})
+	expr := "request.method == 'GET' && origin.asn == 64512 && origin.region_code == 'US'"
+	for _, opts := range [][]cloudarmor.RulesOption{
+		nil,
+		{cloudarmor.Presence(cloudarmor.PresenceAbsent)},
+		{cloudarmor.WithUnknowns()},
+	} {
+		rules, err := cloudarmor.NewRules(opts...)
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+		}
+		ast, err := rules.Compile(expr)
+		if err != nil {
+			t.Fatalf("rules.Compile(%q) returned error: %v", expr, err)
+		}
+		prg, err := rules.Program(ast)
+		if err != nil {
+			t.Fatalf("rules.Program() returned error: %v", err)
+		}
+		if out, _, err := prg.Eval(enriched); err != nil || out != types.True {
+			t.Errorf("prg.Eval(overlay) with %d options = %v, %v, wanted true", len(opts), out, err)
+		}
+		if out, _, err := prg.Eval(vars); err != nil || out != types.False {
+			t.Errorf("prg.Eval(vars) with %d options = %v, %v, wanted false", len(opts), out, err)
+		}
+	}
+
+	// Presence semantics continue to apply to the attributes of the base Variables.
+	rules, err := cloudarmor.NewRules(cloudarmor.Presence(cloudarmor.PresenceAbsent))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := rules.Compile("has(request.path) || has(origin.asn)")
+	if err != nil {
+		t.Fatalf("rules.Compile() returned error: %v", err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	if out, _, err := prg.Eval(cloudarmor.Overlay(vars, cloudarmor.Attributes{})); err != nil || out != types.False {
+		t.Errorf("prg.Eval() = %v, %v, wanted false for unset attributes", out, err)
+	}
+	if out, _, err := prg.Eval(enriched); err != nil || out != types.True {
+		t.Errorf("prg.Eval() = %v, %v, wanted true for an overridden attribute", out, err)
+	}
+}
+
+func TestAttributeResolution(t *testing.T) {
+	resolvable := map[string]bool{}
+	for _, name := range cloudarmor.AttributeNames() {
+		resolvable[name] = true
+	}
+	declared := map[string]bool{}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{})
+	for _, key := range cloudarmor.Configs() {
+		if key.Profile > cloudarmor.ProfileResponse {
+			// Skip configurations registered by other tests.
+			continue
+		}
+		rules, err := cloudarmor.NewRules(cloudarmor.Profile(key.Profile), cloudarmor.Version(key.Version))
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules(%v) returned error: %v", key, err)
+		}
+		for _, v := range rules.Env().Variables() {
+			if v.Type().Kind() == types.TypeKind {
+				// Type identifiers such as int are declared alongside the attributes.
+				continue
+			}
+			declared[v.Name()] = true
+			if !resolvable[v.Name()] {
+				t.Errorf("%v declares %s, which Variables cannot resolve", key, v.Name())
+			}
+			if _, found := vars.ResolveName(v.Name()); !found {
+				t.Errorf("vars.ResolveName(%q) not found for %v", v.Name(), key)
+			}
+		}
+	}
+	for name := range resolvable {
+		if !declared[name] {
+			t.Errorf("Variables resolves %s, which no configuration declares", name)
+		}
+	}
+	if _, found := vars.ResolveName("request.unknown"); found {
+		t.Error("vars.ResolveName(request.unknown) found, wanted not found")
+	}
+	// Attributes of uninitialized subtrees resolve to their zero values.
+	if val, found := (&cloudarmor.Variables{}).ResolveName("request.method"); !found || val != "" {
+		t.Errorf("ResolveName(request.method) = %v, %v, wanted the empty string", val, found)
+	}
+}
+
+func TestResolveNameAfterMutation(t *testing.T) {
+	rules, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ast, err := rules.Compile(`request.method == 'POST' && request.path == '/login' && origin.asn == 15169`)
+	if err != nil {
+		t.Fatalf("rules.Compile() returned error: %v", err)
+	}
+	prg, err := rules.Program(ast)
+	if err != nil {
+		t.Fatalf("rules.Program() returned error: %v", err)
+	}
+	vars := cloudarmor.SafeVariables(&cloudarmor.Variables{
+		Request: &cloudarmor.Request{Method: "GET", Path: "/a/../login"},
+	})
+	if out, _, err := prg.Eval(vars); err != nil || out != types.False {
+		t.Fatalf("prg.Eval() = %v, %v, wanted false before the mutation", out, err)
+	}
+	vars.Request.Method = "POST"
+	vars.Origin = &cloudarmor.Origin{ASN: 15169}
+	vars.Normalize(cloudarmor.NormalizeGFE)
+	if out, _, err := prg.Eval(vars); err != nil || out != types.True {
+		t.Errorf("prg.Eval() = %v, %v, wanted true after mutating the fields", out, err)
+	}
+}
+
+func TestVerifyEnvBindings(t *testing.T) {
+	for _, key := range cloudarmor.Configs() {
+		if key.Profile > cloudarmor.ProfileResponse {
+			continue
+		}
+		rules, err := cloudarmor.NewRules(cloudarmor.Profile(key.Profile), cloudarmor.Version(key.Version),
+			cloudarmor.Presence(cloudarmor.PresenceAbsent))
+		if err != nil {
+			t.Fatalf("cloudarmor.NewRules(%v) returned error: %v", key, err)
+		}
+		if err := cloudarmor.VerifyEnvBindings(rules); err != nil {
+			t.Errorf("cloudarmor.VerifyEnvBindings(%v) returned error: %v", key, err)
+		}
+	}
+
+	mismatched := cloudarmor.PolicyProfile(101)
+	config := `
+name: mismatched
+stdlib:
+  include_functions:
+    - name: _&&_
+variables:
+  - name: request.method
+    type_name: int
+  - name: request.headers
+    type_name: map
+    params:
+      - type_name: string
+      - type_name: string
+  - name: request.missing
+    type_name: string
+`
+	if err := cloudarmor.RegisterConfig(mismatched, cloudarmor.VCurrent, config); err != nil {
+		t.Fatalf("cloudarmor.RegisterConfig() returned error: %v", err)
+	}
+	rules, err := cloudarmor.NewRules(cloudarmor.Profile(mismatched))
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	err = cloudarmor.VerifyEnvBindings(rules)
+	if err == nil {
+		t.Fatal("cloudarmor.VerifyEnvBindings() succeeded, wanted error")
+	}
+	want := "request.method: declared as int but resolved from a field of type string\n" +
+		"request.missing: declared as string but not resolved by Variables"
+	if err.Error() != want {
+		t.Errorf("cloudarmor.VerifyEnvBindings() = %q, wanted %q", err.Error(), want)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     string
+		path, query string
+		wantPath    string
+		wantQuery   string
+	}{
+		{name: "none", profile: "none", path: "/a//b/../%7e", query: "q=%2e", wantPath: "/a//b/../%7e", wantQuery: "q=%2e"},
+		{name: "gfe-unreserved", profile: "gfe", path: "/%7euser/%41dmin", wantPath: "/~user/Admin"},
+		{name: "gfe-reserved", profile: "gfe", path: "/a%2fb%3F", wantPath: "/a%2Fb%3F"},
+		{name: "gfe-dot-segments", profile: "gfe", path: "/static/%2E%2E/admin/./login", wantPath: "/admin/login"},
+		{name: "gfe-above-root", profile: "gfe", path: "/../../etc/passwd", wantPath: "/etc/passwd"},
+		{name: "gfe-trailing-dots", profile: "gfe", path: "/a/b/..", wantPath: "/a/"},
+		{name: "gfe-slashes", profile: "gfe", path: "/a//b", wantPath: "/a//b"},
+		{name: "gfe-query", profile: "gfe", path: "/", query: "q=%7e", wantPath: "/", wantQuery: "q=%7e"},
+		{name: "gfe-invalid-escape", profile: "gfe", path: "/%zz/%4", wantPath: "/%zz/%4"},
+		{name: "strict", profile: "strict", path: "//a//%2f..//admin", wantPath: "/admin"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := cloudarmor.ParseNormalization(tc.profile)
+			if err != nil {
+				t.Fatalf("ParseNormalization(%q) returned error: %v", tc.profile, err)
+			}
+			v := &cloudarmor.Variables{Request: &cloudarmor.Request{Path: tc.path, Query: tc.query}}
+			v.Normalize(n)
+			if v.Request.Path != tc.wantPath || v.Request.Query != tc.wantQuery {
+				t.Errorf("Normalize() returned path %q and query %q, wanted %q and %q",
+					v.Request.Path, v.Request.Query, tc.wantPath, tc.wantQuery)
+			}
+		})
+	}
+	if _, err := cloudarmor.ParseNormalization("nginx"); err == nil {
+		t.Error("ParseNormalization(nginx) succeeded, wanted error")
+	}
+
+	r, err := cloudarmor.NewRules()
+	if err != nil {
+		t.Fatalf("cloudarmor.NewRules() returned error: %v", err)
+	}
+	ts, err := cloudarmor.TestSuiteFromYAML([]byte(`
+name: "admin"
+expr: "request.path.startsWith('/admin')"
+tests:
+  - name: "traversal"
+    expect: true
+    when:
+      request:
+        path: "/static/%2e%2e/admin"
+`), cloudarmor.NormalizeRequests(cloudarmor.NormalizeGFE))
+	if err != nil {
+		t.Fatalf("TestSuiteFromYAML() returned error: %v", err)
+	}
+	ast, err := r.Compile(ts.Expr)
+	if err != nil {
+		t.Fatalf("r.Compile() returned error: %v", err)
+	}
+	prg, err := r.Program(ast)
+	if err != nil {
+		t.Fatalf("r.Program() returned error: %v", err)
+	}
+	for _, s := range r.RunRuleValidation(prg, ts.Tests) {
+		if !s.Pass {
+			t.Errorf("test case %s of a normalized suite failed: %s", s.Name, s.Fail)
+		}
+	}
+}